@@ -0,0 +1,139 @@
+// Package bench measures the network path to a Tailscale peer: UDP
+// round-trip latency and jitter, and TCP throughput. Every wc3ts instance
+// runs a Server on its Tailscale IP so peers can benchmark the path to it.
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/kradalby/wc3ts/impair"
+)
+
+// Protocol constants.
+const (
+	// packetSize is the size of a UDP echo packet: magic, sequence, send time.
+	packetSize = 16
+
+	// maxUpload bounds how long the server reads a TCP upload.
+	maxUpload = 30 * time.Second
+
+	// countSize is the size of the byte count the server replies with.
+	countSize = 8
+)
+
+// magic prefixes every UDP echo packet.
+var magic = []byte("WC3B")
+
+// ErrShortReply is returned when the server closes without a byte count.
+var ErrShortReply = errors.New("server closed without a byte count")
+
+// Server echoes UDP probes and counts TCP uploads on one port.
+type Server struct {
+	udp net.PacketConn
+	tcp net.Listener
+}
+
+// NewServer listens for benchmarks on addr's UDP and TCP port. If imp is
+// non-nil, replies are impaired like proxied game traffic.
+func NewServer(ctx context.Context, addr netip.AddrPort, imp *impair.Impairer) (*Server, error) {
+	lc := &net.ListenConfig{}
+
+	tcp, err := lc.Listen(ctx, "tcp", addr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	// Share the port picked for TCP when addr has none
+	udp, err := lc.ListenPacket(ctx, "udp", tcp.Addr().String())
+	if err != nil {
+		_ = tcp.Close()
+
+		return nil, err
+	}
+
+	return &Server{
+		udp: imp.PacketConn(udp),
+		tcp: tcp,
+	}, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.tcp.Addr()
+}
+
+// Close stops the server. Run closes it when its context is cancelled.
+func (s *Server) Close() error {
+	return errors.Join(s.udp.Close(), s.tcp.Close())
+}
+
+// Run serves benchmarks until the context is cancelled.
+func (s *Server) Run(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+
+		_ = s.Close()
+	}()
+
+	go s.echoLoop()
+
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		go s.countUpload(conn)
+	}
+}
+
+// echoLoop returns every well-formed probe to its sender. Other packets
+// are ignored so the port cannot be used for amplification.
+func (s *Server) echoLoop() {
+	buf := make([]byte, packetSize+1)
+
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		if n != packetSize || !bytes.Equal(buf[:len(magic)], magic) {
+			continue
+		}
+
+		_, _ = s.udp.WriteTo(buf[:n], addr)
+	}
+}
+
+// countUpload discards an upload and replies with the number of bytes
+// received once the client half-closes.
+func (s *Server) countUpload(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	_ = conn.SetReadDeadline(time.Now().Add(maxUpload))
+
+	n, err := io.Copy(io.Discard, conn)
+	if err != nil {
+		slog.Debug("benchmark upload failed", "remote", conn.RemoteAddr(), "error", err)
+
+		return
+	}
+
+	reply := make([]byte, countSize)
+	binary.BigEndian.PutUint64(reply, uint64(n)) //nolint:gosec // io.Copy counts are non-negative
+
+	_, _ = conn.Write(reply)
+}
@@ -0,0 +1,268 @@
+package bench
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"time"
+)
+
+// Benchmark defaults.
+const (
+	DefaultCount    = 20
+	DefaultInterval = 100 * time.Millisecond
+	DefaultDuration = 5 * time.Second
+
+	// replyWait is how long to wait for late echoes after the last probe.
+	replyWait = time.Second
+
+	chunkSize = 32 * 1024
+)
+
+// Options controls a benchmark run.
+type Options struct {
+	// Count is the number of UDP probes to send.
+	Count int
+
+	// Interval is the time between UDP probes.
+	Interval time.Duration
+
+	// Duration is how long to upload for the TCP test; zero skips it.
+	Duration time.Duration
+}
+
+// Latency summarises UDP round trips.
+type Latency struct {
+	Sent     int           `json:"sent"`
+	Received int           `json:"received"`
+	Min      time.Duration `json:"min"`
+	Avg      time.Duration `json:"avg"`
+	Max      time.Duration `json:"max"`
+
+	// Jitter is the mean difference between consecutive round trips.
+	Jitter time.Duration `json:"jitter"`
+}
+
+// Loss returns the fraction of probes without an echo.
+func (l Latency) Loss() float64 {
+	if l.Sent == 0 {
+		return 0
+	}
+
+	return float64(l.Sent-l.Received) / float64(l.Sent)
+}
+
+// Throughput summarises a TCP upload.
+type Throughput struct {
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Mbps returns the throughput in megabits per second.
+func (t Throughput) Mbps() float64 {
+	if t.Duration <= 0 {
+		return 0
+	}
+
+	return float64(t.Bytes) * 8 / t.Duration.Seconds() / 1e6
+}
+
+// Result holds the results of a benchmark run.
+type Result struct {
+	Latency    Latency     `json:"latency"`
+	Throughput *Throughput `json:"throughput,omitempty"`
+}
+
+// Run benchmarks the path to the server at addr (host:port).
+func Run(ctx context.Context, addr string, opts Options) (*Result, error) {
+	latency, err := measureLatency(ctx, addr, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{Latency: *latency}
+
+	if opts.Duration > 0 {
+		res.Throughput, err = measureThroughput(ctx, addr, opts.Duration)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	return res, nil
+}
+
+// measureLatency sends numbered probes and times their echoes.
+func measureLatency(ctx context.Context, addr string, opts Options) (*Latency, error) {
+	dialer := &net.Dialer{}
+
+	conn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = conn.Close() }()
+
+	rtts := make([]time.Duration, opts.Count)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		buf := make([]byte, packetSize)
+
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+
+			if n != packetSize || string(buf[:len(magic)]) != string(magic) {
+				continue
+			}
+
+			seq := int(binary.BigEndian.Uint32(buf[4:]))
+			sent := time.Unix(0, int64(binary.BigEndian.Uint64(buf[8:]))) //nolint:gosec // Our own timestamp
+
+			if seq < len(rtts) && rtts[seq] == 0 {
+				rtts[seq] = time.Since(sent)
+			}
+		}
+	}()
+
+	pkt := make([]byte, packetSize)
+	copy(pkt, magic)
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	sent := 0
+
+	for seq := range opts.Count {
+		binary.BigEndian.PutUint32(pkt[4:], uint32(seq))                   //nolint:gosec // Bounded by Count
+		binary.BigEndian.PutUint64(pkt[8:], uint64(time.Now().UnixNano())) //nolint:gosec // Positive
+
+		_, err = conn.Write(pkt)
+		if err != nil {
+			return nil, err
+		}
+
+		sent++
+
+		if seq == opts.Count-1 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	// Late echoes still count; then stop the reader
+	_ = conn.SetReadDeadline(time.Now().Add(replyWait))
+	<-done
+
+	return summarise(rtts[:sent]), nil
+}
+
+// summarise computes latency statistics, skipping lost probes.
+func summarise(rtts []time.Duration) *Latency {
+	l := &Latency{Sent: len(rtts), Min: time.Duration(math.MaxInt64)}
+
+	var (
+		total, jitter time.Duration
+		prev          time.Duration
+	)
+
+	for _, rtt := range rtts {
+		if rtt == 0 {
+			continue
+		}
+
+		l.Received++
+		total += rtt
+		l.Min = min(l.Min, rtt)
+		l.Max = max(l.Max, rtt)
+
+		if prev != 0 {
+			jitter += (rtt - prev).Abs()
+		}
+
+		prev = rtt
+	}
+
+	if l.Received == 0 {
+		l.Min = 0
+
+		return l
+	}
+
+	l.Avg = total / time.Duration(l.Received)
+
+	if l.Received > 1 {
+		l.Jitter = jitter / time.Duration(l.Received-1)
+	}
+
+	return l
+}
+
+// measureThroughput uploads for duration and waits for the server to
+// confirm how much arrived.
+func measureThroughput(ctx context.Context, addr string, duration time.Duration) (*Throughput, error) {
+	dialer := &net.Dialer{}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = conn.Close() }()
+
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	chunk := make([]byte, chunkSize)
+	start := time.Now()
+	deadline := start.Add(duration)
+
+	_ = conn.SetWriteDeadline(deadline)
+
+	for time.Now().Before(deadline) {
+		_, err = conn.Write(chunk)
+		if err != nil {
+			break
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	tcp, ok := conn.(*net.TCPConn)
+	if ok {
+		_ = tcp.CloseWrite()
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(maxUpload))
+
+	reply := make([]byte, countSize)
+
+	_, err = io.ReadFull(conn, reply)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrShortReply
+		}
+
+		return nil, err
+	}
+
+	return &Throughput{
+		Bytes:    int64(binary.BigEndian.Uint64(reply)), //nolint:gosec // Server byte count
+		Duration: time.Since(start),
+	}, nil
+}
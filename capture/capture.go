@@ -0,0 +1,271 @@
+// Package capture records W3GS traffic with timestamps and direction, as
+// JSON lines or a pcap file, for debugging compatibility with odd builds.
+package capture
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kradalby/wc3ts/packet"
+)
+
+// Capture formats.
+const (
+	FormatJSON = "json"
+	FormatPcap = "pcap"
+)
+
+// Directions relative to the capturing component.
+const (
+	DirIn  = "in"
+	DirOut = "out"
+)
+
+// Transport protocols.
+const (
+	ProtoUDP = "udp"
+	ProtoTCP = "tcp"
+)
+
+// ErrUnknownFormat is returned for unsupported capture formats.
+var ErrUnknownFormat = errors.New("unknown capture format (use json or pcap)")
+
+// FormatFromPath picks the format from a file extension: pcap for .pcap
+// and .cap, JSON otherwise.
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".pcap", ".cap":
+		return FormatPcap
+	default:
+		return FormatJSON
+	}
+}
+
+// Record is one captured read or write.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Component string    `json:"component"`
+	Proto     string    `json:"proto"`
+	Dir       string    `json:"dir"`
+	Local     string    `json:"local"`
+	Remote    string    `json:"remote"`
+	Len       int       `json:"len"`
+
+	// Packet names the W3GS packet for datagrams; stream reads and
+	// writes may hold several packets or a partial one.
+	Packet string `json:"packet,omitempty"`
+
+	// Data is the payload in hex.
+	Data string `json:"data"`
+}
+
+// Recorder writes captured traffic. A nil Recorder records nothing, so
+// components can wrap their connections unconditionally.
+type Recorder struct {
+	mu   sync.Mutex
+	enc  *json.Encoder
+	pcap *pcapWriter
+	err  error
+}
+
+// New returns a recorder writing format to w.
+func New(w io.Writer, format string) (*Recorder, error) {
+	r := &Recorder{}
+
+	switch format {
+	case FormatJSON:
+		r.enc = json.NewEncoder(w)
+	case FormatPcap:
+		r.pcap = newPcapWriter(w)
+
+		err := r.pcap.writeHeader()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+
+	return r, nil
+}
+
+// Err returns the first write error, after which recording stops.
+func (r *Recorder) Err() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+// Record captures data sent or received by component.
+func (r *Recorder) Record(component, proto, dir string, local, remote net.Addr, data []byte) {
+	if r == nil || len(data) == 0 {
+		return
+	}
+
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	if r.pcap != nil {
+		src, dst := addrPort(local), addrPort(remote)
+		if dir == DirIn {
+			src, dst = dst, src
+		}
+
+		r.err = r.pcap.writePacket(now, proto, src, dst, data)
+
+		return
+	}
+
+	rec := Record{
+		Time:      now,
+		Component: component,
+		Proto:     proto,
+		Dir:       dir,
+		Local:     addrString(local),
+		Remote:    addrString(remote),
+		Len:       len(data),
+		Data:      hex.EncodeToString(data),
+	}
+
+	if proto == ProtoUDP && packet.Length(data) == len(data) {
+		rec.Packet = packetName(data)
+	}
+
+	r.err = r.enc.Encode(rec)
+}
+
+// packetName names a W3GS packet by its ID.
+func packetName(data []byte) string {
+	id := packet.ID(data)
+
+	name := packet.Name(id)
+	if name == "Unknown" {
+		name = fmt.Sprintf("0x%02X", id)
+	}
+
+	return name
+}
+
+// addrString formats an address, empty if unknown.
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	return addr.String()
+}
+
+// addrPort converts a UDP or TCP address, zero if unknown.
+func addrPort(addr net.Addr) netip.AddrPort {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.AddrPort()
+	case *net.TCPAddr:
+		return a.AddrPort()
+	default:
+		return netip.AddrPort{}
+	}
+}
+
+// PacketConn wraps conn so every datagram read or written is recorded.
+func (r *Recorder) PacketConn(conn net.PacketConn, component string) net.PacketConn {
+	if r == nil {
+		return conn
+	}
+
+	return &packetConn{PacketConn: conn, rec: r, component: component}
+}
+
+// Conn wraps conn so every read and write is recorded.
+func (r *Recorder) Conn(conn net.Conn, component string) net.Conn {
+	if r == nil {
+		return conn
+	}
+
+	return &streamConn{Conn: conn, rec: r, component: component}
+}
+
+// packetConn records datagrams.
+type packetConn struct {
+	net.PacketConn
+
+	rec       *Recorder
+	component string
+}
+
+// ReadFrom records received datagrams.
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if n > 0 {
+		c.rec.Record(c.component, ProtoUDP, DirIn, c.LocalAddr(), addr, b[:n])
+	}
+
+	return n, addr, err
+}
+
+// WriteTo records sent datagrams.
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	if err == nil {
+		c.rec.Record(c.component, ProtoUDP, DirOut, c.LocalAddr(), addr, b)
+	}
+
+	return n, err
+}
+
+// streamConn records stream reads and writes.
+type streamConn struct {
+	net.Conn
+
+	rec       *Recorder
+	component string
+}
+
+// Read records received data.
+func (c *streamConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.rec.Record(c.component, ProtoTCP, DirIn, c.LocalAddr(), c.RemoteAddr(), b[:n])
+	}
+
+	return n, err
+}
+
+// Write records sent data.
+func (c *streamConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.rec.Record(c.component, ProtoTCP, DirOut, c.LocalAddr(), c.RemoteAddr(), b[:n])
+	}
+
+	return n, err
+}
+
+// CloseWrite half-closes the connection if supported.
+func (c *streamConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+
+	return nil
+}
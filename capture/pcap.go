@@ -0,0 +1,161 @@
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"time"
+)
+
+// pcap file constants.
+const (
+	pcapMagic        = 0xA1B2C3D4
+	pcapVersionMajor = 2
+	pcapVersionMinor = 4
+	pcapSnapLen      = 65535
+	pcapHeaderSize   = 24
+	pcapRecordSize   = 16
+
+	// linkTypeRaw is raw IP without a link layer header.
+	linkTypeRaw = 101
+
+	ipv4HeaderSize = 20
+	udpHeaderSize  = 8
+	tcpHeaderSize  = 20
+	ipTTL          = 64
+	ipDontFragment = 0x4000
+	tcpPushAck     = 0x18
+	tcpWindow      = 0xFFFF
+	ipProtoTCP     = 6
+	ipProtoUDP     = 17
+
+	// maxPayload keeps the synthesised IP packet within its length field.
+	maxPayload = pcapSnapLen - ipv4HeaderSize - tcpHeaderSize
+)
+
+// be is the byte order of IP, UDP and TCP headers.
+var be = binary.BigEndian
+
+// pcapWriter writes captured payloads as IPv4 packets with synthesised
+// UDP and TCP headers, so Wireshark can follow the streams.
+type pcapWriter struct {
+	w io.Writer
+
+	// seq tracks the next TCP sequence number per direction of each flow.
+	seq map[[2]netip.AddrPort]uint32
+}
+
+func newPcapWriter(w io.Writer) *pcapWriter {
+	return &pcapWriter{w: w, seq: make(map[[2]netip.AddrPort]uint32)}
+}
+
+// writeHeader writes the pcap file header.
+func (p *pcapWriter) writeHeader() error {
+	hdr := make([]byte, pcapHeaderSize)
+	le := binary.LittleEndian
+	le.PutUint32(hdr[0:], pcapMagic)
+	le.PutUint16(hdr[4:], pcapVersionMajor)
+	le.PutUint16(hdr[6:], pcapVersionMinor)
+	le.PutUint32(hdr[16:], pcapSnapLen)
+	le.PutUint32(hdr[20:], linkTypeRaw)
+
+	_, err := p.w.Write(hdr)
+
+	return err
+}
+
+// writePacket writes one payload from src to dst.
+func (p *pcapWriter) writePacket(t time.Time, proto string, src, dst netip.AddrPort, data []byte) error {
+	if len(data) > maxPayload {
+		data = data[:maxPayload]
+	}
+
+	transport := p.transportHeader(proto, src, dst, len(data))
+	ip := ipv4Header(proto, src.Addr(), dst.Addr(), len(transport)+len(data))
+	size := len(ip) + len(transport) + len(data)
+
+	rec := make([]byte, pcapRecordSize, pcapRecordSize+size)
+	le := binary.LittleEndian
+	le.PutUint32(rec[0:], uint32(t.Unix()))                             //nolint:gosec // pcap timestamps are 32-bit
+	le.PutUint32(rec[4:], uint32(t.Nanosecond()/int(time.Microsecond))) //nolint:gosec // Below one million
+	le.PutUint32(rec[8:], uint32(size))                                 //nolint:gosec // Bounded by maxPayload
+	le.PutUint32(rec[12:], uint32(size))                                //nolint:gosec // Bounded by maxPayload
+
+	rec = append(rec, ip...)
+	rec = append(rec, transport...)
+	rec = append(rec, data...)
+
+	_, err := p.w.Write(rec)
+
+	return err
+}
+
+// transportHeader builds the UDP or TCP header for a payload of size n.
+func (p *pcapWriter) transportHeader(proto string, src, dst netip.AddrPort, n int) []byte {
+	if proto == ProtoUDP {
+		hdr := make([]byte, udpHeaderSize)
+		be.PutUint16(hdr[0:], src.Port())
+		be.PutUint16(hdr[2:], dst.Port())
+		be.PutUint16(hdr[4:], uint16(udpHeaderSize+n)) //nolint:gosec // Bounded by maxPayload
+
+		return hdr
+	}
+
+	flow := [2]netip.AddrPort{src, dst}
+	seq := p.seq[flow]
+	p.seq[flow] = seq + uint32(n) //nolint:gosec // Bounded by maxPayload
+
+	hdr := make([]byte, tcpHeaderSize)
+	be.PutUint16(hdr[0:], src.Port())
+	be.PutUint16(hdr[2:], dst.Port())
+	be.PutUint32(hdr[4:], seq)
+	be.PutUint32(hdr[8:], p.seq[[2]netip.AddrPort{dst, src}])
+	hdr[12] = (tcpHeaderSize / 4) << 4
+	hdr[13] = tcpPushAck
+	be.PutUint16(hdr[14:], tcpWindow)
+
+	return hdr
+}
+
+// ipv4Header builds an IPv4 header for a payload of size n. Addresses that
+// are not IPv4 are written as 0.0.0.0.
+func ipv4Header(proto string, src, dst netip.Addr, n int) []byte {
+	hdr := make([]byte, ipv4HeaderSize)
+	hdr[0] = 0x45 // Version 4, 5 words
+
+	be.PutUint16(hdr[2:], uint16(ipv4HeaderSize+n)) //nolint:gosec // Bounded by maxPayload
+	be.PutUint16(hdr[6:], ipDontFragment)
+	hdr[8] = ipTTL
+
+	hdr[9] = ipProtoTCP
+	if proto == ProtoUDP {
+		hdr[9] = ipProtoUDP
+	}
+
+	if src.Unmap().Is4() {
+		copy(hdr[12:16], src.Unmap().AsSlice())
+	}
+
+	if dst.Unmap().Is4() {
+		copy(hdr[16:20], dst.Unmap().AsSlice())
+	}
+
+	be.PutUint16(hdr[10:], checksum(hdr))
+
+	return hdr
+}
+
+// checksum computes the IPv4 header checksum.
+func checksum(hdr []byte) uint16 {
+	var sum uint32
+
+	for i := 0; i+1 < len(hdr); i += 2 {
+		sum += uint32(be.Uint16(hdr[i:]))
+	}
+
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+
+	return ^uint16(sum)
+}
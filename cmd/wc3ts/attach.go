@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/ipc"
+	"github.com/kradalby/wc3ts/mapfile"
+	"github.com/kradalby/wc3ts/tui"
+	"github.com/kradalby/wc3ts/version"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+func newAttachCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("attach", flag.ExitOnError)
+	socket := fs.String("socket", "", "IPC socket path (default wc3ts.sock in the state directory)")
+	charset := fs.String("charset", config.DefaultCharset, "Charset for game names (auto, utf-8, gbk, cp949, cp1251...)")
+	mapsDir := fs.String("maps-dir", "", "Local Warcraft III Maps directory for map metadata")
+	port := fs.Int("port", config.DefaultAttachPort, "Port of a remote instance's attach listener")
+
+	return &ffcli.Command{
+		Name:       "attach",
+		ShortUsage: "wc3ts attach [flags] [peer]",
+		ShortHelp:  "Attach the TUI to a running 'wc3ts daemon' or a remote instance",
+		LongHelp: `Connect the TUI to a daemon started with 'wc3ts daemon'. Quitting the
+TUI detaches it; the daemon keeps running.
+
+With a peer (a tailnet node name or IP, optionally with a port), attach to
+the wc3ts instance on that node over Tailscale instead. The remote instance
+must allow you with -attach-allow.`,
+		FlagSet: fs,
+		Exec: func(_ context.Context, args []string) error {
+			if len(args) > 0 {
+				return attachExec("tcp", attachAddr(args[0], *port), *charset, *mapsDir)
+			}
+
+			path, err := socketPath(*socket)
+			if err != nil {
+				return err
+			}
+
+			return attachExec("unix", path, *charset, *mapsDir)
+		},
+	}
+}
+
+// attachAddr returns the attach address of peer, adding port unless the
+// peer already includes one.
+func attachAddr(peer string, port int) string {
+	_, _, err := net.SplitHostPort(peer)
+	if err == nil {
+		return peer
+	}
+
+	return net.JoinHostPort(peer, strconv.Itoa(port))
+}
+
+// attachExec runs a TUI attached to the daemon at addr.
+func attachExec(network, addr, charsetName, mapsDir string) error {
+	charset, err := game.ParseCharset(charsetName)
+	if err != nil {
+		return err
+	}
+
+	client, err := ipc.Attach(network, addr)
+	if err != nil {
+		return fmt.Errorf("attach to %s: %w", addr, err)
+	}
+
+	defer func() { _ = client.Close() }()
+
+	model := tui.NewModel(0, client.Version(), version.Get(), charset, mapfile.NewLibrary(mapsDir),
+		client.SetVersion, client.Refresh, client.Pause, client.SetPrivate, client.PeerAction)
+	program := tea.NewProgram(model, tea.WithAltScreen())
+
+	lost := make(chan error, 1)
+
+	go func() {
+		lost <- client.Run(program)
+
+		program.Quit()
+	}()
+
+	_, err = program.Run()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-lost:
+		return fmt.Errorf("lost connection to %s: %w", addr, err)
+	default:
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+//nolint:forbidigo // CLI output uses fmt.Print
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kradalby/wc3ts/bench"
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/impair"
+	"github.com/kradalby/wc3ts/tailscale"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// Bench errors.
+var (
+	errNoPeer      = errors.New("no peer given")
+	errUnknownPeer = errors.New("unknown peer")
+)
+
+// Round-trip times WC3 games still play smoothly at.
+const (
+	benchGoodRTT = 60 * time.Millisecond
+	benchFairRTT = 150 * time.Millisecond
+)
+
+func newBenchCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	opts := bench.Options{}
+	fs.IntVar(&opts.Count, "count", bench.DefaultCount, "Number of UDP round trips to measure")
+	fs.DurationVar(&opts.Interval, "interval", bench.DefaultInterval, "Time between UDP probes")
+	fs.DurationVar(&opts.Duration, "duration", bench.DefaultDuration, "TCP upload duration (0 skips the throughput test)")
+	port := fs.Int("port", config.DefaultBenchPort, "Benchmark port of the peer (its -bench-port)")
+	jsonOut := fs.Bool("json", false, "Print results as JSON")
+
+	return &ffcli.Command{
+		Name:       "bench",
+		ShortUsage: "wc3ts bench [flags] <peer>",
+		ShortHelp:  "Measure latency, jitter and throughput to a peer",
+		LongHelp: `Measure UDP round-trip latency, jitter and loss, and TCP throughput, to a
+peer running wc3ts, to predict in-game lag before starting a match. The
+peer is a Tailscale hostname or IP.
+
+The peer answers on its -bench-port and applies its -impair-* settings to
+replies, like proxied game traffic.`,
+		FlagSet: fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) != 1 {
+				return errNoPeer
+			}
+
+			if opts.Count <= 0 || opts.Interval <= 0 {
+				return flag.ErrHelp
+			}
+
+			ip, err := resolvePeer(ctx, args[0])
+			if err != nil {
+				return err
+			}
+
+			addr := net.JoinHostPort(ip.String(), strconv.Itoa(*port))
+
+			res, err := bench.Run(ctx, addr, opts)
+			if err != nil && res == nil {
+				return fmt.Errorf("benchmark %s: %w", addr, err)
+			}
+
+			if *jsonOut {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+
+				return errors.Join(enc.Encode(res), err)
+			}
+
+			printBench(args[0], res)
+
+			return err
+		},
+	}
+}
+
+// resolvePeer returns the Tailscale IP of the named peer.
+func resolvePeer(ctx context.Context, name string) (netip.Addr, error) {
+	ip, err := netip.ParseAddr(name)
+	if err == nil {
+		return ip, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, peersTimeout)
+	defer cancel()
+
+	peers, err := tailscale.NewDiscovery(nil).FetchPeerStatus(ctx)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	for _, p := range peers {
+		if strings.EqualFold(p.Name, name) && p.IP.IsValid() {
+			return p.IP, nil
+		}
+	}
+
+	return netip.Addr{}, fmt.Errorf("%w: %s", errUnknownPeer, name)
+}
+
+// printBench prints benchmark results with a lag estimate.
+func printBench(peer string, res *bench.Result) {
+	l := res.Latency
+
+	fmt.Printf("Benchmark to %s\n\n", peer)
+	fmt.Printf("UDP round trip: %d/%d replies (%.0f%% loss)\n", l.Received, l.Sent, l.Loss()*100)
+
+	if l.Received > 0 {
+		fmt.Printf("  min %s  avg %s  max %s  jitter %s\n",
+			roundMs(l.Min), roundMs(l.Avg), roundMs(l.Max), roundMs(l.Jitter))
+	}
+
+	if t := res.Throughput; t != nil {
+		fmt.Printf("TCP throughput: %.2f Mbit/s (%d bytes in %s)\n", t.Mbps(), t.Bytes, t.Duration.Round(time.Millisecond))
+	}
+
+	fmt.Printf("\n%s\n", benchVerdict(l))
+}
+
+// benchVerdict summarises how a game over this path will feel.
+func benchVerdict(l bench.Latency) string {
+	switch {
+	case l.Received == 0:
+		return "No replies: is wc3ts running on the peer with -bench-port enabled?"
+	case l.Loss() > 0.05:
+		return "Expect stutter: packet loss causes lag screens."
+	case l.Avg+l.Jitter <= benchGoodRTT:
+		return "Good: games should feel like a LAN."
+	case l.Avg+l.Jitter <= benchFairRTT:
+		return "Fair: expect slightly delayed unit responses."
+	default:
+		return "Poor: expect noticeable delay and lag screens."
+	}
+}
+
+// roundMs rounds d for display.
+func roundMs(d time.Duration) time.Duration {
+	return d.Round(100 * time.Microsecond)
+}
+
+// initBench answers benchmarks from peers on the Tailscale IP.
+func (a *app) initBench(ctx context.Context, localIP netip.Addr, imp *impair.Impairer) error {
+	if a.cfg.BenchPort == 0 || !localIP.IsValid() {
+		return nil
+	}
+
+	addr := netip.AddrPortFrom(localIP, safeUint16(a.cfg.BenchPort))
+
+	srv, err := bench.NewServer(ctx, addr, imp)
+	if err != nil {
+		// Benchmarks are optional, e.g. when a second instance runs
+		slog.Warn("benchmark server disabled", "addr", addr, "error", err)
+
+		return nil
+	}
+
+	a.bench = srv
+
+	return nil
+}
+
+func (a *app) runBench(ctx context.Context) {
+	err := a.bench.Run(ctx)
+	if err != nil && ctx.Err() == nil {
+		slog.Error("benchmark server error", "error", err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/kradalby/wc3ts/capture"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// errNoCaptureFile is returned when 'wc3ts capture' is run without -o.
+var errNoCaptureFile = errors.New("no capture file given (use -o file.pcap or -o file.json)")
+
+func newCaptureCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("capture", flag.ExitOnError)
+	flags := newConfigFlags(fs)
+	out := fs.String("o", "", "Capture file (.pcap for pcap, JSON lines otherwise)")
+	format := fs.String("format", "", "Capture format (json or pcap; default from the -o extension)")
+
+	return &ffcli.Command{
+		Name:       "capture",
+		ShortUsage: "wc3ts capture -o file [flags]",
+		ShortHelp:  "Run headless and record all W3GS traffic to a file",
+		LongHelp: `Run the proxy headless like 'wc3ts serve' and record every W3GS packet
+sent or received by the peer manager, responder, join guard and TCP proxy,
+with timestamps and direction.
+
+JSON output has one object per read or write, with the payload in hex.
+pcap output wraps payloads in synthesised IPv4, UDP and TCP headers for
+Wireshark. Attach the file to bug reports about unusual WC3 builds.`,
+		FlagSet: fs,
+		Options: flags.options(),
+		Exec: func(ctx context.Context, _ []string) error {
+			if *out == "" {
+				return errNoCaptureFile
+			}
+
+			if *format == "" {
+				*format = capture.FormatFromPath(*out)
+			}
+
+			cfg, err := flags.apply()
+			if err != nil {
+				return err
+			}
+
+			f, err := os.Create(*out)
+			if err != nil {
+				return err
+			}
+
+			defer func() { _ = f.Close() }()
+
+			rec, err := capture.New(f, *format)
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+
+			a := &app{
+				cfg:     cfg,
+				capture: rec,
+			}
+
+			slog.Info("capturing W3GS traffic", "file", *out, "format", *format)
+
+			return errors.Join(a.serve(ctx), rec.Err(), f.Close())
+		},
+	}
+}
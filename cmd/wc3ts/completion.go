@@ -0,0 +1,244 @@
+//nolint:forbidigo // CLI output uses fmt.Print
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/kradalby/wc3ts/config"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// errUnknownShell is returned for shells completion cannot generate for.
+var errUnknownShell = errors.New("unknown shell (use bash, zsh, fish or powershell)")
+
+// completionShells are the shells completion scripts can be generated for.
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// versionFlag is the flag completed with the supported game versions.
+const versionFlag = "version"
+
+// newCompletionCommand returns the completion command for root. It must be
+// added to root after all other subcommands.
+func newCompletionCommand(root *ffcli.Command) *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "completion",
+		ShortUsage: "wc3ts completion bash|zsh|fish|powershell",
+		ShortHelp:  "Print a shell completion script",
+		LongHelp: `Print a completion script for subcommands and flags. Game versions for
+-version are completed from the current version table.
+
+  bash:        source <(wc3ts completion bash)
+  zsh:         source <(wc3ts completion zsh)
+  fish:        wc3ts completion fish | source
+  powershell:  wc3ts completion powershell | Out-String | Invoke-Expression`,
+		Exec: func(_ context.Context, args []string) error {
+			if len(args) != 1 {
+				return errUnknownShell
+			}
+
+			if args[0] == "versions" {
+				// Called by the scripts to complete -version
+				for _, v := range config.SupportedVersions() {
+					fmt.Println(config.FormatVersion(v))
+				}
+
+				return nil
+			}
+
+			return writeCompletion(os.Stdout, root, args[0])
+		},
+	}
+}
+
+// writeCompletion writes the completion script for shell.
+func writeCompletion(w io.Writer, root *ffcli.Command, shell string) error {
+	switch shell {
+	case "bash":
+		writeBashCompletion(w, root)
+	case "zsh":
+		_, _ = fmt.Fprintln(w, "autoload -U +X bashcompinit && bashcompinit")
+
+		writeBashCompletion(w, root)
+	case "fish":
+		writeFishCompletion(w, root)
+	case "powershell":
+		writePowerShellCompletion(w, root)
+	default:
+		return fmt.Errorf("%w: %q", errUnknownShell, shell)
+	}
+
+	return nil
+}
+
+// completionFlag is a flag offered for completion.
+type completionFlag struct {
+	name   string
+	usage  string
+	isBool bool
+}
+
+// commandFlags returns the flags of cmd, sorted by name.
+func commandFlags(cmd *ffcli.Command) []completionFlag {
+	if cmd.FlagSet == nil {
+		return nil
+	}
+
+	var flags []completionFlag
+
+	cmd.FlagSet.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{name: f.Name, usage: f.Usage, isBool: ok && b.IsBoolFlag()})
+	})
+
+	sort.Slice(flags, func(i, j int) bool { return flags[i].name < flags[j].name })
+
+	return flags
+}
+
+// flagWords returns the flags of cmd as "-name" words.
+func flagWords(cmd *ffcli.Command) string {
+	flags := commandFlags(cmd)
+	words := make([]string, 0, len(flags))
+
+	for _, f := range flags {
+		words = append(words, "-"+f.name)
+	}
+
+	return strings.Join(words, " ")
+}
+
+// subcommandNames returns the names of root's subcommands.
+func subcommandNames(root *ffcli.Command) string {
+	names := make([]string, 0, len(root.Subcommands))
+	for _, sub := range root.Subcommands {
+		names = append(names, sub.Name)
+	}
+
+	return strings.Join(names, " ")
+}
+
+func writeBashCompletion(w io.Writer, root *ffcli.Command) {
+	p := func(format string, args ...any) { _, _ = fmt.Fprintf(w, format+"\n", args...) }
+
+	p("_wc3ts() {")
+	p("  local cur prev cmd word flags words")
+	p(`  cur="${COMP_WORDS[COMP_CWORD]}"`)
+	p(`  prev="${COMP_WORDS[COMP_CWORD-1]}"`)
+	p(`  local subcommands="%s"`, subcommandNames(root))
+	p("")
+	p(`  for word in "${COMP_WORDS[@]:1:COMP_CWORD-1}"; do`)
+	p(`    case " $subcommands " in *" $word "*) cmd="$word"; break;; esac`)
+	p("  done")
+	p("")
+	p(`  case "$prev" in`)
+	p(`    -%[1]s|--%[1]s)`, versionFlag)
+	p(`      COMPREPLY=($(compgen -W "$(wc3ts completion versions 2>/dev/null)" -- "$cur"))`)
+	p("      return;;")
+	p("  esac")
+	p("")
+	p(`  case "$cmd" in`)
+
+	for _, sub := range root.Subcommands {
+		words := ""
+		if sub.Name == "completion" {
+			words = strings.Join(completionShells, " ")
+		}
+
+		p(`    %s) flags="%s"; words="%s";;`, sub.Name, flagWords(sub), words)
+	}
+
+	p(`    *) flags="%s"; words="$subcommands";;`, flagWords(root))
+	p("  esac")
+	p("")
+	p(`  if [[ "$cur" == -* ]]; then`)
+	p(`    COMPREPLY=($(compgen -W "$flags" -- "$cur"))`)
+	p("  else")
+	p(`    COMPREPLY=($(compgen -W "$words" -- "$cur"))`)
+	p("  fi")
+	p("}")
+	p("")
+	p("complete -o default -F _wc3ts wc3ts")
+}
+
+func writeFishCompletion(w io.Writer, root *ffcli.Command) {
+	p := func(format string, args ...any) { _, _ = fmt.Fprintf(w, format+"\n", args...) }
+
+	p("complete -c wc3ts -f")
+
+	for _, f := range commandFlags(root) {
+		p("complete -c wc3ts -n __fish_use_subcommand %s", fishFlag(f))
+	}
+
+	for _, sub := range root.Subcommands {
+		p("complete -c wc3ts -n __fish_use_subcommand -a %s -d %s", sub.Name, fishQuote(sub.ShortHelp))
+
+		cond := "'__fish_seen_subcommand_from " + sub.Name + "'"
+
+		for _, f := range commandFlags(sub) {
+			p("complete -c wc3ts -n %s %s", cond, fishFlag(f))
+		}
+	}
+
+	p("complete -c wc3ts -n '__fish_seen_subcommand_from completion' -a '%s'", strings.Join(completionShells, " "))
+}
+
+// fishFlag returns the complete options for a single-dash flag.
+func fishFlag(f completionFlag) string {
+	opts := "-o " + f.name + " -d " + fishQuote(f.usage)
+
+	switch {
+	case f.name == versionFlag:
+		opts += " -x -a '(wc3ts completion versions)'"
+	case !f.isBool:
+		opts += " -r"
+	}
+
+	return opts
+}
+
+// fishQuote quotes s for a fish script.
+func fishQuote(s string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), "'", `\'`) + "'"
+}
+
+func writePowerShellCompletion(w io.Writer, root *ffcli.Command) {
+	p := func(format string, args ...any) { _, _ = fmt.Fprintf(w, format+"\n", args...) }
+
+	p("Register-ArgumentCompleter -Native -CommandName wc3ts -ScriptBlock {")
+	p("  param($wordToComplete, $commandAst, $cursorPosition)")
+	p("  $flags = @{")
+	p("    '' = '%s'", flagWords(root))
+
+	for _, sub := range root.Subcommands {
+		p("    '%s' = '%s'", sub.Name, flagWords(sub))
+	}
+
+	p("  }")
+	p("  $subcommands = '%s' -split ' '", subcommandNames(root))
+	p("  $words = $commandAst.CommandElements | Select-Object -Skip 1 | ForEach-Object { $_.ToString() }")
+	p("  $cmd = ''")
+	p("  foreach ($w in $words) { if ($subcommands -contains $w) { $cmd = $w; break } }")
+	p("  $prev = if ($wordToComplete) { $words[-2] } else { $words[-1] }")
+	p("  if ($prev -in '-%[1]s', '--%[1]s') {", versionFlag)
+	p("    $candidates = wc3ts completion versions")
+	p("  } elseif ($wordToComplete -like '-*') {")
+	p("    $candidates = $flags[$cmd] -split ' '")
+	p("  } elseif ($cmd -eq 'completion') {")
+	p("    $candidates = '%s' -split ' '", strings.Join(completionShells, " "))
+	p("  } elseif ($cmd -eq '') {")
+	p("    $candidates = $subcommands")
+	p("  } else {")
+	p("    return")
+	p("  }")
+	p("  $candidates | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {")
+	p("    [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)")
+	p("  }")
+	p("}")
+}
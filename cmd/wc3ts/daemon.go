@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/paths"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// errDaemonRunning is returned when another daemon owns the socket.
+var errDaemonRunning = errors.New("a daemon is already listening")
+
+// socketDirPerm keeps the socket directory private to the user.
+const socketDirPerm = 0o700
+
+func newDaemonCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	flags := newConfigFlags(fs)
+	socket := fs.String("socket", "", "IPC socket path (default wc3ts.sock in the state directory)")
+
+	return &ffcli.Command{
+		Name:       "daemon",
+		ShortUsage: "wc3ts daemon [flags]",
+		ShortHelp:  "Run the WC3 LAN proxy in the background for 'wc3ts attach'",
+		LongHelp: `Run all services like 'wc3ts serve' and serve the TUI state on a local
+socket. 'wc3ts attach' connects a TUI to the daemon; closing it leaves
+the proxy and active game connections running. Logs go to stderr.`,
+		FlagSet: fs,
+		Options: flags.options(),
+		Exec: func(ctx context.Context, _ []string) error {
+			cfg, err := flags.apply()
+			if err != nil {
+				return err
+			}
+
+			return daemonExec(ctx, cfg, *socket)
+		},
+	}
+}
+
+// daemonExec runs all services and serves attached TUIs until interrupted.
+func daemonExec(ctx context.Context, cfg *config.Config, socket string) error {
+	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	socket, err := socketPath(socket)
+	if err != nil {
+		return err
+	}
+
+	listener, err := listenSocket(ctx, socket)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = os.Remove(socket) }()
+
+	a := &app{
+		cfg: cfg,
+	}
+
+	// Created before the services so early logs and state reach the snapshot
+	a.newIPC()
+	slog.SetDefault(slog.New(a.logHandler(slog.Default().Handler())))
+
+	err = a.initServices(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer a.close()
+
+	a.startServices(ctx)
+	a.sendStartup()
+
+	go func() {
+		err := a.ipc.Serve(ctx, listener, nil)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("IPC server error", "error", err)
+		}
+	}()
+
+	slog.Info("wc3ts daemon started", "proxyPort", a.tcpProxy.Port(), "socket", socket)
+
+	notifyReady(ctx)
+
+	<-ctx.Done()
+
+	slog.Info("wc3ts daemon stopping")
+
+	a.drain()
+
+	return nil
+}
+
+// socketPath returns path, or the default socket in the state directory.
+func socketPath(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+
+	p, err := paths.Get()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(p.State, paths.SocketFile), nil
+}
+
+// listenSocket listens on a unix socket, replacing a stale socket file
+// left by a daemon that did not shut down cleanly.
+func listenSocket(ctx context.Context, path string) (net.Listener, error) {
+	err := os.MkdirAll(filepath.Dir(path), socketDirPerm)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		_ = conn.Close()
+
+		return nil, fmt.Errorf("%w on %s", errDaemonRunning, path)
+	}
+
+	_ = os.Remove(path)
+
+	lc := &net.ListenConfig{}
+
+	return lc.Listen(ctx, "unix", path)
+}
+
+// teeHandler sends log records to several handlers.
+type teeHandler []slog.Handler
+
+// Enabled reports whether any handler handles records at the given level.
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Handle passes the record to every handler enabled for its level.
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// WithAttrs returns a teeHandler with the attributes added to every handler.
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(teeHandler, len(t))
+	for i, h := range t {
+		out[i] = h.WithAttrs(attrs)
+	}
+
+	return out
+}
+
+// WithGroup returns a teeHandler with the group added to every handler.
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	out := make(teeHandler, len(t))
+	for i, h := range t {
+		out[i] = h.WithGroup(name)
+	}
+
+	return out
+}
@@ -0,0 +1,208 @@
+//nolint:forbidigo // CLI output uses fmt.Print
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/packet"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// errNoPacketData is returned when decode finds no bytes to decode.
+var errNoPacketData = errors.New("no packet data (pass hex, pipe it on stdin, or use -f)")
+
+// dumpOffset matches the offset column of a hex.Dump line, as printed by 'probe -dump'.
+var dumpOffset = regexp.MustCompile(`^[0-9a-fA-F]{8}\s`)
+
+func newDecodeCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	file := fs.String("f", "", "Read raw packet bytes from this file (e.g. written by 'probe -o')")
+	charsetName := fs.String("charset", config.DefaultCharset,
+		"Charset for game names (auto, utf-8, gbk, cp949, cp1251...)")
+	dump := fs.Bool("dump", false, "Print each packet as annotated hex")
+
+	return &ffcli.Command{
+		Name:       "decode",
+		ShortUsage: "wc3ts decode [flags] [hex...]",
+		ShortHelp:  "Decode W3GS packets from a hex dump or file",
+		LongHelp: `Decode W3GS packets offline and print their fields, using the same
+decoder as probe and the proxy.
+
+Hex is read from the arguments, or from stdin when there are none.
+Whitespace, colons, 0x prefixes and 'probe -dump' output are accepted.
+With -f, the file holds raw bytes instead. Several packets back to back
+are split on their W3GS length fields.
+
+Examples:
+  wc3ts decode f7 2f 10 00 50 58 33 57 1a 00 00 00 01 00 00 00
+  wc3ts decode -f game.bin
+  pbpaste | wc3ts decode`,
+		FlagSet: fs,
+		Exec: func(_ context.Context, args []string) error {
+			charset, err := game.ParseCharset(*charsetName)
+			if err != nil {
+				return err
+			}
+
+			data, err := readPacketData(*file, args)
+			if err != nil {
+				return err
+			}
+
+			if len(data) == 0 {
+				return errNoPacketData
+			}
+
+			decodePackets(data, charset, *dump)
+
+			return nil
+		},
+	}
+}
+
+// readPacketData returns raw bytes from file, or hex from args or stdin.
+func readPacketData(file string, args []string) ([]byte, error) {
+	if file != "" {
+		return os.ReadFile(file)
+	}
+
+	text := strings.Join(args, " ")
+
+	if len(args) == 0 {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+
+		text = string(b)
+	}
+
+	return parseHex(text)
+}
+
+// parseHex decodes hex text, ignoring separators, 0x prefixes and the
+// offset and ASCII columns of hex.Dump output.
+func parseHex(text string) ([]byte, error) {
+	var digits strings.Builder
+
+	for line := range strings.Lines(text) {
+		line = strings.TrimSpace(line)
+
+		if dumpOffset.MatchString(line) {
+			line = line[len("00000000"):]
+
+			if i := strings.Index(line, "|"); i >= 0 {
+				line = line[:i]
+			}
+		}
+
+		line = strings.ReplaceAll(line, "0x", "")
+		line = strings.ReplaceAll(line, "0X", "")
+
+		for _, r := range line {
+			switch r {
+			case ' ', '\t', ':', ',', '-':
+				continue
+			}
+
+			digits.WriteRune(r)
+		}
+	}
+
+	data, err := hex.DecodeString(digits.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex: %w", err)
+	}
+
+	return data, nil
+}
+
+// decodePackets splits data on W3GS length fields and prints each packet.
+// Framing errors leave the rest of data as one undecodable chunk.
+func decodePackets(data []byte, charset game.Charset, dump bool) {
+	for offset := 0; len(data) > 0; {
+		size := packet.Length(data)
+		if packet.ID(data) == 0 || size < packet.HeaderSize || size > len(data) {
+			size = len(data)
+		}
+
+		fmt.Printf("=== Packet at offset %d (%d bytes) ===\n", offset, size)
+
+		if dump {
+			dumpPacket(data[:size])
+		}
+
+		printDecoded(data[:size], charset)
+		fmt.Println()
+
+		data = data[size:]
+		offset += size
+	}
+}
+
+// printDecoded prints the fields of one packet.
+func printDecoded(data []byte, charset game.Charset) {
+	pkt, err := packet.Parse(data)
+	if err != nil {
+		fmt.Printf("  Error:    %v\n", err)
+
+		if len(data) >= packet.HeaderSize {
+			fmt.Printf("  Header:   sig=0x%02X id=0x%02X (%s) len=%d\n",
+				data[0], data[1], packet.Name(data[1]), packet.Length(data))
+		}
+
+		return
+	}
+
+	fmt.Printf("  Type:     %s (0x%02X)\n", strings.TrimPrefix(fmt.Sprintf("%T", pkt), "*w3gs."), data[1])
+
+	switch p := pkt.(type) {
+	case *w3gs.SearchGame:
+		fmt.Printf("  Version:  %s 1.%d\n", p.Product, p.Version)
+		fmt.Printf("  HostCtr:  %d\n", p.HostCounter)
+	case *w3gs.GameInfo:
+		// Same checks the manager applies to announcements
+		_, err = packet.ParseGameInfo(data)
+		if err != nil {
+			fmt.Printf("  Invalid:  %v\n", err)
+		}
+
+		fmt.Printf("  Name:     %s\n", charset.Decode(p.GameName))
+		fmt.Printf("  Host:     %s\n", charset.Decode(p.GameSettings.HostName))
+		fmt.Printf("  Map:      %s\n", charset.Decode(p.GameSettings.MapPath))
+		fmt.Printf("  Players:  %d/%d (%d open)\n", p.SlotsUsed, p.SlotsTotal, p.SlotsAvailable)
+		fmt.Printf("  Port:     %d\n", p.GamePort)
+		fmt.Printf("  Version:  %s 1.%d\n", p.Product, p.Version)
+		fmt.Printf("  HostCtr:  %d\n", p.HostCounter)
+		fmt.Printf("  EntryKey: 0x%08X\n", p.EntryKey)
+		fmt.Printf("  Flags:    %s\n", p.GameFlags)
+		fmt.Printf("  Uptime:   %ds\n", p.UptimeSec)
+	case *w3gs.CreateGame:
+		fmt.Printf("  Version:  %s 1.%d\n", p.Product, p.Version)
+		fmt.Printf("  HostCtr:  %d\n", p.HostCounter)
+	case *w3gs.RefreshGame:
+		fmt.Printf("  HostCtr:  %d\n", p.HostCounter)
+		fmt.Printf("  Slots:    %d used, %d open\n", p.SlotsUsed, p.SlotsAvailable)
+	case *w3gs.DecreateGame:
+		fmt.Printf("  HostCtr:  %d\n", p.HostCounter)
+	case *w3gs.Join:
+		fmt.Printf("  Player:   %s\n", charset.Decode(p.PlayerName))
+		fmt.Printf("  HostCtr:  %d\n", p.HostCounter)
+		fmt.Printf("  EntryKey: 0x%08X\n", p.EntryKey)
+		fmt.Printf("  Port:     %d\n", p.ListenPort)
+		fmt.Printf("  Internal: %s\n", p.InternalAddr.UDPAddr())
+	default:
+		fmt.Printf("  Fields:   %+v\n", pkt)
+	}
+}
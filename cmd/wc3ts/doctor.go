@@ -0,0 +1,54 @@
+//nolint:forbidigo // CLI output uses fmt.Print
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kradalby/wc3ts/tailscale"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// doctorTimeout bounds the Tailscale queries made by doctor.
+const doctorTimeout = 10 * time.Second
+
+func newDoctorCommand() *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "doctor",
+		ShortUsage: "wc3ts doctor",
+		ShortHelp:  "Check the Tailscale connection and network health",
+		LongHelp: `Report the Tailscale IP and a network health summary: the nearest DERP
+relay and its latency, whether UDP works, and how many active peers are
+reached directly versus through a relay.
+
+Relayed connections add the round trip to the DERP server to every
+packet, a common cause of lag in cross-site games.`,
+		Exec: func(ctx context.Context, _ []string) error {
+			ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+			defer cancel()
+
+			discovery := tailscale.NewDiscovery(nil)
+
+			ip, err := discovery.FetchSelfIP(ctx)
+			if err != nil {
+				return fmt.Errorf("tailscale not reachable: %w", err)
+			}
+
+			fmt.Printf("Tailscale IP:  %s\n", ip)
+
+			health, err := discovery.FetchHealth(ctx)
+			if err != nil {
+				return fmt.Errorf("network health: %w", err)
+			}
+
+			fmt.Printf("Network:       %s\n", health)
+
+			if health.UDP == tailscale.UDPBlocked {
+				fmt.Println("\nUDP appears blocked; all peers will be relayed through DERP.")
+			}
+
+			return nil
+		},
+	}
+}
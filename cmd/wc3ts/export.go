@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/control"
+	"github.com/kradalby/wc3ts/game"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// errExportFailed is returned when the control API rejects an export.
+var errExportFailed = errors.New("export request failed")
+
+// exportRequestTimeout bounds the export request to a running instance.
+const exportRequestTimeout = 10 * time.Second
+
+func newExportCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", game.FormatJSON, "Export format (json or csv)")
+	out := fs.String("o", "", "Output file (default stdout)")
+	controlAddr := fs.String("control-addr", "",
+		"Export from the instance whose control API listens here, e.g. 127.0.0.1:6114")
+	tokenFile := fs.String("control-token-file", "", "Control API token file, if not the default")
+	timeout := fs.Duration("timeout", defaultListTimeout, "How long to wait for replies when discovering")
+	versionStr := fs.String("version", "26", "Game version (e.g., 26, 1.26, 27, 1.27, 28, 1.28)")
+	allVersions := fs.Bool("all-versions", false, "Discover games of every supported version")
+
+	return &ffcli.Command{
+		Name:       "export",
+		ShortUsage: "wc3ts export [-format json|csv] [-o file] [-control-addr addr [-control-token-file file]]",
+		ShortHelp:  "Export known games with raw packets for debugging",
+		LongHelp: `Export every known game, including the raw GameInfo packet (hex),
+peer, lifecycle state and timestamps, as JSON or CSV. Attach the output
+to bug reports.
+
+With -control-addr, the registry of a running instance is exported via
+its control API, authorized with the token the instance keeps in its
+-control-token-file. Otherwise localhost and all Tailscale peers are probed
+once, as with 'wc3ts list'.`,
+		FlagSet: fs,
+		Exec: func(ctx context.Context, _ []string) error {
+			w := io.Writer(os.Stdout)
+
+			if *out != "" {
+				f, err := os.Create(*out)
+				if err != nil {
+					return err
+				}
+
+				defer func() { _ = f.Close() }()
+
+				w = f
+			}
+
+			if *controlAddr != "" {
+				return exportFromControl(ctx, w, *controlAddr, *tokenFile, *format)
+			}
+
+			v, err := config.ParseVersion(*versionStr)
+			if err != nil {
+				return err
+			}
+
+			cfg := config.Default()
+			cfg.GameVersion.Version = v
+			cfg.AllVersions = *allVersions
+
+			games, err := discoverGames(ctx, cfg, *timeout)
+			if err != nil {
+				return err
+			}
+
+			return game.Export(w, games, *format)
+		},
+	}
+}
+
+// exportFromControl copies the game export of a running instance to w.
+func exportFromControl(ctx context.Context, w io.Writer, addr, tokenFile, format string) error {
+	ctx, cancel := context.WithTimeout(ctx, exportRequestTimeout)
+	defer cancel()
+
+	u := url.URL{
+		Scheme:   "http",
+		Host:     addr,
+		Path:     "/v1/games/export",
+		RawQuery: url.Values{"format": {format}}.Encode(),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	err = authorizeControl(req, tokenFile)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		return fmt.Errorf("%w: %s: %s", errExportFailed, resp.Status, body)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+
+	return err
+}
+
+// authorizeControl adds the token of the running instance, read from
+// tokenFile or the default location, to a request to its control API.
+func authorizeControl(req *http.Request, tokenFile string) error {
+	path, err := controlTokenPath(tokenFile)
+	if err != nil {
+		return err
+	}
+
+	token, err := control.ReadToken(path)
+	if err != nil {
+		return err
+	}
+
+	control.Authorize(req, token)
+
+	return nil
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/paths"
+	"github.com/kradalby/wc3ts/state"
+	"github.com/peterbourgon/ff/v3"
+)
+
+// configFlags binds the flags shared by commands that run the proxy
+// to a Config. Call apply after parsing to resolve derived values.
+type configFlags struct {
+	fs            *flag.FlagSet
+	cfg           *config.Config
+	versionStr    string
+	strictVersion bool
+	compatGroups  []config.CompatGroup
+	history       bool
+
+	// commandLine lists the flags given on the command line, recorded
+	// before the config file is applied.
+	commandLine []string
+}
+
+// newConfigFlags registers the proxy configuration flags on fs.
+func newConfigFlags(fs *flag.FlagSet) *configFlags {
+	f := &configFlags{fs: fs, cfg: config.Default()}
+	cfg := f.cfg
+
+	fs.StringVar(&cfg.ConfigFile, "config", defaultConfigFile(),
+		"File with one 'flag value' per line, re-read when it changes or on SIGHUP")
+	fs.StringVar(&f.versionStr, "version", "26",
+		"Game version (e.g., 26, 1.26, 27, 1.27, 28, 1.28), or auto to detect it from a local game")
+	fs.IntVar(&cfg.UDPReceiveBuffer, "udp-rcvbuf", cfg.UDPReceiveBuffer,
+		"UDP receive buffer size in bytes (0 for OS default)")
+	fs.StringVar(&cfg.ProbeBind, "probe-bind", cfg.ProbeBind, "Source address for peer probes (auto, any, or an IP)")
+	fs.StringVar(&cfg.ProxyBind, "proxy-bind", cfg.ProxyBind,
+		"Addresses the TCP proxy listens on (all, loopback, lan, tailscale, interface names or IPs, comma-separated)")
+	fs.StringVar(&cfg.BroadcastIfaces, "broadcast-iface", cfg.BroadcastIfaces,
+		"Interfaces to announce games on, by name or IP, comma-separated (default: the OS's choice)")
+	fs.IntVar(&cfg.ProxyPort, "proxy-port", cfg.ProxyPort,
+		"Fixed TCP proxy port for firewall rules; a random port is used if it is taken (0 for random)")
+	fs.BoolVar(&cfg.GamePorts, "game-ports", cfg.GamePorts,
+		"Give each remote game its own random proxy port instead of sharing the proxy port")
+	fs.BoolVar(&cfg.UDPRelay, "udp-relay", cfg.UDPRelay,
+		"Relay in-game UDP datagrams sent to the proxy port to the game host")
+	fs.DurationVar(&cfg.ReconnectWait, "reconnect-wait", cfg.ReconnectWait,
+		"How long GProxy++ clients may take to reconnect to a proxied game (0 disables reconnecting)")
+	fs.DurationVar(&cfg.RelayIdleTimeout, "relay-idle-timeout", cfg.RelayIdleTimeout,
+		"Close proxied connections when the client or host sends nothing for this long (0 disables)")
+	fs.IntVar(&cfg.RelayBufferSize, "relay-buffer", cfg.RelayBufferSize,
+		"Size in bytes of the pooled buffers proxied connections are copied through")
+	fs.BoolVar(&cfg.Splice, "splice", cfg.Splice,
+		"Linux: relay proxied traffic in the kernel with splice when no idle timeout, throttle or GProxy needs it; "+
+			"disable to count packets per connection")
+	fs.BoolVar(&cfg.TCPNoDelay, "tcp-nodelay", cfg.TCPNoDelay,
+		"Send small game packets on proxied connections at once instead of coalescing them (Nagle)")
+	fs.DurationVar(&cfg.TCPKeepAlive, "tcp-keepalive", cfg.TCPKeepAlive,
+		"Keepalive probe interval on proxied connections (0 disables keepalives)")
+	fs.Func("dscp", "Mark proxied traffic and LAN broadcasts for router QoS with this DSCP, e.g. ef, cs4 or af41",
+		cfg.SetDSCP)
+	fs.IntVar(&cfg.MaxConnections, "max-connections", cfg.MaxConnections,
+		"Most simultaneous connections to the TCP proxy; more are rejected (0 for no limit)")
+	fs.IntVar(&cfg.Throttle, "throttle", cfg.Throttle,
+		"Cap each proxied connection at this many bytes per second in each direction (0 disables)")
+	fs.IntVar(&cfg.ThrottleTotal, "throttle-total", cfg.ThrottleTotal,
+		"Cap all proxied connections together at this many bytes per second in each direction (0 disables)")
+	fs.StringVar(&cfg.ControlAddr, "control-addr", cfg.ControlAddr,
+		"Listen address for the control API, e.g. 127.0.0.1:6114 (empty disables it)")
+	fs.StringVar(&cfg.ControlTokenFile, "control-token-file", cfg.ControlTokenFile,
+		"File holding the control API token, created if missing (default: control-token in the config directory)")
+	fs.StringVar(&cfg.Webhook, "webhook", cfg.Webhook,
+		"Post every game lifecycle event (lobby, starting, ended, ...) as JSON to this URL (empty disables it)")
+	fs.DurationVar(&cfg.Impair.Latency, "impair-latency", 0, "Testing: latency added to peer traffic")
+	fs.DurationVar(&cfg.Impair.Jitter, "impair-jitter", 0, "Testing: maximum random jitter added to peer traffic")
+	fs.Float64Var(&cfg.Impair.Loss, "impair-loss", 0, "Testing: packet loss probability for peer traffic (0-1)")
+	fs.Uint64Var(&cfg.Impair.Seed, "impair-seed", 1, "Testing: random seed for reproducible jitter and loss")
+	fs.BoolVar(&cfg.UpdateCheck, "update-check", cfg.UpdateCheck,
+		"Check GitHub for a newer release in the background and show it in the TUI and 'wc3ts version'")
+	fs.BoolVar(&cfg.AllVersions, "all-versions", cfg.AllVersions,
+		"Discover and advertise games of every supported version, not only the selected one")
+	fs.BoolVar(&cfg.ShowPeerNames, "peer-names", cfg.ShowPeerNames,
+		"Rewrite remote game names with -name-template so players see whose game it is")
+	fs.StringVar(&cfg.NameTemplate, "name-template", cfg.NameTemplate,
+		"Remote game name shown in WC3, with {peer}, {name} and {version} placeholders")
+	fs.BoolVar(&cfg.VersionTags, "version-tags", cfg.VersionTags,
+		"Prefix game names with their version when several versions are advertised")
+	fs.BoolVar(&cfg.LatencyTags, "latency-tags", cfg.LatencyTags,
+		"Append the round trip to each host to its game names, e.g. 'Pudge Wars [43ms]'")
+	fs.DurationVar(&cfg.ProbeInterval, "probe-interval", cfg.ProbeInterval,
+		"How often to probe peers for games (raise on metered connections)")
+	fs.DurationVar(&cfg.ProbeBackoff, "probe-backoff", cfg.ProbeBackoff,
+		"Longest interval to probe peers that do not answer at (0 probes every peer each interval)")
+	fs.DurationVar(&cfg.RefreshInterval, "refresh-interval", cfg.RefreshInterval,
+		"How often to announce games to the local LAN")
+	fs.DurationVar(&cfg.IdleRefresh, "idle-refresh-interval", cfg.IdleRefresh,
+		"How often to announce games while they are unchanged and no WC3 client is running (0 disables slowing down)")
+	fs.DurationVar(&cfg.BroadcastPace, "broadcast-pace", cfg.BroadcastPace,
+		"Pause between announcement packets, plus up to half as much jitter, so Wi-Fi does not drop bursts (0 disables)")
+	fs.BoolVar(&cfg.InstantSearch, "instant-search", cfg.InstantSearch,
+		"Linux: announce games as soon as WC3 opens the LAN screen instead of on the next refresh")
+	fs.DurationVar(&cfg.GameTimeout, "game-timeout", cfg.GameTimeout,
+		"Forget games that go unannounced for this long (0 keeps them until their host decreates them)")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", cfg.IdleTimeout,
+		"Slow down peer probing after this long without local activity (0 disables)")
+	fs.DurationVar(&cfg.DrainTimeout, "drain-timeout", cfg.DrainTimeout,
+		"Headless: on shutdown, wait this long for proxied game connections to finish")
+	fs.BoolVar(&f.strictVersion, "strict-version", false, "Only discover games announcing exactly the selected version")
+	fs.Func("compat", "Extra compatible versions, e.g. 'mypatch=W3XP:1.26,WAR3:1.26' (repeatable)", f.addCompatGroup)
+	fs.StringVar(&cfg.MapsDir, "maps-dir", cfg.MapsDir, "Local Warcraft III Maps directory for map metadata")
+	fs.BoolVar(&f.history, "history", false,
+		"Record probe, session and game history in the history directory (see 'wc3ts paths')")
+	fs.StringVar(&cfg.HistoryDir, "history-dir", cfg.HistoryDir,
+		"Directory to record history in, overriding the default (implies -history)")
+	fs.StringVar(&cfg.AuditLog, "audit-log", cfg.AuditLog,
+		"File to append a JSON line to for every proxied join: player, client, game, host, duration, bytes and reason")
+	fs.StringVar(&cfg.DirectConnect, "direct", cfg.DirectConnect,
+		"Let reachable hosts announce games straight to WC3, bypassing the proxy (auto, on, off)")
+	fs.BoolVar(&cfg.ReachCheck, "reach-check", cfg.ReachCheck,
+		"Check that hosts accept connections on their game port before advertising their games")
+	fs.BoolVar(&cfg.Compress, "compress", cfg.Compress,
+		"Compress game traffic with peers also running -compress while the Tailscale path is relayed through DERP")
+	fs.BoolVar(&cfg.LANBypass, "lan-bypass", cfg.LANBypass,
+		"Join games of peers on the same physical LAN directly instead of through the proxy")
+	fs.BoolVar(&cfg.LANBridge, "lan-bridge", cfg.LANBridge,
+		"Offer games hosted by other machines on the local LAN to Tailscale peers")
+	fs.BoolVar(&cfg.MDNS, "mdns", cfg.MDNS,
+		"Find other wc3ts instances on the local LAN over mDNS and share games with them")
+	fs.BoolVar(&cfg.Gateway, "gateway", cfg.Gateway,
+		"Serve WC3 clients on other LAN machines, so only this one needs Tailscale")
+	fs.BoolVar(&cfg.Ghost, "ghost", cfg.Ghost,
+		"Ghost mode: keep local games private to the LAN unless made public in the TUI")
+	fs.Func("join-allow",
+		"Tailnet user, node, IP or tag allowed to join local games or the proxy over Tailscale; "+
+			"'game name=identity' for one game (repeatable)",
+		cfg.JoinACL.Add)
+	fs.Func("peer-allow",
+		"Only probe Tailscale peers matching this hostname glob, IP, CIDR or tag:name (repeatable)",
+		cfg.AddPeerAllow)
+	fs.Func("peer-deny",
+		"Never probe Tailscale peers matching this hostname glob, IP, CIDR or tag:name (repeatable)",
+		cfg.AddPeerDeny)
+	fs.Func("game-allow", "Only show and rebroadcast games whose name matches this glob or /regexp/ (repeatable)",
+		cfg.AddGameAllow)
+	fs.Func("game-block", "Hide games whose name matches this glob or /regexp/, e.g. '*AFK*' (repeatable)",
+		cfg.AddGameBlock)
+	fs.Func("static-host",
+		"Also probe this host outside the tailnet, as 'name=host:port' (name and port optional, repeatable)",
+		cfg.AddStaticHost)
+	fs.Func("probe-subnet",
+		"Also probe every address of this subnet, e.g. a LAN behind a Tailscale subnet router, as a CIDR (repeatable)",
+		cfg.AddProbeSubnet)
+	fs.Func("announce-to",
+		"Also announce games to this address, as 'ip' or 'ip:port', e.g. a VM that never sees broadcasts (repeatable)",
+		cfg.AddAnnounceTarget)
+	fs.Func("peer-addr",
+		"Extra address to join a peer's games at if its Tailscale address fails, as 'peer=ip', e.g. its LAN IP (repeatable)",
+		cfg.AddPeerAddr)
+	fs.Func("attach-allow",
+		"Tailnet user, node, IP or tag allowed to attach a TUI remotely with 'wc3ts attach <peer>' (repeatable)",
+		cfg.AddAttachAllow)
+	fs.IntVar(&cfg.AttachPort, "attach-port", cfg.AttachPort, "Tailscale port remote TUIs attach to")
+	fs.IntVar(&cfg.LANPort, "lan-port", cfg.LANPort,
+		"UDP port WC3 discovers LAN games on, for modified clients that do not use 6112")
+	fs.IntVar(&cfg.FallbackPort, "fallback-port", cfg.FallbackPort,
+		"UDP port to answer peers on when another program holds the LAN port on the Tailscale IP (0 disables)")
+	fs.IntVar(&cfg.BenchPort, "bench-port", cfg.BenchPort,
+		"Tailscale port answering 'wc3ts bench' from peers (0 disables)")
+	fs.StringVar(&cfg.Wine, "wine", cfg.Wine,
+		"Adapt to a WC3 client running under Wine or Proton (auto, on, off)")
+	fs.StringVar(&cfg.Charset, "charset", cfg.Charset, "Charset for game names (auto, utf-8, gbk, cp949, cp1251...)")
+
+	return f
+}
+
+// defaultConfigFile returns the config file in the config directory, or ""
+// if there is none on this platform.
+func defaultConfigFile() string {
+	p, err := paths.Get()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(p.Config, paths.ConfigFile)
+}
+
+// options returns the ff options reading the config file named by -config.
+// Keys for flags of other commands are ignored so one file serves all.
+func (f *configFlags) options() []ff.Option {
+	return []ff.Option{
+		ff.WithConfigFileFlag("config"),
+		ff.WithConfigFileParser(f.parseConfigFile),
+		ff.WithAllowMissingConfigFile(true),
+		ff.WithIgnoreUndefined(true),
+	}
+}
+
+// parseConfigFile records the command line flags, which ff has applied by
+// now, and parses the config file.
+func (f *configFlags) parseConfigFile(r io.Reader, set func(name, value string) error) error {
+	f.recordCommandLine()
+
+	return ff.PlainParser(r, set)
+}
+
+// recordCommandLine records the flags set so far, once.
+func (f *configFlags) recordCommandLine() {
+	if f.commandLine != nil {
+		return
+	}
+
+	f.commandLine = []string{}
+
+	f.fs.Visit(func(fl *flag.Flag) {
+		f.commandLine = append(f.commandLine, fl.Name)
+	})
+}
+
+// newGameFilter compiles the game name patterns of cfg.
+func newGameFilter(cfg *config.Config) (*game.NameFilter, error) {
+	charset, err := game.ParseCharset(cfg.Charset)
+	if err != nil {
+		return nil, err
+	}
+
+	return game.NewNameFilter(cfg.GameAllow, cfg.GameBlock, charset)
+}
+
+// isSet reports whether the named flag was given on the command line or in
+// the config file.
+func (f *configFlags) isSet(name string) bool {
+	set := false
+
+	f.fs.Visit(func(fl *flag.Flag) {
+		set = set || fl.Name == name
+	})
+
+	return set
+}
+
+// applyGateway turns off what assumes a WC3 client on this machine,
+// unless it was set explicitly. Games announced straight to this machine
+// never reach the LAN's clients, and neither a local client nor TUI input
+// says whether they are playing.
+func (f *configFlags) applyGateway(cfg *config.Config) {
+	if !f.isSet("direct") {
+		cfg.DirectConnect = config.DirectConnectOff
+	}
+
+	if !f.isSet("wine") {
+		cfg.Wine = config.WineOff
+	}
+
+	if !f.isSet("idle-timeout") {
+		cfg.IdleTimeout = 0
+	}
+
+	if !f.isSet("idle-refresh-interval") {
+		cfg.IdleRefresh = 0
+	}
+}
+
+// addCompatGroup parses and records a -compat flag value.
+func (f *configFlags) addCompatGroup(s string) error {
+	group, err := config.ParseCompatGroup(s)
+	if err != nil {
+		return err
+	}
+
+	f.compatGroups = append(f.compatGroups, group)
+
+	return nil
+}
+
+// apply resolves derived values and returns the final Config.
+func (f *configFlags) apply() (*config.Config, error) {
+	// Version 0 probes for every version until a local game is found
+	var (
+		gameVersion uint32
+		err         error
+	)
+
+	if !strings.EqualFold(strings.TrimSpace(f.versionStr), "auto") {
+		gameVersion, err = config.ParseVersion(f.versionStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cfg := f.cfg
+	cfg.GameVersion.Version = gameVersion
+
+	// Without a -version flag or config file entry, reuse the version last
+	// selected in the TUI
+	if !f.isSet("version") {
+		ui, err := state.LoadUI()
+		if err != nil {
+			slog.Debug("failed to load TUI preferences", "error", err)
+		}
+
+		if ui.Version != 0 {
+			cfg.GameVersion.Version = ui.Version
+		}
+	}
+
+	// Without a config file the parser never ran; every flag set is ours
+	f.recordCommandLine()
+	cfg.CommandLine = f.commandLine
+
+	if cfg.Gateway {
+		f.applyGateway(cfg)
+	}
+
+	cfg.DirectConnect, err = config.ParseDirectConnect(cfg.DirectConnect)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Wine, err = config.ParseWine(cfg.Wine)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.LANPort <= 0 || cfg.LANPort > math.MaxUint16 {
+		return nil, fmt.Errorf("%w: %d", config.ErrInvalidLANPort, cfg.LANPort)
+	}
+
+	if cfg.FallbackPort < 0 || cfg.FallbackPort > math.MaxUint16 {
+		return nil, fmt.Errorf("%w: fallback port %d", config.ErrInvalidLANPort, cfg.FallbackPort)
+	}
+
+	_, err = newGameFilter(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.ProbeInterval <= 0 {
+		return nil, fmt.Errorf("%w: probe interval %s", config.ErrInvalidInterval, cfg.ProbeInterval)
+	}
+
+	if cfg.ProbeBackoff < 0 {
+		return nil, fmt.Errorf("%w: probe backoff %s", config.ErrInvalidInterval, cfg.ProbeBackoff)
+	}
+
+	if cfg.RefreshInterval <= 0 {
+		return nil, fmt.Errorf("%w: refresh interval %s", config.ErrInvalidInterval, cfg.RefreshInterval)
+	}
+
+	if cfg.ProxyPort < 0 || cfg.ProxyPort > math.MaxUint16 {
+		return nil, fmt.Errorf("%w: %d", config.ErrInvalidProxyPort, cfg.ProxyPort)
+	}
+
+	if f.history && cfg.HistoryDir == "" {
+		p, err := paths.Get()
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.HistoryDir = p.History
+	}
+
+	// Re-read the groups in case --versions-file replaced the table
+	cfg.CompatGroups = append(config.DefaultCompatGroups(), f.compatGroups...)
+
+	if f.strictVersion {
+		cfg.CompatGroups = nil
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,47 @@
+//nolint:forbidigo // CLI output uses fmt.Print
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/kradalby/wc3ts/history"
+	"github.com/kradalby/wc3ts/paths"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+func newHistoryCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	dir := fs.String("dir", "", "History directory to export (defaults to the history path from 'wc3ts paths')")
+	out := fs.String("o", ".", "Output directory for the exported files")
+	format := fs.String("format", history.FormatCSV, "Export format (csv or json)")
+
+	return &ffcli.Command{
+		Name:       "history",
+		ShortUsage: "wc3ts history [-dir path] [-o dir] [-format csv|json]",
+		ShortHelp:  "Export recorded probe, session and game history",
+		LongHelp: `Export the history recorded by 'wc3ts run -history' as one file per
+record kind: probes (peer round trip times), sessions (proxied games
+joined) and games (games discovered). CSV files open directly in a
+spreadsheet; JSON keeps the full records.`,
+		FlagSet: fs,
+		Exec: func(_ context.Context, _ []string) error {
+			if *dir == "" {
+				p, err := paths.Get()
+				if err != nil {
+					return err
+				}
+
+				*dir = p.History
+			}
+
+			written, err := history.Export(*dir, *out, *format)
+			for _, path := range written {
+				fmt.Println(path)
+			}
+
+			return err
+		},
+	}
+}
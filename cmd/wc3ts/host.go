@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os/signal"
+	"syscall"
+
+	"github.com/kradalby/wc3ts/host"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// errNoMap is returned when 'wc3ts host' is run without a map.
+var errNoMap = errors.New("no map given (use -map path/to/map.w3x)")
+
+func newHostCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("host", flag.ExitOnError)
+	flags := newConfigFlags(fs)
+	hostCfg := &host.Config{}
+
+	fs.StringVar(&hostCfg.MapPath, "map", "", "Map file to host (w3m/w3x)")
+	fs.StringVar(&hostCfg.ScriptsDir, "scripts-dir", "",
+		"Directory with the game's common.j and blizzard.j, for maps that do not embed them")
+	fs.StringVar(&hostCfg.GameName, "name", "", "Game name (default the map name)")
+	fs.StringVar(&hostCfg.HostName, "host-name", host.DefaultHostName, "Creator name shown in the game list")
+	fs.IntVar(&hostCfg.Port, "port", 0, "TCP port players join on (0 picks a free port)")
+	fs.IntVar(&hostCfg.AutoStart, "auto-start", 0,
+		"Start once this many players joined (0 waits for "+host.StartCommand+")")
+
+	return &ffcli.Command{
+		Name:       "host",
+		ShortUsage: "wc3ts host -map path [flags]",
+		ShortHelp:  "Host a game lobby headless, without a WC3 client",
+		LongHelp: `Run the proxy headless like 'wc3ts serve' and host a lobby for the given
+map. The lobby is announced to Tailscale peers like a locally hosted game.
+Any player can type ` + host.StartCommand + ` in the lobby to start once everyone
+has the map; a new lobby is hosted when the game ends.
+
+Players need the map in Maps\Download already: maps are not sent to
+players. Maps that do not embed common.j and blizzard.j need -scripts-dir
+pointing at copies extracted from the game, or clients report a
+different version of the map.`,
+		FlagSet: fs,
+		Options: flags.options(),
+		Exec: func(ctx context.Context, _ []string) error {
+			if hostCfg.MapPath == "" {
+				return errNoMap
+			}
+
+			cfg, err := flags.apply()
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+
+			hostCfg.Version = cfg.GameVersion
+
+			a := &app{
+				cfg:     cfg,
+				hostCfg: hostCfg,
+			}
+
+			return a.serve(ctx)
+		},
+	}
+}
+
+// initHost loads the map to host, if any.
+func (a *app) initHost() error {
+	if a.hostCfg == nil {
+		return nil
+	}
+
+	h, err := host.New(*a.hostCfg, a.registry)
+	if err != nil {
+		return err
+	}
+
+	a.hosted = h
+
+	return nil
+}
+
+func (a *app) runHost(ctx context.Context) {
+	err := a.hosted.Run(ctx, a.cfg.RefreshInterval)
+	if err != nil && ctx.Err() == nil {
+		slog.Error("game host error", "error", err)
+	}
+}
@@ -0,0 +1,286 @@
+//nolint:forbidigo // CLI output uses fmt.Print
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/install"
+	"github.com/kradalby/wc3ts/state"
+	"github.com/kradalby/wc3ts/tailscale"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// Init errors.
+var (
+	errConfigExists       = errors.New("config file already exists (use -force to overwrite)")
+	errNoConfigFile       = errors.New("no config file location (use -config)")
+	errUnsupportedVersion = errors.New("unsupported game version")
+)
+
+// Permissions of the config file written by init and its directory.
+const (
+	configFilePerm = 0o644
+	configDirPerm  = 0o755
+)
+
+func newInitCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	configFile := fs.String("config", defaultConfigFile(), "Config file to write")
+	force := fs.Bool("force", false, "Overwrite an existing config file")
+	yes := fs.Bool("yes", false, "Accept the detected defaults without asking")
+
+	return &ffcli.Command{
+		Name:       "init",
+		ShortUsage: "wc3ts init [flags]",
+		ShortHelp:  "Set up wc3ts interactively and write a config file",
+		LongHelp: `Walk through a first-time setup:
+
+  1. Look for Warcraft III in the usual install locations, including
+     Wine prefixes on Linux, to find its Maps directory.
+  2. Ask which game version you play.
+  3. Check that Tailscale is running and report the network health.
+  4. Write the answers to the config file read by the other commands.
+
+Press enter to accept the default shown in brackets. With -yes, all
+defaults are accepted, which is useful in scripts.`,
+		FlagSet: fs,
+		Exec: func(ctx context.Context, _ []string) error {
+			if *configFile == "" {
+				return errNoConfigFile
+			}
+
+			p := &prompter{in: bufio.NewScanner(os.Stdin), out: os.Stdout, defaults: *yes}
+
+			_, err := os.Stat(*configFile)
+			if err == nil && !*force {
+				if *yes || !p.confirm(fmt.Sprintf("%s exists, overwrite it?", *configFile)) {
+					return errConfigExists
+				}
+			}
+
+			fmt.Println("Warcraft III installation")
+
+			mapsDir := askInstallation(p)
+
+			fmt.Println("\nGame version")
+
+			version, err := askVersion(p)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println("\nTailscale")
+			checkTailscale(ctx)
+
+			err = writeInitConfig(*configFile, version, mapsDir)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("\nWrote %s\n", *configFile)
+			fmt.Println("Run 'wc3ts' to start, or edit the file to change more settings.")
+
+			return nil
+		},
+	}
+}
+
+// prompter asks questions on the terminal.
+type prompter struct {
+	in  *bufio.Scanner
+	out io.Writer
+
+	// defaults answers every question with its default.
+	defaults bool
+}
+
+// ask prints question and returns the answer, or def if the answer is
+// empty or input has ended.
+func (p *prompter) ask(question, def string) string {
+	if def != "" {
+		question += " [" + def + "]"
+	}
+
+	_, _ = fmt.Fprintf(p.out, "  %s: ", question)
+
+	if p.defaults || !p.in.Scan() {
+		_, _ = fmt.Fprintln(p.out, def)
+
+		return def
+	}
+
+	answer := strings.TrimSpace(p.in.Text())
+	if answer == "" {
+		return def
+	}
+
+	return answer
+}
+
+// confirm asks a yes or no question, defaulting to no.
+func (p *prompter) confirm(question string) bool {
+	answer := strings.ToLower(p.ask(question+" (y/n)", "n"))
+
+	return answer == "y" || answer == "yes"
+}
+
+// askInstallation reports the detected installations and returns the Maps
+// directory to use, empty if none was chosen.
+func askInstallation(p *prompter) string {
+	found := install.Find()
+
+	def := ""
+
+	switch len(found) {
+	case 0:
+		fmt.Println("  No installation found in the usual locations.")
+	default:
+		for _, inst := range found {
+			fmt.Printf("  Found %s\n", inst.Executable)
+		}
+
+		def = found[0].Dir
+	}
+
+	for {
+		dir := p.ask("Warcraft III directory (empty to skip)", def)
+		if dir == "" {
+			return ""
+		}
+
+		inst, ok := install.Detect(dir)
+		if !ok {
+			fmt.Printf("  No Warcraft III executable in %s.\n", dir)
+
+			if p.defaults {
+				return ""
+			}
+
+			def = ""
+
+			continue
+		}
+
+		if inst.MapsDir == "" {
+			fmt.Println("  The installation has no Maps directory; map checks stay disabled.")
+		}
+
+		return inst.MapsDir
+	}
+}
+
+// askVersion asks for a supported game version, defaulting to the one last
+// selected in the TUI.
+func askVersion(p *prompter) (uint32, error) {
+	supported := config.SupportedVersions()
+
+	def := config.Default().GameVersion.Version
+
+	ui, err := state.LoadUI()
+	if err == nil && ui.Version != 0 {
+		def = ui.Version
+	}
+
+	names := make([]string, 0, len(supported))
+	for _, v := range supported {
+		names = append(names, config.FormatVersion(v))
+	}
+
+	fmt.Printf("  Supported: %s\n", strings.Join(names, ", "))
+
+	for {
+		answer := p.ask("Version", config.FormatVersion(def))
+
+		v, err := config.ParseVersion(answer)
+		if err == nil && !slices.Contains(supported, v) {
+			err = fmt.Errorf("%w: %s", errUnsupportedVersion, answer)
+		}
+
+		if err == nil {
+			return v, nil
+		}
+
+		if p.defaults {
+			return 0, err
+		}
+
+		fmt.Printf("  %v\n", err)
+	}
+}
+
+// checkTailscale reports whether tailscaled is reachable and the network
+// health. Problems are printed rather than returned, as the config file is
+// useful either way.
+func checkTailscale(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+
+	discovery := tailscale.NewDiscovery(nil)
+
+	ip, err := discovery.FetchSelfIP(ctx)
+	if err != nil {
+		fmt.Printf("  Not reachable: %v\n", err)
+		fmt.Println("  Install Tailscale and log in, then run 'wc3ts doctor'.")
+
+		return
+	}
+
+	fmt.Printf("  IP:       %s\n", ip)
+
+	health, err := discovery.FetchHealth(ctx)
+	if err != nil {
+		fmt.Printf("  Network:  unknown (%v)\n", err)
+
+		return
+	}
+
+	fmt.Printf("  Network:  %s\n", health)
+
+	peers, err := discovery.FetchPeerStatus(ctx)
+	if err == nil {
+		probed := 0
+
+		for _, peer := range peers {
+			if peer.Filtered == "" {
+				probed++
+			}
+		}
+
+		fmt.Printf("  Peers:    %d of %d will be probed for games\n", probed, len(peers))
+	}
+
+	if health.UDP == tailscale.UDPBlocked {
+		fmt.Println("  UDP appears blocked; all peers will be relayed through DERP.")
+	}
+}
+
+// writeInitConfig writes the settings chosen by init in the config file
+// format, one flag per line.
+func writeInitConfig(path string, version uint32, mapsDir string) error {
+	var b strings.Builder
+
+	b.WriteString("# Written by 'wc3ts init'. One flag per line: name value.\n")
+	b.WriteString("# See 'wc3ts serve -h' for the available flags.\n\n")
+	fmt.Fprintf(&b, "version %s\n", config.FormatVersion(version))
+
+	if mapsDir != "" {
+		fmt.Fprintf(&b, "maps-dir %s\n", mapsDir)
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), configDirPerm)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, []byte(b.String()), configFilePerm)
+}
@@ -0,0 +1,243 @@
+//nolint:forbidigo // CLI output uses fmt.Print
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/control"
+	"github.com/kradalby/wc3ts/game"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// Join lookup errors.
+var (
+	errNoGame        = errors.New("game name or host counter required")
+	errGameNotFound  = errors.New("no matching game")
+	errAmbiguousGame = errors.New("several games match")
+	errGamesFailed   = errors.New("games request failed")
+)
+
+func newJoinCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("join", flag.ExitOnError)
+	controlAddr := fs.String("control-addr", "",
+		"Look the game up in the instance whose control API listens here, e.g. 127.0.0.1:6114")
+	tokenFile := fs.String("control-token-file", "", "Control API token file, if not the default")
+	launch := fs.String("launch", "", "Start this Warcraft III executable once the game is found")
+	timeout := fs.Duration("timeout", defaultListTimeout, "How long to wait for replies when discovering")
+	versionStr := fs.String("version", "26", "Game version (e.g., 26, 1.26, 27, 1.27, 28, 1.28)")
+	allVersions := fs.Bool("all-versions", false, "Discover games of every supported version")
+	charsetName := fs.String("charset", config.DefaultCharset,
+		"Charset for game names (auto, utf-8, gbk, cp949, cp1251...)")
+
+	return &ffcli.Command{
+		Name:       "join",
+		ShortUsage: "wc3ts join [flags] <game name | host counter>",
+		ShortHelp:  "Find a game and print the address WC3 joins it on",
+		LongHelp: `Look up a game by (part of) its name, its host counter or its key and
+print the address the local WC3 client connects to, then optionally start
+Warcraft III with -launch so the game is waiting in the LAN list.
+
+With -control-addr, the game is looked up in a running instance, and
+remote games are reported at its TCP proxy. Otherwise localhost and all
+Tailscale peers are probed once, as with 'wc3ts list', and remote games
+are reported at their host; run wc3ts for them to appear in WC3.
+
+Examples:
+  wc3ts join -control-addr 127.0.0.1:6114 dota
+  wc3ts join -control-addr 127.0.0.1:6114 -launch "C:\Games\Warcraft III\Frozen Throne.exe" 3`,
+		FlagSet: fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) == 0 {
+				return errNoGame
+			}
+
+			query := strings.Join(args, " ")
+
+			charset, err := game.ParseCharset(*charsetName)
+			if err != nil {
+				return err
+			}
+
+			var games []control.GameState
+
+			if *controlAddr != "" {
+				games, err = gamesFromControl(ctx, *controlAddr, *tokenFile)
+			} else {
+				games, err = discoverGameStates(ctx, *versionStr, *allVersions, *timeout)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			g, err := findGame(games, query, charset)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Game:    %s\n", charset.Decode(g.Name))
+			fmt.Printf("Host:    %s\n", gameHost(g))
+			fmt.Printf("Map:     %s\n", charset.Decode(g.Map))
+			fmt.Printf("Players: %d/%d\n", g.Players, g.Slots)
+			fmt.Printf("Join:    %s\n", g.Join)
+
+			if *controlAddr == "" && g.Source == string(game.SourceRemote) {
+				fmt.Println("\nThis is the host's address: run wc3ts so the game is listed in WC3.")
+			}
+
+			if *launch == "" {
+				return nil
+			}
+
+			return launchGame(*launch)
+		},
+	}
+}
+
+// gameHost returns the host name of a game, "localhost" for local games.
+func gameHost(g *control.GameState) string {
+	if g.Source == string(game.SourceLocal) {
+		return "localhost"
+	}
+
+	return g.Host
+}
+
+// gamesFromControl fetches the games of a running instance.
+func gamesFromControl(ctx context.Context, addr, tokenFile string) ([]control.GameState, error) {
+	ctx, cancel := context.WithTimeout(ctx, exportRequestTimeout)
+	defer cancel()
+
+	u := url.URL{Scheme: "http", Host: addr, Path: "/v1/games"}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = authorizeControl(req, tokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		return nil, fmt.Errorf("%w: %s: %s", errGamesFailed, resp.Status, body)
+	}
+
+	var games []control.GameState
+
+	err = json.NewDecoder(resp.Body).Decode(&games)
+
+	return games, err
+}
+
+// discoverGameStates probes once and converts the games like the control API.
+func discoverGameStates(
+	ctx context.Context,
+	versionStr string,
+	allVersions bool,
+	timeout time.Duration,
+) ([]control.GameState, error) {
+	v, err := config.ParseVersion(versionStr)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := config.Default()
+	cfg.GameVersion.Version = v
+	cfg.AllVersions = allVersions
+
+	found, err := discoverGames(ctx, cfg, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	games := make([]control.GameState, 0, len(found))
+
+	for i := range found {
+		g := &found[i]
+		games = append(games, control.GameState{
+			Key:         g.Key(),
+			Name:        g.Info.GameName,
+			Source:      string(g.Source),
+			Host:        g.PeerName,
+			Map:         g.Info.GameSettings.MapPath,
+			HostCounter: g.Info.HostCounter,
+			State:       g.State,
+			Players:     g.Info.SlotsUsed,
+			Slots:       g.Info.SlotsTotal,
+			Join:        g.JoinAddr(0).String(),
+		})
+	}
+
+	return games, nil
+}
+
+// findGame returns the game whose key or host counter equals query, or
+// the only game whose name contains it, ignoring case.
+func findGame(games []control.GameState, query string, charset game.Charset) (*control.GameState, error) {
+	counter, counterErr := strconv.ParseUint(query, 10, 32)
+
+	var matches []*control.GameState
+
+	for i := range games {
+		g := &games[i]
+
+		if g.Key == query || (counterErr == nil && uint64(g.HostCounter) == counter) {
+			return g, nil
+		}
+
+		if strings.Contains(strings.ToLower(charset.Decode(g.Name)), strings.ToLower(query)) {
+			matches = append(matches, g)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%w: %q", errGameNotFound, query)
+	case 1:
+		return matches[0], nil
+	}
+
+	names := make([]string, 0, len(matches))
+	for _, g := range matches {
+		names = append(names, fmt.Sprintf("%q (host counter %d)", charset.Decode(g.Name), g.HostCounter))
+	}
+
+	return nil, fmt.Errorf("%w: %s", errAmbiguousGame, strings.Join(names, ", "))
+}
+
+// launchGame starts Warcraft III without waiting for it to exit.
+func launchGame(path string) error {
+	cmd := exec.Command(path) //nolint:gosec // Path chosen by the user
+
+	err := cmd.Start()
+	if err != nil {
+		return fmt.Errorf("failed to launch Warcraft III: %w", err)
+	}
+
+	fmt.Printf("\nStarted %s; the game is listed under Local Area Network.\n", path)
+
+	return cmd.Process.Release()
+}
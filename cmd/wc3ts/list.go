@@ -0,0 +1,190 @@
+//nolint:forbidigo // CLI output uses fmt.Print
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/peer"
+	"github.com/kradalby/wc3ts/tailscale"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// defaultListTimeout is how long list waits for GameInfo replies.
+const defaultListTimeout = 3 * time.Second
+
+func newListCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	timeout := fs.Duration("timeout", defaultListTimeout, "How long to wait for replies")
+	versionStr := fs.String("version", "26", "Game version (e.g., 26, 1.26, 27, 1.27, 28, 1.28)")
+	allVersions := fs.Bool("all-versions", false, "Discover games of every supported version")
+	charsetName := fs.String("charset", config.DefaultCharset,
+		"Charset for game names (auto, utf-8, gbk, cp949, cp1251...)")
+	jsonOut := fs.Bool("json", false, "Print games as JSON")
+
+	return &ffcli.Command{
+		Name:       "list",
+		ShortUsage: "wc3ts list [flags]",
+		ShortHelp:  "Print the games on localhost and Tailscale peers",
+		LongHelp: `Probe localhost and all online Tailscale peers once, wait for replies
+and print the discovered games as a table, or as JSON with --json.
+Logs go to stderr.`,
+		FlagSet: fs,
+		Exec: func(ctx context.Context, _ []string) error {
+			v, err := config.ParseVersion(*versionStr)
+			if err != nil {
+				return err
+			}
+
+			charset, err := game.ParseCharset(*charsetName)
+			if err != nil {
+				return err
+			}
+
+			cfg := config.Default()
+			cfg.GameVersion.Version = v
+			cfg.AllVersions = *allVersions
+
+			games, err := discoverGames(ctx, cfg, *timeout)
+			if err != nil {
+				return err
+			}
+
+			entries := make([]listEntry, 0, len(games))
+			for i := range games {
+				entries = append(entries, newListEntry(&games[i], charset))
+			}
+
+			if *jsonOut {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+
+				return enc.Encode(entries)
+			}
+
+			printGameTable(entries)
+
+			return nil
+		},
+	}
+}
+
+// listEntry is a discovered game as printed by list.
+type listEntry struct {
+	Name    string `json:"name"`
+	Host    string `json:"host"`
+	PeerIP  string `json:"peerIP,omitempty"`
+	Source  string `json:"source"`
+	Map     string `json:"map"`
+	Version string `json:"version"`
+	Players uint32 `json:"players"`
+	Slots   uint32 `json:"slots"`
+	Port    uint16 `json:"port"`
+}
+
+// newListEntry converts a game, decoding names with charset.
+func newListEntry(g *game.Game, charset game.Charset) listEntry {
+	e := listEntry{
+		Name:    charset.Decode(g.Info.GameName),
+		Host:    g.PeerName,
+		Source:  string(g.Source),
+		Map:     charset.Decode(g.Info.GameSettings.MapPath),
+		Version: fmt.Sprintf("%s %s", g.Info.Product, config.FormatVersion(g.Info.Version)),
+		Players: g.Info.SlotsUsed,
+		Slots:   g.Info.SlotsTotal,
+		Port:    g.Info.GamePort,
+	}
+
+	if g.PeerIP.IsValid() {
+		e.PeerIP = g.PeerIP.String()
+	}
+
+	if g.Source == game.SourceLocal {
+		e.Host = "localhost"
+	}
+
+	return e
+}
+
+// discoverGames probes localhost and all Tailscale peers once and returns
+// the games that replied within timeout, sorted by host and name.
+func discoverGames(ctx context.Context, cfg *config.Config, timeout time.Duration) ([]game.Game, error) {
+	registry := game.NewRegistry(nil)
+
+	var manager *peer.Manager
+
+	discovery := tailscale.NewDiscovery(func(peers []tailscale.Peer) {
+		if manager != nil {
+			manager.OnPeersChanged(peers)
+		}
+	})
+
+	localIP, ipErr := discovery.FetchSelfIP(ctx)
+	if ipErr != nil {
+		slog.Warn("could not get Tailscale IP, only probing localhost", "error", ipErr)
+	}
+
+	// The ticker never fires; probes are sent on peer updates and below
+	manager, err := peer.NewManager(
+		discovery, registry, timeout, cfg.UDPReceiveBuffer, localIP, discovery.SelfIP6(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	manager.SetPort(safeUint16(cfg.LANPort))
+	manager.SetVersion(cfg.GameVersion)
+	manager.SetCompatGroups(cfg.CompatGroups)
+	manager.SetAllVersions(cfg.AllVersions)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if localIP.IsValid() {
+		go func() { _ = discovery.Run(ctx) }()
+	}
+
+	go func() { _ = manager.Run(ctx) }()
+
+	manager.Refresh()
+
+	<-ctx.Done()
+
+	games := registry.Games()
+	slices.SortFunc(games, func(a, b game.Game) int {
+		return cmp.Or(
+			cmp.Compare(a.PeerName, b.PeerName),
+			cmp.Compare(a.Info.GameName, b.Info.GameName),
+			cmp.Compare(a.Key(), b.Key()),
+		)
+	})
+
+	return games, nil
+}
+
+// printGameTable prints games as an aligned table.
+func printGameTable(entries []listEntry) {
+	if len(entries) == 0 {
+		fmt.Println("No games found.")
+
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tHOST\tPLAYERS\tMAP\tVERSION")
+
+	for _, e := range entries {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d/%d\t%s\t%s\n", e.Name, e.Host, e.Players, e.Slots, e.Map, e.Version)
+	}
+
+	_ = w.Flush()
+}
@@ -5,31 +5,179 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/paths"
+	"github.com/kradalby/wc3ts/state"
+	"github.com/peterbourgon/ff/v3"
 	"github.com/peterbourgon/ff/v3/ffcli"
 )
 
+// errInvalidLogLevel is returned when --log-level cannot be parsed.
+var errInvalidLogLevel = errors.New("invalid log level (use debug, info, warn or error)")
+
+// logLevel is the global log level shared by all subcommands and the TUI handler.
+var logLevel = new(slog.LevelVar)
+
 func main() {
+	defer state.RecoverCrash()
+
 	runCmd := newRunCommand()
 
+	fs := flag.NewFlagSet("wc3ts", flag.ExitOnError)
+	levelStr := fs.String("log-level", "info", "Log level (debug, info, warn, error)")
+	verbose := fs.Bool("v", false, "Verbose output (shorthand for --log-level debug)")
+	versionsFile := fs.String("versions-file", "", "JSON file replacing the built-in version and product table")
+	stateDir := fs.String("state-dir", "",
+		"Directory for state, logs, history and crash reports, overriding the platform default")
+	versionList := fs.String("versions", "",
+		"Versions to cycle through in the TUI and probe with -all-versions, e.g. 1.24,1.26,1.31 (default: all in the table)")
+
 	root := &ffcli.Command{
-		ShortUsage: "wc3ts <subcommand> [flags]",
+		ShortUsage: "wc3ts [--log-level level] [-v] [--state-dir dir] [--versions-file path] <subcommand> [flags]",
 		ShortHelp:  "WC3 LAN game proxy over Tailscale",
+		FlagSet:    fs,
 		Subcommands: []*ffcli.Command{
 			runCmd,
+			newServeCommand(),
+			newHostCommand(),
+			newCaptureCommand(),
+			newDaemonCommand(),
+			newServiceCommand(),
+			newAttachCommand(),
+			newWatchCommand(),
+			newListCommand(),
+			newMapsCommand(),
+			newJoinCommand(),
+			newPeersCommand(),
+			newBenchCommand(),
+			newExportCommand(),
 			newProbeCommand(),
+			newDecodeCommand(),
+			newSelftestCommand(),
+			newDoctorCommand(),
+			newInitCommand(),
+			newHistoryCommand(),
+			newPathsCommand(),
 			newVersionCommand(),
+			newSelfUpdateCommand(),
 		},
 		Exec: func(ctx context.Context, args []string) error {
 			// Default to run command when no subcommand is specified
-			return runCmd.Exec(ctx, args)
+			err := ff.Parse(runCmd.FlagSet, args, runCmd.Options...)
+			if err != nil {
+				return err
+			}
+
+			return runCmd.Exec(ctx, runCmd.FlagSet.Args())
 		},
 	}
 
-	err := root.ParseAndRun(context.Background(), os.Args[1:])
+	root.Subcommands = append(root.Subcommands, newCompletionCommand(root))
+
+	err := root.Parse(os.Args[1:])
+	if err == nil {
+		paths.SetStateDir(*stateDir)
+	}
+
+	if err == nil {
+		err = setupLogging(*levelStr, *verbose)
+	}
+
+	if err == nil {
+		err = loadVersionTable(*versionsFile)
+	}
+
+	if err == nil && *versionList != "" {
+		err = selectVersions(*versionList)
+	}
+
+	if err == nil {
+		err = root.Run(context.Background())
+	}
+
 	if err != nil && !errors.Is(err, flag.ErrHelp) {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
 }
+
+// selectVersions limits the version table to a comma-separated list.
+func selectVersions(list string) error {
+	versions, err := config.ParseVersionList(list)
+	if err != nil {
+		return err
+	}
+
+	config.SelectVersions(versions)
+
+	return nil
+}
+
+// loadVersionTable migrates legacy files and loads the version table from
+// path, or from versions.json in the config directory if path is empty and
+// that file exists.
+func loadVersionTable(path string) error {
+	p, err := paths.Get()
+	if err != nil {
+		slog.Debug("no platform directories", "error", err)
+	} else {
+		moved, err := p.Migrate()
+		for _, dst := range moved {
+			slog.Info("migrated legacy file", "path", dst)
+		}
+
+		if err != nil {
+			slog.Warn("failed to migrate legacy files", "error", err)
+		}
+
+		if path == "" {
+			path = filepath.Join(p.Config, paths.VersionsFile)
+
+			_, err = os.Stat(path)
+			if err != nil {
+				return nil //nolint:nilerr // The default file is optional
+			}
+		}
+	}
+
+	if path == "" {
+		return nil
+	}
+
+	return config.LoadVersionTable(path)
+}
+
+// setupLogging sets the global log level and installs a stderr handler
+// for subcommands that do not replace it (the TUI installs its own).
+func setupLogging(levelStr string, verbose bool) error {
+	level, err := parseLogLevel(levelStr)
+	if err != nil {
+		return err
+	}
+
+	if verbose {
+		level = slog.LevelDebug
+	}
+
+	logLevel.Set(level)
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+
+	return nil
+}
+
+// parseLogLevel parses a log level name such as "debug" or "warn".
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+
+	err := level.UnmarshalText([]byte(strings.TrimSpace(s)))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", errInvalidLogLevel, s)
+	}
+
+	return level, nil
+}
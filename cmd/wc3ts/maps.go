@@ -0,0 +1,314 @@
+//nolint:forbidigo // CLI output uses fmt.Print
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/mapfile"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// errNoMapsDir is returned when maps is run without a Maps directory.
+var errNoMapsDir = errors.New("no Maps directory given (use -maps-dir)")
+
+func newMapsCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("maps", flag.ExitOnError)
+	mapsDir := fs.String("maps-dir", config.Default().MapsDir, "Local Warcraft III Maps directory")
+	scriptsDir := fs.String("scripts-dir", "",
+		"Directory with the game's common.j and blizzard.j, for maps that do not embed them")
+	games := fs.Bool("games", false, "Compare the maps of discovered games instead of listing local maps")
+	timeout := fs.Duration("timeout", defaultListTimeout, "How long to wait for game replies with -games")
+	versionStr := fs.String("version", "26", "Game version (e.g., 26, 1.26, 27, 1.27, 28, 1.28)")
+	allVersions := fs.Bool("all-versions", false, "Discover games of every supported version")
+	charsetName := fs.String("charset", config.DefaultCharset,
+		"Charset for game names (auto, utf-8, gbk, cp949, cp1251...)")
+	jsonOut := fs.Bool("json", false, "Print results as JSON")
+
+	return &ffcli.Command{
+		Name:       "maps",
+		ShortUsage: "wc3ts maps -maps-dir dir [flags] [filter...]",
+		ShortHelp:  "List local maps and check the maps of discovered games",
+		LongHelp: `List the maps in the local Warcraft III Maps directory with their size,
+CRC-32 and the Xoro and SHA-1 hashes WC3 compares when joining a game.
+
+With -games, probe localhost and Tailscale peers like 'wc3ts list' and
+check whether the map of each discovered game exists locally and matches
+the advertised hash, so players know before joining whether they need to
+download it.
+
+The hashes cover the game's common.j and blizzard.j unless the map embeds
+its own; point -scripts-dir at a directory holding them to hash other
+maps. Without them only the map dimensions can be compared.
+
+Filters match map paths, or game and map names with -games, ignoring case.`,
+		FlagSet: fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if *mapsDir == "" {
+				return errNoMapsDir
+			}
+
+			if !*games {
+				return listMaps(*mapsDir, *scriptsDir, args, *jsonOut)
+			}
+
+			v, err := config.ParseVersion(*versionStr)
+			if err != nil {
+				return err
+			}
+
+			charset, err := game.ParseCharset(*charsetName)
+			if err != nil {
+				return err
+			}
+
+			cfg := config.Default()
+			cfg.GameVersion.Version = v
+			cfg.AllVersions = *allVersions
+
+			found, err := discoverGames(ctx, cfg, *timeout)
+			if err != nil {
+				return err
+			}
+
+			lib := mapfile.NewLibrary(*mapsDir)
+			checker := &mapChecker{scriptsDir: *scriptsDir, sums: make(map[string]*mapfile.Checksum)}
+
+			var entries []mapGameEntry
+
+			for i := range found {
+				e := checker.check(lib, &found[i], charset)
+				if matchesFilter(args, e.Game, e.Map) {
+					entries = append(entries, e)
+				}
+			}
+
+			if *jsonOut {
+				return printJSON(entries)
+			}
+
+			printMapGameTable(entries)
+
+			return nil
+		},
+	}
+}
+
+// mapEntry is a local map as printed by maps.
+type mapEntry struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	CRC  string `json:"crc"`
+
+	// Xoro and Sha1 are empty when the game scripts are not available.
+	Xoro string `json:"xoro,omitempty"`
+	Sha1 string `json:"sha1,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// listMaps prints the maps below dir whose path matches filters.
+func listMaps(dir, scriptsDir string, filters []string, jsonOut bool) error {
+	paths, err := mapfile.Scan(dir)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]mapEntry, 0, len(paths))
+
+	for _, path := range paths {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			rel = path
+		}
+
+		if !matchesFilter(filters, rel) {
+			continue
+		}
+
+		entries = append(entries, newMapEntry(path, rel, scriptsDir))
+	}
+
+	if jsonOut {
+		return printJSON(entries)
+	}
+
+	printMapTable(entries)
+
+	return nil
+}
+
+// newMapEntry hashes the map at path. Size and CRC are filled in even if
+// the game scripts are missing.
+func newMapEntry(path, rel, scriptsDir string) mapEntry {
+	e := mapEntry{Path: rel}
+
+	sum, err := mapfile.LoadChecksum(path, scriptsDir)
+	if err == nil {
+		e.Size = int64(sum.Size)
+		e.CRC = fmt.Sprintf("%08x", sum.CRC)
+		e.Xoro = fmt.Sprintf("%08x", sum.Xoro)
+		e.Sha1 = hex.EncodeToString(sum.Sha1[:])
+
+		return e
+	}
+
+	if !errors.Is(err, mapfile.ErrMissingScripts) {
+		e.Error = err.Error()
+	}
+
+	data, readErr := os.ReadFile(path)
+	if readErr != nil {
+		e.Error = readErr.Error()
+
+		return e
+	}
+
+	e.Size = int64(len(data))
+	e.CRC = fmt.Sprintf("%08x", crc32.ChecksumIEEE(data))
+
+	return e
+}
+
+// printMapTable prints local maps as an aligned table.
+func printMapTable(entries []mapEntry) {
+	if len(entries) == 0 {
+		fmt.Println("No maps found.")
+
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "PATH\tSIZE\tCRC\tXORO\tSHA1")
+
+	for _, e := range entries {
+		xoro, sha := cmp.Or(e.Xoro, "-"), cmp.Or(e.Sha1, "-")
+		if e.Error != "" {
+			sha = "error: " + e.Error
+		}
+
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", e.Path, e.Size, cmp.Or(e.CRC, "-"), xoro, sha)
+	}
+
+	_ = w.Flush()
+}
+
+// mapGameEntry is a discovered game's map as checked by maps -games.
+type mapGameEntry struct {
+	Game   string `json:"game"`
+	Host   string `json:"host"`
+	Map    string `json:"map"`
+	Local  string `json:"local,omitempty"`
+	Status string `json:"status"`
+
+	// Verified is true when the advertised hash was compared, rather than
+	// only the map dimensions.
+	Verified bool `json:"verified"`
+}
+
+// mapChecker compares advertised maps against local copies, hashing each
+// local map once.
+type mapChecker struct {
+	scriptsDir string
+	sums       map[string]*mapfile.Checksum
+}
+
+// check compares the map of g against the library.
+func (c *mapChecker) check(lib *mapfile.Library, g *game.Game, charset game.Charset) mapGameEntry {
+	settings := g.Info.GameSettings
+
+	e := mapGameEntry{
+		Game: charset.Decode(g.Info.GameName),
+		Host: newListEntry(g, charset).Host,
+		Map:  charset.Decode(settings.MapPath),
+	}
+
+	e.Local = lib.Resolve(settings.MapPath)
+	if e.Local == "" {
+		e.Status = mapfile.StatusMissing.String()
+
+		return e
+	}
+
+	sum, ok := c.sums[e.Local]
+	if !ok {
+		sum, _ = mapfile.LoadChecksum(e.Local, c.scriptsDir)
+		c.sums[e.Local] = sum
+	}
+
+	if sum == nil {
+		e.Status = lib.Check(settings).String()
+
+		return e
+	}
+
+	e.Verified = true
+	e.Status = mapfile.StatusDifferent.String()
+
+	if sum.Matches(settings) {
+		e.Status = mapfile.StatusPresent.String()
+	}
+
+	return e
+}
+
+// printMapGameTable prints checked games as an aligned table.
+func printMapGameTable(entries []mapGameEntry) {
+	if len(entries) == 0 {
+		fmt.Println("No games found.")
+
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tHOST\tMAP\tSTATUS\tLOCAL")
+
+	for _, e := range entries {
+		status := e.Status
+		if !e.Verified && e.Local != "" {
+			status += " (hash not checked)"
+		}
+
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Game, e.Host, e.Map, status, cmp.Or(e.Local, "-"))
+	}
+
+	_ = w.Flush()
+}
+
+// matchesFilter reports whether any of fields contains any filter, ignoring
+// case. No filters match everything.
+func matchesFilter(filters []string, fields ...string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+
+	for _, f := range filters {
+		for _, field := range fields {
+			if strings.Contains(strings.ToLower(field), strings.ToLower(f)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// printJSON prints v as indented JSON.
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
@@ -0,0 +1,47 @@
+//nolint:forbidigo // CLI output uses fmt.Print
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kradalby/wc3ts/paths"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+func newPathsCommand() *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "paths",
+		ShortUsage: "wc3ts paths",
+		ShortHelp:  "Show where wc3ts keeps its files",
+		LongHelp: `Show the configuration, state, cache, log, capture, history and crash
+report directories for this platform: XDG directories on Linux, ~/Library
+on macOS and %APPDATA% / %LOCALAPPDATA% on Windows. --state-dir moves
+state, logs, history and crash reports below another directory.
+
+The state directory also remembers TUI preferences, such as the game
+version last selected, which is used when -version is not given.
+
+A versions.json in the config directory replaces the built-in version
+table unless --versions-file is given. A wc3ts.conf there holds default
+flags for the commands that run the proxy, one 'flag value' per line;
+running instances re-read it when it changes or on SIGHUP. Files from the legacy ~/.wc3ts
+directory are moved to these locations on startup.`,
+		Exec: func(_ context.Context, _ []string) error {
+			p, err := paths.Get()
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Config:    %s\n", p.Config)
+			fmt.Printf("State:     %s\n", p.State)
+			fmt.Printf("Cache:     %s\n", p.Cache)
+			fmt.Printf("Logs:      %s\n", p.Logs)
+			fmt.Printf("Captures:  %s\n", p.Captures)
+			fmt.Printf("History:   %s\n", p.History)
+			fmt.Printf("Crashes:   %s\n", p.Crashes)
+
+			return nil
+		},
+	}
+}
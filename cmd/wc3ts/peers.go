@@ -0,0 +1,131 @@
+//nolint:forbidigo // CLI output uses fmt.Print
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/tailscale"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// peersTimeout bounds the netmap request to tailscaled.
+const peersTimeout = 10 * time.Second
+
+func newPeersCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("peers", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "Print peers as JSON")
+	cfg := config.Default()
+	fs.Func("peer-allow", "Only probe peers matching this hostname glob, IP, CIDR or tag:name (repeatable)",
+		cfg.AddPeerAllow)
+	fs.Func("peer-deny", "Never probe peers matching this hostname glob, IP, CIDR or tag:name (repeatable)",
+		cfg.AddPeerDeny)
+
+	return &ffcli.Command{
+		Name:       "peers",
+		ShortUsage: "wc3ts peers [--json] [--peer-allow ...] [--peer-deny ...]",
+		ShortHelp:  "List Tailscale peers and whether they are probed for games",
+		LongHelp: `List every peer in the tailnet with its IP, OS and online state. Peers
+that are offline, Mullvad exit nodes, mobile devices or have no Tailscale
+address are not probed; the STATUS column says why.
+
+Pass the -peer-allow and -peer-deny flags used with 'wc3ts run' to check
+which peers they exclude.`,
+		FlagSet: fs,
+		Exec: func(ctx context.Context, _ []string) error {
+			ctx, cancel := context.WithTimeout(ctx, peersTimeout)
+			defer cancel()
+
+			discovery := tailscale.NewDiscovery(nil)
+			discovery.SetFilter(peerFilter(cfg))
+
+			peers, err := discovery.FetchPeerStatus(ctx)
+			if err != nil {
+				return err
+			}
+
+			if *jsonOut {
+				return printPeersJSON(peers)
+			}
+
+			printPeerTable(peers)
+
+			return nil
+		},
+	}
+}
+
+// peerEntry is a peer as printed by peers --json.
+type peerEntry struct {
+	Name     string `json:"name"`
+	IP       string `json:"ip,omitempty"`
+	OS       string `json:"os"`
+	Online   bool   `json:"online"`
+	Probed   bool   `json:"probed"`
+	Filtered string `json:"filtered,omitempty"`
+}
+
+// printPeersJSON prints peers as a JSON array.
+func printPeersJSON(peers []tailscale.PeerStatus) error {
+	entries := make([]peerEntry, 0, len(peers))
+
+	for _, p := range peers {
+		e := peerEntry{
+			Name:     p.Name,
+			OS:       p.OS,
+			Online:   p.Online,
+			Probed:   p.Filtered == "",
+			Filtered: string(p.Filtered),
+		}
+
+		if p.IP.IsValid() {
+			e.IP = p.IP.String()
+		}
+
+		entries = append(entries, e)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(entries)
+}
+
+// printPeerTable prints peers as an aligned table.
+func printPeerTable(peers []tailscale.PeerStatus) {
+	if len(peers) == 0 {
+		fmt.Println("No peers found.")
+
+		return
+	}
+
+	probed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tIP\tOS\tONLINE\tSTATUS")
+
+	for _, p := range peers {
+		status := "probed"
+		if p.Filtered != "" {
+			status = "skipped: " + string(p.Filtered)
+		} else {
+			probed++
+		}
+
+		ip := "-"
+		if p.IP.IsValid() {
+			ip = p.IP.String()
+		}
+
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\n", p.Name, ip, p.OS, p.Online, status)
+	}
+
+	_ = w.Flush()
+
+	fmt.Printf("\n%d of %d peer(s) probed.\n", probed, len(peers))
+}
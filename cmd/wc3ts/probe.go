@@ -3,13 +3,23 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"maps"
 	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/packet"
 	"github.com/nielsAD/gowarcraft3/network"
 	"github.com/nielsAD/gowarcraft3/protocol"
 	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
@@ -19,19 +29,23 @@ import (
 // Silence unused import warning - network is used for W3GSPacketConn.
 var _ = network.W3GSPacketConn{}
 
-// Errors for the probe command.
-var (
-	errNoHosts        = errors.New("at least one host required")
-	errUnknownProduct = errors.New("unknown product (use W3XP or WAR3)")
-	errPacketTooShort = errors.New("packet too short")
-	errNotGameInfo    = errors.New("not a GameInfo packet")
-)
+// errNoHosts is returned when probe is run without hosts.
+var errNoHosts = errors.New("at least one host required")
 
 func newProbeCommand() *ffcli.Command {
 	fs := flag.NewFlagSet("probe", flag.ExitOnError)
 	timeout := fs.Duration("timeout", 5*time.Second, "Response timeout")
 	versionStr := fs.String("version", "26", "Game version (e.g., 26, 1.26, 27, 1.27, 28, 1.28)")
 	product := fs.String("product", "W3XP", "Product code (W3XP for TFT, WAR3 for ROC)")
+	port := fs.Int("port", config.DefaultLANPort, "UDP port to probe, for modified clients that do not use 6112")
+	charsetName := fs.String("charset", config.DefaultCharset,
+		"Charset for game names (auto, utf-8, gbk, cp949, cp1251...)")
+	dump := fs.Bool("dump", false, "Print each response as annotated hex")
+	outFile := fs.String("o", "", "Append raw responses to this file")
+	watch := fs.Bool("watch", false, "Keep probing and print games as they appear, change and disappear")
+	interval := fs.Duration("interval", 2*time.Second, "Time between probes with -watch")
+	allVersions := fs.Bool("all-versions", false,
+		"Probe every supported version of every product and report which ones each host answers")
 
 	return &ffcli.Command{
 		Name:       "probe",
@@ -46,7 +60,19 @@ Examples:
   wc3ts probe 100.64.0.1                 # Probe a Tailscale peer
   wc3ts probe 192.168.1.10 192.168.1.11  # Probe multiple hosts
   wc3ts probe -version 1.28 127.0.0.1    # Use WC3 1.28
-  wc3ts probe -version 27 127.0.0.1      # Use WC3 1.27`,
+  wc3ts probe -version 27 127.0.0.1      # Use WC3 1.27
+  wc3ts probe -dump -o game.bin 10.0.0.5 # Hex dump and save raw responses
+  wc3ts probe -watch 10.0.0.5            # Print changes until interrupted
+  wc3ts probe -all-versions 100.64.0.1   # Find out which patch a host runs
+  wc3ts probe -port 6113 10.0.0.5        # Probe a client on a non-standard port
+
+With -watch, hosts are probed every interval and each game is printed
+with + when it appears, ~ when its name, map or players change and -
+when a probe goes unanswered.
+
+With -all-versions, a SearchGame is sent for every product and every
+version in the version table (built in, or from --versions-file), and
+the versions each host answered are summarised at the end.`,
 		FlagSet: fs,
 		Exec: func(ctx context.Context, args []string) error {
 			if len(args) == 0 {
@@ -60,30 +86,74 @@ Examples:
 			}
 
 			// Parse product code
-			var prod protocol.DWordString
-
-			switch *product {
-			case "W3XP", "TFT":
-				prod = w3gs.ProductTFT
-			case "WAR3", "ROC":
-				prod = w3gs.ProductROC
-			default:
-				return fmt.Errorf("%w: %s", errUnknownProduct, *product)
+			prod, err := config.ParseProduct(*product)
+			if err != nil {
+				return err
+			}
+
+			charset, err := game.ParseCharset(*charsetName)
+			if err != nil {
+				return err
+			}
+
+			opts := probeOptions{
+				timeout: *timeout,
+				port:    *port,
+				product: prod,
+				version: version,
+				charset: charset,
+				dump:    *dump,
+				watch:   *watch,
+
+				allVersions: *allVersions,
 			}
 
-			return probeHosts(ctx, args, *timeout, prod, version)
+			if *watch {
+				opts.timeout = *interval
+			}
+
+			if *outFile != "" {
+				f, err := os.OpenFile(*outFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+				if err != nil {
+					return fmt.Errorf("failed to open capture file: %w", err)
+				}
+
+				defer func() { _ = f.Close() }()
+
+				opts.capture = f
+			}
+
+			return probeHosts(ctx, args, opts)
 		},
 	}
 }
 
-func probeHosts(
-	ctx context.Context,
-	hosts []string,
-	timeout time.Duration,
-	product protocol.DWordString,
-	version uint32,
-) error {
-	conn, err := net.ListenUDP("udp4", nil)
+// probeOptions controls how probe sends queries and reports responses.
+type probeOptions struct {
+	timeout time.Duration
+	port    int
+	product protocol.DWordString
+	version uint32
+	charset game.Charset
+
+	// dump prints every response as annotated hex.
+	dump bool
+
+	// watch keeps probing every timeout and prints changes.
+	watch bool
+
+	// allVersions probes every supported version and product instead of
+	// only product and version.
+	allVersions bool
+
+	// capture receives the raw bytes of every W3GS response, if set.
+	// Packets are written back to back; W3GS framing makes them self-delimiting.
+	capture io.Writer
+}
+
+func probeHosts(ctx context.Context, hosts []string, opts probeOptions) error {
+	// Dual-stack, so hosts on IPv6-only tailnets can be probed too
+	conn, err := net.ListenUDP("udp", nil)
 	if err != nil {
 		return fmt.Errorf("failed to create socket: %w", err)
 	}
@@ -93,41 +163,76 @@ func probeHosts(
 	w3gsConn := &network.W3GSPacketConn{}
 	w3gsConn.SetConn(conn, w3gs.NewFactoryCache(w3gs.DefaultFactory), w3gs.Encoding{})
 
-	searchGame := &w3gs.SearchGame{
-		GameVersion: w3gs.GameVersion{
-			Product: product,
-			Version: version,
-		},
-		HostCounter: 1,
+	searches := probeSearches(opts)
+
+	if opts.allVersions {
+		fmt.Printf("Probing with: %d versions of %s\n\n",
+			len(config.SupportedVersions()), strings.Join(config.Versions().ProductCodes(), ", "))
+	} else {
+		fmt.Printf("Probing with: Product=%s Version=1.%d\n\n", opts.product, opts.version)
+	}
+
+	addrs := make([]*net.UDPAddr, 0, len(hosts))
+
+	for _, host := range hosts {
+		if addr := resolveHost(ctx, host, opts.port); addr != nil {
+			addrs = append(addrs, addr)
+		}
 	}
 
-	fmt.Printf("Probing with: Product=%s Version=1.%d\n\n", product, version)
+	if opts.watch {
+		return watchHosts(ctx, conn, w3gsConn, addrs, searches, opts)
+	}
 
-	sendSearchToHosts(ctx, hosts, w3gsConn, searchGame)
+	sendSearchToHosts(w3gsConn, addrs, searches, true)
 
-	return receiveResponses(conn, timeout)
+	return receiveResponses(conn, opts)
 }
 
-func sendSearchToHosts(ctx context.Context, hosts []string, w3gsConn *network.W3GSPacketConn, pkt *w3gs.SearchGame) {
-	for _, host := range hosts {
-		addr := resolveHost(ctx, host)
-		if addr == nil {
-			continue
+// probeSearches returns the SearchGame packets to send: one for the
+// selected version, or one per product and supported version.
+func probeSearches(opts probeOptions) []*w3gs.SearchGame {
+	if !opts.allVersions {
+		return []*w3gs.SearchGame{{
+			GameVersion: w3gs.GameVersion{Product: opts.product, Version: opts.version},
+			HostCounter: 1,
+		}}
+	}
+
+	var searches []*w3gs.SearchGame
+
+	for _, v := range config.AllGameVersions() {
+		searches = append(searches, &w3gs.SearchGame{
+			GameVersion: v,
+			HostCounter: uint32(len(searches) + 1), //nolint:gosec // Bounded by the version table
+		})
+	}
+
+	return searches
+}
+
+// sendSearchToHosts sends pkts to every address, reporting each if verbose.
+func sendSearchToHosts(w3gsConn *network.W3GSPacketConn, addrs []*net.UDPAddr, pkts []*w3gs.SearchGame, verbose bool) {
+	for _, addr := range addrs {
+		if verbose {
+			fmt.Printf("Sending %d SearchGame packet(s) to %s...\n", len(pkts), addr)
 		}
 
-		fmt.Printf("Sending SearchGame to %s...\n", addr)
+		for _, pkt := range pkts {
+			_, err := w3gsConn.Send(addr, pkt)
+			if err != nil {
+				fmt.Printf("  Error sending to %s: %v\n", addr, err)
 
-		_, err := w3gsConn.Send(addr, pkt)
-		if err != nil {
-			fmt.Printf("  Error: %v\n", err)
+				break
+			}
 		}
 	}
 }
 
-func resolveHost(ctx context.Context, host string) *net.UDPAddr {
+func resolveHost(ctx context.Context, host string, port int) *net.UDPAddr {
 	addr := &net.UDPAddr{
 		IP:   net.ParseIP(host),
-		Port: 6112,
+		Port: port,
 	}
 
 	if addr.IP == nil {
@@ -140,6 +245,7 @@ func resolveHost(ctx context.Context, host string) *net.UDPAddr {
 			return nil
 		}
 
+		// Prefer IPv4, the only family WC3 itself speaks
 		for _, ip := range ips {
 			if ip4 := ip.IP.To4(); ip4 != nil {
 				addr.IP = ip4
@@ -147,10 +253,14 @@ func resolveHost(ctx context.Context, host string) *net.UDPAddr {
 				break
 			}
 		}
+
+		if addr.IP == nil && len(ips) > 0 {
+			addr.IP = ips[0].IP
+		}
 	}
 
 	if addr.IP == nil {
-		fmt.Printf("No IPv4 address for %s\n", host)
+		fmt.Printf("No address for %s\n", host)
 
 		return nil
 	}
@@ -158,16 +268,20 @@ func resolveHost(ctx context.Context, host string) *net.UDPAddr {
 	return addr
 }
 
-func receiveResponses(conn *net.UDPConn, timeout time.Duration) error {
-	fmt.Printf("\nWaiting for responses (timeout: %s)...\n\n", timeout)
+func receiveResponses(conn *net.UDPConn, opts probeOptions) error {
+	fmt.Printf("\nWaiting for responses (timeout: %s)...\n\n", opts.timeout)
 
-	err := conn.SetReadDeadline(time.Now().Add(timeout))
+	err := conn.SetReadDeadline(time.Now().Add(opts.timeout))
 	if err != nil {
 		return fmt.Errorf("failed to set deadline: %w", err)
 	}
 
 	gamesFound := 0
-	buf := make([]byte, 4096)
+	buf := make([]byte, packet.MaxDatagramSize)
+
+	// Hosts answer every matching search; report each game and version once
+	seen := make(map[string]bool)
+	answered := make(map[string][]w3gs.GameVersion)
 
 	for {
 		n, from, err := conn.ReadFromUDP(buf)
@@ -180,47 +294,101 @@ func receiveResponses(conn *net.UDPConn, timeout time.Duration) error {
 			return fmt.Errorf("read error: %w", err)
 		}
 
-		gamesFound += handlePacket(buf[:n], from)
+		gameInfo := handlePacket(buf[:n], from, opts)
+		if gameInfo == nil {
+			continue
+		}
+
+		key := fmt.Sprintf("%s/%d/%s/%d", from, gameInfo.HostCounter, gameInfo.Product, gameInfo.Version)
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+
+		printGameInfo(gameInfo, from, opts.charset)
+
+		gamesFound++
+
+		if !slices.Contains(answered[from.String()], gameInfo.GameVersion) {
+			answered[from.String()] = append(answered[from.String()], gameInfo.GameVersion)
+		}
 	}
 
 	printSummary(gamesFound)
 
+	if opts.allVersions {
+		printAnswered(answered)
+	}
+
 	return nil
 }
 
-func handlePacket(data []byte, from *net.UDPAddr) int {
-	if len(data) < 4 || data[0] != 0xF7 {
+// printAnswered prints the versions each host's games announced.
+func printAnswered(answered map[string][]w3gs.GameVersion) {
+	if len(answered) == 0 {
+		return
+	}
+
+	fmt.Println("\nVersions answered:")
+
+	for _, host := range slices.Sorted(maps.Keys(answered)) {
+		versions := make([]string, 0, len(answered[host]))
+		for _, v := range answered[host] {
+			versions = append(versions, fmt.Sprintf("%s %s", v.Product, config.FormatVersion(v.Version)))
+		}
+
+		fmt.Printf("  %-21s %s\n", host, strings.Join(versions, ", "))
+	}
+}
+
+// handlePacket reports a received packet and returns it if it is a valid GameInfo.
+func handlePacket(data []byte, from *net.UDPAddr, opts probeOptions) *w3gs.GameInfo {
+	packetID := packet.ID(data)
+	if packetID == 0 {
 		fmt.Printf("Received non-W3GS data from %s (%d bytes)\n", from, len(data))
 
-		return 0
+		if opts.dump {
+			dumpPacket(data)
+		}
+
+		return nil
 	}
 
-	packetID := data[1]
 	fmt.Printf("Received W3GS packet 0x%02X from %s (%d bytes)\n", packetID, from, len(data))
 
-	if packetID != 0x30 { // Not GameInfo
-		return 0
+	if opts.dump {
+		dumpPacket(data)
 	}
 
-	gameInfo, err := parseGameInfo(data)
+	if opts.capture != nil {
+		_, err := opts.capture.Write(data)
+		if err != nil {
+			fmt.Printf("  Failed to write capture: %v\n", err)
+		}
+	}
+
+	if packetID != packet.IDGameInfo {
+		return nil
+	}
+
+	gameInfo, err := packet.ParseGameInfo(data)
 	if err != nil {
 		fmt.Printf("  Failed to parse: %v\n", err)
 		fmt.Printf("  Raw: %x\n", data)
 
-		return 0
+		return nil
 	}
 
-	printGameInfo(gameInfo, from)
-
-	return 1
+	return gameInfo
 }
 
-func printGameInfo(gi *w3gs.GameInfo, from *net.UDPAddr) {
+func printGameInfo(gi *w3gs.GameInfo, from *net.UDPAddr, charset game.Charset) {
 	fmt.Println()
 	fmt.Printf("=== Game Found ===\n")
 	fmt.Printf("  From:     %s\n", from)
-	fmt.Printf("  Name:     %s\n", gi.GameName)
-	fmt.Printf("  Map:      %s\n", gi.GameSettings.MapPath)
+	fmt.Printf("  Name:     %s\n", charset.Decode(gi.GameName))
+	fmt.Printf("  Map:      %s\n", charset.Decode(gi.GameSettings.MapPath))
 	fmt.Printf("  Players:  %d/%d\n", gi.SlotsUsed, gi.SlotsTotal)
 	fmt.Printf("  Port:     %d\n", gi.GamePort)
 	fmt.Printf("  Version:  %s 1.%d\n", gi.Product, gi.Version)
@@ -228,6 +396,20 @@ func printGameInfo(gi *w3gs.GameInfo, from *net.UDPAddr) {
 	fmt.Println()
 }
 
+// dumpPacket prints the W3GS header fields followed by a hex dump of data.
+func dumpPacket(data []byte) {
+	if len(data) >= packet.HeaderSize {
+		fmt.Printf("  Header:   sig=0x%02X id=0x%02X (%s) len=%d\n",
+			data[0], data[1], packet.Name(data[1]), packet.Length(data))
+	}
+
+	for line := range strings.Lines(hex.Dump(data)) {
+		fmt.Printf("  %s", line)
+	}
+
+	fmt.Println()
+}
+
 func printSummary(count int) {
 	if count == 0 {
 		fmt.Println("No games found.")
@@ -236,23 +418,141 @@ func printSummary(count int) {
 	}
 }
 
-// parseGameInfo parses a raw GameInfo packet.
-func parseGameInfo(data []byte) (*w3gs.GameInfo, error) {
-	if len(data) < 4 {
-		return nil, errPacketTooShort
+// watchedGame is a game seen during a watch round.
+type watchedGame struct {
+	info *w3gs.GameInfo
+	from *net.UDPAddr
+}
+
+// label describes the game for watch output.
+func (g watchedGame) label(charset game.Charset) string {
+	return fmt.Sprintf("%q from %s", charset.Decode(g.info.GameName), g.from)
+}
+
+// watchHosts probes addrs every opts.timeout until the context is
+// cancelled, printing games as they appear, change and disappear.
+func watchHosts(
+	ctx context.Context,
+	conn *net.UDPConn,
+	w3gsConn *network.W3GSPacketConn,
+	addrs []*net.UDPAddr,
+	pkts []*w3gs.SearchGame,
+	opts probeOptions,
+) error {
+	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	// Unblock the read of the current round on interrupt
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	fmt.Printf("Watching %d host(s) every %s (Ctrl-C to stop)...\n\n", len(addrs), opts.timeout)
+
+	previous := map[string]watchedGame{}
+
+	for ctx.Err() == nil {
+		sendSearchToHosts(w3gsConn, addrs, pkts, false)
+
+		current, err := collectGames(ctx, conn, opts)
+		if err != nil {
+			return err
+		}
+
+		printWatchDiff(previous, current, opts.charset)
+		previous = current
 	}
 
-	decoder := w3gs.NewDecoder(w3gs.Encoding{}, w3gs.NewFactoryCache(w3gs.DefaultFactory))
+	return nil
+}
 
-	pkt, _, err := decoder.Deserialize(data)
+// collectGames reads GameInfo replies for one round of opts.timeout.
+func collectGames(ctx context.Context, conn *net.UDPConn, opts probeOptions) (map[string]watchedGame, error) {
+	err := conn.SetReadDeadline(time.Now().Add(opts.timeout))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to set deadline: %w", err)
+	}
+
+	games := map[string]watchedGame{}
+	buf := make([]byte, packet.MaxDatagramSize)
+
+	for ctx.Err() == nil {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+
+			return nil, fmt.Errorf("read error: %w", err)
+		}
+
+		data := buf[:n]
+
+		if opts.dump {
+			dumpPacket(data)
+		}
+
+		if opts.capture != nil {
+			_, err = opts.capture.Write(data)
+			if err != nil {
+				fmt.Printf("  Failed to write capture: %v\n", err)
+			}
+		}
+
+		info, err := packet.ParseGameInfo(data)
+		if err != nil {
+			continue
+		}
+
+		key := fmt.Sprintf("%s/%d", from, info.HostCounter)
+		games[key] = watchedGame{info: info, from: from}
 	}
 
-	gi, ok := pkt.(*w3gs.GameInfo)
-	if !ok {
-		return nil, errNotGameInfo
+	return games, nil
+}
+
+// printWatchDiff prints the games that appeared, changed or disappeared
+// between two rounds.
+func printWatchDiff(previous, current map[string]watchedGame, charset game.Charset) {
+	now := time.Now().Format(time.TimeOnly)
+
+	for _, key := range slices.Sorted(maps.Keys(current)) {
+		g := current[key]
+
+		old, ok := previous[key]
+		if !ok {
+			fmt.Printf("%s + %s: %s, %d/%d players, %s 1.%d, host counter %d\n",
+				now, g.label(charset), charset.Decode(g.info.GameSettings.MapPath),
+				g.info.SlotsUsed, g.info.SlotsTotal, g.info.Product, g.info.Version, g.info.HostCounter)
+
+			continue
+		}
+
+		var changes []string
+
+		if old.info.GameName != g.info.GameName {
+			changes = append(changes, fmt.Sprintf("name %q -> %q",
+				charset.Decode(old.info.GameName), charset.Decode(g.info.GameName)))
+		}
+
+		if old.info.GameSettings.MapPath != g.info.GameSettings.MapPath {
+			changes = append(changes, fmt.Sprintf("map %s -> %s",
+				charset.Decode(old.info.GameSettings.MapPath), charset.Decode(g.info.GameSettings.MapPath)))
+		}
+
+		if old.info.SlotsUsed != g.info.SlotsUsed || old.info.SlotsTotal != g.info.SlotsTotal {
+			changes = append(changes, fmt.Sprintf("players %d/%d -> %d/%d",
+				old.info.SlotsUsed, old.info.SlotsTotal, g.info.SlotsUsed, g.info.SlotsTotal))
+		}
+
+		if len(changes) > 0 {
+			fmt.Printf("%s ~ %s: %s\n", now, g.label(charset), strings.Join(changes, ", "))
+		}
 	}
 
-	return gi, nil
+	for _, key := range slices.Sorted(maps.Keys(previous)) {
+		if _, ok := current[key]; !ok {
+			fmt.Printf("%s - %s\n", now, previous[key].label(charset))
+		}
+	}
 }
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/tui"
+	"github.com/peterbourgon/ff/v3"
+)
+
+// configCheckInterval is how often the config file is checked for changes.
+const configCheckInterval = 2 * time.Second
+
+// reloadable lists the settings that take effect without a restart. Each
+// is read from the config file unless one of its flags was given on the
+// command line. Settings not listed here need a restart.
+//
+// Reloaded settings reach the running services only through apply. The
+// app's config is read concurrently and never changes after startup; set
+// records a setting on the reload loop's own copy.
+var reloadable = []struct {
+	flags []string
+	get   func(cfg *config.Config) any
+	set   func(dst, src *config.Config)
+	apply func(a *app, cfg *config.Config)
+}{
+	{
+		flags: []string{"version"},
+		get:   func(cfg *config.Config) any { return cfg.GameVersion },
+		set:   func(dst, src *config.Config) { dst.GameVersion = src.GameVersion },
+		apply: func(a *app, cfg *config.Config) {
+			a.useVersion(cfg.GameVersion)
+			a.send(tui.VersionMsg{Version: cfg.GameVersion})
+		},
+	},
+	{
+		flags: []string{"compat", "strict-version"},
+		get:   func(cfg *config.Config) any { return cfg.CompatGroups },
+		set:   func(dst, src *config.Config) { dst.CompatGroups = src.CompatGroups },
+		apply: func(a *app, cfg *config.Config) {
+			a.peerManager.SetCompatGroups(cfg.CompatGroups)
+			a.broadcaster.SetCompatGroups(cfg.CompatGroups)
+		},
+	},
+	{
+		flags: []string{"all-versions"},
+		get:   func(cfg *config.Config) any { return cfg.AllVersions },
+		set:   func(dst, src *config.Config) { dst.AllVersions = src.AllVersions },
+		apply: func(a *app, cfg *config.Config) { a.peerManager.SetAllVersions(cfg.AllVersions) },
+	},
+	{
+		flags: []string{"version-tags"},
+		get:   func(cfg *config.Config) any { return cfg.VersionTags },
+		set:   func(dst, src *config.Config) { dst.VersionTags = src.VersionTags },
+		apply: func(a *app, cfg *config.Config) { a.broadcaster.SetVersionTags(cfg.VersionTags) },
+	},
+	{
+		flags: []string{"latency-tags"},
+		get:   func(cfg *config.Config) any { return cfg.LatencyTags },
+		set:   func(dst, src *config.Config) { dst.LatencyTags = src.LatencyTags },
+		apply: func(a *app, cfg *config.Config) { a.broadcaster.SetLatencyTags(cfg.LatencyTags) },
+	},
+	{
+		flags: []string{"probe-interval"},
+		get:   func(cfg *config.Config) any { return cfg.ProbeInterval },
+		set:   func(dst, src *config.Config) { dst.ProbeInterval = src.ProbeInterval },
+		apply: func(a *app, cfg *config.Config) { a.peerManager.SetProbeInterval(cfg.ProbeInterval) },
+	},
+	{
+		flags: []string{"probe-backoff"},
+		get:   func(cfg *config.Config) any { return cfg.ProbeBackoff },
+		set:   func(dst, src *config.Config) { dst.ProbeBackoff = src.ProbeBackoff },
+		apply: func(a *app, cfg *config.Config) { a.peerManager.SetProbeBackoff(cfg.ProbeBackoff) },
+	},
+	{
+		flags: []string{"refresh-interval"},
+		get:   func(cfg *config.Config) any { return cfg.RefreshInterval },
+		set:   func(dst, src *config.Config) { dst.RefreshInterval = src.RefreshInterval },
+		apply: func(a *app, cfg *config.Config) { a.broadcaster.SetInterval(cfg.RefreshInterval) },
+	},
+	{
+		flags: []string{"idle-refresh-interval"},
+		get:   func(cfg *config.Config) any { return cfg.IdleRefresh },
+		set:   func(dst, src *config.Config) { dst.IdleRefresh = src.IdleRefresh },
+		apply: func(a *app, cfg *config.Config) { a.broadcaster.SetIdleInterval(cfg.IdleRefresh) },
+	},
+	{
+		flags: []string{"broadcast-pace"},
+		get:   func(cfg *config.Config) any { return cfg.BroadcastPace },
+		set:   func(dst, src *config.Config) { dst.BroadcastPace = src.BroadcastPace },
+		apply: func(a *app, cfg *config.Config) { a.broadcaster.SetPace(cfg.BroadcastPace) },
+	},
+	{
+		flags: []string{"game-timeout"},
+		get:   func(cfg *config.Config) any { return cfg.GameTimeout },
+		set:   func(dst, src *config.Config) { dst.GameTimeout = src.GameTimeout },
+		apply: func(a *app, cfg *config.Config) { a.peerManager.SetGameTimeout(cfg.GameTimeout) },
+	},
+	{
+		flags: []string{"peer-allow", "peer-deny"},
+		get:   func(cfg *config.Config) any { return peerFilter(cfg) },
+		set: func(dst, src *config.Config) {
+			dst.PeerAllow = src.PeerAllow
+			dst.PeerDeny = src.PeerDeny
+		},
+		apply: func(a *app, cfg *config.Config) { a.discovery.SetFilter(peerFilter(cfg)) },
+	},
+	{
+		flags: []string{"static-host"},
+		get:   func(cfg *config.Config) any { return cfg.StaticHosts },
+		set:   func(dst, src *config.Config) { dst.StaticHosts = src.StaticHosts },
+		apply: func(a *app, cfg *config.Config) { a.peerManager.SetStaticHosts(cfg.StaticHosts) },
+	},
+	{
+		flags: []string{"probe-subnet"},
+		get:   func(cfg *config.Config) any { return cfg.ProbeSubnets },
+		set:   func(dst, src *config.Config) { dst.ProbeSubnets = src.ProbeSubnets },
+		apply: func(a *app, cfg *config.Config) { a.peerManager.SetProbeSubnets(cfg.ProbeSubnets) },
+	},
+	{
+		flags: []string{"reach-check"},
+		get:   func(cfg *config.Config) any { return cfg.ReachCheck },
+		set:   func(dst, src *config.Config) { dst.ReachCheck = src.ReachCheck },
+		apply: func(a *app, cfg *config.Config) { a.peerManager.SetReachCheck(cfg.ReachCheck) },
+	},
+	{
+		flags: []string{"announce-to"},
+		get:   func(cfg *config.Config) any { return cfg.AnnounceTargets },
+		set:   func(dst, src *config.Config) { dst.AnnounceTargets = src.AnnounceTargets },
+		apply: func(a *app, cfg *config.Config) { a.broadcaster.SetAnnounceTargets(cfg.AnnounceTargets) },
+	},
+	{
+		flags: []string{"peer-addr"},
+		get:   func(cfg *config.Config) any { return cfg.PeerAddrs },
+		set:   func(dst, src *config.Config) { dst.PeerAddrs = src.PeerAddrs },
+		apply: func(a *app, cfg *config.Config) { a.peerManager.SetPeerAddrs(cfg.PeerAddrs) },
+	},
+	{
+		flags: []string{"peer-names", "name-template"},
+		get:   func(cfg *config.Config) any { return nameTemplate(cfg) },
+		set: func(dst, src *config.Config) {
+			dst.ShowPeerNames = src.ShowPeerNames
+			dst.NameTemplate = src.NameTemplate
+		},
+		apply: func(a *app, cfg *config.Config) { a.broadcaster.SetNameTemplate(nameTemplate(cfg)) },
+	},
+	{
+		flags: []string{"game-allow", "game-block"},
+		get:   func(cfg *config.Config) any { return [][]string{cfg.GameAllow, cfg.GameBlock} },
+		set: func(dst, src *config.Config) {
+			dst.GameAllow = src.GameAllow
+			dst.GameBlock = src.GameBlock
+		},
+		apply: func(a *app, cfg *config.Config) {
+			filter, err := newGameFilter(cfg)
+			if err != nil {
+				slog.Warn("ignoring invalid game filter", "error", err)
+
+				return
+			}
+
+			a.gameFilter.Store(filter)
+			a.onGamesChanged(a.registry.Games())
+		},
+	},
+	{
+		flags: []string{"throttle", "throttle-total"},
+		get:   func(cfg *config.Config) any { return [2]int{cfg.Throttle, cfg.ThrottleTotal} },
+		set: func(dst, src *config.Config) {
+			dst.Throttle = src.Throttle
+			dst.ThrottleTotal = src.ThrottleTotal
+		},
+		apply: func(a *app, cfg *config.Config) { a.tcpProxy.SetThrottle(cfg.Throttle, cfg.ThrottleTotal) },
+	},
+	{
+		flags: []string{"idle-timeout"},
+		get:   func(cfg *config.Config) any { return cfg.IdleTimeout },
+		set:   func(dst, src *config.Config) { dst.IdleTimeout = src.IdleTimeout },
+		apply: func(a *app, cfg *config.Config) { a.peerManager.SetIdleTimeout(cfg.IdleTimeout) },
+	},
+}
+
+// readConfigFile reads the config file of cfg on top of the defaults.
+func readConfigFile(cfg *config.Config) (*config.Config, error) {
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	flags := newConfigFlags(fs)
+
+	err := ff.Parse(fs, []string{"-config", cfg.ConfigFile}, flags.options()...)
+	if err != nil {
+		return nil, err
+	}
+
+	return flags.apply()
+}
+
+// runConfigReload re-reads the config file when it changes or on SIGHUP.
+func (a *app) runConfigReload(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(configCheckInterval)
+	defer ticker.Stop()
+
+	last := configFileStamp(a.cfg.ConfigFile)
+
+	// The settings as last applied, owned by this loop
+	applied := *a.cfg
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("reloading configuration on SIGHUP", "file", a.cfg.ConfigFile)
+		case <-ticker.C:
+			stamp := configFileStamp(a.cfg.ConfigFile)
+			if stamp == last {
+				continue
+			}
+
+			slog.Info("config file changed, reloading", "file", a.cfg.ConfigFile)
+		}
+
+		last = configFileStamp(a.cfg.ConfigFile)
+
+		a.reloadConfig(&applied)
+	}
+}
+
+// configFileStamp identifies a version of the config file; missing files
+// have the zero stamp.
+func configFileStamp(path string) time.Time {
+	st, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return st.ModTime()
+}
+
+// reloadConfig applies the reloadable settings that changed in the config
+// file since applied, and records them there. Active proxy sessions are
+// not affected.
+func (a *app) reloadConfig(applied *config.Config) {
+	next, err := readConfigFile(applied)
+	if err != nil {
+		slog.Warn("config reload failed, keeping the current configuration", "error", err)
+
+		return
+	}
+
+	var changed []string
+
+	for _, setting := range reloadable {
+		pinned := slices.ContainsFunc(setting.flags, func(name string) bool {
+			return slices.Contains(applied.CommandLine, name)
+		})
+
+		if pinned || reflect.DeepEqual(setting.get(applied), setting.get(next)) {
+			continue
+		}
+
+		// Settings changed elsewhere, such as the version in the TUI, are
+		// only overridden when the file changes them again
+		setting.set(applied, next)
+		setting.apply(a, applied)
+
+		changed = append(changed, setting.flags[0])
+	}
+
+	if len(changed) == 0 {
+		slog.Info("configuration reloaded, nothing changed")
+
+		return
+	}
+
+	slog.Info("configuration reloaded", "changed", changed)
+	a.send(tui.NoticeMsg{Text: "Configuration reloaded"})
+}
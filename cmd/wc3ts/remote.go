@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+
+	"github.com/kradalby/wc3ts/ipc"
+	"github.com/kradalby/wc3ts/tui"
+)
+
+// errAttachDenied is returned for remote TUIs not on the attach allowlist.
+var errAttachDenied = errors.New("not allowed to attach")
+
+// newIPC creates the server attached TUIs connect to, wired to the app.
+func (a *app) newIPC() *ipc.Server {
+	a.ipc = ipc.NewServer(ipc.Handlers{
+		SetVersion: a.selectVersion,
+		Refresh:    a.refresh,
+		Pause:      a.togglePause,
+		SetPrivate: a.setPrivate,
+		PeerAction: a.peerAction,
+	})
+	a.ipc.SetVersion(a.cfg.GameVersion)
+
+	return a.ipc
+}
+
+// logHandler returns base, also sending logs to attached TUIs if any.
+func (a *app) logHandler(base slog.Handler) slog.Handler {
+	if a.ipc == nil {
+		return base
+	}
+
+	handler := tui.NewHandler(a.ipc, logLevel)
+	handler.SetReady()
+
+	return teeHandler{base, handler}
+}
+
+// initRemoteAttach listens on the Tailscale IP for remote TUIs if an
+// attach allowlist is configured.
+func (a *app) initRemoteAttach(ctx context.Context, localIP netip.Addr) error {
+	if len(a.cfg.AttachAllow) == 0 {
+		return nil
+	}
+
+	if !localIP.IsValid() {
+		slog.Warn("remote attach disabled: no Tailscale IP")
+
+		return nil
+	}
+
+	addr := net.JoinHostPort(localIP.String(), strconv.Itoa(a.cfg.AttachPort))
+
+	lc := &net.ListenConfig{}
+
+	listener, err := lc.Listen(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen for remote attach: %w", err)
+	}
+
+	if a.ipc == nil {
+		a.newIPC()
+	}
+
+	a.attachListener = listener
+
+	slog.Info("remote attach enabled", "addr", listener.Addr(), "allow", a.cfg.AttachAllow)
+
+	return nil
+}
+
+func (a *app) runRemoteAttach(ctx context.Context) {
+	err := a.ipc.Serve(ctx, a.attachListener, a.authorizeAttach)
+	if err != nil && ctx.Err() == nil {
+		slog.Error("remote attach error", "error", err)
+	}
+}
+
+// authorizeAttach allows remote TUIs whose tailnet identity is on the
+// attach allowlist.
+func (a *app) authorizeAttach(ctx context.Context, conn net.Conn) error {
+	addr, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		return err
+	}
+
+	id, err := a.discovery.WhoIs(ctx, addr)
+	if err != nil {
+		return fmt.Errorf("whois %s: %w", addr, err)
+	}
+
+	if !slices.ContainsFunc(a.cfg.AttachAllow, id.Matches) {
+		return fmt.Errorf("%w: %s", errAttachDenied, id)
+	}
+
+	slog.Info("remote TUI attaching", "identity", id.String())
+
+	return nil
+}
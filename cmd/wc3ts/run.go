@@ -2,94 +2,159 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log/slog"
 	"math"
+	"net"
+	"net/netip"
+	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/kradalby/wc3ts/bench"
+	"github.com/kradalby/wc3ts/capture"
 	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/control"
 	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/history"
+	"github.com/kradalby/wc3ts/host"
+	"github.com/kradalby/wc3ts/impair"
+	"github.com/kradalby/wc3ts/ipc"
 	"github.com/kradalby/wc3ts/lan"
+	"github.com/kradalby/wc3ts/mapfile"
+	"github.com/kradalby/wc3ts/mdns"
+	"github.com/kradalby/wc3ts/paths"
 	"github.com/kradalby/wc3ts/peer"
 	"github.com/kradalby/wc3ts/proxy"
+	"github.com/kradalby/wc3ts/state"
 	"github.com/kradalby/wc3ts/tailscale"
 	"github.com/kradalby/wc3ts/tui"
 	"github.com/kradalby/wc3ts/version"
+	"github.com/kradalby/wc3ts/webhook"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
 	"github.com/peterbourgon/ff/v3/ffcli"
 )
 
+// healthInterval is how often the network health summary is refreshed.
+const healthInterval = 30 * time.Second
+
+// peerPingInterval is how often every peer is pinged to measure its
+// round trip time.
+const peerPingInterval = 30 * time.Second
+
+// updateCheckInterval is how often the background update check runs.
+const updateCheckInterval = 12 * time.Hour
+
+// connectionsInterval is how often the proxied connections are sent to the
+// TUI, so byte counts and durations stay current.
+const connectionsInterval = time.Second
+
+// lanBypassInterval is how often peers' direct paths are checked for a
+// shared LAN.
+const lanBypassInterval = 30 * time.Second
+
+// lanBridgeInterval is how often the bridged LAN subnets are refreshed.
+const lanBridgeInterval = 30 * time.Second
+
+// wineCheckInterval is how often a Wine or Proton client is looked for.
+const wineCheckInterval = 10 * time.Second
+
 // app holds the application state and dependencies.
 type app struct {
 	cfg         *config.Config
 	registry    *game.Registry
 	tcpProxy    *proxy.TCPProxy
+	udpRelay    *proxy.UDPRelay
+	buffers     *proxy.BufferPool
 	discovery   *tailscale.Discovery
 	peerManager *peer.Manager
 	responder   *peer.Responder
+	mdns        *mdns.Service
+	warning     string // problem to keep in view in the TUI, e.g. a port conflict
 	broadcaster *lan.Broadcaster
+	control     *control.Server
+	webhook     *webhook.Notifier
+	guard       *proxy.Guard
+	acl         *proxy.ACL
+	history     *history.Recorder
+	audit       *history.AuditLog
+	subsystems  map[string]control.Subsystem
 	program     *tea.Program
+	ipc         *ipc.Server
+	watch       *watcher
+
+	// attachListener accepts remote TUIs when remote attach is enabled.
+	attachListener net.Listener
+
+	bench *bench.Server
+
+	// capture records W3GS traffic when running 'wc3ts capture'.
+	capture *capture.Recorder
+
+	// hostCfg describes the game to host, if any; hosted serves it.
+	hostCfg *host.Config
+	hosted  *host.Host
+
+	// gameFilter hides games by name from the TUI and broadcaster.
+	gameFilter atomic.Pointer[game.NameFilter]
 }
 
 func newRunCommand() *ffcli.Command {
 	fs := flag.NewFlagSet("run", flag.ExitOnError)
-	versionStr := fs.String("version", "26", "Game version (e.g., 26, 1.26, 27, 1.27, 28, 1.28)")
+	flags := newConfigFlags(fs)
 
 	return &ffcli.Command{
 		Name:       "run",
 		ShortUsage: "wc3ts run [flags]",
 		ShortHelp:  "Run the WC3 LAN proxy with TUI",
 		FlagSet:    fs,
+		Options:    flags.options(),
 		Exec: func(ctx context.Context, args []string) error {
-			gameVersion, err := config.ParseVersion(*versionStr)
+			cfg, err := flags.apply()
 			if err != nil {
 				return err
 			}
 
-			return runExec(ctx, args, gameVersion)
+			return runExec(ctx, args, cfg)
 		},
 	}
 }
 
-func runExec(ctx context.Context, _ []string, gameVersion uint32) error {
+func runExec(ctx context.Context, _ []string, cfg *config.Config) error {
 	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	// Create app with default config
-	a := &app{
-		cfg: config.Default(),
+	charset, err := game.ParseCharset(cfg.Charset)
+	if err != nil {
+		return err
 	}
 
-	// Override version if specified
-	a.cfg.GameVersion.Version = gameVersion
+	a := &app{
+		cfg: cfg,
+	}
 
 	// Initialize services first (so we have peer manager for the callback)
-	err := a.initServices(ctx)
+	err = a.initServices(ctx)
 	if err != nil {
 		return err
 	}
 
-	// Create TUI model with version callback that updates peer manager
-	versionCallback := func(v uint32) {
-		newVersion := a.cfg.GameVersion
-		newVersion.Version = v
-		a.peerManager.SetVersion(newVersion)
-		slog.Info("version changed", "version", config.FormatVersion(v))
-	}
+	library := mapfile.NewLibrary(a.cfg.MapsDir)
 
-	// Create refresh callback that triggers peer probing
-	refreshCallback := func() {
-		a.peerManager.Refresh()
-		slog.Debug("manual refresh triggered")
-	}
+	model := tui.NewModel(0, a.cfg.GameVersion, version.Get(), charset, library,
+		a.selectVersion, a.refresh, a.togglePause, a.setPrivate, a.peerAction)
+	a.program = tea.NewProgram(model, tea.WithAltScreen(), tea.WithFilter(a.filterActivity))
 
-	model := tui.NewModel(0, a.cfg.GameVersion, version.Get(), versionCallback, refreshCallback)
-	a.program = tea.NewProgram(model, tea.WithAltScreen())
-
-	// Set up logging to TUI (Debug level to see everything)
-	handler := tui.NewHandler(a.program, slog.LevelDebug)
-	slog.SetDefault(slog.New(handler))
+	// Set up logging to TUI, honouring the global --log-level
+	handler := tui.NewHandler(a.program, logLevel)
+	slog.SetDefault(slog.New(a.logHandler(handler)))
 
 	a.startServices(ctx)
 
@@ -105,7 +170,7 @@ func runExec(ctx context.Context, _ []string, gameVersion uint32) error {
 	handler.SetReady()
 
 	// Update TUI model with actual proxy port
-	a.program.Send(tui.PortMsg{Port: a.tcpProxy.Port()})
+	a.sendStartup()
 
 	// Log that we're ready
 	slog.Info("wc3ts started", "proxyPort", a.tcpProxy.Port())
@@ -118,35 +183,165 @@ func runExec(ctx context.Context, _ []string, gameVersion uint32) error {
 
 	// Clean up
 	cancel()
+	a.close()
+
+	return nil
+}
 
+// close releases resources that are not closed by context cancellation.
+func (a *app) close() {
 	if a.broadcaster != nil {
 		_ = a.broadcaster.Close()
 	}
 
-	return nil
+	if a.hosted != nil {
+		_ = a.hosted.Close()
+	}
+
+	_ = a.history.Close()
+	_ = a.audit.Close()
 }
 
+// initServices creates all services. If a step fails, the services created
+// before it are closed again: they only close themselves once started.
 func (a *app) initServices(ctx context.Context) error {
+	err := a.createServices(ctx)
+	if err != nil {
+		a.closeServices()
+	}
+
+	return err
+}
+
+// closeServices closes the services of an app that failed to start.
+func (a *app) closeServices() {
+	if a.tcpProxy != nil {
+		_ = a.tcpProxy.Close()
+	}
+
+	if a.udpRelay != nil {
+		_ = a.udpRelay.Close()
+	}
+
+	if a.peerManager != nil {
+		_ = a.peerManager.Close()
+	}
+
+	if a.responder != nil {
+		_ = a.responder.Close()
+	}
+
+	if a.guard != nil {
+		_ = a.guard.Close()
+	}
+
+	if a.attachListener != nil {
+		_ = a.attachListener.Close()
+	}
+
+	if a.bench != nil {
+		_ = a.bench.Close()
+	}
+
+	a.close()
+}
+
+func (a *app) createServices(ctx context.Context) error {
+	filter, err := newGameFilter(a.cfg)
+	if err != nil {
+		return err
+	}
+
+	a.gameFilter.Store(filter)
+
 	// Create game registry with callback
 	a.registry = game.NewRegistry(a.onGamesChanged)
+	a.registry.SetGhost(a.cfg.Ghost)
+
+	// Create Tailscale discovery
+	a.discovery = tailscale.NewDiscovery(a.onPeersChanged)
+	a.discovery.SetFilter(peerFilter(a.cfg))
+
+	// The proxy, peer manager and responder all need our Tailscale IP,
+	// so we fetch it synchronously
+	localIP, ipErr := a.discovery.FetchSelfIP(ctx)
+	if ipErr != nil {
+		slog.Warn("could not get Tailscale IP, remote discovery disabled", "error", ipErr)
+	}
+
+	localIP6 := a.discovery.SelfIP6()
+
+	proxyAddrs, err := config.ParseProxyBind(a.cfg.ProxyBind, localIP)
+	if err != nil {
+		return err
+	}
+
+	imp := impair.New(a.cfg.Impair)
+	if imp != nil {
+		slog.Warn("simulating network impairment",
+			"latency", a.cfg.Impair.Latency,
+			"jitter", a.cfg.Impair.Jitter,
+			"loss", a.cfg.Impair.Loss,
+			"seed", a.cfg.Impair.Seed,
+		)
+	}
 
 	// Create TCP proxy
-	var err error
+	a.tcpProxy, err = proxy.NewTCPProxy(ctx, a.registry, proxyAddrs, a.cfg.ProxyPort, imp)
+	if err != nil {
+		return err
+	}
 
-	a.tcpProxy, err = proxy.NewTCPProxy(ctx, a.registry)
+	a.buffers = proxy.NewBufferPool(a.cfg.RelayBufferSize)
+
+	a.tcpProxy.SetCapture(a.capture)
+	a.tcpProxy.SetBufferPool(a.buffers)
+	a.tcpProxy.SetSplice(a.cfg.Splice)
+	a.tcpProxy.SetGamePorts(a.cfg.GamePorts)
+
+	if a.cfg.JoinACL.Enabled() {
+		a.acl = proxy.NewACL(a.discovery, a.cfg.JoinACL)
+		a.acl.SetRejectFunc(func(gameName, who string) {
+			a.send(tui.NoticeMsg{Text: "rejected join to " + gameName + " from " + who})
+		})
+		a.tcpProxy.SetACL(a.acl)
+	}
+	a.tcpProxy.SetMaxConnections(a.cfg.MaxConnections)
+	a.tcpProxy.SetTCPOptions(proxy.TCPOptions{
+		NoDelay:   a.cfg.TCPNoDelay,
+		KeepAlive: a.cfg.TCPKeepAlive,
+		DSCP:      a.cfg.DSCP,
+	})
+	a.tcpProxy.SetReconnectWait(a.cfg.ReconnectWait)
+	a.tcpProxy.SetIdleTimeout(a.cfg.RelayIdleTimeout)
+	a.tcpProxy.SetThrottle(a.cfg.Throttle, a.cfg.ThrottleTotal)
+
+	if a.cfg.UDPRelay {
+		a.initUDPRelay(ctx, proxyAddrs, imp)
+	}
+
+	bindIP, err := config.ParseProbeBind(a.cfg.ProbeBind, localIP)
 	if err != nil {
 		return err
 	}
 
-	// Create Tailscale discovery
-	a.discovery = tailscale.NewDiscovery(a.onPeersChanged)
+	// IPv6 probes follow IPv4 ones onto the Tailscale interface
+	var bindIP6 netip.Addr
+	if bindIP.IsValid() && bindIP == localIP {
+		bindIP6 = localIP6
+	}
 
 	// Create peer manager
-	a.peerManager, err = peer.NewManager(a.discovery, a.registry, a.cfg.ProbeInterval)
+	a.peerManager, err = peer.NewManager(
+		a.discovery, a.registry, a.cfg.ProbeInterval, a.cfg.UDPReceiveBuffer, bindIP, bindIP6, imp, a.capture)
 	if err != nil {
 		return err
 	}
 
+	lanPort := safeUint16(a.cfg.LANPort)
+	a.peerManager.SetPort(lanPort)
+	a.peerManager.SetFallbackPort(safeUint16(a.cfg.FallbackPort))
+
 	// Create LAN broadcaster (uses ephemeral port, doesn't conflict with WC3)
 	proxyPort := safeUint16(a.tcpProxy.Port())
 
@@ -155,29 +350,484 @@ func (a *app) initServices(ctx context.Context) error {
 		return err
 	}
 
-	// Set default version for peer probing
+	a.broadcaster.SetPort(lanPort)
+
+	if a.cfg.GamePorts {
+		a.broadcaster.SetGamePortFunc(a.tcpProxy.GamePort)
+	}
+	a.broadcaster.SetInterval(a.cfg.RefreshInterval)
+	a.broadcaster.SetIdleInterval(a.cfg.IdleRefresh)
+	a.broadcaster.SetPace(a.cfg.BroadcastPace)
+
+	// A loopback-only proxy is unreachable at the LAN source address of a
+	// broadcast, so announce to localhost instead
+	loopbackOnly := config.IsLoopbackOnly(proxyAddrs)
+	if loopbackOnly && a.cfg.Gateway {
+		return fmt.Errorf("%w: -proxy-bind %s", config.ErrGatewayLoopback, a.cfg.ProxyBind)
+	}
+
+	if a.cfg.Gateway {
+		slog.Info("gateway mode: serving WC3 clients on the LAN", "lanAddrs", config.LANAddrs(), "proxyPort", proxyPort)
+	}
+
+	if loopbackOnly {
+		a.broadcaster.SetTarget(netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), lanPort))
+	} else {
+		err = a.initBroadcastIfaces()
+		if err != nil {
+			return err
+		}
+	}
+
+	if a.cfg.InstantSearch {
+		err = a.broadcaster.ListenSearch()
+		if err != nil {
+			slog.Info("not answering local game searches, games appear on the next refresh", "error", err)
+		}
+	}
+
+	a.broadcaster.SetAnnounceTargets(a.cfg.AnnounceTargets)
+	a.broadcaster.SetDSCP(a.cfg.DSCP)
+
+	// Set default version for peer probing and rebroadcasting
 	a.peerManager.SetVersion(a.cfg.GameVersion)
+	a.peerManager.SetCompatGroups(a.cfg.CompatGroups)
+	a.peerManager.SetAllVersions(a.cfg.AllVersions)
+	a.peerManager.SetIdleTimeout(a.cfg.IdleTimeout)
+	a.peerManager.SetProbeBackoff(a.cfg.ProbeBackoff)
+	a.peerManager.SetVersionFunc(a.onVersionDetected)
+	a.peerManager.SetGameTimeout(a.cfg.GameTimeout)
+	a.peerManager.SetStaticHosts(a.cfg.StaticHosts)
+	a.peerManager.SetProbeSubnets(a.cfg.ProbeSubnets)
+	a.peerManager.SetPeerAddrs(a.cfg.PeerAddrs)
+
+	if a.cfg.Compress {
+		a.tcpProxy.SetCompress(a.discovery.Relayed, a.peerManager.Compresses)
+	}
+
+	a.broadcaster.SetVersion(a.cfg.GameVersion)
+	a.broadcaster.SetCompatGroups(a.cfg.CompatGroups)
+	a.broadcaster.SetVersionTags(a.cfg.VersionTags)
+	a.broadcaster.SetLatencyTags(a.cfg.LatencyTags)
+	a.broadcaster.SetNameTemplate(nameTemplate(a.cfg))
 
 	// Create responder to answer queries from remote Tailscale peers
-	// This requires our Tailscale IP, so we fetch it synchronously
-	localIP, err := a.discovery.FetchSelfIP(ctx)
+	if ipErr == nil && localIP.IsValid() {
+		a.responder, err = peer.NewResponder(
+			a.registry, localIP, localIP6, a.cfg.LANPort, a.cfg.FallbackPort, a.cfg.UDPReceiveBuffer, imp, a.capture)
+
+		switch {
+		case errors.Is(err, peer.ErrPortInUse):
+			slog.Warn("could not create responder, remote discovery disabled", "error", err)
+
+			a.warning = fmt.Sprintf("port %d is held by another program: peers cannot find our games", a.cfg.LANPort)
+		case err != nil:
+			slog.Warn("could not create responder, remote discovery disabled", "error", err)
+		default:
+			slog.Info("responder listening for remote queries", "ip", localIP, "ip6", localIP6, "port", a.responder.Port())
+
+			if a.responder.Port() != a.cfg.LANPort {
+				a.warning = fmt.Sprintf("port %d is held by another program: answering peers on %d",
+					a.cfg.LANPort, a.responder.Port())
+			}
+		}
+	}
+
+	if a.cfg.MDNS {
+		a.initMDNS()
+	}
+
+	a.peerManager.SetDirect(a.directEnabled(localIP))
+	a.peerManager.SetReachCheck(a.cfg.ReachCheck)
+
+	err = a.initGuard(ctx, localIP, localIP6, imp)
+	if err != nil {
+		return err
+	}
+
+	err = a.initRemoteAttach(ctx, localIP)
 	if err != nil {
-		slog.Warn("could not get Tailscale IP, remote discovery disabled", "error", err)
-	} else if localIP.IsValid() {
-		a.responder, err = peer.NewResponder(a.registry, localIP)
+		return err
+	}
+
+	err = a.initBench(ctx, localIP, imp)
+	if err != nil {
+		return err
+	}
+
+	err = a.initHost()
+	if err != nil {
+		return err
+	}
+
+	if a.cfg.HistoryDir != "" {
+		a.history, err = history.NewRecorder(a.cfg.HistoryDir)
 		if err != nil {
-			slog.Warn("could not create responder, remote discovery disabled", "error", err)
-		} else {
-			slog.Info("responder listening for remote queries", "ip", localIP)
+			return err
+		}
+
+		a.peerManager.SetHistory(a.history)
+		a.tcpProxy.SetHistory(a.history)
+	}
+
+	if a.cfg.AuditLog != "" {
+		a.audit, err = history.OpenAuditLog(a.cfg.AuditLog)
+		if err != nil {
+			return err
+		}
+
+		a.tcpProxy.SetAuditLog(a.audit)
+
+		slog.Info("recording proxied joins", "auditLog", a.cfg.AuditLog)
+	}
+
+	// Subsystems that can be paused without affecting active proxy sessions
+	a.subsystems = map[string]control.Subsystem{
+		"broadcaster": a.broadcaster,
+		"probing":     a.peerManager,
+	}
+
+	if a.responder != nil {
+		a.subsystems["responder"] = a.responder
+	}
+
+	if a.cfg.ControlAddr != "" {
+		err = a.initControl()
+		if err != nil {
+			return err
 		}
 	}
 
+	if a.cfg.Webhook != "" {
+		a.webhook, err = webhook.New(a.cfg.Webhook)
+		if err != nil {
+			return err
+		}
+
+		a.registry.Subscribe(a.webhook.Notify)
+	}
+
 	return nil
 }
 
-func (a *app) onGamesChanged(games []game.Game) {
+// initBroadcastIfaces restricts announcements to the configured interfaces.
+func (a *app) initBroadcastIfaces() error {
+	prefixes, err := config.ParseBroadcastIfaces(a.cfg.BroadcastIfaces)
+	if err != nil {
+		return err
+	}
+
+	return a.broadcaster.SetInterfaces(prefixes)
+}
+
+// initUDPRelay creates the UDP relay on the TCP proxy's port. The relay is
+// best effort: if the port is taken over UDP, games still work for the
+// versions that only use TCP.
+func (a *app) initUDPRelay(ctx context.Context, bindAddrs []netip.Addr, imp *impair.Impairer) {
+	relay, err := proxy.NewUDPRelay(ctx, bindAddrs, a.tcpProxy.Port(), imp)
+	if err != nil {
+		slog.Warn("UDP relay disabled", "error", err)
+
+		return
+	}
+
+	relay.SetCapture(a.capture)
+	relay.SetDSCP(a.cfg.DSCP)
+	a.tcpProxy.SetUDPRelay(relay)
+	a.udpRelay = relay
+}
+
+// initGuard creates the join guard if a join allowlist is configured.
+// Without one, a guard on our Tailscale IPv6 address still bridges joins
+// over IPv6 to WC3, which only listens on IPv4.
+// Joins can only be guarded for games advertised by our responder.
+func (a *app) initGuard(ctx context.Context, localIP, localIP6 netip.Addr, imp *impair.Impairer) error {
+	acl := a.acl != nil
+
+	// Compressed sessions and joins to bridged LAN games are only
+	// accepted by the guard
+	full := acl || a.cfg.Compress || a.cfg.LANBridge
+	if !full && !localIP6.IsValid() {
+		return nil
+	}
+
+	if a.responder == nil {
+		if acl {
+			slog.Warn("join allowlist only applies to the proxy: local games are not advertised to peers")
+		}
+
+		if a.cfg.LANBridge {
+			slog.Warn("LAN bridge disabled: local games are not advertised to peers")
+		}
+
+		return nil
+	}
+
+	guardIPs := []netip.Addr{localIP6}
+	if full {
+		guardIPs = []netip.Addr{localIP}
+		if localIP6.IsValid() && localIP6 != localIP {
+			guardIPs = append(guardIPs, localIP6)
+		}
+	}
+
+	guard, err := proxy.NewGuard(ctx, a.registry, guardIPs, a.acl, imp)
+	if err != nil {
+		return err
+	}
+
+	guard.SetCapture(a.capture)
+	guard.SetIdleTimeout(a.cfg.RelayIdleTimeout)
+	guard.SetBufferPool(a.buffers)
+	guard.SetSplice(a.cfg.Splice)
+
+	a.guard = guard
+
+	if !full {
+		a.responder.SetIPv6GuardPort(safeUint16(guard.Port()))
+		slog.Info("bridging IPv6 joins to local games", "ip", localIP6, "guardPort", guard.Port())
+
+		return nil
+	}
+
+	a.responder.SetGuardPort(safeUint16(guard.Port()))
+	a.responder.SetCompress(a.cfg.Compress)
+
+	switch {
+	case acl:
+		slog.Info("join allowlist enabled", "guardPort", guard.Port())
+	case a.cfg.Compress:
+		slog.Info("accepting compressed joins to local games", "guardPort", guard.Port())
+	default:
+		slog.Info("accepting joins to local and LAN games", "guardPort", guard.Port())
+	}
+
+	return nil
+}
+
+// initControl creates the control API. Its token is read from the token
+// file, or generated and written there on first start, so local clients
+// such as scrapers keep working across restarts.
+func (a *app) initControl() error {
+	path, err := controlTokenPath(a.cfg.ControlTokenFile)
+	if err != nil {
+		return err
+	}
+
+	token, err := control.LoadToken(path)
+	if err != nil {
+		return fmt.Errorf("loading control API token: %w", err)
+	}
+
+	a.control = control.NewServer(a.subsystems, a.onPauseChanged)
+	a.control.SetRegistry(a.registry)
+	a.control.SetProxyPort(a.tcpProxy.Port())
+	a.control.SetToken(token)
+
+	slog.Info("control API token", "path", path)
+
+	return nil
+}
+
+// controlTokenPath returns the file the control API token is kept in:
+// file if set, otherwise the default location.
+func controlTokenPath(file string) (string, error) {
+	if file != "" {
+		return file, nil
+	}
+
+	p, err := paths.Get()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(p.Config, control.TokenFile), nil
+}
+
+// filterActivity records TUI input as local activity for idle probing.
+func (a *app) filterActivity(_ tea.Model, msg tea.Msg) tea.Msg {
+	if _, ok := msg.(tea.KeyMsg); ok && a.peerManager != nil {
+		a.peerManager.Touch()
+	}
+
+	return msg
+}
+
+// send forwards a message to the TUI and to attached clients, if any.
+func (a *app) send(msg tea.Msg) {
 	if a.program != nil {
-		a.program.Send(tui.GamesMsg{Games: games})
+		a.program.Send(msg)
+	}
+
+	if a.ipc != nil {
+		a.ipc.Send(msg)
+	}
+}
+
+// sendStartup sends the TUI the state only known once services started.
+func (a *app) sendStartup() {
+	msg := tui.PortMsg{Port: a.tcpProxy.Port(), LANPort: a.cfg.LANPort}
+	if a.responder != nil && a.responder.Port() != a.cfg.LANPort {
+		msg.PeerPort = a.responder.Port()
+	}
+
+	a.send(msg)
+
+	if a.warning != "" {
+		a.send(tui.WarningMsg{Text: a.warning})
+	}
+}
+
+// attached reports whether messages sent with send reach a TUI.
+func (a *app) attached() bool {
+	return a.program != nil || a.ipc != nil
+}
+
+// setVersion switches the game version probed for and advertised,
+// keeping the current product.
+func (a *app) setVersion(v uint32) {
+	newVersion := a.peerManager.Version()
+	newVersion.Version = v
+	a.useVersion(newVersion)
+}
+
+// useVersion switches the game version probed for and advertised.
+func (a *app) useVersion(v w3gs.GameVersion) {
+	a.peerManager.SetVersion(v)
+	a.broadcaster.SetVersion(v)
+
+	if a.ipc != nil {
+		a.ipc.SetVersion(v)
+	}
+
+	slog.Info("version changed", "product", v.Product, "version", config.FormatVersion(v.Version))
+}
+
+// onVersionDetected adopts the game version detected from a local game
+// when none was configured. It is not remembered, so it is detected again
+// on the next start.
+func (a *app) onVersionDetected(v w3gs.GameVersion) {
+	a.broadcaster.SetVersion(v)
+
+	if a.ipc != nil {
+		a.ipc.SetVersion(v)
+	}
+
+	a.send(tui.VersionMsg{Version: v})
+}
+
+// selectVersion switches the game version as chosen in a TUI and
+// remembers it for the next start.
+func (a *app) selectVersion(v uint32) {
+	a.setVersion(v)
+
+	err := state.SaveUI(state.UI{Version: v})
+	if err != nil {
+		slog.Warn("failed to save TUI preferences", "error", err)
+	}
+}
+
+// refresh triggers an immediate peer probe.
+func (a *app) refresh() {
+	a.peerManager.Refresh()
+	slog.Debug("manual refresh triggered")
+}
+
+// togglePause pauses all subsystems, or resumes them if all are paused.
+func (a *app) togglePause() {
+	allPaused := true
+
+	for _, sub := range a.subsystems {
+		allPaused = allPaused && sub.Paused()
+	}
+
+	for _, sub := range a.subsystems {
+		if allPaused {
+			sub.Resume()
+		} else {
+			sub.Pause()
+		}
+	}
+
+	slog.Info("discovery toggled", "paused", !allPaused)
+
+	a.onPauseChanged()
+}
+
+// setPrivate hides a local game from remote peers, or shows it again.
+func (a *app) setPrivate(key string, private bool) {
+	if a.registry.SetPrivate(key, private) {
+		slog.Info("game privacy changed", "game", key, "private", private)
+	}
+}
+
+// peerAction applies a batch action from the TUI to the given peers.
+func (a *app) peerAction(action tui.PeerAction, ips []netip.Addr) {
+	switch action {
+	case tui.PeerActionProbe:
+		a.peerManager.ProbePeers(ips)
+	case tui.PeerActionAllow:
+		if a.acl == nil {
+			slog.Warn("join allowlist is not enabled (start with -join-allow)")
+
+			return
+		}
+
+		for _, ip := range ips {
+			a.acl.Allow(ip.String())
+			slog.Info("peer allowed to join", "peer", ip)
+		}
+	case tui.PeerActionMute:
+		for _, ip := range ips {
+			a.peerManager.SetMuted(ip, !a.peerManager.IsMuted(ip))
+		}
+
+		a.send(tui.MutedMsg{Muted: a.peerManager.Muted()})
+	}
+}
+
+// onPauseChanged notifies the TUI of the paused subsystems.
+func (a *app) onPauseChanged() {
+	paused := make([]string, 0, len(a.subsystems))
+
+	for name, sub := range a.subsystems {
+		if sub.Paused() {
+			paused = append(paused, name)
+		}
+	}
+
+	slices.Sort(paused)
+
+	a.send(tui.PausedMsg{Paused: paused})
+}
+
+// directEnabled resolves the direct-connect mode. In auto mode it is enabled
+// when tailscaled runs locally and our responder is not holding the Tailscale
+// game port, so hosts' direct announcements reach WC3 instead of wc3ts.
+func (a *app) directEnabled(localIP netip.Addr) bool {
+	switch a.cfg.DirectConnect {
+	case config.DirectConnectOn:
+		return true
+	case config.DirectConnectAuto:
+		enabled := localIP.Is4() && a.responder == nil
+		if enabled {
+			slog.Info("direct-connect enabled for reachable hosts", "ip", localIP)
+		}
+
+		return enabled
+	}
+
+	return false
+}
+
+func (a *app) onGamesChanged(games []game.Game) {
+	games = a.gameFilter.Load().Filter(games)
+
+	a.send(tui.GamesMsg{Games: games})
+
+	// Dedicated game ports follow the games that are announced
+	if a.tcpProxy != nil {
+		a.tcpProxy.OnGamesChanged(games)
 	}
 
 	if a.broadcaster != nil {
@@ -186,9 +836,8 @@ func (a *app) onGamesChanged(games []game.Game) {
 }
 
 func (a *app) onPeersChanged(peers []tailscale.Peer) {
-	if a.program != nil {
-		a.program.Send(tui.PeersMsg{Peers: peers})
-	}
+	a.send(tui.PeersMsg{Peers: peers})
+	a.watch.peersChanged(peers)
 
 	if a.peerManager != nil {
 		a.peerManager.OnPeersChanged(peers)
@@ -201,9 +850,65 @@ func (a *app) startServices(ctx context.Context) {
 	go a.runBroadcaster(ctx)
 	go a.runTCPProxy(ctx)
 
+	if a.udpRelay != nil {
+		go a.runUDPRelay(ctx)
+	}
+
 	if a.responder != nil {
 		go a.runResponder(ctx)
 	}
+
+	if a.control != nil {
+		go a.runControl(ctx)
+	}
+
+	if a.webhook != nil {
+		go a.runWebhook(ctx)
+	}
+
+	if a.guard != nil {
+		go a.runGuard(ctx)
+	}
+
+	if a.mdns != nil {
+		go a.runMDNS(ctx)
+	}
+
+	if a.attachListener != nil {
+		go a.runRemoteAttach(ctx)
+	}
+
+	if a.bench != nil {
+		go a.runBench(ctx)
+	}
+
+	if a.hosted != nil {
+		go a.runHost(ctx)
+	}
+
+	if a.cfg.UpdateCheck {
+		go a.runUpdateCheck(ctx)
+	}
+
+	go a.runHealth(ctx)
+	go a.runPeerPing(ctx)
+	go a.runConnections(ctx)
+
+	if a.cfg.ConfigFile != "" {
+		go a.runConfigReload(ctx)
+	}
+
+	if a.cfg.Wine != config.WineOff {
+		go a.runWine(ctx)
+	}
+
+	if a.cfg.LANBypass {
+		go a.runLANBypass(ctx)
+	}
+
+	if a.cfg.LANBridge && a.guard != nil {
+		go a.runLANBridge(ctx)
+	}
 }
 
 func (a *app) runDiscovery(ctx context.Context) {
@@ -234,6 +939,13 @@ func (a *app) runTCPProxy(ctx context.Context) {
 	}
 }
 
+func (a *app) runUDPRelay(ctx context.Context) {
+	err := a.udpRelay.Run(ctx)
+	if err != nil && ctx.Err() == nil {
+		slog.Error("UDP relay error", "error", err)
+	}
+}
+
 func (a *app) runResponder(ctx context.Context) {
 	err := a.responder.Run(ctx)
 	if err != nil && ctx.Err() == nil {
@@ -241,6 +953,342 @@ func (a *app) runResponder(ctx context.Context) {
 	}
 }
 
+func (a *app) runMDNS(ctx context.Context) {
+	err := a.mdns.Run(ctx)
+	if err != nil && ctx.Err() == nil {
+		slog.Error("mDNS discovery error", "error", err)
+	}
+}
+
+func (a *app) runGuard(ctx context.Context) {
+	err := a.guard.Run(ctx)
+	if err != nil && ctx.Err() == nil {
+		slog.Error("join guard error", "error", err)
+	}
+}
+
+func (a *app) runControl(ctx context.Context) {
+	err := a.control.Run(ctx, a.cfg.ControlAddr)
+	if err != nil && ctx.Err() == nil {
+		slog.Error("control API error", "error", err)
+	}
+}
+
+func (a *app) runWebhook(ctx context.Context) {
+	err := a.webhook.Run(ctx)
+	if err != nil && ctx.Err() == nil {
+		slog.Error("webhook error", "error", err)
+	}
+}
+
+// runHealth periodically sends the network health summary to the TUI.
+func (a *app) runHealth(ctx context.Context) {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+
+	for {
+		health, err := a.discovery.FetchHealth(ctx)
+		if err != nil {
+			slog.Debug("network health check failed", "error", err)
+		} else if a.attached() {
+			a.send(tui.HealthMsg{Health: health})
+		} else {
+			slog.Debug("network health", "summary", health.String())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runPeerPing periodically pings every peer and passes the round trip
+// times to the TUI and the peer manager.
+func (a *app) runPeerPing(ctx context.Context) {
+	ticker := time.NewTicker(peerPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		peers := a.discovery.PingPeers(ctx)
+		a.send(tui.PeersMsg{Peers: peers})
+
+		if a.peerManager != nil {
+			a.peerManager.OnPeersPinged(peers)
+		}
+	}
+}
+
+// runConnections sends the proxied connections to the TUI while any are
+// open, and once more when the last one closes.
+func (a *app) runConnections(ctx context.Context) {
+	ticker := time.NewTicker(connectionsInterval)
+	defer ticker.Stop()
+
+	sent := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		conns := a.tcpProxy.Connections().Connections()
+		if len(conns) == 0 && sent == 0 {
+			continue
+		}
+
+		if a.attached() {
+			a.send(tui.ConnectionsMsg{Connections: conns})
+		}
+
+		sent = len(conns)
+	}
+}
+
+// runWine adapts local probing and announcements while a WC3 client runs
+// under Wine or Proton. In auto mode the client is looked for periodically.
+func (a *app) runWine(ctx context.Context) {
+	ticker := time.NewTicker(wineCheckInterval)
+	defer ticker.Stop()
+
+	enabled := false
+
+	for {
+		runtime := lan.WineClient()
+		want := a.cfg.Wine == config.WineOn || runtime != ""
+
+		if want != enabled {
+			enabled = want
+			a.setWine(enabled, runtime)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runLANBypass periodically finds the peers reached over a local LAN
+// subnet, whose games WC3 sees without the proxy.
+func (a *app) runLANBypass(ctx context.Context) {
+	ticker := time.NewTicker(lanBypassInterval)
+	defer ticker.Stop()
+
+	for {
+		endpoints, err := a.discovery.FetchEndpoints(ctx)
+		if err == nil {
+			a.peerManager.SetLANPeers(lanPeers(endpoints, config.LANPrefixes()))
+		} else {
+			slog.Debug("could not check peers for a shared LAN", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// initMDNS advertises this instance on the LAN over mDNS, answering the
+// instances found on a port of their own, and probes them for games.
+func (a *app) initMDNS() {
+	if a.responder == nil {
+		slog.Warn("mDNS discovery needs the responder, disabled")
+
+		return
+	}
+
+	port, err := a.responder.ListenLAN(a.cfg.UDPReceiveBuffer)
+	if err != nil {
+		slog.Warn("could not listen for LAN instances, mDNS discovery disabled", "error", err)
+
+		return
+	}
+
+	// An empty name is advertised as "wc3ts"
+	name, _ := os.Hostname()
+
+	slog.Info("advertising over mDNS", "service", mdns.ServiceType, "name", name, "port", port)
+
+	a.mdns = mdns.New(name, port, a.onLANInstances)
+}
+
+// onLANInstances probes the wc3ts instances found on the LAN like static
+// hosts, named after their machines.
+func (a *app) onLANInstances(instances []mdns.Instance) {
+	hosts := make([]config.StaticHost, 0, len(instances))
+
+	for _, inst := range instances {
+		hosts = append(hosts, config.StaticHost{
+			Name: inst.Name,
+			Host: inst.Addr.Addr().String(),
+			Port: int(inst.Addr.Port()),
+		})
+	}
+
+	a.peerManager.SetLANInstances(hosts)
+}
+
+// runLANBridge keeps the bridged LAN subnets current as interfaces come
+// and go.
+func (a *app) runLANBridge(ctx context.Context) {
+	ticker := time.NewTicker(lanBridgeInterval)
+	defer ticker.Stop()
+
+	var last []netip.Prefix
+
+	for {
+		prefixes := config.LANPrefixes()
+		if !slices.Equal(prefixes, last) {
+			slog.Info("offering games from LAN subnets to peers", "subnets", prefixes)
+
+			a.peerManager.SetLANBridge(prefixes, config.LANAddrs())
+			last = prefixes
+		}
+
+		endpoints, err := a.discovery.FetchEndpoints(ctx)
+		if err == nil {
+			a.peerManager.SetBridgePeers(peerLANAddrs(endpoints, prefixes))
+		} else {
+			slog.Debug("could not check peers on the bridged LAN", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lanPeers returns the peers whose endpoint lies in one of prefixes.
+func lanPeers(endpoints map[netip.Addr]netip.AddrPort, prefixes []netip.Prefix) []netip.Addr {
+	var peers []netip.Addr
+
+	for ip, endpoint := range endpoints {
+		for _, prefix := range prefixes {
+			if prefix.Contains(endpoint.Addr()) {
+				peers = append(peers, ip)
+
+				break
+			}
+		}
+	}
+
+	return peers
+}
+
+// peerLANAddrs returns the endpoints of peers that lie in one of prefixes.
+func peerLANAddrs(endpoints map[netip.Addr]netip.AddrPort, prefixes []netip.Prefix) []netip.Addr {
+	var addrs []netip.Addr
+
+	for _, endpoint := range endpoints {
+		for _, prefix := range prefixes {
+			if prefix.Contains(endpoint.Addr()) {
+				addrs = append(addrs, endpoint.Addr())
+
+				break
+			}
+		}
+	}
+
+	return addrs
+}
+
+// setWine switches Wine adaptations on or off.
+func (a *app) setWine(enabled bool, runtime string) {
+	var hostAddrs []netip.Addr
+	if enabled {
+		hostAddrs = config.LANAddrs()
+
+		slog.Info("adapting to WC3 under Wine", "runtime", runtime, "lanAddrs", hostAddrs)
+	} else {
+		slog.Info("WC3 under Wine no longer detected")
+	}
+
+	a.peerManager.SetHostAddrs(hostAddrs)
+	a.broadcaster.SetWine(enabled)
+}
+
+// runUpdateCheck periodically checks for a newer release, notifying the
+// TUI and caching the result for 'wc3ts version'.
+func (a *app) runUpdateCheck(ctx context.Context) {
+	v := version.Get()
+	if !v.IsRelease() {
+		slog.Debug("skipping update check for development build")
+
+		return
+	}
+
+	ticker := time.NewTicker(updateCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		a.checkForUpdate(ctx, v)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkForUpdate runs a single update check.
+func (a *app) checkForUpdate(ctx context.Context, v version.Info) {
+	ctx, cancel := context.WithTimeout(ctx, updateCheckTimeout)
+	defer cancel()
+
+	latest, err := version.LatestRelease(ctx)
+	if err != nil {
+		slog.Debug("update check failed", "error", err)
+
+		return
+	}
+
+	cachePath, err := latestReleasePath()
+	if err == nil {
+		err = version.SaveRelease(cachePath, latest)
+	}
+
+	if err != nil {
+		slog.Debug("failed to cache latest release", "error", err)
+	}
+
+	if v.UpdateAvailable(latest) {
+		slog.Info("update available", "version", latest.Version, "url", latest.URL)
+
+		a.send(tui.UpdateMsg{Version: latest.Version})
+	}
+}
+
+// nameTemplate returns the template for rebroadcast game names, empty if
+// names are kept as announced.
+func nameTemplate(cfg *config.Config) string {
+	if !cfg.ShowPeerNames {
+		return ""
+	}
+
+	return cfg.NameTemplate
+}
+
+// peerFilter returns the Tailscale peer filter configured in cfg.
+func peerFilter(cfg *config.Config) tailscale.PeerFilter {
+	return tailscale.PeerFilter{Allow: cfg.PeerAllow, Deny: cfg.PeerDeny}
+}
+
 // safeUint16 safely converts an int to uint16, clamping to max value.
 func safeUint16(n int) uint16 {
 	if n < 0 {
@@ -0,0 +1,438 @@
+//nolint:forbidigo,mnd // CLI output uses fmt.Print and has magic numbers
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/lan"
+	"github.com/kradalby/wc3ts/packet"
+	"github.com/kradalby/wc3ts/peer"
+	"github.com/kradalby/wc3ts/proxy"
+	"github.com/kradalby/wc3ts/tailscale"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// Selftest fixtures.
+const (
+	selftestGameName    = "wc3ts selftest"
+	selftestHostCounter = 0x5E1F7E57
+	selftestPlayer      = "selftest"
+)
+
+// errSelftestFailed is returned when any selftest stage fails.
+var errSelftestFailed = errors.New("selftest failed")
+
+// errSelftestTimeout is returned when a stage does not complete in time.
+var errSelftestTimeout = errors.New("timed out")
+
+// selftestLoopback is the address every selftest component runs on.
+var selftestLoopback = netip.AddrFrom4([4]byte{127, 0, 0, 1})
+
+func newSelftestCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 10*time.Second, "Timeout for each stage")
+	versionStr := fs.String("version", "26", "Game version to simulate (e.g., 26, 1.26)")
+
+	return &ffcli.Command{
+		Name:       "selftest",
+		ShortUsage: "wc3ts selftest [flags]",
+		ShortHelp:  "Verify the discovery, broadcast and proxy pipeline end to end",
+		LongHelp: `Run a fake game host and a fake LAN client on loopback and pass a game
+through the full wc3ts pipeline: the peer manager discovers the host,
+the registry stores the game, the broadcaster announces it to the client,
+and the client joins through the TCP proxy.
+
+Tailscale and Warcraft III are not needed; all ports are ephemeral.`,
+		FlagSet: fs,
+		Exec: func(ctx context.Context, _ []string) error {
+			v, err := config.ParseVersion(*versionStr)
+			if err != nil {
+				return err
+			}
+
+			version := w3gs.GameVersion{Product: w3gs.ProductTFT, Version: v}
+
+			// Keep the report readable; -v still shows component logs
+			if logLevel.Level() > slog.LevelDebug {
+				logLevel.Set(slog.LevelWarn)
+			}
+
+			return runSelftest(ctx, version, *timeout)
+		},
+	}
+}
+
+// selftestReport records the outcome of each stage.
+type selftestReport struct {
+	failed int
+}
+
+// check prints the result of a stage and reports whether it passed.
+func (r *selftestReport) check(stage string, err error) bool {
+	if err != nil {
+		r.failed++
+
+		fmt.Printf("FAIL  %-10s %v\n", stage, err)
+
+		return false
+	}
+
+	fmt.Printf("PASS  %s\n", stage)
+
+	return true
+}
+
+// runSelftest runs each stage in order, stopping at the first failure.
+func runSelftest(ctx context.Context, version w3gs.GameVersion, timeout time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	report := &selftestReport{}
+
+	fmt.Printf("Running selftest with %s 1.%d\n\n", version.Product, version.Version)
+
+	defer func() {
+		fmt.Println()
+
+		if report.failed == 0 {
+			fmt.Println("All stages passed.")
+		}
+	}()
+
+	host, err := newFakeHost(ctx, version)
+	if !report.check("host", err) {
+		return errSelftestFailed
+	}
+
+	defer host.close()
+
+	go host.serve()
+
+	client, err := net.ListenUDP("udp4", net.UDPAddrFromAddrPort(netip.AddrPortFrom(selftestLoopback, 0)))
+	if !report.check("client", err) {
+		return errSelftestFailed
+	}
+
+	defer func() { _ = client.Close() }()
+
+	pipeline, err := newSelftestPipeline(ctx, version, host, client)
+	if !report.check("pipeline", err) {
+		return errSelftestFailed
+	}
+
+	go pipeline.run(ctx)
+
+	if !report.check("discovery", pipeline.waitForGame(ctx, timeout)) {
+		return errSelftestFailed
+	}
+
+	if !report.check("broadcast", pipeline.waitForBroadcast(client, timeout)) {
+		return errSelftestFailed
+	}
+
+	if !report.check("proxy", pipeline.join(ctx, host, timeout)) {
+		return errSelftestFailed
+	}
+
+	return nil
+}
+
+// selftestPipeline is the discovery, registry, broadcast and proxy chain under test.
+type selftestPipeline struct {
+	registry    *game.Registry
+	manager     *peer.Manager
+	broadcaster *lan.Broadcaster
+	tcpProxy    *proxy.TCPProxy
+
+	// hostCounter is the counter the game was announced to the client under.
+	hostCounter uint32
+}
+
+// newSelftestPipeline wires the pipeline to probe host and announce to client.
+func newSelftestPipeline(
+	ctx context.Context,
+	version w3gs.GameVersion,
+	host *fakeHost,
+	client *net.UDPConn,
+) (*selftestPipeline, error) {
+	p := &selftestPipeline{}
+
+	p.registry = game.NewRegistry(func(games []game.Game) {
+		if p.broadcaster != nil {
+			p.broadcaster.OnGamesChanged(games)
+		}
+	})
+
+	var err error
+
+	p.tcpProxy, err = proxy.NewTCPProxy(ctx, p.registry, []netip.Addr{selftestLoopback}, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	p.manager, err = peer.NewManager(nil, p.registry, time.Second, 0, netip.Addr{}, netip.Addr{}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	p.manager.SetPort(host.udpPort())
+	p.manager.SetVersion(version)
+
+	p.broadcaster, err = lan.NewBroadcaster(safeUint16(p.tcpProxy.Port()))
+	if err != nil {
+		return nil, err
+	}
+
+	p.broadcaster.SetVersion(version)
+	p.broadcaster.SetTarget(client.LocalAddr().(*net.UDPAddr).AddrPort()) //nolint:forcetypeassert
+
+	return p, nil
+}
+
+// run starts the pipeline and announces the fake host as a peer.
+func (p *selftestPipeline) run(ctx context.Context) {
+	go func() { _ = p.tcpProxy.Run(ctx) }()
+	go func() { _ = p.broadcaster.Run(ctx) }()
+	go func() { _ = p.manager.Run(ctx) }()
+
+	p.manager.OnPeersChanged([]tailscale.Peer{{
+		Name:   "selftest-host",
+		IP:     selftestLoopback,
+		Online: true,
+	}})
+
+	<-ctx.Done()
+
+	_ = p.broadcaster.Close()
+}
+
+// waitForGame waits until the manager has registered the fake host's game.
+func (p *selftestPipeline) waitForGame(ctx context.Context, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		for _, g := range p.registry.RemoteGames() {
+			if g.Info.GameName == selftestGameName {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	return fmt.Errorf("%w: game not discovered", errSelftestTimeout)
+}
+
+// waitForBroadcast waits until the client receives the game pointing at the proxy.
+func (p *selftestPipeline) waitForBroadcast(client *net.UDPConn, timeout time.Duration) error {
+	err := client.SetReadDeadline(time.Now().Add(timeout + lan.BroadcastInterval))
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, packet.MaxDatagramSize)
+
+	for {
+		n, _, err := client.ReadFrom(buf)
+		if err != nil {
+			return fmt.Errorf("no announcement received: %w", err)
+		}
+
+		if packet.ID(buf[:n]) != packet.IDGameInfo {
+			continue
+		}
+
+		info, err := packet.ParseGameInfo(buf[:n])
+		if err != nil {
+			return fmt.Errorf("malformed announcement: %w", err)
+		}
+
+		if info.GameName != selftestGameName {
+			continue
+		}
+
+		if int(info.GamePort) != p.tcpProxy.Port() {
+			return fmt.Errorf("%w: announced port %d, proxy on %d",
+				packet.ErrInvalidField, info.GamePort, p.tcpProxy.Port())
+		}
+
+		p.hostCounter = info.HostCounter
+
+		return nil
+	}
+}
+
+// join connects to the proxy as a LAN client and waits for the host's reply.
+func (p *selftestPipeline) join(ctx context.Context, host *fakeHost, timeout time.Duration) error {
+	dialer := &net.Dialer{Timeout: timeout}
+
+	proxyAddr := netip.AddrPortFrom(selftestLoopback, safeUint16(p.tcpProxy.Port()))
+
+	conn, err := dialer.DialContext(ctx, "tcp4", proxyAddr.String())
+	if err != nil {
+		return fmt.Errorf("connect to proxy: %w", err)
+	}
+
+	defer func() { _ = conn.Close() }()
+
+	_, err = w3gs.Write(conn, &w3gs.Join{
+		HostCounter: p.hostCounter,
+		PlayerName:  selftestPlayer,
+	}, w3gs.Encoding{})
+	if err != nil {
+		return fmt.Errorf("send Join: %w", err)
+	}
+
+	err = conn.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return err
+	}
+
+	reply, err := packet.Read(conn)
+	if err != nil {
+		return fmt.Errorf("no reply from host: %w", err)
+	}
+
+	if packet.ID(reply) != w3gs.PidRejectJoin {
+		return fmt.Errorf("%w: reply 0x%02X", packet.ErrUnexpectedType, packet.ID(reply))
+	}
+
+	if !host.joined() {
+		return fmt.Errorf("%w: host did not see the Join", packet.ErrUnexpectedType)
+	}
+
+	return nil
+}
+
+// fakeHost answers SearchGame queries with a fixed game and rejects every
+// Join, which is enough to observe a join round trip through the proxy.
+type fakeHost struct {
+	udp     *net.UDPConn
+	tcp     net.Listener
+	info    []byte
+	gotJoin chan struct{}
+}
+
+// newFakeHost listens on ephemeral loopback UDP and TCP ports.
+func newFakeHost(ctx context.Context, version w3gs.GameVersion) (*fakeHost, error) {
+	lc := &net.ListenConfig{}
+
+	tcp, err := lc.Listen(ctx, "tcp4", netip.AddrPortFrom(selftestLoopback, 0).String())
+	if err != nil {
+		return nil, err
+	}
+
+	udp, err := net.ListenUDP("udp4", net.UDPAddrFromAddrPort(netip.AddrPortFrom(selftestLoopback, 0)))
+	if err != nil {
+		_ = tcp.Close()
+
+		return nil, err
+	}
+
+	info, err := w3gs.Serialize(&w3gs.GameInfo{
+		GameVersion: version,
+		HostCounter: selftestHostCounter,
+		GameName:    selftestGameName,
+		GameSettings: w3gs.GameSettings{
+			MapPath:  `Maps\selftest.w3x`,
+			HostName: selftestPlayer,
+		},
+		SlotsTotal:     2,
+		SlotsUsed:      1,
+		SlotsAvailable: 2,
+		GamePort:       safeUint16(tcp.Addr().(*net.TCPAddr).Port), //nolint:forcetypeassert
+	}, w3gs.Encoding{})
+	if err != nil {
+		_ = tcp.Close()
+		_ = udp.Close()
+
+		return nil, err
+	}
+
+	return &fakeHost{
+		udp:     udp,
+		tcp:     tcp,
+		info:    info,
+		gotJoin: make(chan struct{}, 1),
+	}, nil
+}
+
+// udpPort returns the port the host answers SearchGame queries on.
+func (h *fakeHost) udpPort() uint16 {
+	return safeUint16(h.udp.LocalAddr().(*net.UDPAddr).Port) //nolint:forcetypeassert
+}
+
+// serve answers queries and joins until the host is closed.
+func (h *fakeHost) serve() {
+	go h.acceptLoop()
+
+	buf := make([]byte, packet.MaxDatagramSize)
+
+	for {
+		n, addr, err := h.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		if packet.ID(buf[:n]) == packet.IDSearchGame {
+			_, _ = h.udp.WriteTo(h.info, addr)
+		}
+	}
+}
+
+// acceptLoop rejects every Join it receives. Only Joins carrying the
+// host's own counter count as joined, which checks that the proxy maps
+// the announced counter back.
+func (h *fakeHost) acceptLoop() {
+	for {
+		conn, err := h.tcp.Accept()
+		if err != nil {
+			return
+		}
+
+		data, err := packet.Read(conn)
+		if err == nil && packet.ID(data) == packet.IDJoin {
+			join, parseErr := packet.ParseJoin(data)
+			if parseErr == nil && join.HostCounter == selftestHostCounter {
+				select {
+				case h.gotJoin <- struct{}{}:
+				default:
+				}
+			}
+
+			_, _ = w3gs.Write(conn, &w3gs.RejectJoin{Reason: w3gs.RejectJoinFull}, w3gs.Encoding{})
+		}
+
+		_ = conn.Close()
+	}
+}
+
+// joined reports whether the host received a Join.
+func (h *fakeHost) joined() bool {
+	select {
+	case <-h.gotJoin:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops the host.
+func (h *fakeHost) close() {
+	_ = h.tcp.Close()
+	_ = h.udp.Close()
+}
@@ -0,0 +1,78 @@
+//nolint:forbidigo // CLI output uses fmt.Print
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kradalby/wc3ts/version"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// selfUpdateTimeout bounds the release lookup and download.
+const selfUpdateTimeout = 5 * time.Minute
+
+func newSelfUpdateCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("selfupdate", flag.ExitOnError)
+	force := fs.Bool("force", false, "Install the latest release even if this build is current or a development build")
+
+	return &ffcli.Command{
+		Name:       "selfupdate",
+		ShortUsage: "wc3ts selfupdate [--force]",
+		ShortHelp:  "Update wc3ts to the latest release",
+		LongHelp: `Download the latest GitHub release for this platform, verify its
+SHA-256 against the release's checksums.txt and replace the running
+executable. Restart wc3ts afterwards to use the new version.`,
+		FlagSet: fs,
+		Exec: func(ctx context.Context, _ []string) error {
+			ctx, cancel := context.WithTimeout(ctx, selfUpdateTimeout)
+			defer cancel()
+
+			v := version.Get()
+
+			latest, err := version.LatestRelease(ctx)
+			if err != nil {
+				return err
+			}
+
+			if !*force {
+				if !v.IsRelease() {
+					fmt.Printf("development build; latest release is %s (use --force to install it)\n", latest.Version)
+
+					return nil
+				}
+
+				if !v.UpdateAvailable(latest) {
+					fmt.Printf("wc3ts %s is up to date\n", v.Version)
+
+					return nil
+				}
+			}
+
+			exe, err := os.Executable()
+			if err != nil {
+				return err
+			}
+
+			exe, err = filepath.EvalSymlinks(exe)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("updating %s from %s to %s...\n", exe, v.String(), latest.Version)
+
+			err = version.Install(ctx, latest, exe)
+			if err != nil {
+				return fmt.Errorf("update failed: %w", err)
+			}
+
+			fmt.Printf("updated to %s\n", latest.Version)
+
+			return nil
+		},
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/sdnotify"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+func newServeCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags := newConfigFlags(fs)
+
+	return &ffcli.Command{
+		Name:       "serve",
+		ShortUsage: "wc3ts serve [flags]",
+		ShortHelp:  "Run the WC3 LAN proxy headless, logging to stderr",
+		LongHelp: `Run discovery, the peer manager, broadcaster, responder and TCP proxy
+without the TUI, for always-on machines and service managers. Logs go to
+stderr at the level set by --log-level. Stop with SIGINT or SIGTERM; open
+game connections are given -drain-timeout to finish.
+
+Under systemd, use Type=notify: readiness is signalled once all services
+are up, and WatchdogSec= keepalives are sent when configured.`,
+		FlagSet: fs,
+		Options: flags.options(),
+		Exec: func(ctx context.Context, _ []string) error {
+			cfg, err := flags.apply()
+			if err != nil {
+				return err
+			}
+
+			return serveExec(ctx, cfg)
+		},
+	}
+}
+
+// serveExec runs all services with plain slog output until interrupted.
+func serveExec(ctx context.Context, cfg *config.Config) error {
+	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	a := &app{
+		cfg: cfg,
+	}
+
+	return a.serve(ctx)
+}
+
+// serve initialises and starts the services, then blocks until the context
+// is cancelled and open connections have drained.
+func (a *app) serve(ctx context.Context) error {
+	cfg := a.cfg
+
+	err := a.initServices(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer a.close()
+
+	slog.SetDefault(slog.New(a.logHandler(slog.Default().Handler())))
+
+	a.startServices(ctx)
+	a.sendStartup()
+
+	slog.Info("wc3ts started", "proxyPort", a.tcpProxy.Port(), "version", config.FormatVersion(cfg.GameVersion.Version))
+
+	notifyReady(ctx)
+
+	<-ctx.Done()
+
+	slog.Info("wc3ts stopping")
+
+	a.drain()
+
+	return nil
+}
+
+// notifyReady tells systemd the services are up and keeps its watchdog
+// fed until the context is cancelled.
+func notifyReady(ctx context.Context) {
+	err := sdnotify.Notify(sdnotify.Ready)
+	if err != nil {
+		slog.Warn("failed to notify systemd", "error", err)
+	}
+
+	interval := sdnotify.WatchdogInterval()
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				_ = sdnotify.Notify(sdnotify.Watchdog)
+			}
+		}
+	}()
+}
+
+// drain waits for open game connections after the proxy stopped accepting.
+func (a *app) drain() {
+	open := a.tcpProxy.Open()
+	if open == 0 || a.cfg.DrainTimeout <= 0 {
+		_ = sdnotify.Notify(sdnotify.Stopping)
+
+		return
+	}
+
+	slog.Info("waiting for game connections to finish", "open", open, "timeout", a.cfg.DrainTimeout)
+
+	_ = sdnotify.Notify(sdnotify.Stopping + "\n" + sdnotify.Status(fmt.Sprintf("draining %d connections", open)))
+
+	left := a.tcpProxy.Drain(a.cfg.DrainTimeout)
+	if left > 0 {
+		slog.Warn("closing game connections still open", "open", left)
+	}
+}
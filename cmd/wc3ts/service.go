@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+
+	"github.com/kradalby/wc3ts/config"
+	"github.com/peterbourgon/ff/v3"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// serviceName is the Windows service and Event Log source name.
+const serviceName = "wc3ts"
+
+// errServiceAction is returned when service is run without an action.
+var errServiceAction = errors.New("missing action (use install, uninstall or run)")
+
+// errServiceUnsupported is returned by service commands on non-Windows systems.
+var errServiceUnsupported = errors.New(
+	"services are only supported on Windows (use 'wc3ts serve' with systemd or launchd)")
+
+func newServiceCommand() *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "service",
+		ShortUsage: "wc3ts service install|uninstall|run [-- serve flags]",
+		ShortHelp:  "Manage the wc3ts Windows service",
+		LongHelp: `Register wc3ts as a Windows service that starts at boot and runs
+headless like 'wc3ts serve', logging to the Windows Event Log.
+
+  wc3ts service install -- -ghost   # flags after -- are passed to serve
+  wc3ts service uninstall
+
+'run' is used by the service manager and should not be called directly.
+Installing and uninstalling need an elevated prompt.`,
+		Subcommands: []*ffcli.Command{
+			{
+				Name:       "install",
+				ShortUsage: "wc3ts service install [-- serve flags]",
+				ShortHelp:  "Install and start the service",
+				Exec: func(_ context.Context, args []string) error {
+					// Fail now rather than when the service starts
+					_, err := parseServeFlags(args)
+					if err != nil {
+						return err
+					}
+
+					return installService(args)
+				},
+			},
+			{
+				Name:       "uninstall",
+				ShortUsage: "wc3ts service uninstall",
+				ShortHelp:  "Stop and remove the service",
+				Exec: func(_ context.Context, _ []string) error {
+					return uninstallService()
+				},
+			},
+			{
+				Name:       "run",
+				ShortUsage: "wc3ts service run [-- serve flags]",
+				ShortHelp:  "Run as a service (called by the service manager)",
+				Exec: func(_ context.Context, args []string) error {
+					return runService(args)
+				},
+			},
+		},
+		Exec: func(_ context.Context, _ []string) error {
+			return errServiceAction
+		},
+	}
+}
+
+// parseServeFlags parses the serve flags passed to the service.
+func parseServeFlags(args []string) (*config.Config, error) {
+	fs := flag.NewFlagSet("service", flag.ContinueOnError)
+	flags := newConfigFlags(fs)
+
+	err := ff.Parse(fs, args, flags.options()...)
+	if err != nil {
+		return nil, err
+	}
+
+	return flags.apply()
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+func installService(_ []string) error {
+	return errServiceUnsupported
+}
+
+func uninstallService() error {
+	return errServiceUnsupported
+}
+
+func runService(_ []string) error {
+	return errServiceUnsupported
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kradalby/wc3ts/config"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceEventID is the Event Log event ID used for all wc3ts messages.
+const serviceEventID = 1
+
+// serviceStopTimeout bounds how long uninstall waits for the service to stop.
+const serviceStopTimeout = 10 * time.Second
+
+func installService(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	exe, err = filepath.Abs(exe)
+	if err != nil {
+		return err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = m.Disconnect() }()
+
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "wc3ts",
+		Description: "Warcraft III LAN game proxy over Tailscale",
+		StartType:   mgr.StartAutomatic,
+	}, append([]string{"service", "run", "--"}, args...)...)
+	if err != nil {
+		return fmt.Errorf("create service: %w", err)
+	}
+
+	defer func() { _ = s.Close() }()
+
+	err = eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info)
+	if err != nil {
+		_ = s.Delete()
+
+		return fmt.Errorf("register event log source: %w", err)
+	}
+
+	err = s.Start()
+	if err != nil {
+		return fmt.Errorf("start service: %w", err)
+	}
+
+	slog.Info("service installed and started", "name", serviceName, "exe", exe, "args", args)
+
+	return nil
+}
+
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = m.Disconnect() }()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("open service: %w", err)
+	}
+
+	defer func() { _ = s.Close() }()
+
+	status, err := s.Control(svc.Stop)
+	if err == nil {
+		deadline := time.Now().Add(serviceStopTimeout)
+		for status.State != svc.Stopped && time.Now().Before(deadline) {
+			time.Sleep(time.Second / 4)
+
+			status, err = s.Query()
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	err = s.Delete()
+	if err != nil {
+		return fmt.Errorf("delete service: %w", err)
+	}
+
+	err = eventlog.Remove(serviceName)
+	if err != nil {
+		slog.Warn("failed to remove event log source", "error", err)
+	}
+
+	slog.Info("service uninstalled", "name", serviceName)
+
+	return nil
+}
+
+func runService(args []string) error {
+	cfg, err := parseServeFlags(args)
+	if err != nil {
+		return err
+	}
+
+	elog, err := eventlog.Open(serviceName)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = elog.Close() }()
+
+	slog.SetDefault(slog.New(newEventLogHandler(elog)))
+
+	return svc.Run(serviceName, &serviceHandler{cfg: cfg})
+}
+
+// serviceHandler runs serve under the service manager.
+type serviceHandler struct {
+	cfg *config.Config
+}
+
+// Execute runs serve until the service manager asks it to stop.
+func (h *serviceHandler) Execute(
+	_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status,
+) (bool, uint32) {
+	const accepted = svc.AcceptStop | svc.AcceptShutdown
+
+	status <- svc.Status{State: svc.StartPending}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+
+	go func() { done <- serveExec(ctx, h.cfg) }()
+
+	status <- svc.Status{State: svc.Running, Accepts: accepted}
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				slog.Error("service stopped", "error", err)
+
+				return false, 1
+			}
+
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+
+				cancel()
+
+				<-done
+
+				return false, 0
+			default:
+			}
+		}
+	}
+}
+
+// eventLogHandler is a slog.Handler writing to the Windows Event Log.
+type eventLogHandler struct {
+	elog *eventlog.Log
+	text slog.Handler // formats records into buf
+	buf  *bytes.Buffer
+	mu   *sync.Mutex
+}
+
+func newEventLogHandler(elog *eventlog.Log) *eventLogHandler {
+	buf := &bytes.Buffer{}
+
+	return &eventLogHandler{
+		elog: elog,
+		text: slog.NewTextHandler(buf, &slog.HandlerOptions{Level: logLevel}),
+		buf:  buf,
+		mu:   &sync.Mutex{},
+	}
+}
+
+// Enabled reports whether the handler handles records at the given level.
+func (h *eventLogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.text.Enabled(ctx, level)
+}
+
+// Handle writes the record as an Event Log entry of matching severity.
+func (h *eventLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.buf.Reset()
+
+	err := h.text.Handle(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	msg := h.buf.String()
+
+	switch {
+	case r.Level >= slog.LevelError:
+		return h.elog.Error(serviceEventID, msg)
+	case r.Level >= slog.LevelWarn:
+		return h.elog.Warning(serviceEventID, msg)
+	default:
+		return h.elog.Info(serviceEventID, msg)
+	}
+}
+
+// WithAttrs returns a handler with the attributes added.
+func (h *eventLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &eventLogHandler{elog: h.elog, text: h.text.WithAttrs(attrs), buf: h.buf, mu: h.mu}
+}
+
+// WithGroup returns a handler with the group added.
+func (h *eventLogHandler) WithGroup(name string) slog.Handler {
+	return &eventLogHandler{elog: h.elog, text: h.text.WithGroup(name), buf: h.buf, mu: h.mu}
+}
@@ -3,26 +3,126 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/kradalby/wc3ts/paths"
 	"github.com/kradalby/wc3ts/version"
 	"github.com/peterbourgon/ff/v3/ffcli"
 )
 
+// updateCheckTimeout bounds the request for the latest release.
+const updateCheckTimeout = 10 * time.Second
+
 func newVersionCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	check := fs.Bool("check", false, "Check GitHub for a newer release")
+	jsonOut := fs.Bool("json", false, "Print version information as JSON")
+
 	return &ffcli.Command{
 		Name:       "version",
-		ShortUsage: "wc3ts version",
+		ShortUsage: "wc3ts version [--check] [--json]",
 		ShortHelp:  "Print version information",
-		Exec: func(_ context.Context, _ []string) error {
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, _ []string) error {
 			v := version.Get()
+
+			if *jsonOut {
+				return printVersionJSON(v)
+			}
+
 			fmt.Printf("wc3ts %s\n", v.String())
 
 			if v.GoVer != "" {
 				fmt.Printf("  go: %s\n", v.GoVer)
 			}
 
+			if *check {
+				return checkForUpdate(ctx, v)
+			}
+
+			printCachedUpdate(v)
+
 			return nil
 		},
 	}
 }
+
+// versionJSON is the output of version --json.
+type versionJSON struct {
+	Version   string            `json:"version"`
+	Commit    string            `json:"commit,omitempty"`
+	Dirty     bool              `json:"dirty"`
+	GoVersion string            `json:"goVersion,omitempty"`
+	Modules   map[string]string `json:"modules"`
+}
+
+// printVersionJSON prints v as JSON.
+func printVersionJSON(v version.Info) error {
+	modules := v.Deps
+	if modules == nil {
+		modules = map[string]string{}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(versionJSON{
+		Version:   v.Version,
+		Commit:    v.Commit,
+		Dirty:     v.Modified,
+		GoVersion: v.GoVer,
+		Modules:   modules,
+	})
+}
+
+// latestReleasePath returns the file the background update check caches
+// the latest release in.
+func latestReleasePath() (string, error) {
+	p, err := paths.Get()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(p.State, paths.LatestReleaseFile), nil
+}
+
+// printCachedUpdate prints a notice if the last background update check
+// found a newer release.
+func printCachedUpdate(v version.Info) {
+	path, err := latestReleasePath()
+	if err != nil {
+		return
+	}
+
+	latest, err := version.LoadRelease(path)
+	if err == nil && v.UpdateAvailable(latest) {
+		fmt.Printf("  update available: %s (%s)\n", latest.Version, latest.URL)
+	}
+}
+
+// checkForUpdate prints whether a newer release than v is available.
+func checkForUpdate(ctx context.Context, v version.Info) error {
+	ctx, cancel := context.WithTimeout(ctx, updateCheckTimeout)
+	defer cancel()
+
+	latest, err := version.LatestRelease(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !v.IsRelease():
+		fmt.Printf("  development build; latest release is %s\n", latest.Version)
+	case v.UpdateAvailable(latest):
+		fmt.Printf("  update available: %s (%s)\n", latest.Version, latest.URL)
+	default:
+		fmt.Println("  up to date")
+	}
+
+	return nil
+}
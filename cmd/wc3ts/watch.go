@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/tailscale"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// Watch event types.
+const (
+	eventGameAdded   = "game-added"
+	eventGameState   = "game-state"
+	eventGameRemoved = "game-removed"
+	eventPeerOnline  = "peer-online"
+	eventPeerOffline = "peer-offline"
+	eventJoin        = "join"
+)
+
+func newWatchCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	flags := newConfigFlags(fs)
+
+	return &ffcli.Command{
+		Name:       "watch",
+		ShortUsage: "wc3ts watch [flags]",
+		ShortHelp:  "Run headless and print events as JSON lines",
+		LongHelp: `Run like 'wc3ts serve' and print one JSON object per line on stdout for
+every event, for piping into notifications or dashboards:
+
+  game-added     a game was discovered
+  game-state     a game changed lifecycle state (lobby, starting, ...)
+  game-removed   a game ended or expired
+  peer-online    a Tailscale peer became eligible for probing
+  peer-offline   a Tailscale peer went away
+  join           a player's join to a remote game was proxied
+
+Logs go to stderr.`,
+		FlagSet: fs,
+		Options: flags.options(),
+		Exec: func(ctx context.Context, _ []string) error {
+			cfg, err := flags.apply()
+			if err != nil {
+				return err
+			}
+
+			return watchExec(ctx, cfg)
+		},
+	}
+}
+
+// watchExec runs all services, printing events to stdout until interrupted.
+func watchExec(ctx context.Context, cfg *config.Config) error {
+	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	a := &app{
+		cfg:   cfg,
+		watch: newWatcher(os.Stdout),
+	}
+
+	err := a.initServices(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer a.close()
+
+	a.registry.Subscribe(a.watch.gameEvent)
+	a.tcpProxy.SetJoinFunc(a.watch.join)
+
+	a.startServices(ctx)
+
+	slog.Info("wc3ts watching", "proxyPort", a.tcpProxy.Port())
+
+	<-ctx.Done()
+
+	return nil
+}
+
+// watchEvent is a single line of watch output.
+type watchEvent struct {
+	Time   time.Time  `json:"time"`
+	Type   string     `json:"type"`
+	Key    string     `json:"key,omitempty"`
+	Game   string     `json:"game,omitempty"`
+	State  game.State `json:"state,omitempty"`
+	Host   string     `json:"host,omitempty"`
+	IP     string     `json:"ip,omitempty"`
+	OS     string     `json:"os,omitempty"`
+	Player string     `json:"player,omitempty"`
+	Client string     `json:"client,omitempty"`
+}
+
+// watcher writes events as JSON lines.
+type watcher struct {
+	enc   *json.Encoder
+	peers map[netip.Addr]tailscale.Peer
+	mu    sync.Mutex
+}
+
+func newWatcher(w io.Writer) *watcher {
+	return &watcher{
+		enc:   json.NewEncoder(w),
+		peers: make(map[netip.Addr]tailscale.Peer),
+	}
+}
+
+// emit writes an event. It is safe to call on a nil watcher.
+func (w *watcher) emit(e watchEvent) {
+	if w == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.enc.Encode(e)
+	if err != nil {
+		slog.Debug("failed to write event", "error", err)
+	}
+}
+
+// gameEvent emits a game lifecycle transition.
+func (w *watcher) gameEvent(ev game.Event) {
+	typ := eventGameState
+
+	switch {
+	case ev.From == "":
+		typ = eventGameAdded
+	case ev.To.Final():
+		typ = eventGameRemoved
+	}
+
+	w.emit(watchEvent{Time: ev.Time, Type: typ, Key: ev.Key, Game: ev.Name, State: ev.To})
+}
+
+// join emits a proxied join.
+func (w *watcher) join(g game.Game, player string, client net.Addr) {
+	w.emit(watchEvent{
+		Type:   eventJoin,
+		Key:    g.Key(),
+		Game:   g.Info.GameName,
+		Host:   g.PeerName,
+		IP:     g.PeerIP.String(),
+		Player: player,
+		Client: client.String(),
+	})
+}
+
+// peersChanged emits peers that appeared or went away since the last update.
+// It is safe to call on a nil watcher.
+func (w *watcher) peersChanged(peers []tailscale.Peer) {
+	if w == nil {
+		return
+	}
+
+	current := make(map[netip.Addr]tailscale.Peer, len(peers))
+	for _, p := range peers {
+		current[p.IP] = p
+	}
+
+	w.mu.Lock()
+	previous := w.peers
+	w.peers = current
+	w.mu.Unlock()
+
+	for ip, p := range current {
+		if _, ok := previous[ip]; !ok {
+			w.emit(watchEvent{Type: eventPeerOnline, Host: p.Name, IP: ip.String(), OS: p.OS})
+		}
+	}
+
+	for ip, p := range previous {
+		if _, ok := current[ip]; !ok {
+			w.emit(watchEvent{Type: eventPeerOffline, Host: p.Name, IP: ip.String(), OS: p.OS})
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"path"
+	"strings"
+)
+
+// ErrInvalidJoinAllow is returned for empty join allowlist entries.
+var ErrInvalidJoinAllow = errors.New("invalid join allowlist entry")
+
+// ErrInvalidAttachAllow is returned for empty attach allowlist entries.
+var ErrInvalidAttachAllow = errors.New("invalid attach allowlist entry")
+
+// ErrInvalidPeerFilter is returned for empty or malformed peer filter entries.
+var ErrInvalidPeerFilter = errors.New("invalid peer filter entry")
+
+// AddAttachAllow parses and adds an identity allowed to attach a TUI remotely.
+func (c *Config) AddAttachAllow(entry string) error {
+	identity := strings.TrimSpace(entry)
+	if identity == "" {
+		return fmt.Errorf("%w: %q", ErrInvalidAttachAllow, entry)
+	}
+
+	c.AttachAllow = append(c.AttachAllow, identity)
+
+	return nil
+}
+
+// AddPeerAllow parses and adds a peer allowlist entry.
+func (c *Config) AddPeerAllow(entry string) error {
+	return addPeerFilter(&c.PeerAllow, entry)
+}
+
+// AddPeerDeny parses and adds a peer denylist entry.
+func (c *Config) AddPeerDeny(entry string) error {
+	return addPeerFilter(&c.PeerDeny, entry)
+}
+
+// AddGameAllow adds a game name pattern to the allowlist.
+func (c *Config) AddGameAllow(pattern string) error {
+	c.GameAllow = append(c.GameAllow, pattern)
+
+	return nil
+}
+
+// AddGameBlock adds a game name pattern to the blocklist.
+func (c *Config) AddGameBlock(pattern string) error {
+	c.GameBlock = append(c.GameBlock, pattern)
+
+	return nil
+}
+
+// addPeerFilter validates a hostname glob, IP, CIDR prefix or ACL tag and
+// appends it to list.
+func addPeerFilter(list *[]string, entry string) error {
+	entry = strings.TrimSpace(entry)
+	if entry == "" {
+		return fmt.Errorf("%w: empty", ErrInvalidPeerFilter)
+	}
+
+	if strings.Contains(entry, "/") {
+		_, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidPeerFilter, err)
+		}
+	}
+
+	_, err := path.Match(entry, "")
+	if err != nil {
+		return fmt.Errorf("%w: %q: %w", ErrInvalidPeerFilter, entry, err)
+	}
+
+	*list = append(*list, entry)
+
+	return nil
+}
+
+// JoinACL lists the tailnet identities allowed to join locally hosted games
+// and, over Tailscale, games through the proxy.
+// Identities are login names, node names, Tailscale IPs or ACL tags.
+type JoinACL struct {
+	// Global identities may join any game.
+	Global []string
+
+	// Games maps a game name to the identities allowed to join it,
+	// in addition to Global.
+	Games map[string][]string
+}
+
+// Add parses and adds an allowlist entry: "identity" allows the identity to
+// join any game, "game name=identity" only the named game.
+func (a *JoinACL) Add(entry string) error {
+	gameName, identity, perGame := strings.Cut(entry, "=")
+	if !perGame {
+		identity = gameName
+	}
+
+	identity = strings.TrimSpace(identity)
+	gameName = strings.TrimSpace(gameName)
+
+	if identity == "" || (perGame && gameName == "") {
+		return fmt.Errorf("%w: %q", ErrInvalidJoinAllow, entry)
+	}
+
+	if !perGame {
+		a.Global = append(a.Global, identity)
+
+		return nil
+	}
+
+	if a.Games == nil {
+		a.Games = make(map[string][]string)
+	}
+
+	a.Games[gameName] = append(a.Games[gameName], identity)
+
+	return nil
+}
+
+// Enabled reports whether any allowlist entries are configured.
+func (a JoinACL) Enabled() bool {
+	return len(a.Global) > 0 || len(a.Games) > 0
+}
+
+// Allowed returns the identities allowed to join the named game.
+// An empty result means the game is open to everyone.
+func (a JoinACL) Allowed(gameName string) []string {
+	allowed := make([]string, 0, len(a.Global)+len(a.Games[gameName]))
+	allowed = append(allowed, a.Global...)
+
+	return append(allowed, a.Games[gameName]...)
+}
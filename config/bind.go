@@ -0,0 +1,270 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// Proxy bind modes. ProxyBind is a comma-separated list of these modes,
+// interface names and IP addresses.
+const (
+	ProxyBindAll       = "all"
+	ProxyBindLoopback  = "loopback"
+	ProxyBindLAN       = "lan"
+	ProxyBindTailscale = "tailscale"
+)
+
+// ErrInvalidProxyBind is returned for proxy bind entries that are neither a
+// known mode, an interface name nor an IP address.
+var ErrInvalidProxyBind = errors.New("invalid proxy bind address")
+
+// ErrNoProxyBindAddrs is returned when a proxy bind list resolves to no addresses.
+var ErrNoProxyBindAddrs = errors.New("proxy bind resolved to no addresses")
+
+// ErrGatewayLoopback is returned in gateway mode when the proxy only
+// listens on loopback, where clients on other machines cannot join.
+var ErrGatewayLoopback = errors.New("gateway mode needs the proxy to listen on the LAN")
+
+// tailscaleRange is the CGNAT range Tailscale assigns IPv4 addresses from.
+var tailscaleRange = netip.MustParsePrefix("100.64.0.0/10")
+
+// ParseProxyBind resolves a ProxyBind value to the IPv4 addresses the TCP
+// proxy should listen on. selfIP is the Tailscale IP used by the
+// "tailscale" mode; it may be invalid, or IPv6 on IPv6-only tailnets, where
+// WC3 cannot reach it. A nil result means all interfaces.
+func ParseProxyBind(s string, selfIP netip.Addr) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+
+	for entry := range strings.SplitSeq(s, ",") {
+		entry = strings.TrimSpace(entry)
+
+		var resolved []netip.Addr
+
+		switch strings.ToLower(entry) {
+		case "", ProxyBindAll:
+			return nil, nil
+		case ProxyBindLoopback:
+			resolved = []netip.Addr{netip.AddrFrom4([4]byte{127, 0, 0, 1})}
+		case ProxyBindTailscale:
+			if selfIP.Is4() {
+				resolved = []netip.Addr{selfIP}
+			}
+		case ProxyBindLAN:
+			resolved = LANAddrs()
+		default:
+			var err error
+
+			resolved, err = parseBindEntry(entry)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, ip := range resolved {
+			if !slices.Contains(addrs, ip) {
+				addrs = append(addrs, ip)
+			}
+		}
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrNoProxyBindAddrs, s)
+	}
+
+	return addrs, nil
+}
+
+// IsLoopbackOnly reports whether every address in addrs is a loopback address.
+// It is false for an empty list, which means all interfaces.
+func IsLoopbackOnly(addrs []netip.Addr) bool {
+	if len(addrs) == 0 {
+		return false
+	}
+
+	for _, ip := range addrs {
+		if !ip.IsLoopback() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseBindEntry parses an explicit IP address or interface name.
+func parseBindEntry(entry string) ([]netip.Addr, error) {
+	ip, err := netip.ParseAddr(entry)
+	if err == nil {
+		if !ip.Is4() {
+			return nil, fmt.Errorf("%w %q: only IPv4 is supported", ErrInvalidProxyBind, entry)
+		}
+
+		return []netip.Addr{ip}, nil
+	}
+
+	iface, err := net.InterfaceByName(entry)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: not an IP or interface name", ErrInvalidProxyBind, entry)
+	}
+
+	return interfaceAddrs(iface), nil
+}
+
+// ErrInvalidBroadcastIface is returned for broadcast interfaces that are
+// neither an interface name nor the IPv4 address of one.
+var ErrInvalidBroadcastIface = errors.New("invalid broadcast interface")
+
+// ParseBroadcastIfaces resolves a BroadcastIfaces value to the IPv4
+// addresses, with their subnet masks, of the interfaces games are announced
+// on. A nil result means the OS picks the interface.
+func ParseBroadcastIfaces(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for entry := range strings.SplitSeq(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		resolved, err := broadcastPrefixes(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range resolved {
+			if !slices.Contains(prefixes, p) {
+				prefixes = append(prefixes, p)
+			}
+		}
+	}
+
+	return prefixes, nil
+}
+
+// broadcastPrefixes returns the IPv4 prefixes of the interface named entry,
+// or of the one assigned the IP entry.
+func broadcastPrefixes(entry string) ([]netip.Prefix, error) {
+	ip, ipErr := netip.ParseAddr(entry)
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrInvalidBroadcastIface, entry, err)
+	}
+
+	for i := range ifaces {
+		prefixes := interfacePrefixes(&ifaces[i])
+
+		switch {
+		case ipErr != nil && ifaces[i].Name == entry:
+			if len(prefixes) == 0 {
+				return nil, fmt.Errorf("%w %q: no IPv4 address", ErrInvalidBroadcastIface, entry)
+			}
+
+			return prefixes, nil
+		case ipErr == nil:
+			for _, p := range prefixes {
+				if p.Addr() == ip {
+					return []netip.Prefix{p}, nil
+				}
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%w %q: not an interface name or address", ErrInvalidBroadcastIface, entry)
+}
+
+// LANAddrs returns the IPv4 addresses of all non-loopback interfaces that are
+// up, excluding Tailscale addresses.
+func LANAddrs() []netip.Addr {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var addrs []netip.Addr
+
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		for _, ip := range interfaceAddrs(iface) {
+			if !tailscaleRange.Contains(ip) {
+				addrs = append(addrs, ip)
+			}
+		}
+	}
+
+	return addrs
+}
+
+// LANPrefixes returns the IPv4 subnets of all non-loopback interfaces that
+// are up, excluding Tailscale's, masked to their network address.
+func LANPrefixes() []netip.Prefix {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var prefixes []netip.Prefix
+
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		ifaddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, a := range ifaddrs {
+			prefix, err := netip.ParsePrefix(a.String())
+			if err != nil || !prefix.Addr().Is4() || tailscaleRange.Contains(prefix.Addr()) {
+				continue
+			}
+
+			prefixes = append(prefixes, prefix.Masked())
+		}
+	}
+
+	return prefixes
+}
+
+// interfaceAddrs returns the IPv4 addresses assigned to iface.
+func interfaceAddrs(iface *net.Interface) []netip.Addr {
+	var addrs []netip.Addr
+
+	for _, prefix := range interfacePrefixes(iface) {
+		addrs = append(addrs, prefix.Addr())
+	}
+
+	return addrs
+}
+
+// interfacePrefixes returns the IPv4 addresses assigned to iface with the
+// length of their subnet.
+func interfacePrefixes(iface *net.Interface) []netip.Prefix {
+	ifaddrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+
+	var prefixes []netip.Prefix
+
+	for _, a := range ifaddrs {
+		prefix, err := netip.ParsePrefix(a.String())
+		if err != nil || !prefix.Addr().Is4() {
+			continue
+		}
+
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes
+}
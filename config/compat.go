@@ -0,0 +1,111 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/nielsAD/gowarcraft3/protocol"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// ErrInvalidCompatGroup is returned when a compatibility group cannot be parsed.
+var ErrInvalidCompatGroup = errors.New("invalid compatibility group")
+
+// CompatGroup is a set of product/version values that are LAN-compatible
+// even though clients announce them differently. Community patches and
+// launchers often keep the 1.26 network protocol but report another value.
+type CompatGroup struct {
+	Name     string
+	Versions []w3gs.GameVersion
+}
+
+// DefaultCompatGroups returns the compatibility groups from the version table.
+func DefaultCompatGroups() []CompatGroup {
+	return Versions().CompatGroupList()
+}
+
+// CompatibleVersions returns every announce value compatible with v,
+// starting with v itself. Without a matching group only v is returned.
+func CompatibleVersions(groups []CompatGroup, v w3gs.GameVersion) []w3gs.GameVersion {
+	result := []w3gs.GameVersion{v}
+
+	for _, group := range groups {
+		if !slices.Contains(group.Versions, v) {
+			continue
+		}
+
+		for _, other := range group.Versions {
+			if !slices.Contains(result, other) {
+				result = append(result, other)
+			}
+		}
+	}
+
+	return result
+}
+
+// ParseCompatGroup parses a group of the form "name=W3XP:1.26,W3XP:1.27".
+// The name is optional.
+func ParseCompatGroup(s string) (CompatGroup, error) {
+	name, list, found := strings.Cut(s, "=")
+	if !found {
+		name, list = "custom", s
+	}
+
+	group := CompatGroup{Name: strings.TrimSpace(name)}
+
+	for item := range strings.SplitSeq(list, ",") {
+		v, err := ParseGameVersion(item)
+		if err != nil {
+			return CompatGroup{}, fmt.Errorf("%w %q: %w", ErrInvalidCompatGroup, s, err)
+		}
+
+		group.Versions = append(group.Versions, v)
+	}
+
+	if len(group.Versions) < 2 { //nolint:mnd
+		return CompatGroup{}, fmt.Errorf("%w %q: need at least two versions", ErrInvalidCompatGroup, s)
+	}
+
+	return group, nil
+}
+
+// ParseGameVersion parses "W3XP:1.26", "WAR3:26" or just "1.26" (TFT).
+func ParseGameVersion(s string) (w3gs.GameVersion, error) {
+	s = strings.TrimSpace(s)
+	product := w3gs.ProductTFT
+
+	if prod, ver, found := strings.Cut(s, ":"); found {
+		p, err := ParseProduct(prod)
+		if err != nil {
+			return w3gs.GameVersion{}, err
+		}
+
+		product, s = p, ver
+	}
+
+	v, err := ParseVersion(s)
+	if err != nil {
+		return w3gs.GameVersion{}, err
+	}
+
+	return w3gs.GameVersion{Product: product, Version: v}, nil
+}
+
+// ErrUnknownProduct is returned for product codes not in the version table.
+var ErrUnknownProduct = errors.New("unknown product")
+
+// ParseProduct parses a product code or alias from the version table,
+// such as "W3XP", "TFT", "WAR3" or "ROC".
+func ParseProduct(s string) (protocol.DWordString, error) {
+	table := Versions()
+
+	product, ok := table.Product(s)
+	if !ok {
+		return 0, fmt.Errorf("%w %q (use %s)", ErrUnknownProduct, s, strings.Join(table.ProductCodes(), " or "))
+	}
+
+	return product, nil
+}
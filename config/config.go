@@ -2,25 +2,97 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net/netip"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/kradalby/wc3ts/impair"
+	"github.com/nielsAD/gowarcraft3/protocol"
 	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
 )
 
 // Default configuration values.
 const (
-	DefaultProbeInterval   = 2 * time.Second
-	DefaultRefreshInterval = 3 * time.Second
-	DefaultGameTimeout     = 10 * time.Second
+	DefaultProbeInterval    = 2 * time.Second
+	DefaultProbeBackoff     = time.Minute
+	DefaultRefreshInterval  = 3 * time.Second
+	DefaultIdleRefresh      = 15 * time.Second
+	DefaultBroadcastPace    = 2 * time.Millisecond
+	DefaultGameTimeout      = 10 * time.Second
+	DefaultIdleTimeout      = 10 * time.Minute
+	DefaultDrainTimeout     = 30 * time.Second
+	DefaultRelayIdleTimeout = 5 * time.Minute
+	DefaultRelayBufferSize  = 32 * 1024
+	DefaultTCPKeepAlive     = 15 * time.Second
+	DefaultMaxConnections   = 128
 
 	// DefaultGameVersion is TFT 1.26 - common for classic WC3 LAN parties.
 	// Classic WC3 versions: 26 (1.26), 27 (1.27), 28 (1.28).
 	DefaultGameVersion = 26
+
+	// DefaultUDPReceiveBuffer is the SO_RCVBUF size for discovery sockets,
+	// large enough to absorb bursts of GameInfo replies during probe sweeps.
+	DefaultUDPReceiveBuffer = 256 * 1024
+
+	// DefaultAttachPort is the Tailscale port remote TUIs attach to.
+	DefaultAttachPort = 6115
+
+	// DefaultBenchPort is the Tailscale port peers run 'wc3ts bench' against.
+	DefaultBenchPort = 6116
+
+	// DefaultLANPort is the standard WC3 LAN discovery port.
+	DefaultLANPort = 6112
+
+	// DefaultNameTemplate prefixes remote game names with their host.
+	DefaultNameTemplate = "{peer}: {name}"
+
+	// DefaultCharset detects the encoding of non-UTF-8 game names heuristically.
+	DefaultCharset = "auto"
+)
+
+// Probe bind modes.
+const (
+	ProbeBindAuto = "auto"
+	ProbeBindAny  = "any"
+)
+
+// Direct-connect modes.
+const (
+	DirectConnectOff  = "off"
+	DirectConnectAuto = "auto"
+	DirectConnectOn   = "on"
+)
+
+// Wine modes.
+const (
+	WineOff  = "off"
+	WineAuto = "auto"
+	WineOn   = "on"
 )
 
+// ErrInvalidDirectConnect is returned for unknown direct-connect modes.
+var ErrInvalidDirectConnect = errors.New("invalid direct-connect mode")
+
+// ErrInvalidWine is returned for unknown Wine modes.
+var ErrInvalidWine = errors.New("invalid wine mode")
+
+// ErrEmptyVersionList is returned for version lists without versions.
+var ErrEmptyVersionList = errors.New("empty version list")
+
+// ErrInvalidLANPort is returned for LAN ports outside 1-65535.
+var ErrInvalidLANPort = errors.New("invalid LAN port")
+
+// ErrInvalidProxyPort is returned for proxy ports outside 0-65535.
+var ErrInvalidProxyPort = errors.New("invalid proxy port")
+
+// ErrInvalidInterval is returned for probe and refresh intervals that are
+// not positive.
+var ErrInvalidInterval = errors.New("interval must be positive")
+
 // Config holds the configuration for the WC3 Tailscale proxy.
 type Config struct {
 	// GameVersion specifies the WC3 version to use.
@@ -30,14 +102,291 @@ type Config struct {
 	// ProbeInterval is how often to probe peers for games.
 	ProbeInterval time.Duration
 
+	// ProbeBackoff is the longest interval peers that do not answer are
+	// probed at. Zero probes every peer each ProbeInterval.
+	ProbeBackoff time.Duration
+
 	// RefreshInterval is how often to refresh game advertisements.
 	RefreshInterval time.Duration
 
+	// IdleRefresh is how often to refresh game advertisements while they
+	// are unchanged and no local WC3 client is running. Zero keeps
+	// RefreshInterval.
+	IdleRefresh time.Duration
+
+	// BroadcastPace is the pause between the packets of one round of
+	// game advertisements, plus up to half as much jitter, so bursts do
+	// not overflow Wi-Fi driver queues. Zero sends them back to back.
+	BroadcastPace time.Duration
+
+	// InstantSearch answers the local client's LAN searches with an
+	// announcement at once instead of on the next refresh (Linux only).
+	InstantSearch bool
+
 	// GameTimeout is how long before a game is considered stale.
 	GameTimeout time.Duration
 
-	// ShowPeerNames prefixes game names with peer hostname.
+	// IdleTimeout is how long without local activity (TUI input, a local
+	// game or a running WC3 client) before peer probing slows down.
+	// Zero disables the slowdown.
+	IdleTimeout time.Duration
+
+	// DrainTimeout is how long headless commands wait on shutdown for
+	// proxied game connections to finish.
+	DrainTimeout time.Duration
+
+	// Ghost keeps newly discovered local games private: they are shown on
+	// the local LAN but never reported to remote peers.
+	Ghost bool
+
+	// JoinACL restricts which tailnet identities may join locally hosted
+	// games, and games through our proxy when connecting over Tailscale.
+	// When empty, anyone who can reach the game may join.
+	JoinACL JoinACL
+
+	// AttachAllow lists the tailnet identities (login names, node names,
+	// Tailscale IPs or ACL tags) allowed to attach a TUI remotely with
+	// 'wc3ts attach <peer>'. Empty disables remote attach.
+	AttachAllow []string
+
+	// PeerAllow, if not empty, limits probing to the Tailscale peers
+	// matching an entry: a hostname glob, IP, CIDR prefix or ACL tag.
+	PeerAllow []string
+
+	// PeerDeny excludes the Tailscale peers matching an entry from
+	// probing, even if allowed.
+	PeerDeny []string
+
+	// GameAllow, if not empty, limits the games shown and rebroadcast to
+	// those whose name matches a pattern: a glob, or a regular expression
+	// between slashes.
+	GameAllow []string
+
+	// GameBlock hides games whose name matches a pattern, such as bot spam
+	// or AFK lobbies, even if allowed.
+	GameBlock []string
+
+	// StaticHosts are hosts outside the tailnet probed alongside
+	// Tailscale peers.
+	StaticHosts []StaticHost
+
+	// ProbeSubnets are IPv4 subnets outside the tailnet whose addresses
+	// are all probed, such as LANs behind Tailscale subnet routers.
+	ProbeSubnets []netip.Prefix
+
+	// AnnounceTargets are addresses games are also announced to by unicast,
+	// such as a VM whose virtual network never sees the host's broadcasts.
+	// A zero port means the LAN port.
+	AnnounceTargets []netip.AddrPort
+
+	// PeerAddrs are extra addresses peers' games are joined at when their
+	// Tailscale address cannot be reached.
+	PeerAddrs []PeerAddr
+
+	// AttachPort is the Tailscale port remote TUIs attach to.
+	AttachPort int
+
+	// BenchPort is the Tailscale UDP and TCP port answering 'wc3ts bench'
+	// from peers. Zero disables it.
+	BenchPort int
+
+	// LANPort is the UDP port WC3 clients discover games on. Peers and
+	// localhost are probed on it, the responder listens on it and games
+	// are broadcast to it. Some modified clients and PvPGN setups use
+	// 6113 or higher.
+	LANPort int
+
+	// FallbackPort is the UDP port the responder listens on when another
+	// program, usually WC3 itself, holds LANPort on the Tailscale IP and
+	// it cannot be shared. Peers are probed on it as well, so every node
+	// should use the same one. Zero disables the fallback.
+	FallbackPort int
+
+	// ShowPeerNames rewrites rebroadcast game names with NameTemplate so
+	// players can tell whose game they are joining.
 	ShowPeerNames bool
+
+	// NameTemplate is the game name shown for remote games when
+	// ShowPeerNames is set. {name} is the announced name, {peer} the
+	// host's peer name and {version} the announced version.
+	NameTemplate string
+
+	// CompatGroups lists product/version values treated as LAN-compatible.
+	// Games announced with any value in the local version's group are
+	// probed for and rebroadcast with the local version.
+	CompatGroups []CompatGroup
+
+	// AllVersions probes peers for every supported version, not only
+	// those compatible with GameVersion, and rebroadcasts all of them.
+	AllVersions bool
+
+	// VersionTags prefixes rebroadcast game names with their version
+	// (e.g. "[1.28] ") when games of several versions are advertised.
+	VersionTags bool
+
+	// LatencyTags appends the round trip to each host to its rebroadcast
+	// game names (e.g. " [43ms]"), so the closest host can be picked.
+	LatencyTags bool
+
+	// ProbeBind selects the source address for peer probes: "auto" binds to
+	// the Tailscale IP when known, "any" uses the wildcard address, and any
+	// other value is parsed as an explicit IP.
+	ProbeBind string
+
+	// ProxyBind restricts the addresses the TCP proxy listens on. It is a
+	// comma-separated list of "all", "loopback", "lan", "tailscale",
+	// interface names and IPs. Defaults to all interfaces.
+	ProxyBind string
+
+	// BroadcastIfaces selects the interfaces games are announced on, as a
+	// comma-separated list of interface names and their IPs. Each gets its
+	// subnet's broadcast address; empty leaves the choice to the OS.
+	BroadcastIfaces string
+
+	// ProxyPort pins the TCP proxy port so firewall rules can be made once.
+	// If it is taken, a random port is used instead. Zero always picks a
+	// random port.
+	ProxyPort int
+
+	// GamePorts gives each rebroadcast remote game its own TCP proxy port,
+	// so joins are routed by port rather than by HostCounter. The ports
+	// are random.
+	GamePorts bool
+
+	// UDPRelay forwards in-game W3GS datagrams that clients send to the
+	// proxy port on to the game host, alongside the TCP stream.
+	UDPRelay bool
+
+	// ReconnectWait is how long a client using GProxy++ may take to
+	// reconnect to a proxied game after its connection drops. Zero
+	// disables reconnecting.
+	ReconnectWait time.Duration
+
+	// RelayIdleTimeout closes proxied connections when either side sends
+	// nothing for this long, e.g. after the client vanished or the host
+	// crashed. Zero keeps them open.
+	RelayIdleTimeout time.Duration
+
+	// RelayBufferSize is the size of the pooled buffers relays copy
+	// through. Smaller buffers save memory with many connections.
+	RelayBufferSize int
+
+	// Splice relays proxied traffic with splice(2) on Linux, keeping it
+	// in the kernel. Only relays that nothing else needs to see, with no
+	// idle timeout, throttle or GProxy++ session, are spliced; packets
+	// are then not counted per connection.
+	Splice bool
+
+	// TCPNoDelay disables Nagle's algorithm on proxied connections, and
+	// TCPKeepAlive sets their keepalive probe interval (zero disables).
+	TCPNoDelay   bool
+	TCPKeepAlive time.Duration
+
+	// DSCP is the codepoint proxied traffic and LAN broadcasts are marked
+	// with, so routers with QoS prioritize it (zero leaves them unmarked).
+	DSCP int
+
+	// MaxConnections caps the simultaneous connections to the TCP proxy,
+	// so a misbehaving client or a scan cannot exhaust file descriptors.
+	// Zero removes the cap.
+	MaxConnections int
+
+	// Throttle caps each proxied connection at this many bytes per second
+	// in each direction, and ThrottleTotal all of them together, e.g. on a
+	// tethered uplink. Zero disables either limit.
+	Throttle      int
+	ThrottleTotal int
+
+	// Impair injects latency, jitter and loss into peer traffic
+	// to simulate bad network paths. Zero disables it.
+	Impair impair.Config
+
+	// UpdateCheck periodically checks GitHub for a newer release in the
+	// background and shows a notice in the TUI and 'wc3ts version'.
+	UpdateCheck bool
+
+	// ControlAddr is the listen address of the local control API
+	// (e.g. "127.0.0.1:6114"). Empty disables the API.
+	ControlAddr string
+
+	// ControlTokenFile holds the token the control API requires. It is
+	// created with a new token when missing and reused otherwise, so
+	// clients keep working across restarts. Empty uses control-token in
+	// the user config directory.
+	ControlTokenFile string
+
+	// Webhook is a URL every game lifecycle event is posted to as JSON.
+	// Empty disables it.
+	Webhook string
+
+	// UDPReceiveBuffer is the SO_RCVBUF size requested for the
+	// manager and responder sockets. Zero leaves the OS default.
+	UDPReceiveBuffer int
+
+	// MapsDir is the local Warcraft III Maps directory, used to show
+	// metadata for advertised maps. Empty disables map lookups.
+	MapsDir string
+
+	// HistoryDir is the directory probe, session and game history is
+	// appended to for later export. Empty disables history recording.
+	HistoryDir string
+
+	// AuditLog is the file every proxied join is appended to as a JSON
+	// line, including joins that were refused. Empty disables it.
+	AuditLog string
+
+	// Charset is the code page used to display game and host names
+	// sent by non-UTF-8 clients (e.g. "gbk", "cp949", "cp1251" or "auto").
+	Charset string
+
+	// DirectConnect controls whether reachable hosts announce their games
+	// straight to the local WC3 client instead of through the TCP proxy:
+	// "off", "on", or "auto" (enabled when tailscaled runs locally and the
+	// Tailscale game port is free for WC3).
+	DirectConnect string
+
+	// ReachCheck dials the game port of remote hosts before their games are
+	// advertised, and withholds those that cannot be connected to.
+	ReachCheck bool
+
+	// Compress deflates proxied sessions to hosts running wc3ts while the
+	// Tailscale path to them is relayed through DERP, and lets peers do
+	// the same for local games. Both sides need it.
+	Compress bool
+
+	// LANBypass stops rebroadcasting the games of peers whose direct
+	// Tailscale path runs over a local LAN subnet: their hosts answer the
+	// local WC3 client's searches themselves, without the proxy's hop.
+	LANBypass bool
+
+	// LANBridge offers games hosted by other machines on the local LAN
+	// subnets to Tailscale peers, joined through the guard, so one node
+	// can share a whole LAN party with remote friends.
+	LANBridge bool
+
+	// MDNS advertises this instance on the local LAN over mDNS and probes
+	// the other instances found, so nodes on one LAN share games without
+	// a tailnet between them.
+	MDNS bool
+
+	// Gateway serves WC3 clients on other machines of the LAN instead of
+	// one on this machine, so only the gateway needs Tailscale. Features
+	// that assume a local client (direct-connect, Wine adaptations and
+	// the idle slowdowns) default to off.
+	Gateway bool
+
+	// Wine adapts local probing and announcements to a WC3 client running
+	// under Wine or Proton: "off", "on", or "auto" (enabled while such a
+	// client is detected; Linux only).
+	Wine string
+
+	// ConfigFile is the flag file the configuration was read from, watched
+	// for changes while running. Empty disables reloading.
+	ConfigFile string
+
+	// CommandLine lists the flags given on the command line. They take
+	// precedence over the config file, including when it is reloaded.
+	CommandLine []string
 }
 
 // Default returns the default configuration.
@@ -47,10 +396,35 @@ func Default() *Config {
 			Product: w3gs.ProductTFT,
 			Version: DefaultGameVersion,
 		},
-		ProbeInterval:   DefaultProbeInterval,
-		RefreshInterval: DefaultRefreshInterval,
-		GameTimeout:     DefaultGameTimeout,
-		ShowPeerNames:   true,
+		ProbeInterval:    DefaultProbeInterval,
+		ProbeBackoff:     DefaultProbeBackoff,
+		RefreshInterval:  DefaultRefreshInterval,
+		InstantSearch:    true,
+		IdleRefresh:      DefaultIdleRefresh,
+		BroadcastPace:    DefaultBroadcastPace,
+		GameTimeout:      DefaultGameTimeout,
+		IdleTimeout:      DefaultIdleTimeout,
+		DrainTimeout:     DefaultDrainTimeout,
+		RelayIdleTimeout: DefaultRelayIdleTimeout,
+		RelayBufferSize:  DefaultRelayBufferSize,
+		Splice:           true,
+		TCPNoDelay:       true,
+		TCPKeepAlive:     DefaultTCPKeepAlive,
+		MaxConnections:   DefaultMaxConnections,
+		AttachPort:       DefaultAttachPort,
+		BenchPort:        DefaultBenchPort,
+		LANPort:          DefaultLANPort,
+		ShowPeerNames:    true,
+		NameTemplate:     DefaultNameTemplate,
+		VersionTags:      true,
+		Charset:          DefaultCharset,
+		UDPReceiveBuffer: DefaultUDPReceiveBuffer,
+		ProbeBind:        ProbeBindAuto,
+		ProxyBind:        ProxyBindAll,
+		UDPRelay:         true,
+		DirectConnect:    DirectConnectAuto,
+		Wine:             WineAuto,
+		CompatGroups:     DefaultCompatGroups(),
 	}
 }
 
@@ -72,12 +446,103 @@ func ParseVersion(s string) (uint32, error) {
 	return uint32(v), nil
 }
 
+// ParseVersionList parses a comma-separated list of versions such as
+// "1.24,26,1.31", dropping duplicates.
+func ParseVersionList(s string) ([]uint32, error) {
+	var versions []uint32
+
+	for item := range strings.SplitSeq(s, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+
+		v, err := ParseVersion(item)
+		if err != nil {
+			return nil, err
+		}
+
+		if !slices.Contains(versions, v) {
+			versions = append(versions, v)
+		}
+	}
+
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrEmptyVersionList, s)
+	}
+
+	return versions, nil
+}
+
+// ParseProbeBind resolves a ProbeBind value to the address to bind to.
+// selfIP is the Tailscale IP used in auto mode; it may be invalid.
+// An invalid result means the socket should not be bound.
+func ParseProbeBind(s string, selfIP netip.Addr) (netip.Addr, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", ProbeBindAuto:
+		return selfIP, nil
+	case ProbeBindAny:
+		return netip.Addr{}, nil
+	}
+
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid probe bind address %q: %w", s, err)
+	}
+
+	return ip, nil
+}
+
+// ParseDirectConnect validates and normalizes a DirectConnect mode.
+func ParseDirectConnect(s string) (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(s))
+
+	switch mode {
+	case DirectConnectOff, DirectConnectAuto, DirectConnectOn:
+		return mode, nil
+	case "":
+		return DirectConnectAuto, nil
+	}
+
+	return "", fmt.Errorf("%w: %q", ErrInvalidDirectConnect, s)
+}
+
+// ParseWine validates a Wine mode, defaulting to auto.
+func ParseWine(s string) (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(s))
+
+	switch mode {
+	case WineOff, WineAuto, WineOn:
+		return mode, nil
+	case "":
+		return WineAuto, nil
+	}
+
+	return "", fmt.Errorf("%w: %q", ErrInvalidWine, s)
+}
+
 // FormatVersion formats a version number as "1.XX".
 func FormatVersion(v uint32) string {
 	return fmt.Sprintf("1.%d", v)
 }
 
-// SupportedVersions returns the list of supported WC3 versions.
+// SupportedVersions returns the list of supported WC3 versions
+// from the version table.
 func SupportedVersions() []uint32 {
-	return []uint32{26, 27, 28}
+	return Versions().SupportedVersions()
+}
+
+// AllGameVersions returns every supported version of every product in the
+// version table, as probed for when the game version is detected.
+func AllGameVersions() []w3gs.GameVersion {
+	table := Versions()
+
+	var versions []w3gs.GameVersion
+
+	for _, code := range table.ProductCodes() {
+		for _, v := range table.SupportedVersions() {
+			versions = append(versions, w3gs.GameVersion{Product: protocol.DString(code), Version: v})
+		}
+	}
+
+	return versions
 }
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidDSCP is returned for unknown DSCP names and out of range values.
+var ErrInvalidDSCP = errors.New("invalid DSCP value (use a name like ef, cs4, af41 or 0-63)")
+
+// DSCP codepoints.
+const (
+	dscpEF  = 46 // Expedited Forwarding, for latency sensitive traffic
+	maxDSCP = 63
+)
+
+// ParseDSCP parses a DSCP codepoint given by name ("ef", "cs0" to "cs7",
+// "af11" to "af43") or number (0-63).
+func ParseDSCP(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	switch {
+	case s == "ef":
+		return dscpEF, nil
+	case len(s) == 3 && strings.HasPrefix(s, "cs") && s[2] >= '0' && s[2] <= '7':
+		return int(s[2]-'0') << 3, nil
+	case len(s) == 4 && strings.HasPrefix(s, "af") && s[2] >= '1' && s[2] <= '4' && s[3] >= '1' && s[3] <= '3':
+		return int(s[2]-'0')<<3 | int(s[3]-'0')<<1, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 || v > maxDSCP {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidDSCP, s)
+	}
+
+	return v, nil
+}
+
+// SetDSCP sets DSCP from a name or number, for use as a flag.
+func (c *Config) SetDSCP(s string) error {
+	v, err := ParseDSCP(s)
+	if err != nil {
+		return err
+	}
+
+	c.DSCP = v
+
+	return nil
+}
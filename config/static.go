@@ -0,0 +1,176 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidStaticHost is returned when a static host cannot be parsed.
+var ErrInvalidStaticHost = errors.New("invalid static host")
+
+// ErrInvalidAnnounceTarget is returned when an announce target cannot be parsed.
+var ErrInvalidAnnounceTarget = errors.New("invalid announce target")
+
+// ErrInvalidPeerAddr is returned when a peer address cannot be parsed.
+var ErrInvalidPeerAddr = errors.New("invalid peer address")
+
+// ErrInvalidProbeSubnet is returned when a subnet to probe cannot be parsed
+// or is too large.
+var ErrInvalidProbeSubnet = errors.New("invalid subnet to probe")
+
+// MinProbeSubnetBits is the shortest prefix of a subnet to probe: every
+// address of it is searched, so it must stay small.
+const MinProbeSubnetBits = 22
+
+// MaxProbeSubnetAddrs is the most addresses all subnets to probe may hold
+// together, as each of them is searched every sweep.
+const MaxProbeSubnetAddrs = 2048
+
+// StaticHost is a host outside the tailnet that is probed for games
+// alongside Tailscale peers, such as a WireGuard-only machine or one on a
+// routed subnet.
+type StaticHost struct {
+	// Name is shown as the host of its games.
+	Name string
+
+	// Host is an IPv4 address or a hostname, resolved on every probe.
+	Host string
+
+	// Port is the UDP port to probe. Zero uses the LAN port.
+	Port int
+}
+
+// ParseStaticHost parses a static host of the form "name=host:port".
+// The name and port are optional; the name defaults to the host.
+func ParseStaticHost(s string) (StaticHost, error) {
+	name, addr, found := strings.Cut(s, "=")
+	if !found {
+		name, addr = "", s
+	}
+
+	h := StaticHost{Name: strings.TrimSpace(name), Host: strings.TrimSpace(addr)}
+
+	if host, port, err := net.SplitHostPort(h.Host); err == nil {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || p == 0 {
+			return StaticHost{}, fmt.Errorf("%w: %q: bad port %q", ErrInvalidStaticHost, s, port)
+		}
+
+		h.Host, h.Port = host, int(p)
+	}
+
+	if h.Host == "" || strings.ContainsAny(h.Host, " /") {
+		return StaticHost{}, fmt.Errorf("%w: %q", ErrInvalidStaticHost, s)
+	}
+
+	if h.Name == "" {
+		h.Name = h.Host
+	}
+
+	return h, nil
+}
+
+// AddStaticHost parses and adds a static host.
+func (c *Config) AddStaticHost(s string) error {
+	h, err := ParseStaticHost(s)
+	if err != nil {
+		return err
+	}
+
+	c.StaticHosts = append(c.StaticHosts, h)
+
+	return nil
+}
+
+// AddProbeSubnet parses and adds an IPv4 subnet whose addresses are all
+// probed, such as an office LAN reached through a Tailscale subnet router.
+func (c *Config) AddProbeSubnet(s string) error {
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("%w: %q: %w", ErrInvalidProbeSubnet, s, err)
+	}
+
+	if !prefix.Addr().Is4() {
+		return fmt.Errorf("%w: %q: only IPv4 is supported", ErrInvalidProbeSubnet, s)
+	}
+
+	if prefix.Bits() < MinProbeSubnetBits {
+		return fmt.Errorf("%w: %q: subnets larger than /%d are not supported", ErrInvalidProbeSubnet, s, MinProbeSubnetBits)
+	}
+
+	total := subnetSize(prefix)
+	for _, p := range c.ProbeSubnets {
+		total += subnetSize(p)
+	}
+
+	if total > MaxProbeSubnetAddrs {
+		return fmt.Errorf("%w: %q: subnets to probe may hold at most %d addresses together",
+			ErrInvalidProbeSubnet, s, MaxProbeSubnetAddrs)
+	}
+
+	c.ProbeSubnets = append(c.ProbeSubnets, prefix.Masked())
+
+	return nil
+}
+
+// subnetSize returns the number of addresses in the IPv4 subnet prefix.
+func subnetSize(prefix netip.Prefix) int {
+	return 1 << (prefix.Addr().BitLen() - prefix.Bits())
+}
+
+// PeerAddr is an extra address a peer's games can be joined at when its
+// Tailscale address fails, such as its address on a shared LAN.
+type PeerAddr struct {
+	// Peer is the peer's name or Tailscale IP.
+	Peer string
+
+	// Addr is the address to try.
+	Addr netip.Addr
+}
+
+// AddPeerAddr parses and adds a peer address of the form "peer=ip".
+func (c *Config) AddPeerAddr(s string) error {
+	peer, addr, found := strings.Cut(s, "=")
+	peer = strings.TrimSpace(peer)
+
+	if !found || peer == "" {
+		return fmt.Errorf("%w: %q: want peer=ip", ErrInvalidPeerAddr, s)
+	}
+
+	ip, err := netip.ParseAddr(strings.TrimSpace(addr))
+	if err != nil {
+		return fmt.Errorf("%w: %q: %w", ErrInvalidPeerAddr, s, err)
+	}
+
+	c.PeerAddrs = append(c.PeerAddrs, PeerAddr{Peer: peer, Addr: ip.Unmap()})
+
+	return nil
+}
+
+// AddAnnounceTarget parses and adds an IPv4 address games are also
+// announced to, as "ip" or "ip:port". Without a port the LAN port is used.
+func (c *Config) AddAnnounceTarget(s string) error {
+	s = strings.TrimSpace(s)
+
+	target, err := netip.ParseAddrPort(s)
+	if err != nil {
+		ip, ipErr := netip.ParseAddr(s)
+		if ipErr != nil {
+			return fmt.Errorf("%w: %q: want ip or ip:port", ErrInvalidAnnounceTarget, s)
+		}
+
+		target = netip.AddrPortFrom(ip, 0)
+	}
+
+	if !target.Addr().Unmap().Is4() {
+		return fmt.Errorf("%w: %q: only IPv4 is supported", ErrInvalidAnnounceTarget, s)
+	}
+
+	c.AnnounceTargets = append(c.AnnounceTargets, netip.AddrPortFrom(target.Addr().Unmap(), target.Port()))
+
+	return nil
+}
@@ -0,0 +1,260 @@
+package config
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/nielsAD/gowarcraft3/protocol"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// builtinVersions is the version table shipped with wc3ts.
+//
+//go:embed versions.json
+var builtinVersions []byte
+
+// ErrInvalidVersionTable is returned when a version table cannot be loaded.
+var ErrInvalidVersionTable = errors.New("invalid version table")
+
+// VersionTable holds the data-driven knowledge about game versions:
+// product codes, supported versions with their quirks, and the
+// compatibility groups. A user file can replace the built-in table so
+// new patches can be supported without a code change.
+type VersionTable struct {
+	Products     []ProductInfo      `json:"products"`
+	Versions     []VersionInfo      `json:"versions"`
+	CompatGroups []compatGroupEntry `json:"compatGroups"`
+}
+
+// ProductInfo describes a product code and the names it may be given as.
+type ProductInfo struct {
+	Code    string   `json:"code"`
+	Aliases []string `json:"aliases"`
+	Name    string   `json:"name"`
+}
+
+// VersionInfo describes a supported version and its protocol quirks.
+type VersionInfo struct {
+	Version uint32 `json:"version"`
+
+	// MaxSlots is the largest lobby the version supports.
+	// GameInfo packets announcing more slots are dropped.
+	MaxSlots uint32 `json:"maxSlots"`
+}
+
+// compatGroupEntry is the table form of a CompatGroup, with versions
+// written as "W3XP:1.26".
+type compatGroupEntry struct {
+	Name     string   `json:"name"`
+	Comment  string   `json:"comment,omitempty"`
+	Versions []string `json:"versions"`
+}
+
+var (
+	versionTable   *VersionTable
+	versionTableMu sync.RWMutex
+)
+
+// Versions returns the active version table.
+func Versions() *VersionTable {
+	versionTableMu.RLock()
+	table := versionTable
+	versionTableMu.RUnlock()
+
+	if table != nil {
+		return table
+	}
+
+	table, err := parseVersionTable(builtinVersions)
+	if err != nil {
+		panic(fmt.Sprintf("built-in version table: %v", err))
+	}
+
+	versionTableMu.Lock()
+	defer versionTableMu.Unlock()
+
+	if versionTable == nil {
+		versionTable = table
+	}
+
+	return versionTable
+}
+
+// LoadVersionTable replaces the active version table with the one in path.
+// Call it before the table is used, typically right after parsing flags.
+func LoadVersionTable(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidVersionTable, err)
+	}
+
+	table, err := parseVersionTable(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	versionTableMu.Lock()
+	defer versionTableMu.Unlock()
+
+	versionTable = table
+
+	return nil
+}
+
+// SelectVersions limits the supported versions of the active table to
+// versions, in the given order. Versions missing from the table are added
+// without quirks. Call it before the table is used, after LoadVersionTable.
+func SelectVersions(versions []uint32) {
+	current := Versions()
+
+	table := *current
+	table.Versions = make([]VersionInfo, 0, len(versions))
+
+	for _, v := range versions {
+		info, ok := current.Version(v)
+		if !ok {
+			info = VersionInfo{Version: v}
+		}
+
+		table.Versions = append(table.Versions, info)
+	}
+
+	versionTableMu.Lock()
+	defer versionTableMu.Unlock()
+
+	versionTable = &table
+}
+
+// parseVersionTable decodes and validates a version table.
+func parseVersionTable(data []byte) (*VersionTable, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var table VersionTable
+
+	err := dec.Decode(&table)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidVersionTable, err)
+	}
+
+	if len(table.Products) == 0 || len(table.Versions) == 0 {
+		return nil, fmt.Errorf("%w: products and versions are required", ErrInvalidVersionTable)
+	}
+
+	for _, p := range table.Products {
+		if len(p.Code) != 4 { //nolint:mnd
+			return nil, fmt.Errorf("%w: product code %q must be 4 characters", ErrInvalidVersionTable, p.Code)
+		}
+	}
+
+	for _, entry := range table.CompatGroups {
+		_, err := table.compatGroup(entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &table, nil
+}
+
+// SupportedVersions returns the version numbers in the table.
+func (t *VersionTable) SupportedVersions() []uint32 {
+	versions := make([]uint32, 0, len(t.Versions))
+
+	for _, v := range t.Versions {
+		versions = append(versions, v.Version)
+	}
+
+	return versions
+}
+
+// Version returns the table entry for version v.
+func (t *VersionTable) Version(v uint32) (VersionInfo, bool) {
+	i := slices.IndexFunc(t.Versions, func(info VersionInfo) bool { return info.Version == v })
+	if i < 0 {
+		return VersionInfo{}, false
+	}
+
+	return t.Versions[i], true
+}
+
+// Product returns the product code named s, matching codes and aliases.
+func (t *VersionTable) Product(s string) (protocol.DWordString, bool) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+
+	for _, p := range t.Products {
+		if s == p.Code || slices.Contains(p.Aliases, s) {
+			return protocol.DString(p.Code), true
+		}
+	}
+
+	return 0, false
+}
+
+// ProductCodes returns the product codes in the table.
+func (t *VersionTable) ProductCodes() []string {
+	codes := make([]string, 0, len(t.Products))
+
+	for _, p := range t.Products {
+		codes = append(codes, p.Code)
+	}
+
+	return codes
+}
+
+// CompatGroupList returns the compatibility groups in the table.
+func (t *VersionTable) CompatGroupList() []CompatGroup {
+	groups := make([]CompatGroup, 0, len(t.CompatGroups))
+
+	for _, entry := range t.CompatGroups {
+		group, err := t.compatGroup(entry)
+		if err == nil {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
+
+// compatGroup converts a table entry to a CompatGroup.
+func (t *VersionTable) compatGroup(entry compatGroupEntry) (CompatGroup, error) {
+	group := CompatGroup{Name: entry.Name}
+
+	for _, s := range entry.Versions {
+		v, err := t.parseGameVersion(s)
+		if err != nil {
+			return CompatGroup{}, fmt.Errorf("%w: group %q: %w", ErrInvalidVersionTable, entry.Name, err)
+		}
+
+		group.Versions = append(group.Versions, v)
+	}
+
+	return group, nil
+}
+
+// parseGameVersion parses "W3XP:1.26" using the table's product codes.
+func (t *VersionTable) parseGameVersion(s string) (w3gs.GameVersion, error) {
+	prod, ver, found := strings.Cut(strings.TrimSpace(s), ":")
+	if !found {
+		prod, ver = "W3XP", prod
+	}
+
+	product, ok := t.Product(prod)
+	if !ok {
+		return w3gs.GameVersion{}, fmt.Errorf("%w: %s", ErrUnknownProduct, prod)
+	}
+
+	v, err := ParseVersion(ver)
+	if err != nil {
+		return w3gs.GameVersion{}, err
+	}
+
+	return w3gs.GameVersion{Product: product, Version: v}, nil
+}
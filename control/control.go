@@ -0,0 +1,305 @@
+// Package control provides a local HTTP API for controlling a running wc3ts instance.
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kradalby/wc3ts/game"
+)
+
+// shutdownTimeout bounds how long in-flight API requests may take on shutdown.
+const shutdownTimeout = 2 * time.Second
+
+// readHeaderTimeout bounds how long a client may take to send request headers.
+const readHeaderTimeout = 5 * time.Second
+
+// Subsystem is a component that can be paused and resumed at runtime.
+type Subsystem interface {
+	Pause()
+	Resume()
+	Paused() bool
+}
+
+// Switch is an embeddable Subsystem implementation backed by an atomic flag.
+// The zero value is running.
+type Switch struct {
+	paused atomic.Bool
+}
+
+// Pause pauses the subsystem.
+func (s *Switch) Pause() {
+	s.paused.Store(true)
+}
+
+// Resume resumes the subsystem.
+func (s *Switch) Resume() {
+	s.paused.Store(false)
+}
+
+// Paused reports whether the subsystem is paused.
+func (s *Switch) Paused() bool {
+	return s.paused.Load()
+}
+
+// SubsystemState is the JSON representation of a subsystem.
+type SubsystemState struct {
+	Name   string `json:"name"`
+	Paused bool   `json:"paused"`
+}
+
+// Server serves the control API.
+type Server struct {
+	subsystems map[string]Subsystem
+	onChange   func()
+	registry   *game.Registry
+	proxyPort  int
+	token      string
+	srv        *http.Server
+
+	mu          sync.Mutex
+	transitions map[game.State]uint64 // lifecycle transitions by state entered
+	streams     map[chan game.Event]struct{}
+}
+
+// NewServer creates a control API server. onChange, if non-nil, is called
+// after a subsystem is paused or resumed.
+func NewServer(subsystems map[string]Subsystem, onChange func()) *Server {
+	s := &Server{
+		subsystems:  subsystems,
+		onChange:    onChange,
+		transitions: make(map[game.State]uint64),
+		streams:     make(map[chan game.Event]struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/subsystems", s.handleList)
+	mux.HandleFunc("POST /v1/subsystems/{name}/{action}", s.handleAction)
+	mux.HandleFunc("GET /v1/games", s.handleGames)
+	mux.HandleFunc("GET /v1/games/events", s.handleGameEvents)
+	mux.HandleFunc("GET /v1/games/events/stream", s.handleGameStream)
+	mux.HandleFunc("GET /v1/games/export", s.handleGameExport)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+
+	s.srv = &http.Server{
+		Handler:           s.guard(mux),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	return s
+}
+
+// SetToken sets the token every request must carry, see Authorize.
+// Without one, all requests are rejected. It must be called before Run.
+func (s *Server) SetToken(token string) {
+	s.token = token
+}
+
+// SetRegistry sets the game registry exposed by the games, event stream
+// and metrics endpoints. It must be called before Run.
+func (s *Server) SetRegistry(registry *game.Registry) {
+	s.registry = registry
+	registry.Subscribe(s.countTransition)
+	registry.Subscribe(s.publish)
+}
+
+// SetProxyPort sets the TCP proxy port used to report where WC3 joins
+// remote games. It must be called before Run.
+func (s *Server) SetProxyPort(port int) {
+	s.proxyPort = port
+}
+
+// Run serves the API on addr until the context is cancelled.
+func (s *Server) Run(ctx context.Context, addr string) error {
+	lc := &net.ListenConfig{}
+
+	listener, err := lc.Listen(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("control API listening", "addr", listener.Addr())
+
+	// Event streams only end with their request context
+	s.srv.BaseContext = func(net.Listener) context.Context { return ctx }
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		_ = s.srv.Shutdown(shutdownCtx) //nolint:contextcheck // ctx is already cancelled
+	}()
+
+	err = s.srv.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// States returns the state of every subsystem, sorted by name.
+func (s *Server) States() []SubsystemState {
+	states := make([]SubsystemState, 0, len(s.subsystems))
+
+	for name, sub := range s.subsystems {
+		states = append(states, SubsystemState{Name: name, Paused: sub.Paused()})
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+
+	return states
+}
+
+// handleList returns the state of all subsystems.
+func (s *Server) handleList(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.States())
+}
+
+// handleAction pauses, resumes or starts a subsystem.
+func (s *Server) handleAction(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	sub, ok := s.subsystems[name]
+	if !ok {
+		http.Error(w, "unknown subsystem", http.StatusNotFound)
+
+		return
+	}
+
+	switch r.PathValue("action") {
+	case "pause":
+		sub.Pause()
+	case "resume", "start":
+		sub.Resume()
+	default:
+		http.Error(w, "unknown action (use pause, resume or start)", http.StatusNotFound)
+
+		return
+	}
+
+	slog.Info("subsystem state changed via control API", "subsystem", name, "paused", sub.Paused())
+
+	if s.onChange != nil {
+		s.onChange()
+	}
+
+	writeJSON(w, http.StatusOK, SubsystemState{Name: name, Paused: sub.Paused()})
+}
+
+// GameState is the JSON representation of a game.
+type GameState struct {
+	Key         string     `json:"key"`
+	Name        string     `json:"name"`
+	Source      string     `json:"source"`
+	Host        string     `json:"host,omitempty"`
+	Map         string     `json:"map"`
+	HostCounter uint32     `json:"hostCounter"`
+	State       game.State `json:"state"`
+	Players     uint32     `json:"players"`
+	Slots       uint32     `json:"slots"`
+	FirstSeen   time.Time  `json:"firstSeen"`
+	LastSeen    time.Time  `json:"lastSeen"`
+
+	// Join is the address the local WC3 client connects to for the game.
+	Join string `json:"join"`
+}
+
+// handleGames returns all games with their lifecycle state.
+func (s *Server) handleGames(w http.ResponseWriter, _ *http.Request) {
+	if s.registry == nil {
+		writeJSON(w, http.StatusOK, []GameState{})
+
+		return
+	}
+
+	games := s.registry.Games()
+	states := make([]GameState, 0, len(games))
+
+	for i := range games {
+		g := &games[i]
+		states = append(states, GameState{
+			Key:         g.Key(),
+			Name:        g.Info.GameName,
+			Source:      string(g.Source),
+			Host:        g.PeerName,
+			Map:         g.Info.GameSettings.MapPath,
+			HostCounter: g.Info.HostCounter,
+			State:       g.State,
+			Players:     g.Info.SlotsUsed,
+			Slots:       g.Info.SlotsTotal,
+			FirstSeen:   g.FirstSeen,
+			LastSeen:    g.LastSeen,
+			Join:        g.JoinAddr(s.proxyPort).String(),
+		})
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Key < states[j].Key })
+
+	writeJSON(w, http.StatusOK, states)
+}
+
+// handleGameEvents returns the recent game lifecycle transitions.
+func (s *Server) handleGameEvents(w http.ResponseWriter, _ *http.Request) {
+	if s.registry == nil {
+		writeJSON(w, http.StatusOK, []game.Event{})
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.registry.Events())
+}
+
+// handleGameExport exports all games with raw packets as JSON or, with
+// ?format=csv, CSV.
+func (s *Server) handleGameExport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = game.FormatJSON
+	}
+
+	var games []game.Game
+	if s.registry != nil {
+		games = s.registry.Games()
+	}
+
+	var buf bytes.Buffer
+
+	err := game.Export(&buf, games, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	contentType := "application/json"
+	if strings.EqualFold(format, game.FormatCSV) {
+		contentType = "text/csv"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// writeJSON writes v as a JSON response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		slog.Debug("failed to write control API response", "error", err)
+	}
+}
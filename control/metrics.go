@@ -0,0 +1,94 @@
+package control
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/kradalby/wc3ts/game"
+)
+
+// metricsContentType is the Prometheus text exposition format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// countTransition records a lifecycle transition for the metrics. It is
+// called with the registry locked.
+func (s *Server) countTransition(ev game.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.transitions[ev.To]++
+}
+
+// handleMetrics returns the game and subsystem state in the Prometheus
+// text format.
+func (s *Server) handleMetrics(w http.ResponseWriter, _ *http.Request) {
+	var buf bytes.Buffer
+
+	type gameKey struct {
+		source game.Source
+		state  game.State
+	}
+
+	games := make(map[gameKey]int)
+
+	if s.registry != nil {
+		all := s.registry.Games()
+		for i := range all {
+			games[gameKey{all[i].Source, all[i].State}]++
+		}
+	}
+
+	keys := make([]gameKey, 0, len(games))
+	for k := range games {
+		keys = append(keys, k)
+	}
+
+	slices.SortFunc(keys, func(a, b gameKey) int {
+		return cmp.Or(cmp.Compare(a.source, b.source), cmp.Compare(a.state, b.state))
+	})
+
+	writeHelp(&buf, "wc3ts_games", "gauge", "Games in the registry by source and lifecycle state.")
+
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "wc3ts_games{source=%q,state=%q} %d\n", k.source, k.state, games[k])
+	}
+
+	s.mu.Lock()
+	states := make([]game.State, 0, len(s.transitions))
+
+	for state := range s.transitions {
+		states = append(states, state)
+	}
+
+	slices.Sort(states)
+
+	writeHelp(&buf, "wc3ts_game_transitions_total", "counter", "Game lifecycle transitions by the state entered.")
+
+	for _, state := range states {
+		fmt.Fprintf(&buf, "wc3ts_game_transitions_total{state=%q} %d\n", state, s.transitions[state])
+	}
+
+	s.mu.Unlock()
+
+	writeHelp(&buf, "wc3ts_subsystem_paused", "gauge", "Whether a subsystem is paused via the control API.")
+
+	for _, sub := range s.States() {
+		paused := 0
+		if sub.Paused {
+			paused = 1
+		}
+
+		fmt.Fprintf(&buf, "wc3ts_subsystem_paused{subsystem=%q} %d\n", sub.Name, paused)
+	}
+
+	w.Header().Set("Content-Type", metricsContentType)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// writeHelp writes the HELP and TYPE lines of a metric.
+func writeHelp(buf *bytes.Buffer, name, typ, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
@@ -0,0 +1,79 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/kradalby/wc3ts/game"
+)
+
+// streamBuffer is how many events a slow stream client may fall behind
+// before further events are dropped for it.
+const streamBuffer = 64
+
+// publish hands a lifecycle transition to every stream client. It is
+// called with the registry locked, so clients that are not keeping up
+// miss the event instead of blocking the registry.
+func (s *Server) publish(ev game.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ch := range s.streams {
+		select {
+		case ch <- ev:
+		default:
+			slog.Debug("dropping game event for slow stream client", "key", ev.Key, "to", ev.To)
+		}
+	}
+}
+
+// handleGameStream streams lifecycle transitions as server-sent events
+// until the client goes away or the server shuts down.
+func (s *Server) handleGameStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok || s.registry == nil {
+		http.Error(w, "streaming not available", http.StatusNotImplemented)
+
+		return
+	}
+
+	ch := make(chan game.Event, streamBuffer)
+
+	s.mu.Lock()
+	s.streams[ch] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.streams, ch)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-ch:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				slog.Debug("failed to encode game event", "error", err)
+
+				continue
+			}
+
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.To, data)
+			if err != nil {
+				return
+			}
+
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package control
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// tokenBytes is the length of the random API token.
+	tokenBytes = 32
+
+	// tokenPerm keeps the token file readable by its owner only, so other
+	// local users cannot use the API.
+	tokenPerm = 0o600
+
+	// tokenDirPerm is used when creating the token's directory.
+	tokenDirPerm = 0o700
+
+	// bearer prefixes the token in the Authorization header.
+	bearer = "Bearer "
+)
+
+// TokenFile is the default name of the file holding the API token.
+const TokenFile = "control-token"
+
+// ErrNoToken is returned when no token file was written, usually because
+// no instance with the control API is running.
+var ErrNoToken = errors.New("no control API token, start wc3ts with -control-addr")
+
+// NewToken returns a random API token.
+func NewToken() (string, error) {
+	b := make([]byte, tokenBytes)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// WriteToken writes token to path for local clients to read.
+func WriteToken(path, token string) error {
+	err := os.MkdirAll(filepath.Dir(path), tokenDirPerm)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, []byte(token+"\n"), tokenPerm)
+}
+
+// ReadToken reads the token a running instance wrote to path.
+func ReadToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrNoToken
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// LoadToken returns the token kept in path. On first use, when there is
+// no file yet, a new token is generated and written there.
+func LoadToken(path string) (string, error) {
+	token, err := ReadToken(path)
+	if !errors.Is(err, ErrNoToken) {
+		if err == nil && token == "" {
+			return "", fmt.Errorf("%w: %s is empty", ErrNoToken, path)
+		}
+
+		return token, err
+	}
+
+	token, err = NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = WriteToken(path, token)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Authorize adds token to a request to the API.
+func Authorize(req *http.Request, token string) {
+	req.Header.Set("Authorization", bearer+token)
+}
+
+// guard rejects requests from browsers, which send an Origin header, so
+// web pages cannot reach the API on localhost, and requests without the
+// token.
+func (s *Server) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" {
+			http.Error(w, "cross-origin requests are not allowed", http.StatusForbidden)
+
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), bearer)
+		if !ok || s.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			http.Error(w, "missing or wrong API token", http.StatusUnauthorized)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
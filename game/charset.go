@@ -0,0 +1,131 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// Charset names with special meaning.
+const (
+	CharsetAuto = "auto"
+	CharsetUTF8 = "utf-8"
+)
+
+// ErrUnknownCharset is returned when a charset name is not recognised.
+var ErrUnknownCharset = errors.New("unknown charset")
+
+// legacyCharsets maps accepted charset names to their encodings.
+var legacyCharsets = map[string]encoding.Encoding{
+	"gbk":       simplifiedchinese.GBK,
+	"cp936":     simplifiedchinese.GBK,
+	"gb18030":   simplifiedchinese.GB18030,
+	"big5":      traditionalchinese.Big5,
+	"cp950":     traditionalchinese.Big5,
+	"cp949":     korean.EUCKR,
+	"euc-kr":    korean.EUCKR,
+	"shift-jis": japanese.ShiftJIS,
+	"cp932":     japanese.ShiftJIS,
+	"cp1250":    charmap.Windows1250,
+	"cp1251":    charmap.Windows1251,
+	"cp1252":    charmap.Windows1252,
+}
+
+// autoCandidates is the order in which legacy charsets are tried when
+// detecting the encoding heuristically. CJK code pages come first as they
+// reject far more invalid input than the single-byte code pages.
+var autoCandidates = []string{"gbk", "cp949", "big5", "shift-jis", "cp1251"}
+
+// Charset decodes game strings (GameName, HostName) for display.
+// WC3 transmits these as raw bytes in the client's code page, which is not
+// necessarily UTF-8. Decoding only affects display; RawData is never touched.
+type Charset struct {
+	name string
+	enc  encoding.Encoding
+}
+
+// ParseCharset returns the Charset with the given name.
+// Accepts "auto", "utf-8" and legacy code pages such as "gbk", "cp949" and "cp1251".
+func ParseCharset(name string) (Charset, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	switch name {
+	case "", CharsetAuto:
+		return Charset{name: CharsetAuto}, nil
+	case CharsetUTF8, "utf8":
+		return Charset{name: CharsetUTF8}, nil
+	}
+
+	enc, ok := legacyCharsets[name]
+	if !ok {
+		return Charset{}, fmt.Errorf("%w: %s", ErrUnknownCharset, name)
+	}
+
+	return Charset{name: name, enc: enc}, nil
+}
+
+// Name returns the charset name.
+func (c Charset) Name() string {
+	if c.name == "" {
+		return CharsetAuto
+	}
+
+	return c.name
+}
+
+// Decode converts a raw game string to UTF-8 for display.
+// Valid UTF-8 is always returned unchanged.
+func (c Charset) Decode(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+
+	if c.enc != nil {
+		return decodeWith(c.enc, s)
+	}
+
+	if c.name == CharsetUTF8 {
+		return strings.ToValidUTF8(s, string(utf8.RuneError))
+	}
+
+	return detect(s)
+}
+
+// detect picks the legacy charset producing the fewest invalid characters.
+func detect(s string) string {
+	best := strings.ToValidUTF8(s, string(utf8.RuneError))
+	bestScore := strings.Count(best, string(utf8.RuneError))
+
+	for _, name := range autoCandidates {
+		decoded := decodeWith(legacyCharsets[name], s)
+
+		score := strings.Count(decoded, string(utf8.RuneError))
+		if score < bestScore {
+			best, bestScore = decoded, score
+		}
+
+		if bestScore == 0 {
+			break
+		}
+	}
+
+	return best
+}
+
+// decodeWith decodes s with enc, falling back to replacing invalid bytes.
+func decodeWith(enc encoding.Encoding, s string) string {
+	decoded, err := enc.NewDecoder().String(s)
+	if err != nil {
+		return strings.ToValidUTF8(s, string(utf8.RuneError))
+	}
+
+	return decoded
+}
@@ -0,0 +1,157 @@
+package game
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats.
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// ErrUnknownFormat is returned for export formats other than json and csv.
+var ErrUnknownFormat = errors.New("unknown export format (use json or csv)")
+
+// Record is the exported form of a game, including its raw GameInfo packet.
+type Record struct {
+	Key         string    `json:"key"`
+	Name        string    `json:"name"`
+	Source      Source    `json:"source"`
+	State       State     `json:"state"`
+	Private     bool      `json:"private"`
+	Direct      bool      `json:"direct"`
+	PeerName    string    `json:"peerName,omitempty"`
+	PeerIP      string    `json:"peerIP,omitempty"`
+	Product     string    `json:"product"`
+	Version     uint32    `json:"version"`
+	HostCounter uint32    `json:"hostCounter"`
+	EntryKey    uint32    `json:"entryKey"`
+	GamePort    uint16    `json:"gamePort"`
+	Map         string    `json:"map"`
+	SlotsUsed   uint32    `json:"slotsUsed"`
+	SlotsTotal  uint32    `json:"slotsTotal"`
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastSeen    time.Time `json:"lastSeen"`
+	Players     []string  `json:"players,omitempty"`
+	Raw         string    `json:"raw"` // hex-encoded GameInfo packet
+}
+
+// NewRecord converts a game to its exported form.
+func NewRecord(g *Game) Record {
+	rec := Record{
+		Key:         g.Key(),
+		Name:        g.Info.GameName,
+		Source:      g.Source,
+		State:       g.State,
+		Private:     g.Private,
+		Direct:      g.Direct,
+		PeerName:    g.PeerName,
+		Product:     g.Info.Product.String(),
+		Version:     g.Info.Version,
+		HostCounter: g.Info.HostCounter,
+		EntryKey:    g.Info.EntryKey,
+		GamePort:    g.Info.GamePort,
+		Map:         g.Info.GameSettings.MapPath,
+		SlotsUsed:   g.Info.SlotsUsed,
+		SlotsTotal:  g.Info.SlotsTotal,
+		FirstSeen:   g.FirstSeen,
+		LastSeen:    g.LastSeen,
+		Players:     g.Players,
+		Raw:         hex.EncodeToString(g.RawData),
+	}
+
+	if g.PeerIP.IsValid() {
+		rec.PeerIP = g.PeerIP.String()
+	}
+
+	return rec
+}
+
+// Export writes all games to w in the given format, sorted by key.
+func (r *Registry) Export(w io.Writer, format string) error {
+	return Export(w, r.Games(), format)
+}
+
+// Export writes games to w in the given format, sorted by key.
+func Export(w io.Writer, games []Game, format string) error {
+	format = strings.ToLower(format)
+	if format != FormatJSON && format != FormatCSV {
+		return fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+
+	records := make([]Record, 0, len(games))
+	for i := range games {
+		records = append(records, NewRecord(&games[i]))
+	}
+
+	slices.SortFunc(records, func(a, b Record) int { return cmp.Compare(a.Key, b.Key) })
+
+	if format == FormatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(records)
+	}
+
+	return writeCSV(w, records)
+}
+
+// csvHeader lists the CSV columns in Record field order.
+var csvHeader = []string{
+	"key", "name", "source", "state", "private", "direct", "peer_name", "peer_ip",
+	"product", "version", "host_counter", "entry_key", "game_port", "map",
+	"slots_used", "slots_total", "first_seen", "last_seen", "players", "raw",
+}
+
+// writeCSV writes records as CSV with a header row.
+func writeCSV(w io.Writer, records []Record) error {
+	cw := csv.NewWriter(w)
+
+	err := cw.Write(csvHeader)
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		err = cw.Write([]string{
+			rec.Key,
+			rec.Name,
+			string(rec.Source),
+			string(rec.State),
+			strconv.FormatBool(rec.Private),
+			strconv.FormatBool(rec.Direct),
+			rec.PeerName,
+			rec.PeerIP,
+			rec.Product,
+			strconv.FormatUint(uint64(rec.Version), 10),
+			strconv.FormatUint(uint64(rec.HostCounter), 10),
+			strconv.FormatUint(uint64(rec.EntryKey), 10),
+			strconv.FormatUint(uint64(rec.GamePort), 10),
+			rec.Map,
+			strconv.FormatUint(uint64(rec.SlotsUsed), 10),
+			strconv.FormatUint(uint64(rec.SlotsTotal), 10),
+			rec.FirstSeen.Format(time.RFC3339),
+			rec.LastSeen.Format(time.RFC3339),
+			strings.Join(rec.Players, ";"),
+			rec.Raw,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
@@ -0,0 +1,134 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidNameFilter is returned for game name patterns that do not compile.
+var ErrInvalidNameFilter = errors.New("invalid game name filter")
+
+// NameFilter hides games by name. Patterns are globs matching the whole
+// name, ignoring case, or regular expressions between slashes, such as
+// "/^bot \d+$/". A nil NameFilter allows every game.
+type NameFilter struct {
+	allow   []*regexp.Regexp
+	block   []*regexp.Regexp
+	charset Charset
+}
+
+// NewNameFilter compiles the allow and block patterns. If allow is not
+// empty, only games matching one of its patterns are shown; games matching
+// a block pattern never are. Names are decoded with charset before matching.
+// It returns nil if there are no patterns.
+func NewNameFilter(allow, block []string, charset Charset) (*NameFilter, error) {
+	if len(allow) == 0 && len(block) == 0 {
+		return nil, nil //nolint:nilnil // A nil filter allows everything
+	}
+
+	f := &NameFilter{charset: charset}
+
+	var err error
+
+	f.allow, err = compilePatterns(allow)
+	if err != nil {
+		return nil, err
+	}
+
+	f.block, err = compilePatterns(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// compilePatterns compiles glob and regular expression patterns.
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+
+	for _, p := range patterns {
+		expr := globToRegexp(p)
+		if len(p) > 1 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
+			expr = p[1 : len(p)-1]
+		}
+
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", ErrInvalidNameFilter, p, err)
+		}
+
+		res = append(res, re)
+	}
+
+	return res, nil
+}
+
+// globToRegexp converts a glob with * and ? wildcards to an anchored,
+// case-insensitive regular expression.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+
+	b.WriteString("(?is)^")
+
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	b.WriteString("$")
+
+	return b.String()
+}
+
+// Allowed reports whether a game with the given raw name is shown.
+func (f *NameFilter) Allowed(name string) bool {
+	if f == nil {
+		return true
+	}
+
+	name = f.charset.Decode(name)
+
+	for _, re := range f.block {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+
+	if len(f.allow) == 0 {
+		return true
+	}
+
+	for _, re := range f.allow {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Filter returns the games that are shown.
+func (f *NameFilter) Filter(games []Game) []Game {
+	if f == nil {
+		return games
+	}
+
+	shown := make([]Game, 0, len(games))
+
+	for i := range games {
+		if f.Allowed(games[i].Info.GameName) {
+			shown = append(shown, games[i])
+		}
+	}
+
+	return shown
+}
@@ -3,6 +3,7 @@ package game
 
 import (
 	"net/netip"
+	"strconv"
 	"time"
 
 	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
@@ -15,6 +16,7 @@ type Source string
 const (
 	SourceLocal  Source = "local"  // Hosted on this machine
 	SourceRemote Source = "remote" // From another Tailscale peer
+	SourceLAN    Source = "lan"    // Hosted by another machine on the local LAN
 )
 
 // Game represents a discovered WC3 game.
@@ -29,14 +31,52 @@ type Game struct {
 	// Source indicates where this game was discovered.
 	Source Source
 
-	// PeerIP is the Tailscale IP of the peer hosting this game.
-	// Only set for remote games.
+	// PeerIP is the Tailscale IP of the peer hosting this game, or the
+	// LAN address of the machine hosting a LAN game. For local games it is
+	// the address of this machine the lobby was announced from.
 	PeerIP netip.Addr
 
+	// AltIPs are other addresses of the peer, tried in order when joins
+	// cannot reach PeerIP. Only set for remote games.
+	AltIPs []netip.Addr
+
+	// RTT is the smoothed probe round trip to the peer, zero until it has
+	// been measured. Only set for remote games.
+	RTT time.Duration
+
 	// PeerName is the hostname of the peer hosting this game.
 	// Only set for remote games.
 	PeerName string
 
+	// Direct is set for remote games that the host announces straight to
+	// the local WC3 client, so they must not be rebroadcast via the proxy.
+	Direct bool
+
+	// Unreachable is set for remote games whose host did not accept a TCP
+	// connection on its game port, so they are not advertised on the LAN.
+	// Only checked with the reachability pre-check enabled.
+	Unreachable bool
+
+	// LocalCounter is the HostCounter a remote game is rebroadcast under on
+	// the LAN. Hosts number their lobbies independently, so the counters of
+	// two peers can collide; the registry assigns each remote game a locally
+	// unique one and the proxy rewrites Joins back to Info.HostCounter.
+	// LAN games are offered to peers under theirs, and the guard rewrites
+	// Joins back the same way.
+	LocalCounter uint32
+
+	// Private is set for local games that are never reported to remote
+	// peers, while still being visible on the local LAN.
+	Private bool
+
+	// State is the game's lifecycle state, maintained by the registry.
+	State State
+
+	// Players are the names of the players in the lobby, as seen by the
+	// proxied sessions joined to it. Only set for remote games while a
+	// join is being proxied.
+	Players []string
+
 	// FirstSeen is when this game was first discovered.
 	FirstSeen time.Time
 
@@ -45,12 +85,87 @@ type Game struct {
 }
 
 // Key returns a unique identifier for this game.
+// A host can run several lobbies at once, so the key includes the game port
+// and HostCounter rather than the (possibly shared) game name.
 func (g *Game) Key() string {
-	if g.Source == SourceLocal {
-		return "local:" + g.Info.GameName
+	return g.hostKey() + ":" + strconv.FormatUint(uint64(g.Info.HostCounter), 10)
+}
+
+// hostKey identifies the host and port a game is served from. Local games
+// are keyed by the address they were announced from too, so that lobbies
+// of several machines sharing a port do not collide.
+func (g *Game) hostKey() string {
+	host := "local"
+	if g.PeerIP.IsValid() {
+		host = g.PeerIP.String()
+	}
+
+	return host + ":" + strconv.FormatUint(uint64(g.Info.GamePort), 10)
+}
+
+// Replaces reports whether g is a re-hosted lobby superseding other: the same
+// name served from the same host and port under a new HostCounter, with
+// other no longer announced since g appeared. Two lobbies announced side by
+// side are both kept.
+func (g *Game) Replaces(other *Game) bool {
+	return g.hostKey() == other.hostKey() &&
+		g.Info.GameName == other.Info.GameName &&
+		g.Info.HostCounter != other.Info.HostCounter &&
+		other.LastSeen.Before(g.FirstSeen)
+}
+
+// SameLobby reports whether g and other are one lobby learned over
+// different paths, such as from its host and through a peer bridging its
+// LAN. Relays renumber the games they announce, so HostCounters are only
+// compared when neither was renumbered; the host's stat string, game name
+// and EntryKey identify the lobby otherwise.
+func (g *Game) SameLobby(other *Game) bool {
+	if g.Info.GameSettings != other.Info.GameSettings ||
+		g.Info.GameName != other.Info.GameName ||
+		g.Info.EntryKey != other.Info.EntryKey {
+		return false
+	}
+
+	return Injected(g.Info.HostCounter) || Injected(other.Info.HostCounter) ||
+		g.Info.HostCounter == other.Info.HostCounter
+}
+
+// Prefers reports whether g is a better path to its lobby than other:
+// reachable over unreachable, announced directly over proxied, then the
+// lower measured round trip, then straight from the host over a relay.
+// The key breaks remaining ties so that the choice is stable.
+func (g *Game) Prefers(other *Game) bool {
+	switch {
+	case g.Unreachable != other.Unreachable:
+		return other.Unreachable
+	case g.Direct != other.Direct:
+		return g.Direct
+	case (g.RTT > 0) != (other.RTT > 0):
+		return g.RTT > 0
+	case g.RTT != other.RTT:
+		return g.RTT < other.RTT
+	case Injected(g.Info.HostCounter) != Injected(other.Info.HostCounter):
+		return Injected(other.Info.HostCounter)
+	default:
+		return g.Key() < other.Key()
 	}
+}
+
+// JoinAddr returns the address a WC3 client on this machine connects to
+// for g: the host itself for local, LAN and direct games, otherwise the
+// TCP proxy on proxyPort. With no proxy running (proxyPort 0), remote
+// games are reported at their host.
+func (g *Game) JoinAddr(proxyPort int) netip.AddrPort {
+	loopback := netip.AddrFrom4([4]byte{127, 0, 0, 1})
 
-	return g.PeerIP.String() + ":" + g.Info.GameName
+	switch {
+	case g.Source == SourceLocal:
+		return netip.AddrPortFrom(loopback, g.Info.GamePort)
+	case g.Source == SourceLAN || g.Direct || proxyPort <= 0:
+		return netip.AddrPortFrom(g.PeerIP, g.Info.GamePort)
+	default:
+		return netip.AddrPortFrom(loopback, uint16(proxyPort)) //nolint:gosec // Ports fit in uint16
+	}
 }
 
 // IsStale returns true if the game hasn't been seen recently.
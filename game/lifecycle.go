@@ -0,0 +1,157 @@
+package game
+
+import (
+	"time"
+
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// State is a game's position in its lifecycle.
+//
+// A game is Discovered when first seen and becomes a Lobby once it is seen
+// again. Proxy inspection moves it to Starting when the host starts the
+// countdown and InProgress when the game loads. It ends when the last
+// proxied player leaves, or expires when it stops being announced without
+// having started.
+type State string
+
+// Game lifecycle states.
+const (
+	StateDiscovered State = "discovered"
+	StateLobby      State = "lobby"
+	StateStarting   State = "starting"
+	StateInProgress State = "in-progress"
+	StateEnded      State = "ended"
+	StateExpired    State = "expired"
+)
+
+// maxEvents is the number of lifecycle events kept by the registry.
+const maxEvents = 256
+
+// infoFresh is how long a game's announcement tells its lobby state. Hosts
+// stop answering once their game starts, so an older one may describe a
+// lobby that is gone.
+const infoFresh = 10 * time.Second
+
+// Started reports whether the game has left the lobby. Started games no
+// longer answer searches, so they do not expire.
+func (s State) Started() bool {
+	return s == StateStarting || s == StateInProgress
+}
+
+// InfoState decodes the lobby state from a game's announcement. A game
+// whose flags name a game type and whose lobby has open slots is a Lobby.
+// One announced without open slots can no longer be joined, whether it is
+// full or has started, as hosting bots keep announcing started games, and
+// is taken as Starting. It reports false when the announcement does not
+// tell, because no game type is set or its slot counts do not add up.
+func InfoState(info *w3gs.GameInfo) (State, bool) {
+	switch {
+	case info.GameFlags&w3gs.GameFlagTypeMask == 0:
+		return "", false
+	case info.SlotsTotal == 0 || info.SlotsUsed > info.SlotsTotal || info.SlotsAvailable > info.SlotsTotal:
+		return "", false
+	case info.SlotsAvailable == 0:
+		return StateStarting, true
+	default:
+		return StateLobby, true
+	}
+}
+
+// Joinable reports whether g can still be joined. The lobby state decoded
+// from a fresh announcement decides; the state seen by the proxy is used
+// when the announcement is stale or does not tell.
+func (g *Game) Joinable() bool {
+	if !g.IsStale(infoFresh) {
+		state, ok := InfoState(&g.Info)
+		if ok {
+			return !state.Started()
+		}
+	}
+
+	return !g.State.Started()
+}
+
+// Final reports whether the game has left the registry.
+func (s State) Final() bool {
+	return s == StateEnded || s == StateExpired
+}
+
+// Event records a game lifecycle transition.
+type Event struct {
+	Time time.Time `json:"time"`
+	Key  string    `json:"key"`
+	Name string    `json:"name"`
+	From State     `json:"from,omitempty"`
+	To   State     `json:"to"`
+}
+
+// EventFunc is called for every lifecycle transition.
+type EventFunc func(Event)
+
+// Subscribe registers fn to be called for every lifecycle transition.
+// fn is called with the registry locked and must not call back into it.
+func (r *Registry) Subscribe(fn EventFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.subscribers = append(r.subscribers, fn)
+}
+
+// Events returns the most recent lifecycle events, oldest first.
+func (r *Registry) Events() []Event {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	events := make([]Event, len(r.events))
+	copy(events, r.events)
+
+	return events
+}
+
+// SetState moves a game to the given state. Final states remove the game.
+// Returns true if the game exists and its state changed.
+func (r *Registry) SetState(key string, to State) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, exists := r.games[key]
+	if !exists || g.State == to {
+		return false
+	}
+
+	r.transition(g, to)
+
+	if to.Final() {
+		delete(r.games, key)
+	}
+
+	if r.onChange != nil {
+		r.onChange(r.snapshot())
+	}
+
+	return true
+}
+
+// transition moves g to state to and records the event.
+// Must be called with r.mu held.
+func (r *Registry) transition(g *Game, to State) {
+	ev := Event{
+		Time: time.Now(),
+		Key:  g.Key(),
+		Name: g.Info.GameName,
+		From: g.State,
+		To:   to,
+	}
+
+	g.State = to
+
+	r.events = append(r.events, ev)
+	if len(r.events) > maxEvents {
+		r.events = r.events[len(r.events)-maxEvents:]
+	}
+
+	for _, fn := range r.subscribers {
+		fn(ev)
+	}
+}
@@ -2,18 +2,38 @@ package game
 
 import (
 	"log/slog"
+	"net/netip"
+	"slices"
 	"sync"
 	"time"
 )
 
+// Remote games are rebroadcast under counters from localCounterBase up,
+// far above the small counters WC3 numbers its own lobbies with.
+const (
+	localCounterBase = 0x10000000
+	localCounterMask = 0x0FFFFFFF
+)
+
+// Injected reports whether hostCounter was allocated by a wc3ts registry,
+// which marks a game announced by wc3ts rather than hosted by WC3. Such
+// games are already being relayed and must never be exported again.
+func Injected(hostCounter uint32) bool {
+	return hostCounter&^localCounterMask == localCounterBase
+}
+
 // OnChangeFunc is called when the game list changes.
 type OnChangeFunc func(games []Game)
 
 // Registry maintains a thread-safe collection of discovered games.
 type Registry struct {
-	games    map[string]*Game
-	onChange OnChangeFunc
-	mu       sync.RWMutex
+	games       map[string]*Game
+	onChange    OnChangeFunc
+	ghost       bool
+	events      []Event
+	subscribers []EventFunc
+	nextCounter uint32
+	mu          sync.RWMutex
 }
 
 // NewRegistry creates a new game registry.
@@ -24,14 +44,32 @@ func NewRegistry(onChange OnChangeFunc) *Registry {
 	}
 }
 
-// Add adds or updates a game in the registry.
+// Add adds or updates a game in the registry. A game re-hosted under a new
+// HostCounter replaces its previous lobby.
 // Returns true if the game was newly added.
 func (r *Registry) Add(game Game) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	key := game.Key()
-	_, exists := r.games[key]
+	existing, exists := r.games[key]
+
+	// Privacy, state and players are tracked locally, never taken from the caller
+	game.Private = r.ghost && game.Source == SourceLocal
+	game.State = ""
+	game.Players = nil
+
+	game.LocalCounter = 0
+
+	if exists {
+		game.FirstSeen = existing.FirstSeen
+		game.Private = existing.Private
+		game.State = existing.State
+		game.Players = existing.Players
+		game.LocalCounter = existing.LocalCounter
+	} else if game.Source != SourceLocal {
+		game.LocalCounter = r.allocCounter()
+	}
 
 	if !exists {
 		game.FirstSeen = time.Now()
@@ -39,6 +77,7 @@ func (r *Registry) Add(game Game) bool {
 			"key", key,
 			"name", game.Info.GameName,
 			"hostCounter", game.Info.HostCounter,
+			"localCounter", game.LocalCounter,
 			"peerIP", game.PeerIP,
 			"source", game.Source,
 			"totalGames", len(r.games)+1,
@@ -48,6 +87,26 @@ func (r *Registry) Add(game Game) bool {
 	game.LastSeen = time.Now()
 	r.games[key] = &game
 
+	// A lobby seen again is confirmed; started games keep their state
+	switch game.State {
+	case "":
+		r.transition(&game, StateDiscovered)
+	case StateDiscovered:
+		r.transition(&game, StateLobby)
+	}
+
+	// Drop the lobby this one was re-hosted from, keeping its privacy. A
+	// lobby is only taken as re-hosted once it is seen again without the
+	// old one, so the first sighting never replaces anything
+	for otherKey, other := range r.games {
+		if exists && game.Replaces(other) {
+			game.Private = other.Private
+
+			r.transition(other, StateExpired)
+			delete(r.games, otherKey)
+		}
+	}
+
 	if r.onChange != nil {
 		r.onChange(r.snapshot())
 	}
@@ -55,17 +114,66 @@ func (r *Registry) Add(game Game) bool {
 	return !exists
 }
 
+// SetGhost sets whether newly discovered local games start out private.
+func (r *Registry) SetGhost(enabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ghost = enabled
+}
+
+// SetPrivate marks a local game as private or public.
+// Returns true if the game exists and is local.
+func (r *Registry) SetPrivate(key string, private bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, exists := r.games[key]
+	if !exists || g.Source != SourceLocal {
+		return false
+	}
+
+	g.Private = private
+
+	if r.onChange != nil {
+		r.onChange(r.snapshot())
+	}
+
+	return true
+}
+
+// SetPlayers sets the names of the players in the lobby of the game with key.
+// Returns false if the game is unknown or its players are unchanged.
+func (r *Registry) SetPlayers(key string, players []string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, exists := r.games[key]
+	if !exists || slices.Equal(g.Players, players) {
+		return false
+	}
+
+	g.Players = players
+
+	if r.onChange != nil {
+		r.onChange(r.snapshot())
+	}
+
+	return true
+}
+
 // Remove removes a game from the registry.
 // Returns true if the game existed.
 func (r *Registry) Remove(key string) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	_, exists := r.games[key]
+	g, exists := r.games[key]
 	if !exists {
 		return false
 	}
 
+	r.transition(g, StateExpired)
 	delete(r.games, key)
 
 	if r.onChange != nil {
@@ -75,7 +183,44 @@ func (r *Registry) Remove(key string) bool {
 	return true
 }
 
-// Games returns a copy of all games.
+// RemovePeer removes all games hosted by the remote peer at ip.
+// Returns the number of games removed.
+func (r *Registry) RemovePeer(ip netip.Addr) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	removed := 0
+
+	for key, g := range r.games {
+		if g.Source == SourceRemote && g.PeerIP == ip {
+			r.transition(g, StateExpired)
+			delete(r.games, key)
+
+			removed++
+		}
+	}
+
+	if removed > 0 && r.onChange != nil {
+		r.onChange(r.snapshot())
+	}
+
+	return removed
+}
+
+// Get returns the game with the given key.
+func (r *Registry) Get(key string) (Game, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	g, exists := r.games[key]
+	if !exists {
+		return Game{}, false
+	}
+
+	return *g, true
+}
+
+// Games returns a copy of all games, listing each lobby once.
 func (r *Registry) Games() []Game {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -99,7 +244,7 @@ func (r *Registry) LocalGames() []Game {
 	return result
 }
 
-// RemoteGames returns games from remote peers.
+// RemoteGames returns games from remote peers, each lobby once.
 func (r *Registry) RemoteGames() []Game {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -107,7 +252,7 @@ func (r *Registry) RemoteGames() []Game {
 	result := make([]Game, 0)
 
 	for _, g := range r.games {
-		if g.Source == SourceRemote {
+		if g.Source == SourceRemote && !r.shadowed(g) {
 			result = append(result, *g)
 		}
 	}
@@ -132,7 +277,90 @@ func (r *Registry) FindByHostCounter(hostCounter uint32) *Game {
 	return nil
 }
 
-// Expire removes games that haven't been seen recently.
+// FindLocalByHostCounter finds a local game by its HostCounter.
+// Returns nil if not found.
+func (r *Registry) FindLocalByHostCounter(hostCounter uint32) *Game {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, g := range r.games {
+		if g.Source == SourceLocal && g.Info.HostCounter == hostCounter {
+			gameCopy := *g
+
+			return &gameCopy
+		}
+	}
+
+	return nil
+}
+
+// LANGames returns games hosted by other machines on the local LAN.
+func (r *Registry) LANGames() []Game {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]Game, 0)
+
+	for _, g := range r.games {
+		if g.Source == SourceLAN {
+			result = append(result, *g)
+		}
+	}
+
+	return result
+}
+
+// FindLANByLocalCounter finds the LAN game offered to peers under counter.
+// Returns nil if not found.
+func (r *Registry) FindLANByLocalCounter(counter uint32) *Game {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, g := range r.games {
+		if g.Source == SourceLAN && g.LocalCounter == counter {
+			gameCopy := *g
+
+			return &gameCopy
+		}
+	}
+
+	return nil
+}
+
+// FindForJoin finds the remote game a Join packet is addressed to.
+// Remote games are rebroadcast under their LocalCounter, which identifies
+// them uniquely. Joins carrying a real HostCounter, e.g. from clients that
+// saw the host's own announcement, are matched by HostCounter and EntryKey,
+// falling back to the HostCounter alone when no game matches both.
+// Returns nil if not found.
+func (r *Registry) FindForJoin(hostCounter, entryKey uint32) *Game {
+	r.mu.RLock()
+
+	for _, g := range r.games {
+		if g.Source == SourceRemote && g.LocalCounter == hostCounter {
+			gameCopy := *g
+			r.mu.RUnlock()
+
+			return &gameCopy
+		}
+	}
+
+	for _, g := range r.games {
+		if g.Source == SourceRemote && g.Info.HostCounter == hostCounter && g.Info.EntryKey == entryKey {
+			gameCopy := *g
+			r.mu.RUnlock()
+
+			return &gameCopy
+		}
+	}
+
+	r.mu.RUnlock()
+
+	return r.FindByHostCounter(hostCounter)
+}
+
+// Expire removes games that haven't been seen recently. Started games stop
+// answering searches, so they are kept until their session ends.
 // Returns the number of games removed.
 func (r *Registry) Expire(timeout time.Duration) int {
 	r.mu.Lock()
@@ -141,7 +369,8 @@ func (r *Registry) Expire(timeout time.Duration) int {
 	removed := 0
 
 	for key, game := range r.games {
-		if game.IsStale(timeout) {
+		if game.IsStale(timeout) && !game.State.Started() {
+			r.transition(game, StateExpired)
 			delete(r.games, key)
 
 			removed++
@@ -155,14 +384,61 @@ func (r *Registry) Expire(timeout time.Duration) int {
 	return removed
 }
 
-// snapshot returns a copy of all games.
+// allocCounter returns a LocalCounter not used by any game.
+// Must be called with the write lock held.
+func (r *Registry) allocCounter() uint32 {
+	for {
+		r.nextCounter++
+		counter := localCounterBase | r.nextCounter&localCounterMask
+
+		if !r.counterInUse(counter) {
+			return counter
+		}
+	}
+}
+
+// counterInUse reports whether counter is announced on the LAN, either as
+// a LocalCounter or as the HostCounter of a local game.
+// Must be called with at least a read lock held.
+func (r *Registry) counterInUse(counter uint32) bool {
+	for _, g := range r.games {
+		if g.LocalCounter == counter || (g.Source == SourceLocal && g.Info.HostCounter == counter) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// snapshot returns a copy of all games, leaving out shadowed ones.
 // Must be called with at least a read lock held.
 func (r *Registry) snapshot() []Game {
 	result := make([]Game, 0, len(r.games))
 
 	for _, g := range r.games {
-		result = append(result, *g)
+		if !r.shadowed(g) {
+			result = append(result, *g)
+		}
 	}
 
 	return result
 }
+
+// shadowed reports whether the remote game g is a lobby also learned over
+// a better path. Both are kept, so joins to either keep working and the
+// other path takes over when the better one goes away, but only the
+// better one is listed.
+// Must be called with at least a read lock held.
+func (r *Registry) shadowed(g *Game) bool {
+	if g.Source != SourceRemote {
+		return false
+	}
+
+	for _, other := range r.games {
+		if other != g && other.Source == SourceRemote && g.SameLobby(other) && other.Prefers(g) {
+			return true
+		}
+	}
+
+	return false
+}
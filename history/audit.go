@@ -0,0 +1,75 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// AuditLog appends a record of every proxied join to a file, for LAN
+// organizers who want to know who played what. Unlike history it is one
+// file at a path of its own and also records refused joins.
+// A nil AuditLog discards records.
+type AuditLog struct {
+	f  *os.File
+	mu sync.Mutex
+}
+
+// auditEntry is a session with its duration spelled out.
+type auditEntry struct {
+	Session
+
+	Duration time.Duration `json:"durationNs"`
+}
+
+// OpenAuditLog opens the audit log at path for appending, creating it and
+// its directory if needed.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	err := os.MkdirAll(filepath.Dir(path), dirPerm)
+	if err != nil {
+		return nil, fmt.Errorf("create audit log directory: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, filePerm)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+
+	return &AuditLog{f: f}, nil
+}
+
+// Record appends a join as a JSON line.
+func (l *AuditLog) Record(s Session) {
+	if l == nil {
+		return
+	}
+
+	line, err := json.Marshal(auditEntry{Session: s, Duration: s.End.Sub(s.Start)})
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.f.Write(append(line, '\n'))
+	if err != nil {
+		slog.Warn("failed to write audit log", "file", l.f.Name(), "error", err)
+	}
+}
+
+// Close closes the audit log.
+func (l *AuditLog) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.f.Close()
+}
@@ -0,0 +1,219 @@
+package history
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats.
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// ErrUnknownFormat is returned for export formats other than csv and json.
+var ErrUnknownFormat = errors.New("unknown export format (use csv or json)")
+
+// maxLineSize bounds a single history line when reading.
+const maxLineSize = 1 << 20
+
+// Export converts the history in dir to one file per record kind in outDir,
+// in the given format. It returns the paths written.
+func Export(dir, outDir, format string) ([]string, error) {
+	format = strings.ToLower(format)
+	if format != FormatCSV && format != FormatJSON {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+
+	probes, err := readLines[Probe](filepath.Join(dir, ProbesFile))
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := readLines[Session](filepath.Join(dir, SessionsFile))
+	if err != nil {
+		return nil, err
+	}
+
+	games, err := readLines[Game](filepath.Join(dir, GamesFile))
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(outDir, dirPerm)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := []struct {
+		name   string
+		data   any
+		header []string
+		rows   [][]string
+	}{
+		{"probes", probes, probeHeader, probeRows(probes)},
+		{"sessions", sessions, sessionHeader, sessionRows(sessions)},
+		{"games", games, gameHeader, gameRows(games)},
+	}
+
+	paths := make([]string, 0, len(tables))
+
+	for _, t := range tables {
+		path := filepath.Join(outDir, t.name+"."+format)
+
+		if format == FormatCSV {
+			err = writeCSV(path, t.header, t.rows)
+		} else {
+			err = writeJSON(path, t.data)
+		}
+
+		if err != nil {
+			return paths, err
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// CSV headers.
+var (
+	probeHeader   = []string{"time", "peer", "peer_ip", "rtt_ms"}
+	sessionHeader = []string{
+		"start", "end", "duration_s", "game", "host", "peer_ip", "player", "client", "reason",
+		"bytes_in", "bytes_out", "packets_in", "packets_out",
+	}
+	gameHeader = []string{"time", "name", "host", "peer_ip", "source", "map", "version", "slots"}
+)
+
+func probeRows(probes []Probe) [][]string {
+	rows := make([][]string, 0, len(probes))
+
+	for _, p := range probes {
+		rows = append(rows, []string{
+			p.Time.Format(time.RFC3339),
+			p.Peer,
+			addrString(p.PeerIP),
+			strconv.FormatFloat(float64(p.RTT)/float64(time.Millisecond), 'f', 1, 64),
+		})
+	}
+
+	return rows
+}
+
+func sessionRows(sessions []Session) [][]string {
+	rows := make([][]string, 0, len(sessions))
+
+	for _, s := range sessions {
+		rows = append(rows, []string{
+			s.Start.Format(time.RFC3339),
+			s.End.Format(time.RFC3339),
+			strconv.FormatFloat(s.End.Sub(s.Start).Seconds(), 'f', 0, 64),
+			s.Game,
+			s.Host,
+			addrString(s.PeerIP),
+			s.Player,
+			s.Client,
+			s.Reason,
+			strconv.FormatUint(s.BytesIn, 10),
+			strconv.FormatUint(s.BytesOut, 10),
+			strconv.FormatUint(s.PacketsIn, 10),
+			strconv.FormatUint(s.PacketsOut, 10),
+		})
+	}
+
+	return rows
+}
+
+func gameRows(games []Game) [][]string {
+	rows := make([][]string, 0, len(games))
+
+	for _, g := range games {
+		rows = append(rows, []string{
+			g.Time.Format(time.RFC3339),
+			g.Name,
+			g.Host,
+			addrString(g.PeerIP),
+			g.Source,
+			g.Map,
+			g.Version,
+			strconv.FormatUint(uint64(g.Slots), 10),
+		})
+	}
+
+	return rows
+}
+
+// addrString formats ip, leaving unset addresses empty.
+func addrString(ip netip.Addr) string {
+	if !ip.IsValid() {
+		return ""
+	}
+
+	return ip.String()
+}
+
+// readLines decodes a JSON lines file. A missing file yields no records.
+func readLines[T any](path string) ([]T, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []T{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	records := []T{}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxLineSize)
+
+	for scanner.Scan() {
+		var rec T
+
+		// Skip lines torn by a crash mid-write
+		if json.Unmarshal(scanner.Bytes(), &rec) == nil {
+			records = append(records, rec)
+		}
+	}
+
+	return records, scanner.Err()
+}
+
+// writeCSV writes a CSV file with a header row.
+func writeCSV(path string, header []string, rows [][]string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(f)
+
+	_ = w.Write(header)
+	_ = w.WriteAll(rows)
+
+	return errors.Join(w.Error(), f.Close())
+}
+
+// writeJSON writes v as an indented JSON file.
+func writeJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), filePerm)
+}
@@ -0,0 +1,162 @@
+// Package history records probe, session and game history for later export.
+//
+// Records are appended as JSON lines to one file per kind in the history
+// directory, so history accumulates across runs. Export converts the files
+// to CSV or JSON for spreadsheets.
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// History file names, one per record kind.
+const (
+	ProbesFile   = "probes.jsonl"
+	SessionsFile = "sessions.jsonl"
+	GamesFile    = "games.jsonl"
+)
+
+// File permissions for the history directory and files.
+const (
+	dirPerm  = 0o755
+	filePerm = 0o644
+)
+
+// Probe records the round trip of a probe answered by a peer.
+type Probe struct {
+	Time   time.Time     `json:"time"`
+	Peer   string        `json:"peer"`
+	PeerIP netip.Addr    `json:"peerIp"`
+	RTT    time.Duration `json:"rttNs"`
+}
+
+// Session records a proxied connection to a remote game.
+type Session struct {
+	Start  time.Time  `json:"start"`
+	End    time.Time  `json:"end"`
+	Game   string     `json:"game"`
+	Host   string     `json:"host"`
+	PeerIP netip.Addr `json:"peerIp"`
+	Player string     `json:"player"`
+	Client string     `json:"client"`
+
+	// Reason says why the session ended, e.g. "host closed", or why the
+	// join was refused.
+	Reason string `json:"reason"`
+
+	// Traffic from (In) and to (Out) the host.
+	BytesIn    uint64 `json:"bytesIn"`
+	BytesOut   uint64 `json:"bytesOut"`
+	PacketsIn  uint64 `json:"packetsIn"`
+	PacketsOut uint64 `json:"packetsOut"`
+}
+
+// Game records a game when it is first discovered.
+type Game struct {
+	Time    time.Time  `json:"time"`
+	Name    string     `json:"name"`
+	Host    string     `json:"host"`
+	PeerIP  netip.Addr `json:"peerIp"`
+	Source  string     `json:"source"`
+	Map     string     `json:"map"`
+	Version string     `json:"version"`
+	Slots   uint32     `json:"slots"`
+}
+
+// Recorder appends history records to files. A nil Recorder discards records.
+type Recorder struct {
+	probes   *os.File
+	sessions *os.File
+	games    *os.File
+	mu       sync.Mutex
+}
+
+// NewRecorder opens the history files in dir, creating it if needed.
+func NewRecorder(dir string) (*Recorder, error) {
+	err := os.MkdirAll(dir, dirPerm)
+	if err != nil {
+		return nil, fmt.Errorf("create history directory: %w", err)
+	}
+
+	r := &Recorder{}
+
+	for name, f := range map[string]**os.File{
+		ProbesFile:   &r.probes,
+		SessionsFile: &r.sessions,
+		GamesFile:    &r.games,
+	} {
+		*f, err = os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, filePerm)
+		if err != nil {
+			_ = r.Close()
+
+			return nil, fmt.Errorf("open history file: %w", err)
+		}
+	}
+
+	return r, nil
+}
+
+// RecordProbe appends a probe round trip.
+func (r *Recorder) RecordProbe(p Probe) {
+	if r != nil {
+		r.write(r.probes, p)
+	}
+}
+
+// RecordSession appends a finished proxy session.
+func (r *Recorder) RecordSession(s Session) {
+	if r != nil {
+		r.write(r.sessions, s)
+	}
+}
+
+// RecordGame appends a newly discovered game.
+func (r *Recorder) RecordGame(g Game) {
+	if r != nil {
+		r.write(r.games, g)
+	}
+}
+
+// Close closes the history files.
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var errs []error
+
+	for _, f := range []*os.File{r.probes, r.sessions, r.games} {
+		if f != nil {
+			errs = append(errs, f.Close())
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// write appends v to f as a JSON line.
+func (r *Recorder) write(f *os.File, v any) {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		slog.Debug("failed to write history", "file", f.Name(), "error", err)
+	}
+}
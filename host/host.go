@@ -0,0 +1,396 @@
+// Package host runs a WC3 game lobby without a game client, so a headless
+// node can host games for the tailnet. The lobby is added to the registry
+// as a local game, which the responder announces to peers like any other.
+package host
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/mapfile"
+	"github.com/kradalby/wc3ts/packet"
+	"github.com/nielsAD/gowarcraft3/network"
+	"github.com/nielsAD/gowarcraft3/network/lobby"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// Lobby defaults.
+const (
+	// DefaultHostName is the creator name shown in the game list.
+	DefaultHostName = "wc3ts"
+
+	// StartCommand is the chat message that starts the game.
+	StartCommand = "!start"
+
+	// mapDir is where announced maps are expected in the client's Maps folder.
+	mapDir = `Maps\Download\`
+
+	// maxSlots is the number of slots supported by pre-1.29 clients.
+	maxSlots = 12
+
+	handicapNone = 100
+)
+
+// ErrNoSlots is returned for maps that define no player slots.
+var ErrNoSlots = errors.New("map defines no player slots")
+
+// Config describes the game to host.
+type Config struct {
+	// MapPath is the local map file.
+	MapPath string
+
+	// ScriptsDir holds common.j and blizzard.j for the map checksum.
+	ScriptsDir string
+
+	// GameName is the name shown in the game list; defaults to the map name.
+	GameName string
+
+	// HostName is the creator name shown in the game list.
+	HostName string
+
+	// Version is the game version announced and spoken.
+	Version w3gs.GameVersion
+
+	// Port is the TCP port players join on; zero picks a free port.
+	Port int
+
+	// AutoStart starts the game once this many players joined (0 disables).
+	AutoStart int
+}
+
+// Host serves one lobby at a time, re-hosting after each game.
+type Host struct {
+	cfg      Config
+	registry *game.Registry
+	listener net.Listener
+	settings w3gs.GameSettings
+	mapCheck w3gs.MapCheck
+	slots    w3gs.SlotInfo
+
+	mu      sync.Mutex
+	current *lobby.Game
+	info    w3gs.GameInfo
+	created time.Time
+}
+
+// New loads the map and starts listening for players.
+func New(cfg Config, registry *game.Registry) (*Host, error) {
+	info, err := mapfile.Load(cfg.MapPath)
+	if err != nil {
+		return nil, err
+	}
+
+	sum, err := mapfile.LoadChecksum(cfg.MapPath, cfg.ScriptsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	players := min(info.MaxPlayers, maxSlots)
+	if players <= 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoSlots, cfg.MapPath)
+	}
+
+	if cfg.GameName == "" {
+		cfg.GameName = info.Name
+	}
+
+	if cfg.HostName == "" {
+		cfg.HostName = DefaultHostName
+	}
+
+	listener, err := net.Listen("tcp4", fmt.Sprintf(":%d", cfg.Port))
+	if err != nil {
+		return nil, err
+	}
+
+	mapPath := mapDir + filepath.Base(cfg.MapPath)
+
+	return &Host{
+		cfg:      cfg,
+		registry: registry,
+		listener: listener,
+		settings: w3gs.GameSettings{
+			GameSettingFlags: w3gs.SettingSpeedFast | w3gs.SettingTerrainDefault |
+				w3gs.SettingObsNone | w3gs.SettingTeamsTogether | w3gs.SettingTeamsFixed,
+			MapWidth:  clampUint16(info.Width),
+			MapHeight: clampUint16(info.Height),
+			MapXoro:   sum.Xoro,
+			MapPath:   mapPath,
+			HostName:  cfg.HostName,
+			MapSha1:   sum.Sha1,
+		},
+		mapCheck: w3gs.MapCheck{
+			FilePath: mapPath,
+			FileSize: sum.Size,
+			FileCRC:  sum.CRC,
+			MapXoro:  sum.Xoro,
+			MapSha1:  sum.Sha1,
+		},
+		slots: openSlots(players),
+	}, nil
+}
+
+// openSlots returns a melee layout with every player slot open.
+func openSlots(n int) w3gs.SlotInfo {
+	slots := make([]w3gs.SlotData, n)
+	for i := range slots {
+		slots[i] = w3gs.SlotData{
+			SlotStatus: w3gs.SlotOpen,
+			Team:       uint8(i), //nolint:gosec // Bounded by maxSlots
+			Color:      uint8(i), //nolint:gosec // Bounded by maxSlots
+			Race:       w3gs.RaceRandom | w3gs.RaceSelectable,
+			Handicap:   handicapNone,
+		}
+	}
+
+	return w3gs.SlotInfo{
+		Slots:      slots,
+		RandomSeed: rand.Uint32(), //nolint:gosec // Not security sensitive
+		SlotLayout: w3gs.LayoutMelee,
+		NumPlayers: uint8(n), //nolint:gosec // Bounded by maxSlots
+	}
+}
+
+// Port returns the TCP port players join on.
+func (h *Host) Port() uint16 {
+	return clampUint16(h.listener.Addr().(*net.TCPAddr).Port) //nolint:forcetypeassert
+}
+
+// Run hosts lobbies until the context is cancelled.
+func (h *Host) Run(ctx context.Context, refresh time.Duration) error {
+	go func() {
+		<-ctx.Done()
+
+		_ = h.listener.Close()
+	}()
+
+	go h.acceptLoop()
+
+	for ctx.Err() == nil {
+		h.serveGame(ctx, refresh)
+	}
+
+	return nil
+}
+
+// serveGame announces a new lobby and waits until its game is over.
+func (h *Host) serveGame(ctx context.Context, refresh time.Duration) {
+	g := lobby.NewGame(w3gs.Encoding{GameVersion: h.cfg.Version.Version}, h.slots, h.mapCheck)
+	done := make(chan struct{})
+
+	g.On(&lobby.PlayerJoined{}, func(ev *network.Event) {
+		p := ev.Arg.(*lobby.PlayerJoined) //nolint:forcetypeassert
+		slog.Info("player joined hosted game", "game", h.cfg.GameName, "player", p.PlayerInfo.PlayerName)
+
+		h.announce()
+
+		if h.cfg.AutoStart > 0 && g.CountPlayers() >= h.cfg.AutoStart {
+			go h.start(g)
+		}
+	})
+	g.On(&lobby.PlayerLeft{}, func(ev *network.Event) {
+		p := ev.Arg.(*lobby.PlayerLeft) //nolint:forcetypeassert
+		slog.Info("player left hosted game", "game", h.cfg.GameName, "player", p.PlayerInfo.PlayerName)
+
+		if g.Stage() == lobby.StageLobby {
+			h.announce()
+		}
+	})
+	g.On(&lobby.PlayerChat{}, func(ev *network.Event) {
+		msg := ev.Arg.(*lobby.PlayerChat) //nolint:forcetypeassert
+		if strings.EqualFold(strings.TrimSpace(msg.Content), StartCommand) {
+			ev.PreventNext()
+
+			go h.start(g)
+		}
+	})
+	g.On(&lobby.StageChanged{}, func(ev *network.Event) {
+		stage := ev.Arg.(*lobby.StageChanged) //nolint:forcetypeassert
+		h.onStage(stage.New)
+
+		if stage.New == lobby.StageDone {
+			close(done)
+		}
+	})
+	g.On(&network.AsyncError{}, func(ev *network.Event) {
+		slog.Debug("hosted game error", "game", h.cfg.GameName, "error", ev.Arg)
+	})
+
+	h.mu.Lock()
+	h.current = g
+	h.created = time.Now()
+	h.info = w3gs.GameInfo{
+		GameVersion:  h.cfg.Version,
+		HostCounter:  rand.Uint32N(1<<24) + 1, //nolint:gosec // Not security sensitive
+		EntryKey:     rand.Uint32(),           //nolint:gosec // Not security sensitive
+		GameName:     h.cfg.GameName,
+		GameSettings: h.settings,
+		SlotsTotal:   uint32(len(h.slots.Slots)),
+		GameFlags:    w3gs.GameFlagCustomGame | w3gs.GameFlagCreatorUser | w3gs.GameFlagObsNone,
+		GamePort:     h.Port(),
+	}
+
+	if packet.FitGameInfo(&h.info) {
+		slog.Warn("shortened the names of the hosted game to fit its announcement",
+			"game", h.info.GameName,
+			"host", h.info.GameSettings.HostName,
+		)
+	}
+
+	h.mu.Unlock()
+
+	slog.Info("hosting game", "game", h.cfg.GameName, "map", h.settings.MapPath, "port", h.Port())
+
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+
+	h.announce()
+
+	for {
+		select {
+		case <-ctx.Done():
+			h.mu.Lock()
+			h.current = nil
+			h.mu.Unlock()
+
+			g.Close()
+			h.remove(game.StateExpired)
+
+			return
+		case <-done:
+			h.remove(game.StateEnded)
+
+			return
+		case <-ticker.C:
+			if g.Stage() == lobby.StageLobby {
+				h.announce()
+			}
+		}
+	}
+}
+
+// acceptLoop hands new connections to the current lobby.
+func (h *Host) acceptLoop() {
+	for {
+		conn, err := h.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		h.mu.Lock()
+		g := h.current
+		h.mu.Unlock()
+
+		if g == nil {
+			_ = conn.Close()
+
+			continue
+		}
+
+		go func() {
+			_, err := g.Accept(conn)
+			if err != nil {
+				slog.Debug("rejected player", "remote", conn.RemoteAddr(), "error", err)
+
+				_ = conn.Close()
+			}
+		}()
+	}
+}
+
+// start starts the game once every player has the map.
+func (h *Host) start(g *lobby.Game) {
+	err := g.Start()
+	if err != nil {
+		slog.Warn("failed to start hosted game", "game", h.cfg.GameName, "error", err)
+
+		return
+	}
+
+	slog.Info("hosted game starting", "game", h.cfg.GameName, "players", g.CountPlayers())
+}
+
+// onStage moves the registry entry along with the game.
+func (h *Host) onStage(stage lobby.Stage) {
+	var state game.State
+
+	switch stage {
+	case lobby.StageLoading:
+		state = game.StateStarting
+	case lobby.StagePlaying:
+		state = game.StateInProgress
+	default:
+		return
+	}
+
+	h.mu.Lock()
+	g := h.game()
+	h.mu.Unlock()
+
+	h.registry.SetState(g.Key(), state)
+}
+
+// announce adds or refreshes the lobby in the registry.
+func (h *Host) announce() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Players leaving a lobby that was shut down must not re-add it
+	if h.current == nil {
+		return
+	}
+
+	h.info.SlotsUsed = uint32(h.current.SlotsUsed())           //nolint:gosec // Bounded by maxSlots
+	h.info.SlotsAvailable = uint32(h.current.SlotsAvailable()) //nolint:gosec // Bounded by maxSlots
+	h.info.UptimeSec = uint32(time.Since(h.created).Seconds())
+
+	g := h.game()
+
+	raw, err := packet.Build(&g.Info)
+	if err != nil {
+		slog.Error("failed to serialize hosted game", "error", err)
+
+		return
+	}
+
+	g.RawData = raw
+	h.registry.Add(g)
+}
+
+// remove drops the lobby from the registry.
+func (h *Host) remove(state game.State) {
+	h.mu.Lock()
+	g := h.game()
+	h.mu.Unlock()
+
+	h.registry.SetState(g.Key(), state)
+}
+
+// game returns the registry entry for the current lobby.
+// Must be called with h.mu held.
+func (h *Host) game() game.Game {
+	return game.Game{
+		Info:     h.info,
+		Source:   game.SourceLocal,
+		PeerName: "local",
+	}
+}
+
+// Close stops accepting players.
+func (h *Host) Close() error {
+	return h.listener.Close()
+}
+
+// clampUint16 converts n to uint16, saturating at the bounds.
+func clampUint16(n int) uint16 {
+	return uint16(max(0, min(n, 0xFFFF))) //nolint:gosec // Clamped above
+}
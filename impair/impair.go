@@ -0,0 +1,266 @@
+// Package impair simulates bad network paths for testing.
+//
+// An Impairer injects latency, jitter and packet loss into connections so
+// behaviour over poor DERP relays can be reproduced. A seeded random source
+// makes the injected pattern deterministic across runs.
+package impair
+
+import (
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+// tcpRetransmitDelay is the extra delay applied to a "lost" TCP write.
+// TCP cannot drop data, so loss shows up as a retransmission stall.
+const tcpRetransmitDelay = 200 * time.Millisecond
+
+// queueSize is the number of delayed writes buffered per connection.
+const queueSize = 256
+
+// flushTimeout bounds how long Close waits for queued writes to be delivered.
+const flushTimeout = time.Second
+
+// Config describes the impairment to inject.
+type Config struct {
+	// Latency is added to every write.
+	Latency time.Duration
+
+	// Jitter is the maximum random extra delay added to every write.
+	Jitter time.Duration
+
+	// Loss is the probability (0-1) that a write is lost.
+	Loss float64
+
+	// Seed seeds the random source for jitter and loss.
+	Seed uint64
+}
+
+// Enabled reports whether the configuration injects any impairment.
+func (c Config) Enabled() bool {
+	return c.Latency > 0 || c.Jitter > 0 || c.Loss > 0
+}
+
+// Impairer injects impairment into connections. A nil Impairer leaves
+// connections untouched.
+type Impairer struct {
+	cfg Config
+	rng *rand.Rand
+	mu  sync.Mutex
+}
+
+// New creates an Impairer, or returns nil if cfg injects nothing.
+func New(cfg Config) *Impairer {
+	if !cfg.Enabled() {
+		return nil
+	}
+
+	return &Impairer{
+		cfg: cfg,
+		rng: rand.New(rand.NewPCG(cfg.Seed, cfg.Seed)), //nolint:gosec // Simulation, not crypto
+	}
+}
+
+// Config returns the injected impairment.
+func (i *Impairer) Config() Config {
+	if i == nil {
+		return Config{}
+	}
+
+	return i.cfg
+}
+
+// next returns the delay for the next write and whether it is lost.
+func (i *Impairer) next() (time.Duration, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	delay := i.cfg.Latency
+	if i.cfg.Jitter > 0 {
+		delay += time.Duration(i.rng.Int64N(int64(i.cfg.Jitter)))
+	}
+
+	return delay, i.rng.Float64() < i.cfg.Loss
+}
+
+// Conn wraps a stream connection so writes are delayed. Ordering is
+// preserved; lost writes are delivered after a retransmission stall.
+func (i *Impairer) Conn(conn net.Conn) net.Conn {
+	if i == nil {
+		return conn
+	}
+
+	c := &impairedConn{
+		Conn:    conn,
+		imp:     i,
+		queue:   make(chan delayedWrite, queueSize),
+		stopped: make(chan struct{}),
+	}
+
+	go c.writeLoop()
+
+	return c
+}
+
+// PacketConn wraps a packet connection so writes are delayed, reordered
+// by jitter, or dropped.
+func (i *Impairer) PacketConn(conn net.PacketConn) net.PacketConn {
+	if i == nil {
+		return conn
+	}
+
+	return &impairedPacketConn{PacketConn: conn, imp: i}
+}
+
+// Write queue entry kinds.
+const (
+	writeData = iota
+	writeCloseWrite
+	writeClose
+)
+
+// delayedWrite is a queued write or close waiting for its delivery time.
+type delayedWrite struct {
+	kind int
+	data []byte
+	due  time.Time
+}
+
+// impairedConn delays writes on a stream connection.
+type impairedConn struct {
+	net.Conn
+
+	imp     *Impairer
+	queue   chan delayedWrite
+	stopped chan struct{} // closed when writeLoop exits
+	lastDue time.Time
+	err     error
+	mu      sync.Mutex
+	once    sync.Once
+}
+
+// Write queues b for delayed delivery.
+func (c *impairedConn) Write(b []byte) (int, error) {
+	delay, lost := c.imp.next()
+	if lost {
+		delay += tcpRetransmitDelay
+	}
+
+	data := make([]byte, len(b))
+	copy(data, b)
+
+	err := c.enqueue(writeData, data, delay)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// CloseWrite half-closes the underlying TCP connection once all queued
+// writes have been delivered.
+func (c *impairedConn) CloseWrite() error {
+	return c.enqueue(writeCloseWrite, nil, 0)
+}
+
+// Close closes the connection once all queued writes have been delivered,
+// waiting at most flushTimeout past the last delivery time.
+func (c *impairedConn) Close() error {
+	c.once.Do(func() {
+		_ = c.enqueue(writeClose, nil, 0)
+	})
+
+	c.mu.Lock()
+	wait := time.Until(c.lastDue) + flushTimeout
+	c.mu.Unlock()
+
+	select {
+	case <-c.stopped:
+	case <-time.After(wait):
+	}
+
+	return c.Conn.Close()
+}
+
+// enqueue schedules an entry after delay, never before an earlier entry
+// so stream order is kept.
+func (c *impairedConn) enqueue(kind int, data []byte, delay time.Duration) error {
+	c.mu.Lock()
+
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+
+		return err
+	}
+
+	due := time.Now().Add(delay)
+	if due.Before(c.lastDue) {
+		due = c.lastDue
+	}
+
+	c.lastDue = due
+	c.mu.Unlock()
+
+	select {
+	case c.queue <- delayedWrite{kind: kind, data: data, due: due}:
+		return nil
+	case <-c.stopped:
+		return net.ErrClosed
+	}
+}
+
+// writeLoop delivers queued entries when they are due.
+func (c *impairedConn) writeLoop() {
+	defer close(c.stopped)
+
+	for w := range c.queue {
+		time.Sleep(time.Until(w.due))
+
+		var err error
+
+		switch w.kind {
+		case writeData:
+			_, err = c.Conn.Write(w.data)
+		case writeCloseWrite:
+			if tc, ok := c.Conn.(*net.TCPConn); ok {
+				err = tc.CloseWrite()
+			}
+		case writeClose:
+			err = net.ErrClosed
+		}
+
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+
+			return
+		}
+	}
+}
+
+// impairedPacketConn delays and drops writes on a packet connection.
+type impairedPacketConn struct {
+	net.PacketConn
+
+	imp *Impairer
+}
+
+// WriteTo sends b to addr after a delay, unless it is lost.
+func (c *impairedPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	delay, lost := c.imp.next()
+	if lost {
+		return len(b), nil
+	}
+
+	data := make([]byte, len(b))
+	copy(data, b)
+
+	time.AfterFunc(delay, func() {
+		_, _ = c.PacketConn.WriteTo(data, addr)
+	})
+
+	return len(b), nil
+}
@@ -0,0 +1,148 @@
+// Package install locates Warcraft III installations on the local system.
+//
+// There is no reliable registry of installed copies across platforms and
+// game launchers, so the usual install locations for Windows, macOS and
+// Wine prefixes are checked for a WC3 executable.
+package install
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// executables are the lower-cased names of the WC3 client, in the order
+// they are preferred when several are present.
+var executables = []string{
+	"frozen throne.exe",
+	"warcraft iii.exe",
+	"war3.exe",
+	"warcraft iii launcher.exe",
+	"frozen throne.app",
+	"warcraft iii.app",
+}
+
+// Installation is a directory holding the Warcraft III client.
+type Installation struct {
+	// Dir is the installation directory.
+	Dir string
+
+	// Executable is the path of the client executable.
+	Executable string
+
+	// MapsDir is the Maps directory, empty if the installation has none.
+	MapsDir string
+}
+
+// Find returns the installations found in the usual locations, without
+// duplicates.
+func Find() []Installation {
+	var found []Installation
+
+	for _, dir := range candidates() {
+		inst, ok := Detect(dir)
+		if !ok {
+			continue
+		}
+
+		if slices.ContainsFunc(found, func(other Installation) bool { return other.Dir == inst.Dir }) {
+			continue
+		}
+
+		found = append(found, inst)
+	}
+
+	return found
+}
+
+// Detect reports whether dir holds a Warcraft III installation. Names are
+// compared ignoring case, as Wine prefixes keep the Windows spelling.
+func Detect(dir string) (Installation, bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return Installation{}, false
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err == nil {
+		dir = abs
+	}
+
+	inst := Installation{Dir: dir}
+	rank := len(executables)
+
+	for _, e := range entries {
+		name := strings.ToLower(e.Name())
+
+		if e.IsDir() && name == "maps" {
+			inst.MapsDir = filepath.Join(dir, e.Name())
+
+			continue
+		}
+
+		i := slices.Index(executables, name)
+		if i >= 0 && i < rank {
+			inst.Executable = filepath.Join(dir, e.Name())
+			rank = i
+		}
+	}
+
+	return inst, inst.Executable != ""
+}
+
+// candidates returns the directories checked by Find, most likely first.
+func candidates() []string {
+	dirs := []string{"."}
+
+	home, _ := os.UserHomeDir()
+
+	switch runtime.GOOS {
+	case "windows":
+		for _, env := range []string{"ProgramFiles(x86)", "ProgramFiles"} {
+			if base := os.Getenv(env); base != "" {
+				dirs = append(dirs, filepath.Join(base, "Warcraft III"))
+			}
+		}
+
+		dirs = append(dirs, `C:\Games\Warcraft III`, `C:\Warcraft III`)
+	case "darwin":
+		dirs = append(dirs, "/Applications/Warcraft III")
+
+		if home != "" {
+			dirs = append(dirs, filepath.Join(home, "Applications", "Warcraft III"))
+		}
+	default:
+		dirs = append(dirs, winePrefixDirs(home)...)
+	}
+
+	return dirs
+}
+
+// winePrefixDirs returns the Windows install locations inside the default
+// Wine prefix, $WINEPREFIX and the prefix Lutris creates for WC3.
+func winePrefixDirs(home string) []string {
+	var prefixes []string
+
+	if prefix := os.Getenv("WINEPREFIX"); prefix != "" {
+		prefixes = append(prefixes, prefix)
+	}
+
+	if home != "" {
+		prefixes = append(prefixes,
+			filepath.Join(home, ".wine"),
+			filepath.Join(home, "Games", "warcraft-iii"),
+		)
+	}
+
+	var dirs []string
+
+	for _, prefix := range prefixes {
+		for _, programs := range []string{"Program Files (x86)", "Program Files", "Games"} {
+			dirs = append(dirs, filepath.Join(prefix, "drive_c", programs, "Warcraft III"))
+		}
+	}
+
+	return dirs
+}
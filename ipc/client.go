@@ -0,0 +1,146 @@
+package ipc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/kradalby/wc3ts/tui"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// ErrNoHello is returned when the daemon does not greet an attaching client.
+var ErrNoHello = errors.New("daemon did not send hello")
+
+// Client is a TUI attached to a daemon.
+type Client struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+	version w3gs.GameVersion
+	mu      sync.Mutex
+}
+
+// Attach connects to the daemon at addr and waits for its hello.
+func Attach(network, addr string) (*Client, error) {
+	nc, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return newClient(nc)
+}
+
+// newClient reads the hello from an established connection.
+func newClient(nc net.Conn) (*Client, error) {
+	c := &Client{conn: nc, scanner: bufio.NewScanner(nc)}
+	c.scanner.Buffer(nil, maxMessageSize)
+
+	hello, err := c.read()
+	if err != nil {
+		_ = nc.Close()
+
+		return nil, err
+	}
+
+	if hello.Type != TypeHello || hello.Version == nil {
+		_ = nc.Close()
+
+		return nil, ErrNoHello
+	}
+
+	c.version = *hello.Version
+
+	return c, nil
+}
+
+// Version returns the daemon's game version at attach time.
+func (c *Client) Version() w3gs.GameVersion {
+	return c.version
+}
+
+// Run forwards daemon updates to program until the connection closes.
+func (c *Client) Run(program tui.Sender) error {
+	for {
+		m, err := c.read()
+		if err != nil {
+			return err
+		}
+
+		if msg := decode(m); msg != nil {
+			program.Send(msg)
+		}
+	}
+}
+
+// Close detaches from the daemon. The daemon keeps running.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// SetVersion asks the daemon to change the game version.
+func (c *Client) SetVersion(v uint32) {
+	version := c.version
+	version.Version = v
+
+	c.write(Message{Type: TypeSetVersion, Version: &version})
+}
+
+// Refresh asks the daemon to probe peers now.
+func (c *Client) Refresh() {
+	c.write(Message{Type: TypeRefresh})
+}
+
+// Pause asks the daemon to pause or resume discovery.
+func (c *Client) Pause() {
+	c.write(Message{Type: TypePause})
+}
+
+// SetPrivate asks the daemon to change a local game's privacy.
+func (c *Client) SetPrivate(key string, private bool) {
+	c.write(Message{Type: TypePrivate, Key: key, Private: private})
+}
+
+// PeerAction asks the daemon to apply a batch action to peers.
+func (c *Client) PeerAction(action tui.PeerAction, ips []netip.Addr) {
+	c.write(Message{Type: TypePeerAction, Action: action, IPs: ips})
+}
+
+// read reads the next message.
+func (c *Client) read() (Message, error) {
+	if !c.scanner.Scan() {
+		err := c.scanner.Err()
+		if err == nil {
+			err = io.EOF
+		}
+
+		return Message{}, fmt.Errorf("daemon connection: %w", err)
+	}
+
+	var m Message
+
+	err := json.Unmarshal(c.scanner.Bytes(), &m)
+
+	return m, err
+}
+
+// write sends an action. Errors surface as a closed connection in Run.
+func (c *Client) write(m Message) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, _ = c.conn.Write(append(data, '\n'))
+}
+
+// Ensure *tea.Program can receive daemon updates.
+var _ tui.Sender = (*tea.Program)(nil)
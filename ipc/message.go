@@ -0,0 +1,134 @@
+// Package ipc connects a TUI to a wc3ts instance running elsewhere.
+//
+// The daemon serves its TUI state over a stream socket as JSON lines: a
+// hello with the game version, a snapshot of the current state and then
+// every update. Attached clients send back the user's actions.
+package ipc
+
+import (
+	"net/netip"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/proxy"
+	"github.com/kradalby/wc3ts/tailscale"
+	"github.com/kradalby/wc3ts/tui"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// Message types sent by the daemon.
+const (
+	TypeHello   = "hello"
+	TypePeers   = "peers"
+	TypeGames   = "games"
+	TypeLog     = "log"
+	TypePaused  = "paused"
+	TypeMuted   = "muted"
+	TypeHealth  = "health"
+	TypePort    = "port"
+	TypeUpdate  = "update"
+	TypeNotice  = "notice"
+	TypeWarning = "warning"
+	TypeVersion = "version"
+
+	TypeConnections = "connections"
+)
+
+// Message types sent by attached clients.
+const (
+	TypeSetVersion = "set-version"
+	TypeRefresh    = "refresh"
+	TypePause      = "pause"
+	TypePrivate    = "private"
+	TypePeerAction = "peer-action"
+)
+
+// Message is a single JSON line exchanged over the socket.
+type Message struct {
+	Type     string            `json:"type"`
+	Version  *w3gs.GameVersion `json:"version,omitempty"`
+	Peers    []tailscale.Peer  `json:"peers,omitempty"`
+	Games    []game.Game       `json:"games,omitempty"`
+	Text     string            `json:"text,omitempty"`
+	Names    []string          `json:"names,omitempty"`
+	IPs      []netip.Addr      `json:"ips,omitempty"`
+	Health   *tailscale.Health `json:"health,omitempty"`
+	Port     int               `json:"port,omitempty"`
+	LANPort  int               `json:"lanPort,omitempty"`
+	PeerPort int               `json:"peerPort,omitempty"`
+	Key      string            `json:"key,omitempty"`
+	Private  bool              `json:"private,omitempty"`
+	Action   tui.PeerAction    `json:"action,omitempty"`
+
+	Connections []proxy.Connection `json:"connections,omitempty"`
+}
+
+// encode converts a TUI message to a Message.
+// It returns false for messages that are not forwarded.
+func encode(msg tea.Msg) (Message, bool) {
+	switch msg := msg.(type) {
+	case tui.PeersMsg:
+		return Message{Type: TypePeers, Peers: msg.Peers}, true
+	case tui.GamesMsg:
+		return Message{Type: TypeGames, Games: msg.Games}, true
+	case tui.LogMsg:
+		return Message{Type: TypeLog, Text: msg.Message}, true
+	case tui.PausedMsg:
+		return Message{Type: TypePaused, Names: msg.Paused}, true
+	case tui.MutedMsg:
+		return Message{Type: TypeMuted, IPs: msg.Muted}, true
+	case tui.HealthMsg:
+		return Message{Type: TypeHealth, Health: &msg.Health}, true
+	case tui.PortMsg:
+		return Message{Type: TypePort, Port: msg.Port, LANPort: msg.LANPort, PeerPort: msg.PeerPort}, true
+	case tui.UpdateMsg:
+		return Message{Type: TypeUpdate, Text: msg.Version}, true
+	case tui.NoticeMsg:
+		return Message{Type: TypeNotice, Text: msg.Text}, true
+	case tui.WarningMsg:
+		return Message{Type: TypeWarning, Text: msg.Text}, true
+	case tui.VersionMsg:
+		return Message{Type: TypeVersion, Version: &msg.Version}, true
+	case tui.ConnectionsMsg:
+		return Message{Type: TypeConnections, Connections: msg.Connections}, true
+	}
+
+	return Message{}, false
+}
+
+// decode converts a Message from the daemon to a TUI message.
+// It returns nil for unknown types.
+func decode(m Message) tea.Msg {
+	switch m.Type {
+	case TypePeers:
+		return tui.PeersMsg{Peers: m.Peers}
+	case TypeGames:
+		return tui.GamesMsg{Games: m.Games}
+	case TypeLog:
+		return tui.LogMsg{Message: m.Text}
+	case TypePaused:
+		return tui.PausedMsg{Paused: m.Names}
+	case TypeMuted:
+		return tui.MutedMsg{Muted: m.IPs}
+	case TypeHealth:
+		if m.Health != nil {
+			return tui.HealthMsg{Health: *m.Health}
+		}
+	case TypePort:
+		return tui.PortMsg{Port: m.Port, LANPort: m.LANPort, PeerPort: m.PeerPort}
+	case TypeUpdate:
+		return tui.UpdateMsg{Version: m.Text}
+	case TypeNotice:
+		return tui.NoticeMsg{Text: m.Text}
+	case TypeWarning:
+		return tui.WarningMsg{Text: m.Text}
+	case TypeVersion:
+		if m.Version != nil {
+			return tui.VersionMsg{Version: *m.Version}
+		}
+	case TypeConnections:
+		return tui.ConnectionsMsg{Connections: m.Connections}
+	}
+
+	return nil
+}
@@ -0,0 +1,245 @@
+package ipc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/kradalby/wc3ts/tui"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// clientQueueSize is the number of messages buffered per client. Clients
+// that fall further behind are disconnected rather than block the daemon.
+const clientQueueSize = 256
+
+// maxLogLines is the number of log lines replayed to a new client.
+const maxLogLines = 50
+
+// maxMessageSize bounds a single message line.
+const maxMessageSize = 4 << 20
+
+// Handlers are the daemon actions attached clients can trigger.
+type Handlers struct {
+	SetVersion func(uint32)
+	Refresh    func()
+	Pause      func()
+	SetPrivate func(key string, private bool)
+	PeerAction func(tui.PeerAction, []netip.Addr)
+}
+
+// AuthorizeFunc decides whether a client may attach. A non-nil error
+// rejects the client.
+type AuthorizeFunc func(ctx context.Context, conn net.Conn) error
+
+// Server serves TUI state to attached clients. It implements tui.Sender.
+type Server struct {
+	handlers Handlers
+	version  w3gs.GameVersion
+	latest   map[string]Message // last message per type, replayed on attach
+	logs     []Message
+	clients  map[*conn]struct{}
+	mu       sync.Mutex
+}
+
+// conn is an attached client.
+type conn struct {
+	net.Conn
+
+	queue chan Message
+}
+
+// NewServer creates a server dispatching client actions to handlers.
+func NewServer(handlers Handlers) *Server {
+	return &Server{
+		handlers: handlers,
+		latest:   make(map[string]Message),
+		clients:  make(map[*conn]struct{}),
+	}
+}
+
+// SetVersion sets the game version announced to clients on attach.
+func (s *Server) SetVersion(v w3gs.GameVersion) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.version = v
+}
+
+// Send records a TUI message and forwards it to all attached clients.
+// It never blocks.
+func (s *Server) Send(msg tea.Msg) {
+	m, ok := encode(msg)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m.Type == TypeLog {
+		s.logs = append(s.logs, m)
+		if len(s.logs) > maxLogLines {
+			s.logs = s.logs[len(s.logs)-maxLogLines:]
+		}
+	} else {
+		s.latest[m.Type] = m
+	}
+
+	for c := range s.clients {
+		select {
+		case c.queue <- m:
+		default:
+			slog.Debug("dropping slow TUI client", "client", c.RemoteAddr())
+
+			delete(s.clients, c)
+			_ = c.Close()
+		}
+	}
+}
+
+// Serve accepts clients on listener until the context is cancelled.
+// If authorize is non-nil, clients it rejects are disconnected.
+func (s *Server) Serve(ctx context.Context, listener net.Listener, authorize AuthorizeFunc) error {
+	go func() {
+		<-ctx.Done()
+
+		_ = listener.Close()
+	}()
+
+	for {
+		nc, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return ctx.Err()
+			}
+
+			return err
+		}
+
+		go func() {
+			if authorize != nil {
+				err := authorize(ctx, nc)
+				if err != nil {
+					slog.Warn("rejected TUI attach", "client", nc.RemoteAddr(), "error", err)
+
+					_ = nc.Close()
+
+					return
+				}
+			}
+
+			s.handle(ctx, nc)
+		}()
+	}
+}
+
+// handle runs one attached client.
+func (s *Server) handle(ctx context.Context, nc net.Conn) {
+	c := &conn{Conn: nc, queue: make(chan Message, clientQueueSize)}
+
+	// Queue the hello and snapshot before any live update
+	s.mu.Lock()
+
+	version := s.version
+	c.queue <- Message{Type: TypeHello, Version: &version}
+
+	for _, m := range s.latest {
+		c.queue <- m
+	}
+
+	for _, m := range s.logs {
+		c.queue <- m
+	}
+
+	s.clients[c] = struct{}{}
+	s.mu.Unlock()
+
+	slog.Info("TUI attached", "client", nc.RemoteAddr())
+
+	go s.writeLoop(ctx, c)
+
+	s.readLoop(c)
+
+	s.mu.Lock()
+	delete(s.clients, c)
+	s.mu.Unlock()
+
+	_ = c.Close()
+
+	slog.Info("TUI detached", "client", nc.RemoteAddr())
+}
+
+// writeLoop writes queued messages to the client.
+func (s *Server) writeLoop(ctx context.Context, c *conn) {
+	enc := json.NewEncoder(c)
+
+	for {
+		select {
+		case <-ctx.Done():
+			_ = c.Close()
+
+			return
+		case m := <-c.queue:
+			err := enc.Encode(m)
+			if err != nil {
+				_ = c.Close()
+
+				return
+			}
+		}
+	}
+}
+
+// readLoop dispatches actions from the client until it disconnects.
+func (s *Server) readLoop(c *conn) {
+	scanner := bufio.NewScanner(c)
+	scanner.Buffer(nil, maxMessageSize)
+
+	for scanner.Scan() {
+		var m Message
+
+		err := json.Unmarshal(scanner.Bytes(), &m)
+		if err != nil {
+			slog.Debug("dropping malformed TUI message", "client", c.RemoteAddr(), "error", err)
+
+			continue
+		}
+
+		s.dispatch(m)
+	}
+}
+
+// dispatch runs the handler for a client action.
+func (s *Server) dispatch(m Message) {
+	h := s.handlers
+
+	switch m.Type {
+	case TypeSetVersion:
+		if m.Version != nil && h.SetVersion != nil {
+			h.SetVersion(m.Version.Version)
+		}
+	case TypeRefresh:
+		if h.Refresh != nil {
+			h.Refresh()
+		}
+	case TypePause:
+		if h.Pause != nil {
+			h.Pause()
+		}
+	case TypePrivate:
+		if h.SetPrivate != nil {
+			h.SetPrivate(m.Key, m.Private)
+		}
+	case TypePeerAction:
+		if h.PeerAction != nil {
+			h.PeerAction(m.Action, m.IPs)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package lan
+
+import (
+	"log/slog"
+	"maps"
+	"time"
+
+	"github.com/kradalby/wc3ts/game"
+)
+
+// refreshBoost is how long games are announced at the refresh interval
+// after they change, before announcements may slow to the idle interval.
+const refreshBoost = 30 * time.Second
+
+// SetIdleInterval sets how often games are announced while they have not
+// changed for a while and no local WC3 client is running, to cut LAN
+// chatter on always-on nodes. Zero, or anything below the refresh
+// interval, keeps the refresh interval. It may be changed while running.
+func (b *Broadcaster) SetIdleInterval(interval time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.idleInterval = interval
+}
+
+// nextInterval returns how long to wait before the next announcement.
+func (b *Broadcaster) nextInterval() time.Duration {
+	b.mu.RLock()
+	interval, idle := b.interval, b.idleInterval
+	quiet := time.Since(b.lastChange) >= refreshBoost
+	port := b.port
+	b.mu.RUnlock()
+
+	slow := idle > interval && quiet && !ClientRunning(int(port))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if slow != b.slowed {
+		b.slowed = slow
+
+		if slow {
+			slog.Debug("games unchanged and no WC3 client running, slowing announcements", "interval", idle)
+		} else {
+			slog.Debug("resuming announcements", "interval", interval)
+		}
+	}
+
+	if slow {
+		return idle
+	}
+
+	return interval
+}
+
+// noteChanges records whether the games to announce differ from the last
+// ones in their lobbies or player counts, and if so wakes slowed
+// announcements. Must be called with b.mu held.
+func (b *Broadcaster) noteChanges(games []game.Game) {
+	state := make(map[string]uint32)
+
+	for i := range games {
+		g := &games[i]
+		if announced(g) {
+			state[g.Key()] = g.Info.SlotsUsed
+		}
+	}
+
+	if maps.Equal(state, b.announcedState) {
+		return
+	}
+
+	b.announcedState = state
+	b.lastChange = time.Now()
+
+	if b.slowed {
+		select {
+		case b.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// announced reports whether g is announced to the LAN. Direct games reach
+// WC3 from the host itself, unreachable ones would never connect, and
+// started or full ones can no longer be joined, so they are withdrawn.
+func announced(g *game.Game) bool {
+	return g.Source == game.SourceRemote && !g.Direct && !g.Unreachable && g.Joinable()
+}
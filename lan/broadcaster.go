@@ -2,12 +2,22 @@ package lan
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"math/rand/v2"
 	"net"
+	"net/netip"
+	"slices"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/control"
 	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/packet"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
 )
 
 // DefaultPort is the standard WC3 LAN port.
@@ -19,29 +29,40 @@ const BroadcastInterval = 3 * time.Second
 // writeBufferSize is the UDP write buffer size.
 const writeBufferSize = 64 * 1024
 
-// minPacketSize is the minimum valid GameInfo packet size.
-const minPacketSize = 4
-
-// portFieldSize is the size of the port field at the end of GameInfo packets.
-const portFieldSize = 2
-
-// byteShift8 is the bit shift for the second byte of a uint16.
-const byteShift8 = 8
-
-// byteShift16 is the bit shift for the third byte of a uint32.
-const byteShift16 = 16
-
-// byteShift24 is the bit shift for the fourth byte of a uint32.
-const byteShift24 = 24
+// GamePortFunc returns the dedicated proxy port of the game with key, or
+// zero if it is joined through the shared proxy port.
+type GamePortFunc func(key string) uint16
 
 // Broadcaster periodically broadcasts remote games to the local LAN.
-// It forwards raw packet bytes with only the port modified.
+// Each announcement is decoded and re-serialized with the w3gs encoder,
+// rewriting the port, host counter, game version and name on the way.
+// While paused, previously announced games are withdrawn.
 type Broadcaster struct {
+	control.Switch
+
 	conn             *net.UDPConn
 	games            []game.Game
 	previousGameKeys map[string]uint32 // game key -> HostCounter for tracking removed games
 	proxyPort        uint16
+	gamePort         GamePortFunc
+	version          w3gs.GameVersion
+	compatGroups     []config.CompatGroup
+	versionTags      bool
+	latencyTags      bool
+	nameTemplate     string
+	port             uint16
+	interval         time.Duration
+	idleInterval     time.Duration
+	slowed           bool
+	lastChange       time.Time
+	announcedState   map[string]uint32 // game key -> SlotsUsed, to detect changes
+	wake             chan struct{}
+	pace             time.Duration
 	broadcastAddr    *net.UDPAddr
+	wineAddr         *net.UDPAddr
+	ifaces           []ifaceTarget
+	unicast          []netip.AddrPort
+	search           *net.UDPConn
 	mu               sync.RWMutex
 }
 
@@ -60,68 +81,260 @@ func NewBroadcaster(proxyPort uint16) (*Broadcaster, error) {
 	return &Broadcaster{
 		conn:             conn,
 		proxyPort:        proxyPort,
+		port:             DefaultPort,
+		interval:         BroadcastInterval,
 		broadcastAddr:    &net.UDPAddr{IP: net.IPv4bcast, Port: DefaultPort},
 		previousGameKeys: make(map[string]uint32),
+		wake:             make(chan struct{}, 1),
 	}, nil
 }
 
 // Run starts the broadcast loop.
 func (b *Broadcaster) Run(ctx context.Context) error {
-	ticker := time.NewTicker(BroadcastInterval)
-	defer ticker.Stop()
+	timer := time.NewTimer(b.nextInterval())
+	defer timer.Stop()
+
+	// Answer local searches between broadcasts
+	searched := make(chan struct{}, 1)
+	if b.search != nil {
+		go searchLoop(ctx, b.search, searched)
+	}
+
+	var lastSearch time.Time
 
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-ticker.C:
+		case <-searched:
+			if time.Since(lastSearch) < searchCooldown {
+				continue
+			}
+
+			lastSearch = time.Now()
+
+			b.broadcastGames()
+		case <-b.wake:
+			b.broadcastGames()
+			timer.Reset(b.nextInterval())
+		case <-timer.C:
 			b.broadcastGames()
+			timer.Reset(b.nextInterval())
 		}
 	}
 }
 
+// SetInterval sets how often games are broadcast. It defaults to
+// BroadcastInterval and may be changed while running.
+func (b *Broadcaster) SetInterval(interval time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.interval = interval
+}
+
+// SetPace sets the pause between announcement packets, to which up to
+// half as much random jitter is added. Zero sends them back to back. It
+// may be changed while running.
+func (b *Broadcaster) SetPace(pace time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pace = pace
+}
+
 // OnGamesChanged updates the list of games to broadcast.
 func (b *Broadcaster) OnGamesChanged(games []game.Game) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
 	b.games = games
+	b.noteChanges(games)
+}
+
+// SetVersion sets the local client's game version. Remote games announced
+// with a compatible version are rewritten to it before broadcasting.
+func (b *Broadcaster) SetVersion(version w3gs.GameVersion) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.version = version
+}
+
+// SetCompatGroups sets the version compatibility table.
+func (b *Broadcaster) SetCompatGroups(groups []config.CompatGroup) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.compatGroups = groups
+}
+
+// SetPort sets the LAN port games are announced to. It defaults to
+// DefaultPort; call it before SetTarget, which brings its own port.
+func (b *Broadcaster) SetPort(port uint16) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.port = port
+	b.broadcastAddr.Port = int(port)
+
+	if b.wineAddr != nil {
+		b.wineAddr.Port = int(port)
+	}
+}
+
+// SetTarget sets the address games are announced to, replacing the
+// IPv4 broadcast address on the LAN port.
+func (b *Broadcaster) SetTarget(addr netip.AddrPort) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.broadcastAddr = net.UDPAddrFromAddrPort(addr)
+
+	slog.Info("announcing games to address", "addr", addr)
+}
+
+// SetAnnounceTargets sets addresses games are also announced to by
+// unicast, for clients that never receive the broadcasts, such as WC3 in
+// a VM on a virtual network. Targets without a port get the LAN port.
+func (b *Broadcaster) SetAnnounceTargets(targets []netip.AddrPort) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.unicast = targets
+
+	for _, target := range targets {
+		if target.Port() == 0 {
+			target = netip.AddrPortFrom(target.Addr(), b.port)
+		}
+
+		slog.Info("also announcing games to address", "addr", target)
+	}
+}
+
+// SetGamePortFunc sets the function looking up dedicated per-game proxy
+// ports. Games without one are announced with the shared proxy port.
+func (b *Broadcaster) SetGamePortFunc(fn GamePortFunc) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.gamePort = fn
+}
+
+// SetVersionTags enables prefixing game names with their version, such as
+// "[1.28] ", whenever games of more than one version are being broadcast.
+func (b *Broadcaster) SetVersionTags(enabled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.versionTags = enabled
+}
+
+// SetLatencyTags enables appending the round trip to each game's host to
+// its name, such as " [43ms]". Games whose host has not been measured yet
+// are announced unchanged.
+func (b *Broadcaster) SetLatencyTags(enabled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.latencyTags = enabled
+}
+
+// SetNameTemplate sets the template rebroadcast game names are rewritten
+// with, such as "{peer}: {name}". {name} is the announced name, {peer}
+// the host's peer name and {version} the announced version. An empty
+// template keeps names as announced.
+func (b *Broadcaster) SetNameTemplate(tmpl string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nameTemplate = tmpl
+}
+
+// SetDSCP marks broadcast packets with the DSCP codepoint dscp; zero
+// leaves them unmarked.
+func (b *Broadcaster) SetDSCP(dscp int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, conn := range b.conns() {
+		err := SetDSCP(conn, dscp)
+		if err != nil {
+			slog.Warn("failed to set DSCP on broadcast socket", "addr", conn.LocalAddr(), "dscp", dscp, "error", err)
+		}
+	}
 }
 
 // Close closes the broadcaster.
 func (b *Broadcaster) Close() error {
-	return b.conn.Close()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	errs := make([]error, 0, len(b.ifaces)+2)
+	for _, conn := range b.conns() {
+		errs = append(errs, conn.Close())
+	}
+
+	if b.search != nil {
+		errs = append(errs, b.search.Close())
+	}
+
+	return errors.Join(errs...)
 }
 
-// broadcastGames sends raw GameInfo packets for all remote games,
-// and DecreateGame for any games that have been removed.
+// broadcastGames sends GameInfo and RefreshGame packets for all remote
+// games, and DecreateGame for any games that have been removed. The
+// packets are encoded under the lock and sent paced after releasing it.
 func (b *Broadcaster) broadcastGames() {
 	b.mu.Lock()
-	defer b.mu.Unlock()
+	pkts := b.announcements()
+	pace := b.pace
+	b.mu.Unlock()
+
+	b.sendPaced(pkts, pace)
+}
+
+// announcements returns the packets announcing the current games and
+// withdrawing removed ones. Must be called with b.mu held.
+func (b *Broadcaster) announcements() []w3gs.Packet {
+	var pkts []w3gs.Packet
 
 	currentKeys := make(map[string]uint32)
 
-	for i := range b.games {
-		g := &b.games[i]
+	games := b.games
+	if b.Paused() {
+		games = nil
+	}
+
+	tagged := b.versionTags && mixedVersions(games)
 
-		if g.Source != game.SourceRemote {
+	for i := range games {
+		g := &games[i]
+
+		if !announced(g) {
 			continue
 		}
 
 		key := g.Key()
-		currentKeys[key] = g.Info.HostCounter
+		currentKeys[key] = g.LocalCounter
 
-		// Forward raw packet with modified port
-		b.sendRawGameInfo(g)
+		// Forward the announcement with the port pointing at the proxy
+		if info := b.gameInfo(g, tagged); info != nil {
+			pkts = append(pkts, info)
+		}
 
 		// Send RefreshGame to update player counts
-		b.sendRefreshGame(g.Info.HostCounter, g.Info.SlotsUsed, g.Info.SlotsAvailable)
+		pkts = append(pkts, &w3gs.RefreshGame{
+			HostCounter:    g.LocalCounter,
+			SlotsUsed:      g.Info.SlotsUsed,
+			SlotsAvailable: g.Info.SlotsAvailable,
+		})
 	}
 
 	// Send DecreateGame for removed games
 	for key, hostCounter := range b.previousGameKeys {
 		if _, exists := currentKeys[key]; !exists {
-			b.sendDecreateGame(hostCounter)
+			pkts = append(pkts, &w3gs.DecreateGame{HostCounter: hostCounter})
 
 			slog.Debug("sent game cancellation",
 				"key", key,
@@ -131,67 +344,119 @@ func (b *Broadcaster) broadcastGames() {
 	}
 
 	b.previousGameKeys = currentKeys
+
+	return pkts
 }
 
-// sendRawGameInfo forwards the raw GameInfo packet with the port modified.
-func (b *Broadcaster) sendRawGameInfo(g *game.Game) {
-	if len(g.RawData) < minPacketSize {
-		slog.Debug("skipping game with no raw data", "game", g.Info.GameName)
+// mixedVersions reports whether the broadcast remote games announce
+// more than one version.
+func mixedVersions(games []game.Game) bool {
+	var first *w3gs.GameVersion
 
-		return
-	}
+	for i := range games {
+		g := &games[i]
+		if !announced(g) {
+			continue
+		}
 
-	// Copy raw data to avoid modifying the original
-	data := make([]byte, len(g.RawData))
-	copy(data, g.RawData)
+		if first == nil {
+			first = &g.Info.GameVersion
+		} else if g.Info.GameVersion != *first {
+			return true
+		}
+	}
 
-	// Modify port at last 2 bytes (little-endian uint16)
-	portIdx := len(data) - portFieldSize
-	data[portIdx] = byte(b.proxyPort)
-	data[portIdx+1] = byte(b.proxyPort >> byteShift8)
+	return false
+}
 
-	// Only send to broadcast address - sending to both broadcast and localhost
-	// causes WC3 to show duplicate games
-	_, err := b.conn.WriteTo(data, b.broadcastAddr)
+// gameInfo returns the GameInfo announcing g, rewritten to be joined
+// through the proxy, or nil if its raw packet is invalid. If tagged is
+// set, the game name is prefixed with the announced version.
+// Must be called with b.mu held.
+func (b *Broadcaster) gameInfo(g *game.Game, tagged bool) *w3gs.GameInfo {
+	// Decode a fresh copy, so the registry's game is never modified
+	info, err := packet.ParseGameInfo(g.RawData)
 	if err != nil {
-		slog.Debug("failed to broadcast game", "game", g.Info.GameName, "error", err)
+		slog.Debug("skipping game with invalid raw data", "game", g.Info.GameName, "error", err)
+
+		return nil
+	}
+
+	// Point joins at the proxy
+	info.GamePort = b.proxyPort
+	if b.gamePort != nil {
+		if port := b.gamePort(g.Key()); port != 0 {
+			info.GamePort = port
+		}
+	}
+
+	// Announce the locally unique counter; the proxy maps Joins back
+	info.HostCounter = g.LocalCounter
+
+	// Announce compatible versions as the local version so the client lists them
+	if b.needsVersionRewrite(g.Info.GameVersion) {
+		info.GameVersion = b.version
+	}
+
+	// Label the game with its host
+	if b.nameTemplate != "" {
+		info.GameName = templateGameName(info.GameName, b.nameTemplate, g)
+	}
+
+	// Label the game with the version it was announced with
+	if tagged {
+		info.GameName = tagGameName(info.GameName, "["+config.FormatVersion(g.Info.Version)+"] ")
+	}
+
+	// Label the game with the round trip to its host
+	if b.latencyTags && g.RTT > 0 {
+		info.GameName = suffixGameName(info.GameName, " ["+strconv.FormatInt(g.RTT.Milliseconds(), 10)+"ms]")
 	}
 
 	slog.Debug("broadcast game",
 		"name", g.Info.GameName,
 		"hostCounter", g.Info.HostCounter,
-		"proxyPort", b.proxyPort,
+		"localCounter", g.LocalCounter,
+		"proxyPort", info.GamePort,
 	)
+
+	return info
 }
 
-// sendRefreshGame sends a RefreshGame (0x32) packet to update player counts.
-func (b *Broadcaster) sendRefreshGame(hostCounter, slotsUsed, slotsAvailable uint32) {
-	packet := []byte{
-		0xF7, 0x32, 0x10, 0x00, // Header: magic, opcode, length=16
-		byte(hostCounter), byte(hostCounter >> byteShift8),
-		byte(hostCounter >> byteShift16), byte(hostCounter >> byteShift24),
-		byte(slotsUsed), byte(slotsUsed >> byteShift8),
-		byte(slotsUsed >> byteShift16), byte(slotsUsed >> byteShift24),
-		byte(slotsAvailable), byte(slotsAvailable >> byteShift8),
-		byte(slotsAvailable >> byteShift16), byte(slotsAvailable >> byteShift24),
+// needsVersionRewrite reports whether a game announced with v should be
+// rebroadcast with the local version. Must be called with b.mu held.
+func (b *Broadcaster) needsVersionRewrite(v w3gs.GameVersion) bool {
+	if b.version.Version == 0 || v == b.version {
+		return false
 	}
 
-	_, err := b.conn.WriteTo(packet, b.broadcastAddr)
-	if err != nil {
-		slog.Debug("failed to send refresh", "error", err)
-	}
+	return slices.Contains(config.CompatibleVersions(b.compatGroups, b.version), v)
 }
 
-// sendDecreateGame sends a DecreateGame (0x33) packet to notify game removal.
-func (b *Broadcaster) sendDecreateGame(hostCounter uint32) {
-	packet := []byte{
-		0xF7, 0x33, 0x08, 0x00, // Header: magic, opcode, length=8
-		byte(hostCounter), byte(hostCounter >> byteShift8),
-		byte(hostCounter >> byteShift16), byte(hostCounter >> byteShift24),
-	}
+// sendPaced encodes and sends pkts to the LAN, waiting pace plus up to
+// half as much random jitter between them, so bursts of announcements do
+// not overflow Wi-Fi driver queues. Zero sends them back to back.
+func (b *Broadcaster) sendPaced(pkts []w3gs.Packet, pace time.Duration) {
+	for i, pkt := range pkts {
+		if i > 0 && pace > 0 {
+			time.Sleep(pace + rand.N(pace/2+1)) //nolint:gosec // Jitter needs no cryptographic randomness
+		}
 
-	_, err := b.conn.WriteTo(packet, b.broadcastAddr)
-	if err != nil {
-		slog.Debug("failed to send decreate", "error", err)
+		data, err := packet.Serialize(pkt)
+		if err != nil {
+			slog.Debug("failed to encode announcement", "packet", fmt.Sprintf("%T", pkt), "error", err)
+
+			continue
+		}
+
+		// Only send to the broadcast address - sending to both broadcast and
+		// localhost causes WC3 to show duplicate games
+		b.mu.RLock()
+		err = b.send(data)
+		b.mu.RUnlock()
+
+		if err != nil {
+			slog.Debug("failed to send announcement", "packet", packet.Name(packet.ID(data)), "error", err)
+		}
 	}
 }
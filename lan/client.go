@@ -0,0 +1,55 @@
+package lan
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// clientCheckInterval is how long a ClientRunning result is reused, so
+// callers asking every broadcast or probe tick do not bind the port each
+// time.
+const clientCheckInterval = 10 * time.Second
+
+// clientCheck is the last ClientRunning result.
+var clientCheck struct {
+	mu      sync.Mutex
+	port    int
+	running bool
+	at      time.Time
+}
+
+// ClientRunning reports whether a local WC3 client listening on the LAN
+// port appears to be running.
+//
+// WC3 binds the LAN port on all interfaces while it is open, so a failed
+// bind on the loopback LAN port means a client (or another LAN game tool)
+// holds it. wc3ts itself only binds the port on the Tailscale IP, which
+// does not conflict. The port is bound at most once every 10 seconds; in
+// between, the last result is returned.
+func ClientRunning(port int) bool {
+	clientCheck.mu.Lock()
+	defer clientCheck.mu.Unlock()
+
+	if clientCheck.port == port && time.Since(clientCheck.at) < clientCheckInterval {
+		return clientCheck.running
+	}
+
+	clientCheck.port = port
+	clientCheck.running = portHeld(port)
+	clientCheck.at = time.Now()
+
+	return clientCheck.running
+}
+
+// portHeld reports whether binding the loopback LAN port fails.
+func portHeld(port int) bool {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		return true
+	}
+
+	_ = conn.Close()
+
+	return false
+}
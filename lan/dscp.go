@@ -0,0 +1,29 @@
+package lan
+
+import (
+	"errors"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// SetDSCP marks the packets sent on conn with the DSCP codepoint dscp.
+// Both the IPv4 TOS and the IPv6 traffic class are set, as a dual-stack
+// socket may carry either; it only fails if neither could be set.
+func SetDSCP(conn net.Conn, dscp int) error {
+	if dscp <= 0 {
+		return nil
+	}
+
+	tos := dscp << 2 // DSCP is the upper six bits of the TOS byte
+
+	err4 := ipv4.NewConn(conn).SetTOS(tos)
+	err6 := ipv6.NewConn(conn).SetTrafficClass(tos)
+
+	if err4 == nil || err6 == nil {
+		return nil
+	}
+
+	return errors.Join(err4, err6)
+}
@@ -0,0 +1,88 @@
+package lan
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+)
+
+// ifaceTarget announces games on one interface: from a socket bound to its
+// address, so the OS sends them out of it, to its subnet's broadcast address.
+type ifaceTarget struct {
+	conn      *net.UDPConn
+	broadcast netip.Addr
+}
+
+// SetInterfaces announces games on the interfaces assigned prefixes, each
+// to its subnet's broadcast address, instead of to the limited broadcast
+// address on whichever interface the OS picks. WC3 joins games at the
+// source address of their announcement, so each interface advertises the
+// proxy at its own address. It must be called before Run.
+func (b *Broadcaster) SetInterfaces(prefixes []netip.Prefix) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, prefix := range prefixes {
+		conn, err := net.ListenUDP("udp4", net.UDPAddrFromAddrPort(netip.AddrPortFrom(prefix.Addr(), 0)))
+		if err != nil {
+			return fmt.Errorf("failed to announce on %s: %w", prefix.Addr(), err)
+		}
+
+		target := ifaceTarget{conn: conn, broadcast: SubnetBroadcast(prefix)}
+		b.ifaces = append(b.ifaces, target)
+
+		slog.Info("announcing games on interface", "addr", prefix.Addr(), "broadcast", target.broadcast)
+	}
+
+	return nil
+}
+
+// send writes an announcement to the LAN: to every selected interface, or
+// to the single destination if none are selected or the client runs under
+// Wine, and to the unicast targets. Must be called with b.mu held.
+func (b *Broadcaster) send(data []byte) error {
+	errs := make([]error, 0, len(b.ifaces)+len(b.unicast)+1)
+
+	if len(b.ifaces) == 0 || b.wineAddr != nil {
+		_, err := b.conn.WriteTo(data, b.dest())
+		errs = append(errs, err)
+	} else {
+		for _, t := range b.ifaces {
+			_, err := t.conn.WriteToUDPAddrPort(data, netip.AddrPortFrom(t.broadcast, b.port))
+			errs = append(errs, err)
+		}
+	}
+
+	for _, target := range b.unicast {
+		if target.Port() == 0 {
+			target = netip.AddrPortFrom(target.Addr(), b.port)
+		}
+
+		_, err := b.conn.WriteToUDPAddrPort(data, target)
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// conns returns every socket announcements are sent from.
+func (b *Broadcaster) conns() []*net.UDPConn {
+	conns := []*net.UDPConn{b.conn}
+	for _, t := range b.ifaces {
+		conns = append(conns, t.conn)
+	}
+
+	return conns
+}
+
+// SubnetBroadcast returns the broadcast address of prefix's subnet.
+func SubnetBroadcast(prefix netip.Prefix) netip.Addr {
+	ip := prefix.Addr().As4()
+	host := ^uint32(0) >> prefix.Bits()
+	binary.BigEndian.PutUint32(ip[:], binary.BigEndian.Uint32(ip[:])|host)
+
+	return netip.AddrFrom4(ip)
+}
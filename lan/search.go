@@ -0,0 +1,66 @@
+package lan
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/kradalby/wc3ts/packet"
+)
+
+// searchCooldown is the shortest time between announcements answering
+// searches, as the client repeats its search while the LAN screen is open.
+const searchCooldown = 500 * time.Millisecond
+
+// ListenSearch answers SearchGame queries from the local WC3 client, sent
+// when the LAN screen is opened, with an announcement at once instead of
+// on the next broadcast. Only broadcast queries are received, so unicast
+// traffic to the client is never taken from it. It must be called before
+// Run.
+func (b *Broadcaster) ListenSearch() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	conn, err := listenSearch(b.port)
+	if err != nil {
+		return err
+	}
+
+	b.search = conn
+
+	slog.Info("answering local game searches", "addr", conn.LocalAddr())
+
+	return nil
+}
+
+// searchLoop reads queries from conn and signals searched for each
+// SearchGame, until conn is closed.
+func searchLoop(ctx context.Context, conn *net.UDPConn, searched chan<- struct{}) {
+	buf := make([]byte, packet.MaxDatagramSize)
+
+	for ctx.Err() == nil {
+		n, addr, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+
+		if packet.ID(buf[:n]) != packet.IDSearchGame {
+			continue
+		}
+
+		_, err = packet.ParseSearchGame(buf[:n])
+		if err != nil {
+			slog.Debug("dropping malformed local SearchGame", "from", addr, "error", err)
+
+			continue
+		}
+
+		slog.Debug("received local SearchGame", "from", addr)
+
+		select {
+		case searched <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package lan
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// listenSearch receives broadcasts to the LAN port. Bound to the limited
+// broadcast address, it shares the port with a client bound to all
+// addresses, as the responder does on the Tailscale address. Both sockets
+// must set SO_REUSEADDR for that, as WC3 does.
+func listenSearch(port uint16) (*net.UDPConn, error) {
+	lc := net.ListenConfig{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var optErr error
+
+			err := c.Control(func(fd uintptr) {
+				optErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+			})
+			if err != nil {
+				return err
+			}
+
+			return optErr
+		},
+	}
+
+	addr := net.JoinHostPort(net.IPv4bcast.String(), strconv.Itoa(int(port)))
+
+	conn, err := lc.ListenPacket(context.Background(), "udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.(*net.UDPConn), nil //nolint:forcetypeassert
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package lan
+
+import (
+	"errors"
+	"net"
+)
+
+// errSearchUnsupported is returned where a socket cannot be bound to the
+// broadcast address.
+var errSearchUnsupported = errors.New("answering local searches is only supported on Linux")
+
+// listenSearch receives broadcasts to the LAN port. Only Linux allows
+// binding the broadcast address, so elsewhere games appear on the next
+// broadcast.
+func listenSearch(uint16) (*net.UDPConn, error) {
+	return nil, errSearchUnsupported
+}
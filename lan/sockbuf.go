@@ -0,0 +1,45 @@
+package lan
+
+import (
+	"log/slog"
+	"net"
+)
+
+// SetReceiveBuffer sets SO_RCVBUF on conn and logs the size the kernel
+// actually granted. It returns the effective size, or the requested size
+// if the platform does not allow reading it back.
+func SetReceiveBuffer(conn *net.UDPConn, name string, size int) int {
+	if size <= 0 {
+		return 0
+	}
+
+	err := conn.SetReadBuffer(size)
+	if err != nil {
+		slog.Warn("failed to set UDP receive buffer",
+			"socket", name,
+			"requested", size,
+			"error", err,
+		)
+
+		return 0
+	}
+
+	effective := size
+
+	raw, err := conn.SyscallConn()
+	if err == nil {
+		_ = raw.Control(func(fd uintptr) {
+			if n, ok := readReceiveBuffer(fd); ok {
+				effective = n
+			}
+		})
+	}
+
+	slog.Debug("UDP receive buffer configured",
+		"socket", name,
+		"requested", size,
+		"effective", effective,
+	)
+
+	return effective
+}
@@ -0,0 +1,8 @@
+//go:build !unix && !windows
+
+package lan
+
+// readReceiveBuffer is not supported on this platform.
+func readReceiveBuffer(_ uintptr) (int, bool) {
+	return 0, false
+}
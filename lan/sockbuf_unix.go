@@ -0,0 +1,15 @@
+//go:build unix
+
+package lan
+
+import "syscall"
+
+// readReceiveBuffer returns the SO_RCVBUF size of the socket fd.
+func readReceiveBuffer(fd uintptr) (int, bool) {
+	n, err := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
@@ -0,0 +1,25 @@
+//go:build windows
+
+package lan
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// readReceiveBuffer returns the SO_RCVBUF size of the socket fd.
+func readReceiveBuffer(fd uintptr) (int, bool) {
+	var n int32
+
+	size := int32(unsafe.Sizeof(n))
+
+	err := syscall.Getsockopt(
+		syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF,
+		(*byte)(unsafe.Pointer(&n)), &size,
+	)
+	if err != nil {
+		return 0, false
+	}
+
+	return int(n), true
+}
@@ -0,0 +1,50 @@
+package lan
+
+import (
+	"strings"
+
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/packet"
+)
+
+// maxGameNameLen is the longest game name WC3 displays in the LAN list.
+const maxGameNameLen = 31
+
+// Placeholders expanded by SetNameTemplate.
+const (
+	namePlaceholder    = "{name}"
+	peerPlaceholder    = "{peer}"
+	versionPlaceholder = "{version}"
+)
+
+// tagGameName returns name with tag prepended, truncating the name so the
+// result fits the LAN list.
+func tagGameName(name, tag string) string {
+	return packet.TruncateName(tag+name, maxGameNameLen)
+}
+
+// suffixGameName returns name with suffix appended, truncating the name
+// rather than the suffix so the result fits the LAN list.
+func suffixGameName(name, suffix string) string {
+	return packet.TruncateName(packet.TruncateName(name, max(maxGameNameLen-len(suffix), 0))+suffix, maxGameNameLen)
+}
+
+// templateGameName returns tmpl expanded for g, truncated to fit the LAN
+// list. The announced name is spliced in as is, so names in legacy code
+// pages survive.
+func templateGameName(name, tmpl string, g *game.Game) string {
+	fields := strings.NewReplacer(
+		peerPlaceholder, g.PeerName,
+		versionPlaceholder, config.FormatVersion(g.Info.Version),
+	)
+
+	parts := strings.Split(tmpl, namePlaceholder)
+	result := fields.Replace(parts[0])
+
+	for _, part := range parts[1:] {
+		result += name + fields.Replace(part)
+	}
+
+	return packet.TruncateName(result, maxGameNameLen)
+}
@@ -0,0 +1,40 @@
+package lan
+
+import (
+	"net"
+	"net/netip"
+)
+
+// Wine runtimes reported by WineClient.
+const (
+	WineRuntimeWine   = "wine"
+	WineRuntimeProton = "proton"
+)
+
+// SetWine adapts announcements to a WC3 client running under Wine or Proton.
+//
+// Wine does not reliably deliver broadcasts sent from the same host to the
+// emulated client, so while enabled games are announced to the client on
+// localhost instead of the broadcast address.
+func (b *Broadcaster) SetWine(enabled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !enabled {
+		b.wineAddr = nil
+
+		return
+	}
+
+	b.wineAddr = net.UDPAddrFromAddrPort(netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), b.port))
+}
+
+// dest returns the address announcements are sent to.
+// Must be called with b.mu held.
+func (b *Broadcaster) dest() *net.UDPAddr {
+	if b.wineAddr != nil {
+		return b.wineAddr
+	}
+
+	return b.broadcastAddr
+}
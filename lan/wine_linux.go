@@ -0,0 +1,56 @@
+package lan
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// wc3Executables are the lower-cased executable names of the WC3 client.
+var wc3Executables = []string{"war3.exe", "warcraft iii.exe", "frozen throne.exe", "warcraft iii launcher.exe"}
+
+// WineClient reports whether a WC3 client is running under Wine or Proton.
+// It returns "proton", "wine" or an empty string if none is found.
+//
+// Wine runs the Windows executable as a regular Linux process, so the
+// client is found by scanning process command lines for the WC3 executable.
+func WineClient() string {
+	procs, err := filepath.Glob("/proc/[0-9]*/cmdline")
+	if err != nil {
+		return ""
+	}
+
+	for _, path := range procs {
+		data, err := os.ReadFile(path)
+		if err != nil || len(data) == 0 {
+			continue
+		}
+
+		cmdline := strings.ToLower(strings.ReplaceAll(string(data), "\x00", " "))
+		if !isWC3Cmdline(cmdline) {
+			continue
+		}
+
+		environ, _ := os.ReadFile(filepath.Join(filepath.Dir(path), "environ"))
+		if strings.Contains(cmdline, "proton") || strings.Contains(strings.ToLower(string(environ)), "steam_compat_") {
+			return WineRuntimeProton
+		}
+
+		return WineRuntimeWine
+	}
+
+	return ""
+}
+
+// isWC3Cmdline reports whether a lower-cased command line runs the WC3 client.
+func isWC3Cmdline(cmdline string) bool {
+	for _, exe := range wc3Executables {
+		// Wine shows Windows paths, so match on both separators
+		if strings.Contains(cmdline, `\`+exe) || strings.Contains(cmdline, "/"+exe) ||
+			strings.HasPrefix(cmdline, exe) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package lan
+
+// WineClient reports whether a WC3 client is running under Wine or Proton.
+// Wine detection is only supported on Linux.
+func WineClient() string {
+	return ""
+}
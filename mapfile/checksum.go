@@ -0,0 +1,149 @@
+package mapfile
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // WC3 identifies maps by SHA-1
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/bits"
+	"os"
+	"path/filepath"
+
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// ErrMissingScripts is returned when common.j or blizzard.j is neither in
+// the map nor in the scripts directory.
+var ErrMissingScripts = errors.New("common.j and blizzard.j not found in the map or scripts directory")
+
+// checksumMagic separates the game scripts from the map files in the hash.
+var checksumMagic = []byte{0x9E, 0x37, 0xF1, 0x03}
+
+// Game scripts hashed before the map files. Maps may override them under
+// scripts\ in the archive.
+var checksumScripts = []string{"common.j", "blizzard.j"}
+
+// Map files hashed after the magic, each with alternative archive paths.
+var checksumFiles = [][]string{
+	{"war3map.j", `scripts\war3map.j`},
+	{"war3map.w3e"},
+	{"war3map.wpm"},
+	{"war3map.doo"},
+	{"war3map.w3u"},
+	{"war3map.w3b"},
+	{"war3map.w3d"},
+	{"war3map.w3a"},
+	{"war3map.w3q"},
+}
+
+// Checksum holds the values WC3 clients compare to decide whether their
+// copy of a map matches the host's (versions before 1.32).
+type Checksum struct {
+	// Size is the map file size in bytes.
+	Size uint32
+
+	// CRC is the CRC-32 of the whole map file.
+	CRC uint32
+
+	// Xoro is the XOR-rotate hash of the game scripts and map files.
+	Xoro uint32
+
+	// Sha1 is the SHA-1 of the same data.
+	Sha1 [20]byte
+}
+
+// LoadChecksum computes the checksum of the map at path. Maps that do not
+// override common.j and blizzard.j hash the game's own copies, which are
+// read from scriptsDir.
+func LoadChecksum(path, scriptsDir string) (*Checksum, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > mpqMaxFileSize {
+		return nil, fmt.Errorf("%w: map too large", ErrCorrupt)
+	}
+
+	a, err := openArchive(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	sum := &Checksum{
+		Size: uint32(len(data)), //nolint:gosec // Bounded by mpqMaxFileSize
+		CRC:  crc32.ChecksumIEEE(data),
+	}
+
+	sha := sha1.New() //nolint:gosec // WC3 identifies maps by SHA-1
+
+	var xor uint32
+
+	for _, name := range checksumScripts {
+		content, err := a.ReadFile(`scripts\` + name)
+		if errors.Is(err, ErrFileNotFound) && scriptsDir != "" {
+			content, err = os.ReadFile(filepath.Join(scriptsDir, name))
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMissingScripts, err)
+		}
+
+		_, _ = sha.Write(content)
+		xor ^= xoro(0, content)
+	}
+
+	xor = bits.RotateLeft32(xor, 3)
+	xor = xoro(xor, checksumMagic)
+	_, _ = sha.Write(checksumMagic)
+
+	for _, names := range checksumFiles {
+		for _, name := range names {
+			content, err := a.ReadFile(name)
+			if errors.Is(err, ErrFileNotFound) {
+				continue
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			_, _ = sha.Write(content)
+			xor = bits.RotateLeft32(xor^xoro(0, content), 3)
+
+			break
+		}
+	}
+
+	sum.Xoro = xor
+	copy(sum.Sha1[:], sha.Sum(nil))
+
+	return sum, nil
+}
+
+// Matches reports whether the checksum equals the one advertised in a
+// GameInfo. Versions that do not advertise a SHA-1 are compared by Xoro only.
+func (c *Checksum) Matches(settings w3gs.GameSettings) bool {
+	if c.Xoro != settings.MapXoro {
+		return false
+	}
+
+	return settings.MapSha1 == [20]byte{} || c.Sha1 == settings.MapSha1
+}
+
+// xoro folds data into h four bytes at a time, then byte by byte,
+// rotating left by three after each step.
+func xoro(h uint32, data []byte) uint32 {
+	for len(data) >= 4 {
+		h = bits.RotateLeft32(h^binary.LittleEndian.Uint32(data), 3)
+		data = data[4:]
+	}
+
+	for _, b := range data {
+		h = bits.RotateLeft32(h^uint32(b), 3)
+	}
+
+	return h
+}
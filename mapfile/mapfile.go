@@ -0,0 +1,492 @@
+// Package mapfile reads metadata from local Warcraft III map files (w3m/w3x).
+package mapfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// Map file layout constants.
+const (
+	// mapHeaderSize is the size of the HM3W header preceding the MPQ archive.
+	mapHeaderSize = 512
+
+	// w3i format versions.
+	w3iVersionROC  = 18
+	w3iVersionTFT  = 25
+	w3iVersion131  = 28
+	w3iVersion132  = 31
+	w3iCameraBytes = 8*4 + 4*4
+
+	trigStrPrefix = "TRIGSTR_"
+)
+
+// Files inside the map archive.
+const (
+	fileInfo    = "war3map.w3i"
+	fileStrings = "war3map.wts"
+)
+
+// mapMagic is the signature of the HM3W map header.
+var mapMagic = []byte("HM3W")
+
+// Map errors.
+var (
+	ErrNotMap   = errors.New("not a WC3 map")
+	ErrShortW3I = errors.New("truncated war3map.w3i")
+)
+
+// Info holds metadata extracted from a map file.
+type Info struct {
+	// Path is the local file path.
+	Path string
+
+	// Size is the file size in bytes.
+	Size int64
+
+	// Name is the map name.
+	Name string
+
+	// Author is the map author.
+	Author string
+
+	// Description is the map description.
+	Description string
+
+	// SuggestedPlayers is the free-form "suggested players" text.
+	SuggestedPlayers string
+
+	// Width and Height are the playable map dimensions.
+	Width  int
+	Height int
+
+	// MaxPlayers is the number of player slots defined by the map.
+	MaxPlayers int
+}
+
+// Load reads metadata from the map file at path.
+// Fields that cannot be extracted (e.g. from protected maps) are left empty.
+func Load(path string) (*Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{Path: path, Size: st.Size()}
+
+	err = readHeader(f, info)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := openArchive(f, st.Size())
+	if err != nil {
+		// Header data alone is still useful
+		return info, nil //nolint:nilerr
+	}
+
+	w3i, err := a.ReadFile(fileInfo)
+	if err != nil {
+		return info, nil //nolint:nilerr
+	}
+
+	var wts map[int]string
+
+	if data, err := a.ReadFile(fileStrings); err == nil {
+		wts = parseWTS(data)
+	}
+
+	_ = parseW3I(w3i, wts, info)
+
+	return info, nil
+}
+
+// readHeader parses the HM3W header for the map name and player count.
+func readHeader(r io.ReaderAt, info *Info) error {
+	header := make([]byte, mapHeaderSize)
+
+	n, err := r.ReadAt(header, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	header = header[:n]
+	if len(header) < len(mapMagic)+4 || !bytes.Equal(header[:4], mapMagic) {
+		return ErrNotMap
+	}
+
+	rd := &reader{data: header[8:]}
+	info.Name = rd.cstring()
+	_ = rd.int32() // flags
+	info.MaxPlayers = rd.int32()
+
+	return nil
+}
+
+// parseW3I extracts the interesting fields from war3map.w3i.
+func parseW3I(data []byte, wts map[int]string, info *Info) error {
+	rd := &reader{data: data}
+
+	version := rd.int32()
+	_ = rd.int32() // saves
+	_ = rd.int32() // editor version
+
+	if version >= w3iVersion131 {
+		rd.skip(4 * 4) // game version major, minor, patch, build
+	}
+
+	info.Name = resolve(rd.cstring(), wts, info.Name)
+	info.Author = resolve(rd.cstring(), wts, "")
+	info.Description = resolve(rd.cstring(), wts, "")
+	info.SuggestedPlayers = resolve(rd.cstring(), wts, "")
+
+	rd.skip(w3iCameraBytes)
+	info.Width = rd.int32()
+	info.Height = rd.int32()
+	_ = rd.int32() // flags
+	rd.skip(1)     // ground type
+
+	switch {
+	case version == w3iVersionROC:
+		_ = rd.int32() // campaign background
+		rd.skipStrings(3)
+		_ = rd.int32() // loading screen
+		rd.skipStrings(3)
+	case version >= w3iVersionTFT:
+		_ = rd.int32() // loading screen
+		rd.skipStrings(4)
+		_ = rd.int32() // game data set
+		rd.skipStrings(4)
+		rd.skip(4 * 4) // fog style, start, end, density
+		rd.skip(4)     // fog color
+		_ = rd.int32() // weather
+		rd.skipStrings(1)
+		rd.skip(1) // light environment
+		rd.skip(4) // water color
+
+		if version >= w3iVersion131 {
+			_ = rd.int32() // script language
+		}
+
+		if version >= w3iVersion132 {
+			rd.skip(2 * 4) // graphics modes, game data version
+		}
+	default:
+		return rd.err
+	}
+
+	if players := rd.int32(); rd.err == nil && players > 0 {
+		info.MaxPlayers = players
+	}
+
+	if rd.err != nil {
+		return fmt.Errorf("%w: %w", ErrShortW3I, rd.err)
+	}
+
+	return nil
+}
+
+// parseWTS parses a war3map.wts trigger string file.
+func parseWTS(data []byte) map[int]string {
+	result := make(map[int]string)
+	text := strings.TrimPrefix(string(data), "\ufeff")
+
+	for {
+		idx := strings.Index(text, "STRING ")
+		if idx < 0 {
+			return result
+		}
+
+		text = text[idx+len("STRING "):]
+
+		line, rest, _ := strings.Cut(text, "\n")
+
+		id, err := strconv.Atoi(strings.TrimSpace(line))
+		if err != nil {
+			continue
+		}
+
+		open := strings.Index(rest, "{")
+		closing := strings.Index(rest, "}")
+
+		if open < 0 || closing < open {
+			return result
+		}
+
+		result[id] = strings.TrimSpace(rest[open+1 : closing])
+		text = rest[closing+1:]
+	}
+}
+
+// resolve replaces TRIGSTR_ references with their text.
+func resolve(s string, wts map[int]string, fallback string) string {
+	if after, ok := strings.CutPrefix(s, trigStrPrefix); ok {
+		id, err := strconv.Atoi(after)
+		if err == nil {
+			if text, found := wts[id]; found {
+				return text
+			}
+		}
+
+		return fallback
+	}
+
+	if s == "" {
+		return fallback
+	}
+
+	return s
+}
+
+// reader is a little-endian cursor that records the first error.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) skip(n int) {
+	if r.err != nil {
+		return
+	}
+
+	if len(r.data) < n {
+		r.err = io.ErrUnexpectedEOF
+		r.data = nil
+
+		return
+	}
+
+	r.data = r.data[n:]
+}
+
+func (r *reader) int32() int {
+	if r.err != nil || len(r.data) < 4 {
+		r.err = io.ErrUnexpectedEOF
+
+		return 0
+	}
+
+	v := binary.LittleEndian.Uint32(r.data)
+	r.data = r.data[4:]
+
+	if v > math.MaxInt32 {
+		return -1
+	}
+
+	return int(v)
+}
+
+func (r *reader) cstring() string {
+	if r.err != nil {
+		return ""
+	}
+
+	idx := bytes.IndexByte(r.data, 0)
+	if idx < 0 {
+		r.err = io.ErrUnexpectedEOF
+
+		return ""
+	}
+
+	s := string(r.data[:idx])
+	r.data = r.data[idx+1:]
+
+	return s
+}
+
+func (r *reader) skipStrings(n int) {
+	for range n {
+		_ = r.cstring()
+	}
+}
+
+// Scan returns the paths of all w3m and w3x maps below dir, sorted.
+func Scan(dir string) ([]string, error) {
+	var maps []string
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".w3m", ".w3x":
+			if d.Type().IsRegular() {
+				maps = append(maps, path)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Sort(maps)
+
+	return maps, nil
+}
+
+// Status describes whether an advertised map is available locally.
+type Status int
+
+// Map availability states.
+const (
+	StatusUnknown   Status = iota // Not checked (no Maps directory)
+	StatusMissing                 // Not found locally
+	StatusPresent                 // Found and matches the advertised map
+	StatusDifferent               // Found but differs from the advertised map
+)
+
+// Symbol returns a compact indicator for the status.
+func (s Status) Symbol() string {
+	switch s {
+	case StatusMissing:
+		return "✗"
+	case StatusPresent:
+		return "✓"
+	case StatusDifferent:
+		return "≠"
+	case StatusUnknown:
+	}
+
+	return "-"
+}
+
+// String returns a readable description of the status.
+func (s Status) String() string {
+	switch s {
+	case StatusMissing:
+		return "missing"
+	case StatusPresent:
+		return "have it"
+	case StatusDifferent:
+		return "different version"
+	case StatusUnknown:
+	}
+
+	return "unknown"
+}
+
+// Library resolves advertised map paths against a local Maps directory
+// and caches the parsed metadata.
+type Library struct {
+	dir   string
+	cache map[string]*Info
+	mu    sync.Mutex
+}
+
+// NewLibrary creates a Library for the given Maps directory.
+// Returns nil if dir is empty.
+func NewLibrary(dir string) *Library {
+	if dir == "" {
+		return nil
+	}
+
+	return &Library{
+		dir:   dir,
+		cache: make(map[string]*Info),
+	}
+}
+
+// Dir returns the Maps directory.
+func (l *Library) Dir() string {
+	return l.dir
+}
+
+// Resolve returns the local file path for an advertised map path
+// such as `Maps\Download\DotA.w3x`, or "" if it does not exist locally.
+func (l *Library) Resolve(mapPath string) string {
+	rel := strings.ReplaceAll(mapPath, "\\", "/")
+
+	// Advertised paths are relative to the WC3 install, which contains Maps/
+	if after, ok := cutPrefixFold(rel, "Maps/"); ok {
+		rel = after
+	}
+
+	candidates := []string{
+		filepath.Join(l.dir, filepath.FromSlash(rel)),
+		filepath.Join(l.dir, "Download", filepath.Base(rel)),
+		filepath.Join(l.dir, filepath.Base(rel)),
+	}
+
+	for _, path := range candidates {
+		st, err := os.Stat(path)
+		if err == nil && st.Mode().IsRegular() {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// Lookup returns metadata for an advertised map path.
+// Returns nil if the map does not exist locally or cannot be read.
+func (l *Library) Lookup(mapPath string) *Info {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if info, ok := l.cache[mapPath]; ok {
+		return info
+	}
+
+	var info *Info
+
+	if path := l.Resolve(mapPath); path != "" {
+		info, _ = Load(path)
+	}
+
+	l.cache[mapPath] = info
+
+	return info
+}
+
+// Check compares an advertised map against the local copy. The GameInfo
+// stat string carries the playable width and height, which differ between
+// most revisions of a map, so a mismatch is reported as StatusDifferent.
+func (l *Library) Check(settings w3gs.GameSettings) Status {
+	if l == nil {
+		return StatusUnknown
+	}
+
+	info := l.Lookup(settings.MapPath)
+	if info == nil {
+		return StatusMissing
+	}
+
+	if info.Width > 0 && info.Height > 0 && settings.MapWidth > 0 &&
+		(info.Width != int(settings.MapWidth) || info.Height != int(settings.MapHeight)) {
+		return StatusDifferent
+	}
+
+	return StatusPresent
+}
+
+// cutPrefixFold is strings.CutPrefix ignoring ASCII case.
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+
+	return s, false
+}
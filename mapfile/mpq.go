@@ -0,0 +1,424 @@
+package mapfile
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// MPQ format constants.
+const (
+	mpqHeaderAlign   = 512
+	mpqHeaderSize    = 32
+	mpqEntrySize     = 16
+	mpqBaseSector    = 512
+	mpqMaxSearch     = 64 * 1024 * 1024
+	mpqMaxFileSize   = 64 * 1024 * 1024
+	mpqMaxTableCount = 1 << 20
+
+	hashEmpty   = 0xFFFFFFFF
+	hashDeleted = 0xFFFFFFFE
+
+	hashTypeOffset = 0
+	hashTypeNameA  = 1
+	hashTypeNameB  = 2
+	hashTypeKey    = 3
+
+	flagImplode    = 0x00000100
+	flagCompress   = 0x00000200
+	flagEncrypted  = 0x00010000
+	flagFixKey     = 0x00020000
+	flagSingleUnit = 0x01000000
+	flagSectorCRC  = 0x04000000
+	flagExists     = 0x80000000
+
+	compressZlib  = 0x02
+	compressBzip2 = 0x10
+)
+
+// mpqMagic is the signature of an MPQ archive header.
+var mpqMagic = []byte("MPQ\x1A")
+
+// MPQ errors.
+var (
+	ErrNotMPQ              = errors.New("not an MPQ archive")
+	ErrFileNotFound        = errors.New("file not found in archive")
+	ErrUnsupportedCompress = errors.New("unsupported compression")
+	ErrCorrupt             = errors.New("corrupt archive")
+)
+
+// cryptTable is the MPQ encryption/hashing table.
+var cryptTable = newCryptTable()
+
+// hashEntry is an MPQ hash table entry.
+type hashEntry struct {
+	nameA      uint32
+	nameB      uint32
+	blockIndex uint32
+}
+
+// blockEntry is an MPQ block table entry.
+type blockEntry struct {
+	filePos        uint32
+	compressedSize uint32
+	fileSize       uint32
+	flags          uint32
+}
+
+// archive is a minimal read-only MPQ archive reader.
+// It understands the v1 layout used by WC3 maps, including the malformed
+// headers written by map protectors, but only zlib and bzip2 compression.
+type archive struct {
+	r          io.ReaderAt
+	base       int64
+	sectorSize int
+	hashes     []hashEntry
+	blocks     []blockEntry
+}
+
+// openArchive finds and parses the MPQ header in r.
+func openArchive(r io.ReaderAt, size int64) (*archive, error) {
+	header := make([]byte, mpqHeaderSize)
+
+	for off := int64(0); off < size && off < mpqMaxSearch; off += mpqHeaderAlign {
+		_, err := r.ReadAt(header, off)
+		if err != nil {
+			break
+		}
+
+		if bytes.Equal(header[:4], mpqMagic) {
+			return parseArchive(r, off, header)
+		}
+	}
+
+	return nil, ErrNotMPQ
+}
+
+// parseArchive reads the hash and block tables for the archive at base.
+func parseArchive(r io.ReaderAt, base int64, header []byte) (*archive, error) {
+	le := binary.LittleEndian
+	sectorShift := le.Uint16(header[14:])
+	hashOffset := le.Uint32(header[16:])
+	blockOffset := le.Uint32(header[20:])
+	hashCount := le.Uint32(header[24:])
+	blockCount := le.Uint32(header[28:])
+
+	if hashCount == 0 || hashCount > mpqMaxTableCount || blockCount > mpqMaxTableCount || sectorShift > 16 {
+		return nil, ErrCorrupt
+	}
+
+	a := &archive{
+		r:          r,
+		base:       base,
+		sectorSize: mpqBaseSector << sectorShift,
+	}
+
+	hashData, err := a.readTable(hashOffset, hashCount, "(hash table)")
+	if err != nil {
+		return nil, err
+	}
+
+	blockData, err := a.readTable(blockOffset, blockCount, "(block table)")
+	if err != nil {
+		return nil, err
+	}
+
+	a.hashes = make([]hashEntry, hashCount)
+	for i := range a.hashes {
+		a.hashes[i] = hashEntry{
+			nameA:      hashData[i*4],
+			nameB:      hashData[i*4+1],
+			blockIndex: hashData[i*4+3],
+		}
+	}
+
+	a.blocks = make([]blockEntry, blockCount)
+	for i := range a.blocks {
+		a.blocks[i] = blockEntry{
+			filePos:        blockData[i*4],
+			compressedSize: blockData[i*4+1],
+			fileSize:       blockData[i*4+2],
+			flags:          blockData[i*4+3],
+		}
+	}
+
+	return a, nil
+}
+
+// readTable reads and decrypts an encrypted MPQ table.
+func (a *archive) readTable(offset, count uint32, key string) ([]uint32, error) {
+	raw := make([]byte, int(count)*mpqEntrySize)
+
+	_, err := a.r.ReadAt(raw, a.base+int64(offset))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: read %s: %w", ErrCorrupt, key, err)
+	}
+
+	words := bytesToWords(raw)
+	decrypt(words, hashString(key, hashTypeKey))
+
+	return words, nil
+}
+
+// ReadFile returns the contents of the named file in the archive.
+func (a *archive) ReadFile(name string) ([]byte, error) {
+	block, ok := a.find(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, name)
+	}
+
+	if block.flags&flagExists == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, name)
+	}
+
+	if block.flags&flagImplode != 0 {
+		return nil, fmt.Errorf("%w: implode (%s)", ErrUnsupportedCompress, name)
+	}
+
+	if block.fileSize > mpqMaxFileSize || block.compressedSize > mpqMaxFileSize {
+		return nil, fmt.Errorf("%w: %s too large", ErrCorrupt, name)
+	}
+
+	var key uint32
+
+	if block.flags&flagEncrypted != 0 {
+		base := name
+		if i := strings.LastIndexByte(base, '\\'); i >= 0 {
+			base = base[i+1:]
+		}
+
+		key = hashString(base, hashTypeKey)
+		if block.flags&flagFixKey != 0 {
+			key = (key + block.filePos) ^ block.fileSize
+		}
+	}
+
+	if block.flags&flagSingleUnit != 0 {
+		return a.readSingleUnit(block, key)
+	}
+
+	return a.readSectors(block, key)
+}
+
+// find looks up a file's block entry by name.
+func (a *archive) find(name string) (blockEntry, bool) {
+	n := uint32(len(a.hashes))
+	start := hashString(name, hashTypeOffset) % n
+	nameA := hashString(name, hashTypeNameA)
+	nameB := hashString(name, hashTypeNameB)
+
+	for i := range n {
+		entry := a.hashes[(start+i)%n]
+
+		switch {
+		case entry.blockIndex == hashEmpty:
+			return blockEntry{}, false
+		case entry.blockIndex == hashDeleted:
+			continue
+		case entry.nameA == nameA && entry.nameB == nameB && int(entry.blockIndex) < len(a.blocks):
+			return a.blocks[entry.blockIndex], true
+		}
+	}
+
+	return blockEntry{}, false
+}
+
+// readSingleUnit reads a file stored as one compressed unit.
+func (a *archive) readSingleUnit(block blockEntry, key uint32) ([]byte, error) {
+	data := make([]byte, block.compressedSize)
+
+	_, err := a.r.ReadAt(data, a.base+int64(block.filePos))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if block.flags&flagEncrypted != 0 {
+		decryptBytes(data, key)
+	}
+
+	if block.flags&flagCompress != 0 && block.compressedSize < block.fileSize {
+		return decompress(data, int(block.fileSize))
+	}
+
+	return data, nil
+}
+
+// readSectors reads a file split into sectors.
+func (a *archive) readSectors(block blockEntry, key uint32) ([]byte, error) {
+	pos := a.base + int64(block.filePos)
+	numSectors := (int(block.fileSize) + a.sectorSize - 1) / a.sectorSize
+
+	if block.flags&flagCompress == 0 {
+		data := make([]byte, block.fileSize)
+
+		_, err := a.r.ReadAt(data, pos)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+
+		if block.flags&flagEncrypted != 0 {
+			for i := range numSectors {
+				end := min((i+1)*a.sectorSize, len(data))
+				decryptBytes(data[i*a.sectorSize:end], key+uint32(i))
+			}
+		}
+
+		return data, nil
+	}
+
+	offsetCount := numSectors + 1
+	if block.flags&flagSectorCRC != 0 {
+		offsetCount++
+	}
+
+	offsetData := make([]byte, offsetCount*4)
+
+	_, err := a.r.ReadAt(offsetData, pos)
+	if err != nil {
+		return nil, fmt.Errorf("%w: sector table: %w", ErrCorrupt, err)
+	}
+
+	offsets := bytesToWords(offsetData)
+	if block.flags&flagEncrypted != 0 {
+		decrypt(offsets, key-1)
+	}
+
+	out := make([]byte, 0, block.fileSize)
+
+	for i := range numSectors {
+		start, end := offsets[i], offsets[i+1]
+		if end < start || end > block.compressedSize {
+			return nil, fmt.Errorf("%w: sector %d bounds", ErrCorrupt, i)
+		}
+
+		sector := make([]byte, end-start)
+
+		_, err := a.r.ReadAt(sector, pos+int64(start))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+
+		if block.flags&flagEncrypted != 0 {
+			decryptBytes(sector, key+uint32(i))
+		}
+
+		expected := min(a.sectorSize, int(block.fileSize)-len(out))
+		if len(sector) < expected {
+			sector, err = decompress(sector, expected)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		out = append(out, sector...)
+	}
+
+	return out, nil
+}
+
+// decompress inflates a compressed sector whose first byte is the compression mask.
+func decompress(data []byte, size int) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrCorrupt
+	}
+
+	var r io.Reader
+
+	switch data[0] {
+	case compressZlib:
+		zr, err := zlib.NewReader(bytes.NewReader(data[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCorrupt, err)
+		}
+
+		defer func() { _ = zr.Close() }()
+
+		r = zr
+	case compressBzip2:
+		r = bzip2.NewReader(bytes.NewReader(data[1:]))
+	default:
+		return nil, fmt.Errorf("%w: mask 0x%02X", ErrUnsupportedCompress, data[0])
+	}
+
+	out := make([]byte, size)
+
+	n, err := io.ReadFull(r, out)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+
+	return out[:n], nil
+}
+
+// newCryptTable builds the MPQ encryption table.
+func newCryptTable() [0x500]uint32 {
+	var table [0x500]uint32
+
+	seed := uint32(0x00100001)
+
+	for i := range 0x100 {
+		idx := i
+
+		for range 5 {
+			seed = (seed*125 + 3) % 0x2AAAAB
+			hi := (seed & 0xFFFF) << 16
+			seed = (seed*125 + 3) % 0x2AAAAB
+			lo := seed & 0xFFFF
+			table[idx] = hi | lo
+			idx += 0x100
+		}
+	}
+
+	return table
+}
+
+// hashString computes the MPQ hash of a file name.
+func hashString(s string, hashType uint32) uint32 {
+	seed1 := uint32(0x7FED7FED)
+	seed2 := uint32(0xEEEEEEEE)
+
+	for _, c := range []byte(strings.ToUpper(s)) {
+		seed1 = cryptTable[hashType*0x100+uint32(c)] ^ (seed1 + seed2)
+		seed2 = uint32(c) + seed1 + seed2 + (seed2 << 5) + 3
+	}
+
+	return seed1
+}
+
+// decrypt decrypts data in place with the given key.
+func decrypt(data []uint32, key uint32) {
+	seed := uint32(0xEEEEEEEE)
+
+	for i := range data {
+		seed += cryptTable[0x400+(key&0xFF)]
+		ch := data[i] ^ (key + seed)
+		key = ((^key << 0x15) + 0x11111111) | (key >> 0x0B)
+		seed = ch + seed + (seed << 5) + 3
+		data[i] = ch
+	}
+}
+
+// decryptBytes decrypts the whole 32-bit words of data in place.
+func decryptBytes(data []byte, key uint32) {
+	words := bytesToWords(data)
+	decrypt(words, key)
+
+	for i, w := range words {
+		binary.LittleEndian.PutUint32(data[i*4:], w)
+	}
+}
+
+// bytesToWords converts little-endian bytes to 32-bit words, ignoring any tail.
+func bytesToWords(data []byte) []uint32 {
+	words := make([]uint32, len(data)/4)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(data[i*4:])
+	}
+
+	return words
+}
@@ -0,0 +1,447 @@
+// Package mdns advertises wc3ts on the local LAN over multicast DNS and
+// browses for other instances, so nodes that share a physical LAN but not
+// a tailnet can find each other.
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kradalby/wc3ts/packet"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ServiceType is the DNS-SD service type wc3ts instances advertise.
+const ServiceType = "_wc3ts._udp.local."
+
+const (
+	// recordTTL is how long, in seconds, other instances may remember us
+	// without hearing from us again.
+	recordTTL = 120
+
+	// queryInterval is how often instances are browsed for. It is well
+	// below recordTTL, so live instances answer before they expire.
+	queryInterval = 30 * time.Second
+
+	// answerInterval is the least time between two answers, so a burst of
+	// queries does not cause a burst of answers.
+	answerInterval = time.Second
+
+	// maxLabelLen is the longest DNS label.
+	maxLabelLen = 63
+
+	// cacheFlush marks records that only this instance answers for.
+	cacheFlush = dnsmessage.Class(1 << 15)
+)
+
+// idKey is the TXT key carrying an instance's random ID, which tells its
+// own answers apart from those of another machine with the same name.
+const idKey = "id="
+
+// group is the mDNS multicast group.
+var group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353} //nolint:mnd
+
+// Instance is another wc3ts instance on the local LAN.
+type Instance struct {
+	// Name is the instance's name, the host name of its machine.
+	Name string
+
+	// Addr is the LAN address and port its games are searched for at.
+	Addr netip.AddrPort
+}
+
+// OnChangeFunc is called with the instances found when they change.
+type OnChangeFunc func(instances []Instance)
+
+// entry is a found instance and when its records expire.
+type entry struct {
+	Instance
+
+	expires time.Time
+}
+
+// Service advertises this instance and browses for others.
+type Service struct {
+	name     string
+	id       string
+	port     uint16
+	onChange OnChangeFunc
+
+	mu         sync.Mutex
+	instances  map[string]entry // by instance ID
+	lastAnswer time.Time
+}
+
+// New creates a service advertising this instance as name, searched for
+// games at port. onChange is called with the other instances found.
+func New(name string, port uint16, onChange OnChangeFunc) *Service {
+	return &Service{
+		name:      label(name),
+		id:        fmt.Sprintf("%08x", rand.Uint32()), //nolint:gosec // not security sensitive
+		port:      port,
+		onChange:  onChange,
+		instances: make(map[string]entry),
+	}
+}
+
+// label turns a host name into an instance label.
+func label(name string) string {
+	name, _, _ = strings.Cut(name, ".")
+	if name == "" {
+		name = "wc3ts"
+	}
+
+	if len(name) > maxLabelLen {
+		name = name[:maxLabelLen]
+	}
+
+	return name
+}
+
+// Run advertises this instance and browses for others until the context
+// is cancelled. On return, the instance is withdrawn.
+func (s *Service) Run(ctx context.Context) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return err
+	}
+
+	go s.receiveLoop(conn)
+
+	s.send(conn, s.announcement(recordTTL))
+
+	ticker := time.NewTicker(queryInterval)
+	defer ticker.Stop()
+
+	for {
+		s.send(conn, query())
+		s.expire()
+
+		select {
+		case <-ctx.Done():
+			// Tell the others we are gone rather than let them time out
+			s.send(conn, s.announcement(0))
+
+			_ = conn.Close()
+
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// send multicasts msg, logging failures.
+func (s *Service) send(conn *net.UDPConn, msg []byte) {
+	if msg == nil {
+		return
+	}
+
+	_, err := conn.WriteToUDP(msg, group)
+	if err != nil {
+		slog.Debug("failed to send mDNS message", "error", err)
+	}
+}
+
+// receiveLoop reads mDNS messages from conn, answering queries for wc3ts
+// and recording the instances in answers.
+func (s *Service) receiveLoop(conn *net.UDPConn) {
+	buf := make([]byte, packet.MaxDatagramSize)
+
+	for {
+		n, addr, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+
+		var p dnsmessage.Parser
+
+		h, err := p.Start(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		if !h.Response {
+			if s.queried(&p) && s.mayAnswer() {
+				s.send(conn, s.announcement(recordTTL))
+			}
+
+			continue
+		}
+
+		s.record(&p, addr.Addr().Unmap())
+	}
+}
+
+// queried reports whether a query asks for wc3ts instances.
+func (s *Service) queried(p *dnsmessage.Parser) bool {
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return false
+	}
+
+	for _, q := range questions {
+		if (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL) && isService(q.Name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mayAnswer reports whether enough time passed since the last answer.
+func (s *Service) mayAnswer() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.lastAnswer) < answerInterval {
+		return false
+	}
+
+	s.lastAnswer = time.Now()
+
+	return true
+}
+
+// record records the instances answered in a response from src. The
+// address is taken from the source of the answer, which is the sender's
+// address on the shared LAN.
+func (s *Service) record(p *dnsmessage.Parser, src netip.Addr) {
+	err := p.SkipAllQuestions()
+	if err != nil {
+		return
+	}
+
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return
+	}
+
+	type found struct {
+		port uint16
+		ttl  uint32
+		id   string
+	}
+
+	instances := make(map[string]*found)
+	get := func(name string) *found {
+		if instances[name] == nil {
+			instances[name] = &found{}
+		}
+
+		return instances[name]
+	}
+
+	for _, rr := range answers {
+		instance, ok := instanceName(rr.Header.Name)
+		if !ok {
+			continue
+		}
+
+		switch body := rr.Body.(type) {
+		case *dnsmessage.SRVResource:
+			f := get(instance)
+			f.port, f.ttl = body.Port, rr.Header.TTL
+		case *dnsmessage.TXTResource:
+			for _, txt := range body.TXT {
+				if id, ok := strings.CutPrefix(txt, idKey); ok {
+					get(instance).id = id
+				}
+			}
+		}
+	}
+
+	changed := false
+
+	s.mu.Lock()
+
+	for name, f := range instances {
+		if f.port == 0 || f.id == "" || f.id == s.id {
+			continue
+		}
+
+		if f.ttl == 0 {
+			_, known := s.instances[f.id]
+			delete(s.instances, f.id)
+
+			changed = changed || known
+
+			continue
+		}
+
+		inst := Instance{Name: name, Addr: netip.AddrPortFrom(src, f.port)}
+		if s.instances[f.id].Instance != inst {
+			slog.Info("found wc3ts instance on the LAN", "name", name, "addr", inst.Addr)
+
+			changed = true
+		}
+
+		s.instances[f.id] = entry{
+			Instance: inst,
+			expires:  time.Now().Add(time.Duration(f.ttl) * time.Second),
+		}
+	}
+
+	s.mu.Unlock()
+
+	if changed {
+		s.notify()
+	}
+}
+
+// expire forgets instances that were not heard from in time.
+func (s *Service) expire() {
+	now := time.Now()
+	changed := false
+
+	s.mu.Lock()
+
+	for id, e := range s.instances {
+		if now.After(e.expires) {
+			slog.Info("wc3ts instance on the LAN is gone", "name", e.Name, "addr", e.Addr)
+			delete(s.instances, id)
+
+			changed = true
+		}
+	}
+
+	s.mu.Unlock()
+
+	if changed {
+		s.notify()
+	}
+}
+
+// Instances returns the other instances found, sorted by name.
+func (s *Service) Instances() []Instance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instances := make([]Instance, 0, len(s.instances))
+	for _, e := range s.instances {
+		instances = append(instances, e.Instance)
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].Name != instances[j].Name {
+			return instances[i].Name < instances[j].Name
+		}
+
+		return instances[i].Addr.Addr().Less(instances[j].Addr.Addr())
+	})
+
+	return instances
+}
+
+// notify passes the instances to the change callback.
+func (s *Service) notify() {
+	if s.onChange != nil {
+		s.onChange(s.Instances())
+	}
+}
+
+// query returns a query for wc3ts instances.
+func query() []byte {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+
+	err := b.StartQuestions()
+	if err == nil {
+		err = b.Question(dnsmessage.Question{
+			Name:  dnsmessage.MustNewName(ServiceType),
+			Type:  dnsmessage.TypePTR,
+			Class: dnsmessage.ClassINET,
+		})
+	}
+
+	if err != nil {
+		return nil
+	}
+
+	msg, err := b.Finish()
+	if err != nil {
+		return nil
+	}
+
+	return msg
+}
+
+// announcement returns an answer advertising this instance, valid for
+// ttl seconds. A ttl of zero withdraws it.
+func (s *Service) announcement(ttl uint32) []byte {
+	instance, err := dnsmessage.NewName(s.name + "." + ServiceType)
+	if err != nil {
+		slog.Debug("cannot advertise instance over mDNS", "name", s.name, "error", err)
+
+		return nil
+	}
+
+	target, err := dnsmessage.NewName(s.name + ".local.")
+	if err != nil {
+		return nil
+	}
+
+	header := func(name dnsmessage.Name, typ dnsmessage.Type, class dnsmessage.Class) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Type: typ, Class: class, TTL: ttl}
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true, Authoritative: true})
+	b.EnableCompression()
+
+	err = b.StartAnswers()
+	if err == nil {
+		err = b.PTRResource(
+			header(dnsmessage.MustNewName(ServiceType), dnsmessage.TypePTR, dnsmessage.ClassINET),
+			dnsmessage.PTRResource{PTR: instance},
+		)
+	}
+
+	if err == nil {
+		err = b.SRVResource(
+			header(instance, dnsmessage.TypeSRV, dnsmessage.ClassINET|cacheFlush),
+			dnsmessage.SRVResource{Port: s.port, Target: target},
+		)
+	}
+
+	if err == nil {
+		err = b.TXTResource(
+			header(instance, dnsmessage.TypeTXT, dnsmessage.ClassINET|cacheFlush),
+			dnsmessage.TXTResource{TXT: []string{idKey + s.id}},
+		)
+	}
+
+	if err != nil {
+		return nil
+	}
+
+	msg, err := b.Finish()
+	if err != nil {
+		return nil
+	}
+
+	return msg
+}
+
+// isService reports whether name is the wc3ts service type.
+func isService(name dnsmessage.Name) bool {
+	return strings.EqualFold(name.String(), ServiceType)
+}
+
+// instanceName returns the instance label of a name under the wc3ts
+// service type.
+func instanceName(name dnsmessage.Name) (string, bool) {
+	s := name.String()
+	if len(s) <= len(ServiceType) || !strings.EqualFold(s[len(s)-len(ServiceType):], ServiceType) {
+		return "", false
+	}
+
+	instance, ok := strings.CutSuffix(s[:len(s)-len(ServiceType)], ".")
+
+	return instance, ok && instance != ""
+}
@@ -0,0 +1,44 @@
+package packet
+
+import "encoding/binary"
+
+// wc3ts hello. A proxy that wants to compress a session sends it to the
+// host before the Join; a guard answers with its own hello, after which
+// both sides deflate the rest of the stream. Responders send it along
+// with their GameInfo answers to tell peers their guard accepts it. It is
+// framed as a GPS packet with an ID GProxy++ does not use, so hosts that
+// are not wc3ts guards see an unknown packet and never a Join.
+const (
+	// IDHello is the GPS packet ID of the hello.
+	IDHello = 0x57 // 'W'
+
+	// HelloSize is the size of a hello: header, magic, version and flags.
+	HelloSize = HeaderSize + len(helloMagic) + 2
+
+	// HelloFlate asks for, or accepts, a deflated stream.
+	HelloFlate = 1
+
+	helloMagic   = "wc3ts"
+	helloVersion = 1
+)
+
+// Hello builds a hello packet with flags.
+func Hello(flags byte) []byte {
+	b := make([]byte, 0, HelloSize)
+	b = append(b, GPSSig, IDHello)
+	b = binary.LittleEndian.AppendUint16(b, uint16(HelloSize))
+	b = append(b, helloMagic...)
+
+	return append(b, helloVersion, flags)
+}
+
+// IsHello reports whether data is a hello packet.
+func IsHello(data []byte) bool {
+	return len(data) == HelloSize && data[0] == GPSSig && data[1] == IDHello &&
+		string(data[HeaderSize:HeaderSize+len(helloMagic)]) == helloMagic
+}
+
+// HelloFlags returns the flags of the hello packet data.
+func HelloFlags(data []byte) byte {
+	return data[HelloSize-1]
+}
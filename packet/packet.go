@@ -0,0 +1,358 @@
+// Package packet provides hardened parsing of W3GS packets received from the network.
+//
+// All bytes arriving on the manager, responder, proxy and probe sockets are
+// attacker-controllable. The functions in this package validate framing and
+// size limits before handing data to gowarcraft3, recover from decoder panics,
+// and sanity-check the decoded fields that wc3ts relies on.
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"unicode/utf8"
+
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// W3GS framing constants.
+const (
+	// HeaderSize is the size of the W3GS header (signature, id, length).
+	HeaderSize = 4
+
+	// MaxSize is the largest packet wc3ts builds itself, and the largest
+	// read by Read. GameInfo with long names and stat strings stays well
+	// below this. Relayed packets may be larger, up to MaxStreamSize.
+	MaxSize = 2048
+
+	// MaxNameLen is the longest game or player name accepted.
+	MaxNameLen = 256
+
+	// MaxPathLen is the longest map path accepted.
+	MaxPathLen = 512
+
+	// MaxSlots is the largest slot count a WC3 lobby can have.
+	MaxSlots = 24
+
+	// MaxDatagramSize is the largest UDP payload. Datagrams are read into
+	// buffers this large, so a large one, fragmented on the way or not,
+	// arrives whole rather than truncated.
+	MaxDatagramSize = 0xFFFF
+
+	// MaxStreamSize is the largest packet accepted from the network and
+	// relayed, on game connections and in datagrams alike. It is the
+	// largest value of the 16-bit length field.
+	MaxStreamSize = 0xFFFF
+
+	// GPSSig is the header signature of GProxy++ reconnect packets, which
+	// share the W3GS framing.
+	GPSSig = 0xF8
+
+	// lengthHi is the bit shift for the high byte of the length field.
+	lengthHi = 8
+)
+
+// Packet IDs used by wc3ts.
+const (
+	IDSearchGame   = w3gs.PidSearchGame
+	IDGameInfo     = w3gs.PidGameInfo
+	IDCreateGame   = w3gs.PidCreateGame
+	IDRefreshGame  = w3gs.PidRefreshGame
+	IDDecreateGame = w3gs.PidDecreateGame
+	IDJoin         = w3gs.PidReqJoin
+)
+
+// names maps the packet IDs seen on the LAN discovery path to readable names.
+var names = map[byte]string{
+	IDSearchGame:   "SearchGame",
+	IDGameInfo:     "GameInfo",
+	IDCreateGame:   "CreateGame",
+	IDRefreshGame:  "RefreshGame",
+	IDDecreateGame: "DecreateGame",
+	IDJoin:         "Join",
+}
+
+// Name returns a readable name for a packet ID.
+func Name(id byte) string {
+	if name, ok := names[id]; ok {
+		return name
+	}
+
+	return "Unknown"
+}
+
+// Parsing errors.
+var (
+	ErrTooShort       = errors.New("packet too short")
+	ErrTooLarge       = errors.New("packet too large")
+	ErrBadSignature   = errors.New("missing W3GS signature")
+	ErrLengthMismatch = errors.New("length field does not match packet size")
+	ErrUnexpectedType = errors.New("unexpected packet type")
+	ErrInvalidField   = errors.New("invalid packet field")
+	ErrDecoderPanic   = errors.New("decoder panic")
+)
+
+// ID returns the packet ID of a framed W3GS packet, or 0 if data is not one.
+func ID(data []byte) byte {
+	if len(data) < HeaderSize || data[0] != w3gs.ProtocolSig {
+		return 0
+	}
+
+	return data[1]
+}
+
+// Length returns the value of the header length field, or 0 if data has no header.
+func Length(data []byte) int {
+	if len(data) < HeaderSize {
+		return 0
+	}
+
+	return int(data[2]) | int(data[3])<<lengthHi
+}
+
+// Validate checks the W3GS framing of a single datagram.
+// The length field must match the datagram size exactly.
+func Validate(data []byte) error {
+	if len(data) < HeaderSize {
+		return ErrTooShort
+	}
+
+	if len(data) > MaxStreamSize {
+		return fmt.Errorf("%w: %d bytes", ErrTooLarge, len(data))
+	}
+
+	if data[0] != w3gs.ProtocolSig {
+		return ErrBadSignature
+	}
+
+	size := Length(data)
+	if size != len(data) {
+		return fmt.Errorf("%w: header %d, got %d", ErrLengthMismatch, size, len(data))
+	}
+
+	return nil
+}
+
+// Parse validates and decodes a single W3GS datagram.
+func Parse(data []byte) (pkt w3gs.Packet, err error) {
+	err = Validate(data)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			pkt, err = nil, fmt.Errorf("%w: %v", ErrDecoderPanic, r)
+		}
+	}()
+
+	pkt, n, err := w3gs.Deserialize(data, w3gs.Encoding{})
+	if err != nil {
+		return nil, err
+	}
+
+	if n != len(data) {
+		return nil, fmt.Errorf("%w: decoded %d of %d bytes", ErrLengthMismatch, n, len(data))
+	}
+
+	return pkt, nil
+}
+
+// ParseGameInfo parses and validates a GameInfo datagram.
+func ParseGameInfo(data []byte) (*w3gs.GameInfo, error) {
+	pkt, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	info, ok := pkt.(*w3gs.GameInfo)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnexpectedType, pkt)
+	}
+
+	switch {
+	case len(info.GameName) > MaxNameLen:
+		return nil, fmt.Errorf("%w: game name length %d", ErrInvalidField, len(info.GameName))
+	case len(info.GameSettings.HostName) > MaxNameLen:
+		return nil, fmt.Errorf("%w: host name length %d", ErrInvalidField, len(info.GameSettings.HostName))
+	case len(info.GameSettings.MapPath) > MaxPathLen:
+		return nil, fmt.Errorf("%w: map path length %d", ErrInvalidField, len(info.GameSettings.MapPath))
+	case info.SlotsTotal > MaxSlots || info.SlotsUsed > MaxSlots:
+		return nil, fmt.Errorf("%w: slots %d/%d", ErrInvalidField, info.SlotsUsed, info.SlotsTotal)
+	case info.GamePort == 0:
+		return nil, fmt.Errorf("%w: zero game port", ErrInvalidField)
+	}
+
+	return info, nil
+}
+
+// Serialize encodes pkt, usually a relayed one, into a W3GS datagram.
+// Datagrams over MaxStreamSize, which the length field cannot express,
+// are refused with ErrTooLarge.
+func Serialize(pkt w3gs.Packet) ([]byte, error) {
+	return serialize(pkt, MaxStreamSize)
+}
+
+// Build encodes a packet wc3ts originates itself, such as the GameInfo of
+// a hosted game. Datagrams over MaxSize are refused with ErrTooLarge, so
+// our own packets stay within what every peer reads.
+func Build(pkt w3gs.Packet) ([]byte, error) {
+	return serialize(pkt, MaxSize)
+}
+
+// serialize encodes pkt, refusing datagrams over maxSize.
+func serialize(pkt w3gs.Packet, maxSize int) ([]byte, error) {
+	data, err := w3gs.Serialize(pkt, w3gs.Encoding{})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > maxSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, len(data))
+	}
+
+	return data, nil
+}
+
+// FitGameInfo cuts the game and host names of info to MaxNameLen, so that
+// ParseGameInfo accepts it. It reports whether a name was cut.
+func FitGameInfo(info *w3gs.GameInfo) bool {
+	name := TruncateName(info.GameName, MaxNameLen)
+	host := TruncateName(info.GameSettings.HostName, MaxNameLen)
+	cut := name != info.GameName || host != info.GameSettings.HostName
+
+	info.GameName = name
+	info.GameSettings.HostName = host
+
+	return cut
+}
+
+// TruncateName cuts name to at most limit bytes, backing off to a rune
+// boundary when the name is valid UTF-8.
+func TruncateName(name string, limit int) string {
+	if len(name) <= limit {
+		return name
+	}
+
+	if !utf8.ValidString(name) {
+		return name[:limit]
+	}
+
+	for limit > 0 && !utf8.RuneStart(name[limit]) {
+		limit--
+	}
+
+	return name[:limit]
+}
+
+// WithGamePort returns a copy of a GameInfo datagram announcing port as the
+// game port. The datagram is decoded and encoded again, so the port lands
+// in the right place whatever the length of the fields before it.
+func WithGamePort(data []byte, port uint16) ([]byte, error) {
+	info, err := ParseGameInfo(data)
+	if err != nil {
+		return nil, err
+	}
+
+	info.GamePort = port
+
+	return Serialize(info)
+}
+
+// WithJoinHostCounter returns a copy of a Join packet addressed to the
+// lobby numbered hostCounter, the first field after the header.
+func WithJoinHostCounter(data []byte, hostCounter uint32) ([]byte, error) {
+	if len(data) < HeaderSize+4 || Length(data) != len(data) {
+		return nil, fmt.Errorf("%w: join of %d bytes", ErrTooShort, len(data))
+	}
+
+	out := make([]byte, len(data))
+	copy(out, data)
+
+	binary.LittleEndian.PutUint32(out[HeaderSize:], hostCounter)
+
+	return out, nil
+}
+
+// ParseSearchGame parses and validates a SearchGame datagram.
+func ParseSearchGame(data []byte) (*w3gs.SearchGame, error) {
+	pkt, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	search, ok := pkt.(*w3gs.SearchGame)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnexpectedType, pkt)
+	}
+
+	return search, nil
+}
+
+// ParseJoin parses and validates a Join packet.
+func ParseJoin(data []byte) (*w3gs.Join, error) {
+	pkt, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	join, ok := pkt.(*w3gs.Join)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnexpectedType, pkt)
+	}
+
+	if len(join.PlayerName) > MaxNameLen {
+		return nil, fmt.Errorf("%w: player name length %d", ErrInvalidField, len(join.PlayerName))
+	}
+
+	return join, nil
+}
+
+// Read reads exactly one framed W3GS packet from a stream.
+// The header is validated before the body is read, so a hostile peer
+// cannot make us allocate more than MaxSize bytes.
+func Read(r io.Reader) ([]byte, error) {
+	return readFrame(r, MaxSize, w3gs.ProtocolSig)
+}
+
+// ReadStream reads one framed packet from an established game connection.
+// Both W3GS and GProxy++ packets are accepted, up to MaxStreamSize bytes.
+func ReadStream(r io.Reader) ([]byte, error) {
+	return readFrame(r, MaxStreamSize, w3gs.ProtocolSig, GPSSig)
+}
+
+// readFrame reads one packet of at most maxSize bytes whose signature is
+// one of sigs.
+func readFrame(r io.Reader, maxSize int, sigs ...byte) ([]byte, error) {
+	header := make([]byte, HeaderSize)
+
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(sigs, header[0]) {
+		return nil, ErrBadSignature
+	}
+
+	size := Length(header)
+
+	switch {
+	case size < HeaderSize:
+		return nil, ErrTooShort
+	case size > maxSize:
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, size)
+	}
+
+	data := make([]byte, size)
+	copy(data, header)
+
+	_, err = io.ReadFull(r, data[HeaderSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
@@ -0,0 +1,214 @@
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/nielsAD/gowarcraft3/protocol"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// seedGameInfo returns a GameInfo datagram as a 1.26 host sends it.
+func seedGameInfo(tb testing.TB) []byte {
+	tb.Helper()
+
+	return seedPacket(tb, &w3gs.GameInfo{
+		GameVersion: w3gs.GameVersion{Product: w3gs.ProductTFT, Version: 26},
+		HostCounter: 1,
+		EntryKey:    0x1234abcd,
+		GameName:    "Pudge Wars",
+		GameSettings: w3gs.GameSettings{
+			GameSettingFlags: w3gs.SettingSpeedFast | w3gs.SettingTerrainDefault | w3gs.SettingObsNone,
+			MapWidth:         116,
+			MapHeight:        116,
+			MapXoro:          0xdeadbeef,
+			MapPath:          `Maps\Download\Pudge Wars.w3x`,
+			HostName:         "kradalby",
+		},
+		SlotsTotal:     10,
+		GameFlags:      w3gs.GameFlagCustomGame,
+		SlotsUsed:      1,
+		SlotsAvailable: 10,
+		UptimeSec:      42,
+		GamePort:       6112,
+	})
+}
+
+// seedJoin returns a Join packet as a WC3 client sends it.
+func seedJoin(tb testing.TB) []byte {
+	tb.Helper()
+
+	return seedPacket(tb, &w3gs.Join{
+		HostCounter:  1,
+		EntryKey:     0x1234abcd,
+		ListenPort:   6112,
+		JoinCounter:  1,
+		PlayerName:   "player",
+		InternalAddr: protocol.Addr(&net.TCPAddr{IP: net.IPv4(192, 168, 1, 10), Port: 6112}),
+	})
+}
+
+// seedGPSReconnect returns a GProxy++ reconnect frame.
+func seedGPSReconnect() []byte {
+	const size = HeaderSize + 9
+
+	frame := []byte{GPSSig, 2, size, 0, 1}
+	frame = binary.LittleEndian.AppendUint32(frame, 0xcafef00d)
+
+	return binary.LittleEndian.AppendUint32(frame, 17)
+}
+
+// seedPacket encodes pkt.
+func seedPacket(tb testing.TB, pkt w3gs.Packet) []byte {
+	tb.Helper()
+
+	data, err := w3gs.Serialize(pkt, w3gs.Encoding{})
+	if err != nil {
+		tb.Fatalf("encoding seed %T: %v", pkt, err)
+	}
+
+	return data
+}
+
+// addSeeds adds the real packets, and each cut short and with a bad
+// length, to the corpus of f.
+func addSeeds(f *testing.F) {
+	f.Helper()
+
+	seeds := [][]byte{
+		seedGameInfo(f),
+		seedJoin(f),
+		seedGPSReconnect(),
+		seedPacket(f, &w3gs.SearchGame{GameVersion: w3gs.GameVersion{Product: w3gs.ProductTFT, Version: 26}}),
+	}
+
+	for _, seed := range seeds {
+		f.Add(seed)
+		f.Add(seed[:len(seed)/2])
+
+		bad := bytes.Clone(seed)
+		bad[2]++
+		f.Add(bad)
+	}
+}
+
+// checkRoundTrip checks that pkt, decoded from data, encodes again within
+// the limits and decodes to the same packet.
+func checkRoundTrip(t *testing.T, data []byte, pkt w3gs.Packet) {
+	t.Helper()
+
+	out, err := Serialize(pkt)
+	if err != nil {
+		t.Fatalf("decoded %T does not encode: %v", pkt, err)
+	}
+
+	if len(data) <= MaxSize && len(out) > MaxSize {
+		t.Fatalf("%T of %d bytes encodes to %d, over MaxSize", pkt, len(data), len(out))
+	}
+
+	again, err := Parse(out)
+	if err != nil {
+		t.Fatalf("encoded %T does not decode: %v", pkt, err)
+	}
+
+	out2, err := Serialize(again)
+	if err != nil {
+		t.Fatalf("re-decoded %T does not encode: %v", pkt, err)
+	}
+
+	if !bytes.Equal(out, out2) {
+		t.Fatalf("%T does not round-trip:\n%x\n%x", pkt, out, out2)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pkt, err := Parse(data)
+		if err != nil {
+			return
+		}
+
+		checkRoundTrip(t, data, pkt)
+	})
+}
+
+func FuzzParseGameInfo(f *testing.F) {
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		info, err := ParseGameInfo(data)
+		if err != nil {
+			return
+		}
+
+		checkRoundTrip(t, data, info)
+
+		out, err := Serialize(info)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = ParseGameInfo(out)
+		if err != nil {
+			t.Fatalf("encoded GameInfo is rejected: %v", err)
+		}
+	})
+}
+
+func FuzzParseJoin(f *testing.F) {
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		join, err := ParseJoin(data)
+		if err != nil {
+			return
+		}
+
+		checkRoundTrip(t, data, join)
+
+		out, err := Serialize(join)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = ParseJoin(out)
+		if err != nil {
+			t.Fatalf("encoded Join is rejected: %v", err)
+		}
+	})
+}
+
+func FuzzReadStream(f *testing.F) {
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frame, err := ReadStream(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		switch {
+		case len(frame) != Length(frame) || len(frame) > MaxStreamSize:
+			t.Fatalf("frame of %d bytes has length %d", len(frame), Length(frame))
+		case !bytes.Equal(frame, data[:len(frame)]):
+			t.Fatalf("frame differs from the stream")
+		case frame[0] != w3gs.ProtocolSig && frame[0] != GPSSig:
+			t.Fatalf("frame has signature %#x", frame[0])
+		}
+
+		if frame[0] != w3gs.ProtocolSig {
+			return
+		}
+
+		pkt, err := Parse(frame)
+		if err != nil {
+			return
+		}
+
+		checkRoundTrip(t, frame, pkt)
+	})
+}
@@ -0,0 +1,220 @@
+// Package paths provides the platform-specific directories wc3ts keeps its
+// files in.
+//
+// Linux and other Unix systems follow the XDG base directory specification,
+// macOS uses ~/Library and Windows uses %APPDATA% and %LOCALAPPDATA%.
+package paths
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// appName is the directory name used under each base directory.
+const appName = "wc3ts"
+
+// dirPerm is the permission used when creating directories.
+const dirPerm = 0o755
+
+// VersionsFile is the name of the optional version table override in the
+// config directory.
+const VersionsFile = "versions.json"
+
+// ConfigFile is the name of the optional flag file in the config directory,
+// re-read by running instances when it changes.
+const ConfigFile = "wc3ts.conf"
+
+// LatestReleaseFile caches the result of the last background update check
+// in the state directory.
+const LatestReleaseFile = "latest-release.json"
+
+// SocketFile is the name of the daemon's IPC socket in the state directory.
+const SocketFile = "wc3ts.sock"
+
+// UIFile holds TUI preferences, such as the last selected game version,
+// in the state directory.
+const UIFile = "ui.json"
+
+var (
+	stateOverride   string
+	stateOverrideMu sync.RWMutex
+)
+
+// SetStateDir overrides the state directory. State, logs, history and
+// crash reports are all kept below dir. An empty dir restores the
+// platform default. Call it before Get is used, typically right after
+// parsing flags.
+func SetStateDir(dir string) {
+	stateOverrideMu.Lock()
+	defer stateOverrideMu.Unlock()
+
+	stateOverride = dir
+}
+
+// Paths holds the directories wc3ts uses.
+type Paths struct {
+	// Config holds user-edited configuration, such as versions.json.
+	Config string
+
+	// State holds data that persists between runs but is not configuration.
+	State string
+
+	// Cache holds data that can be regenerated and safely deleted.
+	Cache string
+
+	// Logs holds log files.
+	Logs string
+
+	// Captures holds packet captures.
+	Captures string
+
+	// History holds recorded probe, session and game history.
+	History string
+
+	// Crashes holds crash reports.
+	Crashes string
+}
+
+// Get returns the directories for the current platform and user.
+func Get() (Paths, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return Paths{}, fmt.Errorf("find home directory: %w", err)
+	}
+
+	var p Paths
+
+	switch runtime.GOOS {
+	case "windows":
+		p = windowsPaths(home)
+	case "darwin":
+		p = darwinPaths(home)
+	default:
+		p = xdgPaths(home)
+	}
+
+	stateOverrideMu.RLock()
+	dir := stateOverride
+	stateOverrideMu.RUnlock()
+
+	if dir != "" {
+		p.State = dir
+		p.Logs = filepath.Join(dir, "logs")
+		p.History = filepath.Join(dir, "history")
+	}
+
+	p.Crashes = filepath.Join(p.State, "crashes")
+
+	return p, nil
+}
+
+// xdgPaths returns the XDG base directory layout.
+func xdgPaths(home string) Paths {
+	state := filepath.Join(envOr("XDG_STATE_HOME", filepath.Join(home, ".local", "state")), appName)
+	data := filepath.Join(envOr("XDG_DATA_HOME", filepath.Join(home, ".local", "share")), appName)
+
+	return Paths{
+		Config:   filepath.Join(envOr("XDG_CONFIG_HOME", filepath.Join(home, ".config")), appName),
+		State:    state,
+		Cache:    filepath.Join(envOr("XDG_CACHE_HOME", filepath.Join(home, ".cache")), appName),
+		Logs:     filepath.Join(state, "logs"),
+		Captures: filepath.Join(data, "captures"),
+		History:  filepath.Join(data, "history"),
+	}
+}
+
+// darwinPaths returns the macOS layout under ~/Library.
+func darwinPaths(home string) Paths {
+	support := filepath.Join(home, "Library", "Application Support", appName)
+
+	return Paths{
+		Config:   support,
+		State:    filepath.Join(support, "state"),
+		Cache:    filepath.Join(home, "Library", "Caches", appName),
+		Logs:     filepath.Join(home, "Library", "Logs", appName),
+		Captures: filepath.Join(support, "captures"),
+		History:  filepath.Join(support, "history"),
+	}
+}
+
+// windowsPaths returns the Windows layout: roaming configuration in
+// %APPDATA%, everything else in %LOCALAPPDATA%.
+func windowsPaths(home string) Paths {
+	local := filepath.Join(envOr("LOCALAPPDATA", filepath.Join(home, "AppData", "Local")), appName)
+
+	return Paths{
+		Config:   filepath.Join(envOr("APPDATA", filepath.Join(home, "AppData", "Roaming")), appName),
+		State:    filepath.Join(local, "state"),
+		Cache:    filepath.Join(local, "cache"),
+		Logs:     filepath.Join(local, "logs"),
+		Captures: filepath.Join(local, "captures"),
+		History:  filepath.Join(local, "history"),
+	}
+}
+
+// envOr returns the environment variable key, or fallback if it is unset.
+// Relative values are ignored, as the XDG specification requires.
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); filepath.IsAbs(v) {
+		return v
+	}
+
+	return fallback
+}
+
+// Migrate moves files from the legacy ~/.wc3ts directory into their
+// platform locations. Entries that already exist at the destination are
+// left in place. It returns the destinations of the moved entries.
+func (p Paths) Migrate() ([]string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil //nolint:nilerr // Without a home there is nothing to migrate
+	}
+
+	legacy := filepath.Join(home, "."+appName)
+
+	moves := map[string]string{
+		VersionsFile: filepath.Join(p.Config, VersionsFile),
+		"history":    p.History,
+		"captures":   p.Captures,
+		"logs":       p.Logs,
+	}
+
+	var (
+		moved []string
+		errs  []error
+	)
+
+	for name, dst := range moves {
+		src := filepath.Join(legacy, name)
+
+		_, err := os.Stat(src)
+		if err != nil {
+			continue
+		}
+
+		_, err = os.Stat(dst)
+		if err == nil {
+			continue
+		}
+
+		err = os.MkdirAll(filepath.Dir(dst), dirPerm)
+		if err == nil {
+			err = os.Rename(src, dst)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("migrate %s: %w", src, err))
+
+			continue
+		}
+
+		moved = append(moved, dst)
+	}
+
+	return moved, errors.Join(errs...)
+}
@@ -0,0 +1,155 @@
+package peer
+
+import (
+	"log/slog"
+	"net/netip"
+	"time"
+
+	"github.com/kradalby/wc3ts/tailscale"
+)
+
+// Peers that leave backoffAfter probes in a row unanswered are probed
+// less often: each further silent probe doubles the wait, up to the limit
+// set with SetProbeBackoff. Most tailnet nodes never run wc3ts, and
+// probing them every interval is wasted traffic on big tailnets.
+const (
+	backoffAfter    = 3
+	maxBackoffShift = 16
+)
+
+// probeBackoff tracks the unanswered probes of a peer.
+type probeBackoff struct {
+	silent int       // probes left unanswered in a row
+	next   time.Time // when the peer is due its next probe
+}
+
+// SetProbeBackoff sets the longest interval silent peers are probed at.
+// Zero disables backing off.
+func (m *Manager) SetProbeBackoff(limit time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.backoffLimit = limit
+
+	if limit <= 0 {
+		clear(m.backoff)
+	}
+}
+
+// probeTargets returns the peers due a probe: online, not muted and not
+// backed off. They are marked as probed now, so the first answer yields
+// a round trip time and a missing one counts against them.
+func (m *Manager) probeTargets(peers []tailscale.Peer) []netip.Addr {
+	var targets []netip.Addr
+
+	for i := range peers {
+		if peers[i].Online && !m.IsMuted(peers[i].IP) {
+			targets = append(targets, peers[i].IP)
+		}
+	}
+
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	due := targets[:0]
+
+	for _, ip := range targets {
+		if now.Before(m.backoff[ip].next) {
+			continue
+		}
+
+		m.noteProbe(ip, now)
+		m.probeSent[ip] = now
+
+		due = append(due, ip)
+	}
+
+	return due
+}
+
+// noteProbe records a probe of ip at now, backing off when the probe
+// before it went unanswered too.
+// Must be called with the write lock held.
+func (m *Manager) noteProbe(ip netip.Addr, now time.Time) {
+	b := m.backoff[ip]
+
+	// An answer clears the probe from probeSent
+	if _, unanswered := m.probeSent[ip]; unanswered {
+		b.silent++
+	} else {
+		if b.silent >= backoffAfter {
+			slog.Debug("peer answered, probing it every interval again", "peer", ip)
+		}
+
+		b.silent = 0
+	}
+
+	b.next = time.Time{}
+
+	if m.backoffLimit > 0 && b.silent >= backoffAfter {
+		wait := m.probeInterval << min(b.silent-backoffAfter+1, maxBackoffShift)
+		b.next = now.Add(min(wait, m.backoffLimit))
+
+		if b.silent == backoffAfter {
+			slog.Debug("peer does not answer, probing it less often", "peer", ip)
+		}
+	}
+
+	// A peer that stopped answering may host another version next
+	if b.silent >= backoffAfter {
+		delete(m.peerVersion, ip)
+	}
+
+	if b.silent == 0 {
+		delete(m.backoff, ip)
+	} else {
+		m.backoff[ip] = b
+	}
+}
+
+// resetBackoff probes every peer each interval and with every version
+// again.
+func (m *Manager) resetBackoff() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clear(m.backoff)
+	clear(m.peerVersion)
+}
+
+// resetChangedBackoff forgets the silence and game version of peers that
+// are new in peers or changed state since prev, and of peers that are
+// gone, so a peer that comes back online is probed right away.
+// Must be called with the write lock held.
+func (m *Manager) resetChangedBackoff(prev, peers []tailscale.Peer) {
+	online := make(map[netip.Addr]bool, len(prev))
+
+	for i := range prev {
+		online[prev[i].IP] = prev[i].Online
+	}
+
+	current := make(map[netip.Addr]bool, len(peers))
+
+	for i := range peers {
+		current[peers[i].IP] = true
+
+		if was, ok := online[peers[i].IP]; !ok || was != peers[i].Online {
+			delete(m.backoff, peers[i].IP)
+			delete(m.peerVersion, peers[i].IP)
+		}
+	}
+
+	for ip := range m.backoff {
+		if !current[ip] {
+			delete(m.backoff, ip)
+		}
+	}
+
+	for ip := range m.peerVersion {
+		if !current[ip] {
+			delete(m.peerVersion, ip)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package peer
+
+import (
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+
+	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/lan"
+	"github.com/kradalby/wc3ts/packet"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// SetLANBridge makes games hosted by other machines on the LAN subnets
+// prefixes discoverable by Tailscale peers. The subnets are searched by
+// broadcast along with the peers, replies from self, this machine's own
+// LAN addresses, count as local games, and replies from other machines on
+// the subnets are registered as LAN games, which the responder offers to
+// peers through the guard. Nil prefixes disable the bridge.
+func (m *Manager) SetLANBridge(prefixes []netip.Prefix, self []netip.Addr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.bridged = prefixes
+	m.bridgeSelf = self
+}
+
+// SetBridgePeers sets the LAN addresses of Tailscale peers. Their games
+// already reach peers over Tailscale, so they are never bridged: a game
+// bridged from them would come back to its own host as a remote game.
+func (m *Manager) SetBridgePeers(addrs []netip.Addr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.bridgePeers = addrs
+}
+
+// isSelf reports whether ip is one of this machine's LAN addresses on a
+// bridged subnet.
+func (m *Manager) isSelf(ip netip.Addr) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return slices.Contains(m.bridgeSelf, ip)
+}
+
+// isBridgePeer reports whether ip is the LAN address of a Tailscale peer.
+func (m *Manager) isBridgePeer(ip netip.Addr) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return slices.Contains(m.bridgePeers, ip)
+}
+
+// isBridgedHost reports whether ip is another machine on a bridged subnet.
+func (m *Manager) isBridgedHost(ip netip.Addr) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if slices.Contains(m.bridgeSelf, ip) {
+		return false
+	}
+
+	for _, prefix := range m.bridged {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// probeBridged broadcasts SearchGame with every version in versions to
+// each bridged subnet. Like static hosts, the subnets are usually not
+// reachable from the Tailscale IP, so the unbound socket is used when
+// there is one.
+func (m *Manager) probeBridged(versions []w3gs.GameVersion) {
+	m.mu.RLock()
+	prefixes := m.bridged
+	m.mu.RUnlock()
+
+	conn := &m.W3GSPacketConn
+	if m.local != nil {
+		conn = m.local
+	}
+
+	for _, prefix := range prefixes {
+		addr := &net.UDPAddr{IP: lan.SubnetBroadcast(prefix).AsSlice(), Port: m.probePort()}
+
+		for _, v := range versions {
+			_, err := conn.Send(addr, &w3gs.SearchGame{GameVersion: v})
+			if err != nil {
+				slog.Debug("failed to search bridged LAN", "addr", addr, "error", err)
+
+				break
+			}
+		}
+	}
+}
+
+// bridgedResponse returns the GameInfo offering the LAN game g to peers:
+// joined at the guard on guardPort and numbered with its LocalCounter,
+// which the guard maps back to the host. LAN games cannot be offered
+// without the guard.
+func bridgedResponse(g *game.Game, guardPort uint16) ([]byte, bool) {
+	if guardPort == 0 {
+		return nil, false
+	}
+
+	info, err := packet.ParseGameInfo(g.RawData)
+	if err != nil {
+		slog.Debug("skipping LAN game with invalid raw data", "game", g.Info.GameName, "error", err)
+
+		return nil, false
+	}
+
+	info.GamePort = guardPort
+	info.HostCounter = g.LocalCounter
+
+	data, err := packet.Serialize(info)
+	if err != nil {
+		slog.Debug("failed to encode LAN game", "game", g.Info.GameName, "error", err)
+
+		return nil, false
+	}
+
+	return data, true
+}
@@ -0,0 +1,130 @@
+package peer
+
+import (
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// DirectSearchCounter is sent as the SearchGame HostCounter by managers that
+// want a peer's games delivered straight to their WC3 client ("wc3t").
+//
+// WC3 joins a LAN game at the source address of the GameInfo datagram, so a
+// local rebroadcast can only ever point at the local proxy. To bypass it, the
+// host's responder sends its GameInfo to the requester's Tailscale IP on the
+// LAN port, where the requester's WC3 client picks it up with the host as
+// source and connects to the host directly.
+const DirectSearchCounter = 0x77633374
+
+// Reachability check tuning.
+const (
+	// reachTimeout bounds the TCP dial to a host's game port.
+	reachTimeout = time.Second
+
+	// reachInterval is how often a peer's game port is rechecked.
+	reachInterval = 30 * time.Second
+)
+
+// reachability records the last game port check for a peer.
+type reachability struct {
+	ok      bool
+	pending bool
+	checked time.Time
+}
+
+// SetDirect enables or disables direct-connect for reachable peers.
+// When disabled, all remote games are proxied.
+func (m *Manager) SetDirect(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.direct = enabled
+}
+
+// isDirect reports whether games from peerIP are delivered directly.
+func (m *Manager) isDirect(peerIP netip.Addr) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	r, ok := m.reach[peerIP]
+
+	return m.direct && ok && r.ok
+}
+
+// checkReachable dials the host's game port in the background if the last
+// check for peerIP is outdated. Peers that fail the check fall back to the proxy.
+// Peers reached over IPv6 always use the proxy, as WC3 only speaks IPv4.
+func (m *Manager) checkReachable(peerIP netip.Addr, port uint16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.direct || !peerIP.Is4() {
+		return
+	}
+
+	r := m.reach[peerIP]
+	if r.pending || time.Since(r.checked) < reachInterval {
+		return
+	}
+
+	r.pending = true
+	m.reach[peerIP] = r
+
+	go func() {
+		addr := net.JoinHostPort(peerIP.String(), strconv.Itoa(int(port)))
+
+		conn, err := net.DialTimeout("tcp4", addr, reachTimeout)
+		if err == nil {
+			_ = conn.Close()
+		}
+
+		m.mu.Lock()
+		m.reach[peerIP] = reachability{ok: err == nil, checked: time.Now()}
+		m.mu.Unlock()
+
+		if err != nil {
+			slog.Debug("host not directly reachable, using proxy", "peer", peerIP, "error", err)
+		} else {
+			slog.Debug("host directly reachable", "peer", peerIP)
+		}
+	}()
+}
+
+// SetLANPeers sets the peers that share a physical LAN with us. Their WC3
+// hosts answer our client's LAN searches themselves, so their games are
+// not rebroadcast through the proxy, which would only add a relay hop and
+// list each game twice.
+func (m *Manager) SetLANPeers(ips []netip.Addr) {
+	lanPeers := make(map[netip.Addr]bool, len(ips))
+
+	for _, ip := range ips {
+		lanPeers[ip] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for ip := range lanPeers {
+		if !m.lanPeers[ip] {
+			slog.Info("peer is on the local LAN, bypassing the proxy for its games", "peer", ip)
+		}
+	}
+
+	for ip := range m.lanPeers {
+		if !lanPeers[ip] {
+			slog.Info("peer left the local LAN, proxying its games", "peer", ip)
+		}
+	}
+
+	m.lanPeers = lanPeers
+}
+
+// isOnLAN reports whether peerIP shares a physical LAN with us.
+func (m *Manager) isOnLAN(peerIP netip.Addr) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lanPeers[peerIP]
+}
@@ -0,0 +1,56 @@
+package peer
+
+import (
+	"log/slog"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/kradalby/wc3ts/packet"
+)
+
+// helloTTL is how long a peer's hello is trusted without a new one. Peers
+// send it with every answer to a probe, so it lapses soon after their
+// guard stops accepting compression.
+const helloTTL = 2 * time.Minute
+
+// noteHello records the hello a peer's responder sent from addr.
+func (m *Manager) noteHello(addr net.Addr, flags byte) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return
+	}
+
+	ip, ok := netip.AddrFromSlice(udpAddr.IP)
+	if !ok {
+		return
+	}
+
+	ip = ip.Unmap()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if flags&packet.HelloFlate == 0 {
+		delete(m.guards, ip)
+
+		return
+	}
+
+	if _, ok := m.guards[ip]; !ok {
+		slog.Debug("peer guard accepts compressed sessions", "peer", ip)
+	}
+
+	m.guards[ip] = time.Now()
+}
+
+// Compresses reports whether the peer at ip recently announced a guard
+// that accepts compressed sessions.
+func (m *Manager) Compresses(ip netip.Addr) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	last, ok := m.guards[ip.Unmap()]
+
+	return ok && time.Since(last) < helloTTL
+}
@@ -0,0 +1,74 @@
+package peer
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/kradalby/wc3ts/lan"
+)
+
+// idleProbeInterval is how often peers are probed while idle.
+const idleProbeInterval = time.Minute
+
+// SetIdleTimeout sets how long without local activity before peer probing
+// slows down to idleProbeInterval. Zero disables idle slowdown.
+func (m *Manager) SetIdleTimeout(timeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.idleTimeout = timeout
+	m.lastActive = time.Now()
+}
+
+// Touch records local activity, such as TUI input or a local game. If
+// probing had slowed down, peers are probed again immediately.
+func (m *Manager) Touch() {
+	m.mu.Lock()
+	wasIdle := m.idle
+	m.idle = false
+	m.lastActive = time.Now()
+	m.mu.Unlock()
+
+	if wasIdle {
+		slog.Info("local activity detected, resuming peer probes")
+
+		go m.probeAllPeers()
+	}
+}
+
+// shouldProbe reports whether the periodic probe should run now. While
+// idle, probes run every idleProbeInterval and a running WC3 client
+// counts as activity.
+func (m *Manager) shouldProbe() bool {
+	m.mu.Lock()
+	timeout := m.idleTimeout
+	idleFor := time.Since(m.lastActive)
+	m.mu.Unlock()
+
+	if timeout <= 0 || idleFor < timeout {
+		return true
+	}
+
+	if lan.ClientRunning(m.probePort()) {
+		m.Touch()
+
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.idle {
+		m.idle = true
+
+		slog.Info("no local activity, slowing peer probes", "idleFor", idleFor.Round(time.Second))
+	}
+
+	if time.Since(m.lastIdleProbe) < idleProbeInterval {
+		return false
+	}
+
+	m.lastIdleProbe = time.Now()
+
+	return true
+}
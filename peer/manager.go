@@ -3,14 +3,23 @@ package peer
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"net"
 	"net/netip"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/kradalby/wc3ts/capture"
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/control"
 	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/history"
+	"github.com/kradalby/wc3ts/impair"
 	"github.com/kradalby/wc3ts/lan"
+	"github.com/kradalby/wc3ts/packet"
 	"github.com/kradalby/wc3ts/tailscale"
 	"github.com/nielsAD/gowarcraft3/network"
 	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
@@ -19,52 +28,213 @@ import (
 // DefaultProbeInterval is how often to probe peers for games.
 const DefaultProbeInterval = 5 * time.Second
 
-// udpBufferSize is the size of the UDP receive buffer.
-const udpBufferSize = 512
+// rttSmoothing is the weight of the smoothed round trip against a new
+// sample, as in TCP's SRTT.
+const rttSmoothing = 8
 
 // Manager probes Tailscale peers to discover remote WC3 games.
+// No probes are sent while paused.
 type Manager struct {
 	network.W3GSPacketConn
+	control.Switch
+
+	// local is used to probe localhost when the main socket is bound to
+	// the Tailscale IP. Nil when the main socket is unbound.
+	local *network.W3GSPacketConn
+
+	// ipv6 probes peers on their Tailscale IPv6 address when the main
+	// socket is IPv4. Nil without IPv6 support.
+	ipv6 *network.W3GSPacketConn
 
 	discovery     *tailscale.Discovery
 	registry      *game.Registry
 	version       w3gs.GameVersion
+	compatGroups  []config.CompatGroup
+	allVersions   bool
 	probeInterval time.Duration
+	port          uint16
+	fallbackPort  uint16
 	peers         []tailscale.Peer
+	direct        bool
+	reach         map[netip.Addr]reachability
+	reachCheck    bool
+	hosts         map[netip.AddrPort]reachability
+	lanPeers      map[netip.Addr]bool
+	history       *history.Recorder
+	probeSent     map[netip.Addr]time.Time
+	backoff       map[netip.Addr]probeBackoff
+	backoffLimit  time.Duration
+	peerVersion   map[netip.Addr]w3gs.GameVersion // versions peers' games were found with
+	onVersion     VersionFunc
+	rtt           map[netip.Addr]time.Duration // smoothed probe round trips
+	hostAddrs     []netip.Addr
+	bridged       []netip.Prefix
+	bridgeSelf    []netip.Addr
+	bridgePeers   []netip.Addr
+	staticHosts   []config.StaticHost
+	lanInstances  []config.StaticHost
+	subnets       []netip.Prefix
+	subnetHosts   map[netip.Addr]time.Time // subnet hosts by last answer
+	routedSubnets map[netip.Prefix]bool    // probed subnets by whether a peer routes them
+	lastSweep     time.Time
+	staticNames   map[netip.Addr]string
+	peerAddrs     []config.PeerAddr
+	muted         map[netip.Addr]bool
+	guards        map[netip.Addr]time.Time // peers with a compressing guard, by last hello
+	idleTimeout   time.Duration
+	gameTimeout   time.Duration
+	lastActive    time.Time
+	lastIdleProbe time.Time
+	idle          bool
 	mu            sync.RWMutex
 }
 
 // NewManager creates a new peer manager.
+// readBuffer is the SO_RCVBUF size to request; zero keeps the OS default.
+// If bindIP is valid, peer probes are sent from a socket bound to it so they
+// leave with the Tailscale source address on multi-homed machines; localhost
+// is then probed from a separate unbound socket.
+// Peers only reachable over IPv6 are probed from a second socket, bound to
+// bindIP6 if it is valid. If bindIP is itself IPv6, it serves all peers.
+// If imp is non-nil, peer probes are impaired; localhost probes are not.
+// If tap is non-nil, all probes and replies are captured.
 func NewManager(
 	discovery *tailscale.Discovery,
 	registry *game.Registry,
 	probeInterval time.Duration,
+	readBuffer int,
+	bindIP netip.Addr,
+	bindIP6 netip.Addr,
+	imp *impair.Impairer,
+	tap *capture.Recorder,
 ) (*Manager, error) {
-	conn, err := net.ListenUDP("udp4", nil) // Random port for sending
+	conn, err := listenProbe(bindIP)
 	if err != nil {
 		return nil, err
 	}
 
+	lan.SetReceiveBuffer(conn, "manager", readBuffer)
+
 	mgr := &Manager{
 		discovery:     discovery,
 		registry:      registry,
 		probeInterval: probeInterval,
+		port:          lan.DefaultPort,
 		peers:         make([]tailscale.Peer, 0),
+		reach:         make(map[netip.Addr]reachability),
+		hosts:         make(map[netip.AddrPort]reachability),
+		probeSent:     make(map[netip.Addr]time.Time),
+		backoff:       make(map[netip.Addr]probeBackoff),
+		peerVersion:   make(map[netip.Addr]w3gs.GameVersion),
+		rtt:           make(map[netip.Addr]time.Duration),
+		muted:         make(map[netip.Addr]bool),
+		guards:        make(map[netip.Addr]time.Time),
+		staticNames:   make(map[netip.Addr]string),
+		subnetHosts:   make(map[netip.Addr]time.Time),
+		routedSubnets: make(map[netip.Prefix]bool),
 	}
 
-	mgr.SetConn(conn, w3gs.NewFactoryCache(w3gs.DefaultFactory), w3gs.Encoding{})
+	mgr.SetConn(
+		imp.PacketConn(tap.PacketConn(conn, "manager")),
+		w3gs.NewFactoryCache(w3gs.DefaultFactory),
+		w3gs.Encoding{},
+	)
+
+	if bindIP.IsValid() {
+		localConn, err := net.ListenUDP("udp4", nil)
+		if err != nil {
+			_ = conn.Close()
+
+			return nil, err
+		}
+
+		mgr.local = &network.W3GSPacketConn{}
+		mgr.local.SetConn(tap.PacketConn(localConn, "manager"), w3gs.NewFactoryCache(w3gs.DefaultFactory), w3gs.Encoding{})
+
+		slog.Info("peer probes bound to address", "ip", bindIP)
+	}
+
+	if !bindIP.Is6() {
+		mgr.listenIPv6(bindIP6, readBuffer, imp, tap)
+	}
 
 	return mgr, nil
 }
 
+// listenProbe opens a probe socket on a random port, bound to bindIP if it
+// is valid and otherwise an unbound IPv4 socket.
+func listenProbe(bindIP netip.Addr) (*net.UDPConn, error) {
+	if !bindIP.IsValid() {
+		return net.ListenUDP("udp4", &net.UDPAddr{})
+	}
+
+	network := "udp4"
+	if bindIP.Is6() {
+		network = "udp6"
+	}
+
+	return net.ListenUDP(network, &net.UDPAddr{IP: bindIP.AsSlice()})
+}
+
+// listenIPv6 opens the socket probing IPv6 peers. Hosts without IPv6 only
+// probe over IPv4.
+func (m *Manager) listenIPv6(bindIP netip.Addr, readBuffer int, imp *impair.Impairer, tap *capture.Recorder) {
+	laddr := &net.UDPAddr{}
+	if bindIP.IsValid() {
+		laddr.IP = bindIP.AsSlice()
+	}
+
+	conn, err := net.ListenUDP("udp6", laddr)
+	if err != nil {
+		slog.Debug("IPv6 probing unavailable", "error", err)
+
+		return
+	}
+
+	lan.SetReceiveBuffer(conn, "manager", readBuffer)
+
+	m.ipv6 = &network.W3GSPacketConn{}
+	m.ipv6.SetConn(
+		imp.PacketConn(tap.PacketConn(conn, "manager")),
+		w3gs.NewFactoryCache(w3gs.DefaultFactory),
+		w3gs.Encoding{},
+	)
+}
+
+// Close closes the manager's sockets. Run closes them when its context is
+// cancelled.
+func (m *Manager) Close() error {
+	errs := []error{m.W3GSPacketConn.Close()}
+
+	if m.local != nil {
+		errs = append(errs, m.local.Close())
+	}
+
+	if m.ipv6 != nil {
+		errs = append(errs, m.ipv6.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
 // Run starts probing peers for games.
 // It blocks until the context is cancelled.
 func (m *Manager) Run(ctx context.Context) error {
 	// Start packet receiving in background (captures raw bytes)
-	go m.receiveLoop()
+	go m.receiveLoop(m.Conn())
+
+	if m.local != nil {
+		go m.receiveLoop(m.local.Conn())
+	}
+
+	if m.ipv6 != nil {
+		go m.receiveLoop(m.ipv6.Conn())
+	}
 
 	// Probe peers periodically
-	ticker := time.NewTicker(m.probeInterval)
+	interval := m.currentProbeInterval()
+
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
@@ -74,7 +244,16 @@ func (m *Manager) Run(ctx context.Context) error {
 
 			return ctx.Err()
 		case <-ticker.C:
-			m.probeAllPeers()
+			if m.shouldProbe() {
+				m.probeAllPeers()
+			}
+
+			m.expireGames()
+
+			if next := m.currentProbeInterval(); next != interval {
+				interval = next
+				ticker.Reset(interval)
+			}
 		}
 	}
 }
@@ -87,27 +266,205 @@ func (m *Manager) SetVersion(version w3gs.GameVersion) {
 	m.version = version
 }
 
-// Refresh triggers an immediate probe of all peers.
+// SetCompatGroups sets the version compatibility table. Peers are probed
+// with every version compatible with the configured one.
+func (m *Manager) SetCompatGroups(groups []config.CompatGroup) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.compatGroups = groups
+}
+
+// SetProbeInterval sets how often peers are probed. It may be changed
+// while running and takes effect after the next probe.
+func (m *Manager) SetProbeInterval(interval time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.probeInterval = interval
+}
+
+// currentProbeInterval returns how often peers are probed.
+func (m *Manager) currentProbeInterval() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.probeInterval
+}
+
+// SetGameTimeout sets how long a game may go unannounced before it expires.
+// Zero disables expiry.
+func (m *Manager) SetGameTimeout(timeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.gameTimeout = timeout
+}
+
+// expireGames removes games that stopped being announced. Games are not
+// refreshed while probing is paused, and less often while idle, so the
+// timeout is suspended or extended accordingly.
+func (m *Manager) expireGames() {
+	m.mu.RLock()
+	timeout := m.gameTimeout
+	idle := m.idle
+	m.mu.RUnlock()
+
+	if timeout <= 0 || m.Paused() {
+		return
+	}
+
+	if idle {
+		timeout += idleProbeInterval
+	}
+
+	if n := m.registry.Expire(timeout); n > 0 {
+		slog.Debug("expired games", "count", n)
+	}
+}
+
+// SetPort sets the UDP port peers and localhost are probed on.
+// It defaults to the standard WC3 LAN port.
+func (m *Manager) SetPort(port uint16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.port = port
+}
+
+// SetFallbackPort sets a second UDP port peers are probed on, which the
+// responder of a peer listens on when another program holds its LAN port.
+// Zero disables it.
+func (m *Manager) SetFallbackPort(port uint16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.fallbackPort = port
+}
+
+// SetHistory sets the recorder for probe round trips and discovered games.
+func (m *Manager) SetHistory(rec *history.Recorder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.history = rec
+}
+
+// probePort returns the UDP port to probe.
+func (m *Manager) probePort() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return int(m.port)
+}
+
+// peerPorts returns the UDP ports to probe peers on.
+func (m *Manager) peerPorts() []int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.fallbackPort == 0 || m.fallbackPort == m.port {
+		return []int{int(m.port)}
+	}
+
+	return []int{int(m.port), int(m.fallbackPort)}
+}
+
+// SetAllVersions enables probing for every supported version, not just
+// those compatible with the configured one.
+func (m *Manager) SetAllVersions(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.allVersions = enabled
+}
+
+// probeVersions returns the versions to probe for: every supported one
+// while the game version is still to be detected.
+// Must be called with m.mu held.
+func (m *Manager) probeVersions() []w3gs.GameVersion {
+	if m.version.Version == 0 {
+		return config.AllGameVersions()
+	}
+
+	versions := config.CompatibleVersions(m.compatGroups, m.version)
+	if !m.allVersions {
+		return versions
+	}
+
+	for _, v := range config.SupportedVersions() {
+		other := w3gs.GameVersion{Product: m.version.Product, Version: v}
+
+		for _, compat := range config.CompatibleVersions(m.compatGroups, other) {
+			if !slices.Contains(versions, compat) {
+				versions = append(versions, compat)
+			}
+		}
+	}
+
+	return versions
+}
+
+// Version returns the game version probed for. Its Version is zero while
+// it is still to be detected.
+func (m *Manager) Version() w3gs.GameVersion {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.version
+}
+
+// Refresh triggers an immediate probe of all peers, including those
+// probed less often for not answering.
 func (m *Manager) Refresh() {
+	m.resetBackoff()
 	m.probeAllPeers()
 }
 
 // OnPeersChanged handles peer list updates from Tailscale discovery.
 func (m *Manager) OnPeersChanged(peers []tailscale.Peer) {
 	m.mu.Lock()
+	m.resetChangedBackoff(m.peers, peers)
 	m.peers = peers
 	m.mu.Unlock()
 
+	// Routes may have moved with the peers
+	m.checkSubnetRoutes()
+
 	// Probe new peers immediately
 	m.probeAllPeers()
 }
 
-// receiveLoop reads raw UDP packets and processes them.
-func (m *Manager) receiveLoop() {
-	buf := make([]byte, udpBufferSize)
+// OnPeersPinged updates the peer list with the round trip times of the
+// latest Tailscale pings, without probing.
+func (m *Manager) OnPeersPinged(peers []tailscale.Peer) {
+	m.mu.Lock()
+	m.resetChangedBackoff(m.peers, peers)
+	m.peers = peers
+	m.mu.Unlock()
+}
+
+// pingRTT returns the Tailscale ping round trip time of the peer at ip,
+// zero if unknown.
+func (m *Manager) pingRTT(ip netip.Addr) time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for i := range m.peers {
+		if m.peers[i].IP == ip || m.peers[i].IP6 == ip {
+			return m.peers[i].RTT
+		}
+	}
+
+	return 0
+}
+
+// receiveLoop reads raw UDP packets from conn and processes them.
+func (m *Manager) receiveLoop(conn net.PacketConn) {
+	buf := make([]byte, packet.MaxDatagramSize)
 
 	for {
-		n, addr, err := m.Conn().ReadFrom(buf)
+		n, addr, err := conn.ReadFrom(buf)
 		if err != nil {
 			return
 		}
@@ -116,15 +473,37 @@ func (m *Manager) receiveLoop() {
 		rawData := make([]byte, n)
 		copy(rawData, buf[:n])
 
-		// Deserialize using gowarcraft3 for display/debug purposes
-		pkt, _, err := w3gs.Deserialize(rawData, w3gs.Encoding{})
-		if err != nil {
+		// Responders of peers whose guard accepts compression follow
+		// their games with a hello
+		if packet.IsHello(rawData) {
+			m.noteHello(addr, packet.HelloFlags(rawData))
+
 			continue
 		}
 
 		// Only handle GameInfo packets
-		info, ok := pkt.(*w3gs.GameInfo)
-		if !ok {
+		if packet.ID(rawData) != packet.IDGameInfo {
+			continue
+		}
+
+		info, err := packet.ParseGameInfo(rawData)
+		if err != nil {
+			slog.Debug("dropping malformed GameInfo",
+				"from", addr,
+				"size", n,
+				"error", err,
+			)
+
+			continue
+		}
+
+		if v, ok := config.Versions().Version(info.Version); ok && v.MaxSlots > 0 && info.SlotsTotal > v.MaxSlots {
+			slog.Debug("dropping GameInfo with too many slots for its version",
+				"from", addr,
+				"version", config.FormatVersion(info.Version),
+				"slots", info.SlotsTotal,
+			)
+
 			continue
 		}
 
@@ -137,62 +516,90 @@ func (m *Manager) probeAllPeers() {
 	m.mu.RLock()
 	peers := make([]tailscale.Peer, len(m.peers))
 	copy(peers, m.peers)
-	version := m.version
+	versions := m.probeVersions()
 	m.mu.RUnlock()
 
-	// Skip if version not yet detected
-	if version.Version == 0 {
+	if m.Paused() {
 		return
 	}
 
+	targets := m.probeTargets(peers)
+
 	// Probe localhost for local games
-	m.probeLocal(version)
+	for _, v := range versions {
+		m.probeLocal(v)
+	}
 
 	// Probe remote Tailscale peers
-	for i := range peers {
-		peer := &peers[i]
-		if peer.Online {
-			m.probePeer(peer.IP, version)
+	for _, ip := range targets {
+		for _, v := range m.versionsFor(ip, versions) {
+			m.probePeer(ip, v)
 		}
 	}
+
+	m.probeStaticHosts(versions)
+	m.probeSubnets(versions)
+	m.probeBridged(versions)
 }
 
 // probeLocal sends a SearchGame packet to localhost to discover local games.
 func (m *Manager) probeLocal(version w3gs.GameVersion) {
 	addr := &net.UDPAddr{
 		IP:   net.ParseIP("127.0.0.1"),
-		Port: lan.DefaultPort,
+		Port: m.probePort(),
 	}
 
+	m.probeLocalAddr(addr, version)
+	m.probeHostAddrs(version)
+}
+
+// probeLocalAddr sends a SearchGame packet for local games to addr.
+func (m *Manager) probeLocalAddr(addr *net.UDPAddr, version w3gs.GameVersion) {
 	pkt := &w3gs.SearchGame{
 		GameVersion: version,
 		HostCounter: 0,
 	}
 
-	_, err := m.Send(addr, pkt)
+	conn := &m.W3GSPacketConn
+	if m.local != nil {
+		conn = m.local
+	}
+
+	_, err := conn.Send(addr, pkt)
 	if err != nil {
-		slog.Debug("failed to probe localhost", "error", err)
+		slog.Debug("failed to probe local client", "addr", addr, "error", err)
 	}
 }
 
 // probePeer sends a SearchGame packet to a specific peer.
 func (m *Manager) probePeer(peerIP netip.Addr, version w3gs.GameVersion) {
-	addr := &net.UDPAddr{
-		IP:   peerIP.AsSlice(),
-		Port: lan.DefaultPort,
-	}
-
 	pkt := &w3gs.SearchGame{
 		GameVersion: version,
 		HostCounter: 0,
 	}
 
-	_, err := m.Send(addr, pkt)
-	if err != nil {
-		slog.Debug("failed to probe peer",
-			"peer", peerIP,
-			"error", err,
-		)
+	// Ask the host to announce its games straight to our WC3 client,
+	// unless the client already finds them on the shared LAN
+	if m.isDirect(peerIP) && !m.isOnLAN(peerIP) {
+		pkt.HostCounter = DirectSearchCounter
+	}
+
+	conn := &m.W3GSPacketConn
+	if peerIP.Is6() && m.ipv6 != nil {
+		conn = m.ipv6
+	}
+
+	for _, port := range m.peerPorts() {
+		addr := &net.UDPAddr{IP: peerIP.AsSlice(), Port: port}
+
+		_, err := conn.Send(addr, pkt)
+		if err != nil {
+			slog.Debug("failed to probe peer",
+				"peer", peerIP,
+				"port", port,
+				"error", err,
+			)
+		}
 	}
 }
 
@@ -208,17 +615,61 @@ func (m *Manager) handleGameInfo(pkt *w3gs.GameInfo, rawData []byte, addr net.Ad
 		return
 	}
 
+	peerIP = peerIP.Unmap()
+
 	// Determine if this is a local or remote game
 	var source game.Source
 
 	var peerName string
 
-	if peerIP.IsLoopback() {
+	var direct bool
+
+	// Known peers are always remote, even when reached over loopback
+	peerName = m.findPeerName(peerIP)
+
+	// Games announced by a wc3ts are only taken from the peer announcing
+	// them. Taken as local or LAN games they would be exported again, and
+	// two instances relaying for each other would pass them around forever
+	if peerName == "" && game.Injected(pkt.HostCounter) {
+		slog.Debug("ignoring game relayed by another wc3ts", "name", pkt.GameName, "from", peerIP)
+
+		return
+	}
+
+	switch {
+	case (peerIP.IsLoopback() || m.isHostAddr(peerIP) || m.isSelf(peerIP)) && peerName == "":
 		source = game.SourceLocal
 		peerName = "local"
-	} else {
+
+		// Hosting a game counts as local activity
+		m.Touch()
+		m.detectVersion(pkt.GameVersion)
+	case peerName == "" && m.isBridgePeer(peerIP):
+		// The peer's own games arrive over Tailscale
+		return
+	case peerName == "" && m.isBridgedHost(peerIP):
+		source = game.SourceLAN
+		peerName = peerIP.String()
+
+		if m.IsMuted(peerIP) {
+			return
+		}
+	default:
 		source = game.SourceRemote
-		peerName = m.findPeerName(peerIP)
+
+		if m.IsMuted(peerIP) {
+			return
+		}
+
+		m.learnPeerVersion(peerIP, pkt.GameVersion)
+		m.noteSubnetHost(peerIP)
+
+		// Direct delivery skips the version rewrite, so it is
+		// only used when the host runs exactly our version
+		if pkt.GameVersion == m.Version() {
+			m.checkReachable(peerIP, pkt.GamePort)
+			direct = m.isDirect(peerIP) || m.isOnLAN(peerIP)
+		}
 	}
 
 	// Always store raw data - needed for responder to send exact packets
@@ -233,26 +684,156 @@ func (m *Manager) handleGameInfo(pkt *w3gs.GameInfo, rawData []byte, addr net.Ad
 		"slots", pkt.SlotsUsed, "/", pkt.SlotsTotal,
 	)
 
-	m.registry.Add(game.Game{
+	var rtt time.Duration
+	if source == game.SourceRemote {
+		rtt = m.recordProbe(peerIP, peerName)
+
+		// The ping measures the path alone, without the probe's scheduling
+		if ping := m.pingRTT(peerIP); ping > 0 {
+			rtt = ping
+		}
+	}
+
+	var altIPs []netip.Addr
+
+	var unreachable bool
+
+	if source == game.SourceRemote {
+		altIPs = m.altIPs(peerIP, peerName)
+
+		if !direct {
+			unreachable = !m.checkHost(pkt.GamePort, append([]netip.Addr{peerIP}, altIPs...))
+		}
+	}
+
+	added := m.registry.Add(game.Game{
 		Info:     *pkt,
 		RawData:  gameRawData,
 		Source:   source,
 		PeerIP:   peerIP,
+		AltIPs:   altIPs,
+		RTT:      rtt,
 		PeerName: peerName,
+		Direct:   direct,
+
+		Unreachable: unreachable,
 	})
+
+	if added {
+		m.recorder().RecordGame(history.Game{
+			Time:    time.Now(),
+			Name:    pkt.GameName,
+			Host:    peerName,
+			PeerIP:  peerIP,
+			Source:  string(source),
+			Map:     pkt.GameSettings.MapPath,
+			Version: config.FormatVersion(pkt.GameVersion.Version),
+			Slots:   pkt.SlotsTotal,
+		})
+	}
+}
+
+// recorder returns the history recorder, which may be nil.
+func (m *Manager) recorder() *history.Recorder {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.history
 }
 
-// findPeerName looks up the hostname for a peer IP.
+// recordProbe records the round trip of the first answer from peerIP since
+// it was last probed, and returns the peer's smoothed round trip.
+func (m *Manager) recordProbe(peerIP netip.Addr, peerName string) time.Duration {
+	m.mu.Lock()
+	sent, ok := m.probeSent[peerIP]
+	delete(m.probeSent, peerIP)
+	rec := m.history
+
+	rtt := time.Since(sent)
+	if ok {
+		m.rtt[peerIP] = smoothRTT(m.rtt[peerIP], rtt)
+	}
+
+	srtt := m.rtt[peerIP]
+	m.mu.Unlock()
+
+	if ok {
+		rec.RecordProbe(history.Probe{
+			Time:   sent,
+			Peer:   peerName,
+			PeerIP: peerIP,
+			RTT:    rtt,
+		})
+	}
+
+	return srtt
+}
+
+// smoothRTT folds a new sample into the smoothed round trip srtt, like
+// TCP does, so one slow probe does not change the tag shown in WC3.
+func smoothRTT(srtt, sample time.Duration) time.Duration {
+	if srtt == 0 {
+		return sample
+	}
+
+	return srtt + (sample-srtt)/rttSmoothing
+}
+
+// SetPeerAddrs sets extra addresses to join peers' games at when their
+// Tailscale address cannot be reached. It applies to games found from then on.
+func (m *Manager) SetPeerAddrs(addrs []config.PeerAddr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.peerAddrs = addrs
+}
+
+// altIPs returns the addresses besides peerIP that the peer named
+// peerName may be reached at: its other Tailscale address, then the
+// configured ones.
+func (m *Manager) altIPs(peerIP netip.Addr, peerName string) []netip.Addr {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ips []netip.Addr
+
+	add := func(ip netip.Addr) {
+		if ip.IsValid() && ip != peerIP && !slices.Contains(ips, ip) {
+			ips = append(ips, ip)
+		}
+	}
+
+	for i := range m.peers {
+		if p := &m.peers[i]; p.IP == peerIP || p.IP6 == peerIP {
+			add(p.IP)
+			add(p.IP6)
+		}
+	}
+
+	for _, pa := range m.peerAddrs {
+		if strings.EqualFold(pa.Peer, peerName) || pa.Peer == peerIP.String() {
+			add(pa.Addr)
+		}
+	}
+
+	return ips
+}
+
+// findPeerName looks up the hostname for a peer or static host IP.
 func (m *Manager) findPeerName(ip netip.Addr) string {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	for i := range m.peers {
 		peer := &m.peers[i]
-		if peer.IP == ip {
+		if peer.IP == ip || peer.IP6 == ip {
 			return peer.Name
 		}
 	}
 
-	return ""
+	if name := m.staticHostName(ip); name != "" {
+		return name
+	}
+
+	return m.subnetHostName(ip)
 }
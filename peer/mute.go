@@ -0,0 +1,69 @@
+package peer
+
+import (
+	"log/slog"
+	"net/netip"
+	"slices"
+)
+
+// SetMuted mutes or unmutes a peer. Muted peers are not probed and their
+// games are removed and ignored.
+func (m *Manager) SetMuted(ip netip.Addr, muted bool) {
+	m.mu.Lock()
+	if muted {
+		m.muted[ip] = true
+	} else {
+		delete(m.muted, ip)
+	}
+	m.mu.Unlock()
+
+	if muted {
+		m.registry.RemovePeer(ip)
+	}
+
+	slog.Info("peer mute changed", "peer", ip, "muted", muted)
+}
+
+// IsMuted reports whether a peer is muted.
+func (m *Manager) IsMuted(ip netip.Addr) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.muted[ip]
+}
+
+// Muted returns the muted peers, sorted.
+func (m *Manager) Muted() []netip.Addr {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	muted := make([]netip.Addr, 0, len(m.muted))
+	for ip := range m.muted {
+		muted = append(muted, ip)
+	}
+
+	slices.SortFunc(muted, netip.Addr.Compare)
+
+	return muted
+}
+
+// ProbePeers probes the given peers immediately, regardless of idle state.
+func (m *Manager) ProbePeers(ips []netip.Addr) {
+	m.mu.RLock()
+	versions := m.probeVersions()
+	m.mu.RUnlock()
+
+	if m.Paused() {
+		return
+	}
+
+	for _, ip := range ips {
+		if m.IsMuted(ip) {
+			continue
+		}
+
+		for _, v := range versions {
+			m.probePeer(ip, v)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package peer
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// SetReachCheck sets whether the game ports of remote hosts are dialed
+// before their games are advertised. Games whose host cannot be connected
+// to are marked unreachable and withheld from the LAN.
+func (m *Manager) SetReachCheck(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reachCheck = enabled
+
+	if !enabled {
+		clear(m.hosts)
+	}
+}
+
+// checkHost reports whether the host serving a game on port at ips, in the
+// order the proxy tries them, was reachable at its last check. Hosts are
+// reachable until checked, so games are not held back; outdated checks are
+// repeated in the background.
+func (m *Manager) checkHost(port uint16, ips []netip.Addr) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.reachCheck {
+		return true
+	}
+
+	host := netip.AddrPortFrom(ips[0], port)
+
+	r := m.hosts[host]
+	if r.pending || time.Since(r.checked) < reachInterval {
+		return r.ok || r.checked.IsZero()
+	}
+
+	r.pending = true
+	m.hosts[host] = r
+
+	go func() {
+		err := dialAny(ips, port)
+
+		m.mu.Lock()
+		was := m.hosts[host]
+		m.hosts[host] = reachability{ok: err == nil, checked: time.Now()}
+		m.mu.Unlock()
+
+		switch {
+		case err != nil && (was.ok || was.checked.IsZero()):
+			slog.Info("host game port unreachable, not advertising its games", "host", host, "error", err)
+		case err == nil && !was.ok && !was.checked.IsZero():
+			slog.Info("host game port reachable again, advertising its games", "host", host)
+		}
+	}()
+
+	return r.ok || r.checked.IsZero()
+}
+
+// dialAny connects to port on each of ips in turn until one accepts.
+func dialAny(ips []netip.Addr, port uint16) error {
+	var errs []error
+
+	for _, ip := range ips {
+		addr := net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+
+		conn, err := net.DialTimeout("tcp", addr, reachTimeout)
+		if err == nil {
+			_ = conn.Close()
+
+			return nil
+		}
+
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
@@ -2,35 +2,82 @@ package peer
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net"
 	"net/netip"
+	"slices"
+	"sync/atomic"
 
+	"github.com/kradalby/wc3ts/capture"
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/control"
 	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/impair"
 	"github.com/kradalby/wc3ts/lan"
+	"github.com/kradalby/wc3ts/packet"
 	"github.com/nielsAD/gowarcraft3/network"
 	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
 )
 
 // Responder listens for SearchGame queries from remote Tailscale peers
-// and responds with local game information.
+// and responds with local game information. Queries are ignored while paused.
 type Responder struct {
-	network.EventEmitter
+	control.Switch
+
 	network.W3GSPacketConn
 
-	registry *game.Registry
-	localIP  netip.Addr
+	// ipv6 answers queries to our Tailscale IPv6 address when the main
+	// socket listens on the IPv4 one. Nil if there is none.
+	ipv6 net.PacketConn
+
+	// lan answers wc3ts instances on the local LAN found over mDNS, one
+	// socket per LAN address, and lanPrefixes are the subnets they are
+	// answered in. Empty unless ListenLAN was called.
+	lan         []net.PacketConn
+	lanPrefixes []netip.Prefix
+
+	registry   *game.Registry
+	localIP    netip.Addr
+	lanPort    int
+	port       int
+	guardPort  atomic.Uint32
+	guardPort6 atomic.Uint32
+	compress   atomic.Bool
 }
 
-// NewResponder creates a new responder that listens on the given Tailscale IP.
-func NewResponder(registry *game.Registry, localIP netip.Addr) (*Responder, error) {
-	// Listen on Tailscale IP, port 6112
-	addr := &net.UDPAddr{
-		IP:   localIP.AsSlice(),
-		Port: lan.DefaultPort,
-	}
+// ErrPortInUse is returned when another program, usually WC3 itself, holds
+// the LAN port on the Tailscale IP and no fallback port could be used.
+var ErrPortInUse = errors.New("LAN port in use on the Tailscale IP")
 
-	conn, err := net.ListenUDP("udp4", addr)
+// errSharingUnsupported is returned where the LAN port cannot be shared.
+var errSharingUnsupported = errors.New("sharing the LAN port is not supported on this platform")
+
+// errNoLANAddrs is returned by ListenLAN when no interface has a LAN address.
+var errNoLANAddrs = errors.New("no LAN address to listen on")
+
+// NewResponder creates a new responder that listens on the given Tailscale IP
+// and LAN port. If localIP6 is valid and differs from localIP, queries to it
+// are answered too.
+// When another program holds the LAN port, the responder tries to share it
+// and then listens on fallbackPort instead, unless that is zero; Port
+// reports the port used.
+// readBuffer is the SO_RCVBUF size to request; zero keeps the OS default.
+// If imp is non-nil, responses are impaired. If tap is non-nil, queries
+// and responses are captured.
+func NewResponder(
+	registry *game.Registry,
+	localIP netip.Addr,
+	localIP6 netip.Addr,
+	lanPort int,
+	fallbackPort int,
+	readBuffer int,
+	imp *impair.Impairer,
+	tap *capture.Recorder,
+) (*Responder, error) {
+	// Listen on Tailscale IP, LAN port
+	conn, port, err := listenResponder(localIP, lanPort, fallbackPort, readBuffer)
 	if err != nil {
 		return nil, err
 	}
@@ -38,22 +85,167 @@ func NewResponder(registry *game.Registry, localIP netip.Addr) (*Responder, erro
 	r := &Responder{
 		registry: registry,
 		localIP:  localIP,
+		lanPort:  lanPort,
+		port:     port,
 	}
 
-	r.SetConn(conn, w3gs.NewFactoryCache(w3gs.DefaultFactory), w3gs.Encoding{})
+	r.SetConn(
+		imp.PacketConn(tap.PacketConn(conn, "responder")),
+		w3gs.NewFactoryCache(w3gs.DefaultFactory),
+		w3gs.Encoding{},
+	)
+
+	if localIP6.IsValid() && localIP6 != localIP {
+		conn6, _, err := listenResponder(localIP6, port, 0, readBuffer)
+		if err != nil {
+			_ = conn.Close()
+
+			return nil, err
+		}
+
+		r.ipv6 = imp.PacketConn(tap.PacketConn(conn6, "responder"))
+	}
 
 	return r, nil
 }
 
+// listenResponder opens the responder socket on ip and the LAN port. If
+// the port is in use, it is shared where the platform allows, and
+// fallbackPort is used otherwise. It returns the port listened on.
+func listenResponder(ip netip.Addr, lanPort, fallbackPort, readBuffer int) (*net.UDPConn, int, error) {
+	network := "udp4"
+	if ip.Is6() {
+		network = "udp6"
+	}
+
+	addr := &net.UDPAddr{IP: ip.AsSlice(), Port: lanPort}
+
+	conn, err := net.ListenUDP(network, addr)
+	if err != nil && addrInUse(err) {
+		var shareErr error
+
+		conn, shareErr = listenShared(network, addr)
+		if shareErr == nil {
+			slog.Warn("LAN port is held by another program, sharing it", "ip", ip, "port", lanPort)
+
+			err = nil
+		}
+	}
+
+	port := lanPort
+
+	if err != nil && addrInUse(err) && fallbackPort > 0 {
+		slog.Warn("LAN port is held by another program, listening on the fallback port",
+			"ip", ip,
+			"port", lanPort,
+			"fallbackPort", fallbackPort,
+		)
+
+		port = fallbackPort
+		conn, err = net.ListenUDP(network, &net.UDPAddr{IP: ip.AsSlice(), Port: fallbackPort})
+	}
+
+	if err != nil {
+		if addrInUse(err) {
+			return nil, 0, fmt.Errorf("%w: port %d: %w", ErrPortInUse, port, err)
+		}
+
+		return nil, 0, err
+	}
+
+	lan.SetReceiveBuffer(conn, "responder", readBuffer)
+
+	return conn, port, nil
+}
+
+// ListenLAN also answers queries on an unused UDP port of every LAN
+// address, for wc3ts instances on the local LAN that share no tailnet with
+// us, and returns the port. WC3 holds the LAN port on those addresses.
+// Only private and link-local requesters in the subnets of those addresses
+// are answered, so the port cannot be used to reflect traffic elsewhere.
+// It must be called before Run.
+func (r *Responder) ListenLAN(readBuffer int) (uint16, error) {
+	addrs := config.LANAddrs()
+	if len(addrs) == 0 {
+		return 0, errNoLANAddrs
+	}
+
+	var port int
+
+	for _, ip := range addrs {
+		// The first socket picks the port, the rest reuse it
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: ip.AsSlice(), Port: port})
+		if err != nil {
+			for _, c := range r.lan {
+				_ = c.Close()
+			}
+
+			r.lan = nil
+
+			return 0, fmt.Errorf("failed to listen for LAN instances on %s: %w", ip, err)
+		}
+
+		lan.SetReceiveBuffer(conn, "responder", readBuffer)
+
+		r.lan = append(r.lan, conn)
+		port = conn.LocalAddr().(*net.UDPAddr).Port //nolint:forcetypeassert
+	}
+
+	r.lanPrefixes = config.LANPrefixes()
+
+	return uint16(port), nil //nolint:gosec // UDP ports fit in uint16
+}
+
+// lanRequester reports whether ip may be answered on the LAN sockets: a
+// private or link-local address in the subnet of one of our LAN addresses.
+func (r *Responder) lanRequester(ip netip.Addr) bool {
+	if !ip.IsPrivate() && !ip.IsLinkLocalUnicast() {
+		return false
+	}
+
+	return slices.ContainsFunc(r.lanPrefixes, func(p netip.Prefix) bool { return p.Contains(ip) })
+}
+
+// Port returns the UDP port the responder listens on: the LAN port, or the
+// fallback port if another program holds the LAN port.
+func (r *Responder) Port() int {
+	return r.port
+}
+
+// SetGuardPort advertises local games with port instead of their own game
+// port, so remote players join through the join guard. Zero disables it.
+func (r *Responder) SetGuardPort(port uint16) {
+	r.guardPort.Store(uint32(port))
+	r.guardPort6.Store(uint32(port))
+}
+
+// SetCompress sets whether peers are told that the guard accepts
+// compressed sessions, by a hello following the games answering their
+// searches. Only answers routing joins through the guard carry it.
+func (r *Responder) SetCompress(enabled bool) {
+	r.compress.Store(enabled)
+}
+
+// SetIPv6GuardPort advertises local games with port to peers querying over
+// IPv6 only. WC3 only listens on IPv4, so their joins must be bridged by
+// the guard. Zero disables it.
+func (r *Responder) SetIPv6GuardPort(port uint16) {
+	r.guardPort6.Store(uint32(port))
+}
+
 // Run starts listening for SearchGame queries and responding with local games.
 // It blocks until the context is cancelled.
 func (r *Responder) Run(ctx context.Context) error {
-	r.On(&w3gs.SearchGame{}, r.onSearchGame)
-
 	// Start packet receiving in background
-	go func() {
-		_ = r.W3GSPacketConn.Run(&r.EventEmitter, 0)
-	}()
+	go r.receiveLoop(r.Conn())
+
+	if r.ipv6 != nil {
+		go r.receiveLoop(r.ipv6)
+	}
+
+	for _, conn := range r.lan {
+		go r.receiveLoop(conn)
+	}
 
 	<-ctx.Done()
 
@@ -62,46 +254,168 @@ func (r *Responder) Run(ctx context.Context) error {
 	return ctx.Err()
 }
 
-// onSearchGame handles SearchGame queries from remote peers.
-func (r *Responder) onSearchGame(ev *network.Event) {
-	// Get requester address
-	addr, ok := ev.Opt[0].(net.Addr)
-	if !ok {
-		return
+// Close closes the responder's sockets. Run closes them when its context
+// is cancelled.
+func (r *Responder) Close() error {
+	errs := []error{r.W3GSPacketConn.Close()}
+
+	if r.ipv6 != nil {
+		errs = append(errs, r.ipv6.Close())
+	}
+
+	for _, conn := range r.lan {
+		errs = append(errs, conn.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// receiveLoop reads raw UDP packets from conn and answers SearchGame
+// queries on it.
+func (r *Responder) receiveLoop(conn net.PacketConn) {
+	buf := make([]byte, packet.MaxDatagramSize)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		if r.Paused() || packet.ID(buf[:n]) != packet.IDSearchGame {
+			continue
+		}
+
+		search, err := packet.ParseSearchGame(buf[:n])
+		if err != nil {
+			slog.Debug("dropping malformed SearchGame",
+				"from", addr,
+				"size", n,
+				"error", err,
+			)
+
+			continue
+		}
+
+		r.onSearchGame(conn, addr, search.HostCounter == DirectSearchCounter)
 	}
+}
 
+// onSearchGame handles SearchGame queries from remote peers, answering on conn.
+// If direct is set, games are also sent to the LAN port on the requester's
+// address so its WC3 client sees them coming from this host. WC3 does not
+// speak IPv6, so requesters reached over it always join through their proxy.
+func (r *Responder) onSearchGame(conn net.PacketConn, addr net.Addr, direct bool) {
 	udpAddr, ok := addr.(*net.UDPAddr)
 	if !ok {
 		return
 	}
 
-	// Get local games and respond with each
+	ipv6 := udpAddr.IP.To4() == nil
+	guardPort := uint16(r.guardPort.Load())
+
+	if ipv6 {
+		guardPort = uint16(r.guardPort6.Load())
+	}
+
+	// LAN instances join local games on their own port, like local clients.
+	// Peers are answered on the Tailscale IP only. Answers on the LAN go
+	// to the requester alone, whatever it asks for
+	fromLAN := slices.Contains(r.lan, conn)
+	if fromLAN {
+		if ip, ok := netip.AddrFromSlice(udpAddr.IP); !ok || !r.lanRequester(ip.Unmap()) {
+			return
+		}
+
+		guardPort = 0
+		direct = false
+	}
+
+	targets := []*net.UDPAddr{udpAddr}
+	if direct && !ipv6 {
+		targets = append(targets, &net.UDPAddr{IP: udpAddr.IP, Port: r.lanPort})
+	}
+
+	// Get local and bridged LAN games and respond with each. Bridged games
+	// need the guard, and LAN instances see them on the LAN anyway.
 	games := r.registry.LocalGames()
+	if !fromLAN {
+		games = append(games, r.registry.LANGames()...)
+	}
 
 	slog.Debug("received SearchGame query",
 		"from", addr,
-		"localGames", len(games),
+		"games", len(games),
+		"direct", direct,
 	)
 
+	answered := false
+
 	for i := range games {
 		g := &games[i]
 
-		// Send raw packet data (preserves exact HostCounter)
-		if len(g.RawData) == 0 {
-			slog.Warn("game has no RawData, skipping",
-				"game", g.Info.GameName,
-			)
+		// Private games stay on the local LAN, and started or full ones
+		// can no longer be joined
+		if g.Private || !g.Joinable() {
+			continue
+		}
 
+		data, ok := r.response(g, guardPort)
+		if !ok {
 			continue
 		}
 
-		_, err := r.Conn().WriteTo(g.RawData, udpAddr)
+		answered = true
+
+		for _, to := range targets {
+			_, err := conn.WriteTo(data, to)
+			if err != nil {
+				slog.Debug("failed to send raw GameInfo response",
+					"game", g.Info.GameName,
+					"to", to,
+					"error", err,
+				)
+			}
+		}
+	}
+
+	if answered && guardPort != 0 && r.compress.Load() {
+		_, err := conn.WriteTo(packet.Hello(packet.HelloFlate), udpAddr)
 		if err != nil {
-			slog.Debug("failed to send raw GameInfo response",
-				"game", g.Info.GameName,
-				"to", addr,
-				"error", err,
-			)
+			slog.Debug("failed to send hello", "to", udpAddr, "error", err)
 		}
 	}
 }
+
+// response returns the GameInfo answering a search with g. Local games
+// are answered with their raw packet, preserving the exact HostCounter,
+// with the game port replaced by guardPort if it is set.
+func (r *Responder) response(g *game.Game, guardPort uint16) ([]byte, bool) {
+	if g.Source == game.SourceLAN {
+		return bridgedResponse(g, guardPort)
+	}
+
+	if len(g.RawData) == 0 {
+		slog.Warn("game has no RawData, skipping",
+			"game", g.Info.GameName,
+		)
+
+		return nil, false
+	}
+
+	if guardPort == 0 {
+		return g.RawData, true
+	}
+
+	// Route joins through the guard
+	data, err := packet.WithGamePort(g.RawData, guardPort)
+	if err != nil {
+		slog.Debug("failed to route game through guard",
+			"game", g.Info.GameName,
+			"error", err,
+		)
+
+		return nil, false
+	}
+
+	return data, true
+}
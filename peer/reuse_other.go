@@ -0,0 +1,19 @@
+//go:build !unix && !windows
+
+package peer
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// listenShared is not supported on this platform.
+func listenShared(string, *net.UDPAddr) (*net.UDPConn, error) {
+	return nil, errSharingUnsupported
+}
+
+// addrInUse reports whether err is the address being bound already in use.
+func addrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
@@ -0,0 +1,43 @@
+//go:build unix
+
+package peer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// listenShared listens on addr with SO_REUSEADDR, which lets a socket
+// bound to the Tailscale IP share its port with one bound to all addresses,
+// as long as both set it. SO_REUSEPORT is not used: it would split the
+// queries between the sockets instead of delivering them to ours.
+func listenShared(network string, addr *net.UDPAddr) (*net.UDPConn, error) {
+	lc := net.ListenConfig{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var optErr error
+
+			err := c.Control(func(fd uintptr) {
+				optErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+			})
+			if err != nil {
+				return err
+			}
+
+			return optErr
+		},
+	}
+
+	conn, err := lc.ListenPacket(context.Background(), network, addr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.(*net.UDPConn), nil //nolint:forcetypeassert
+}
+
+// addrInUse reports whether err is the address being bound already in use.
+func addrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
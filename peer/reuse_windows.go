@@ -0,0 +1,22 @@
+//go:build windows
+
+package peer
+
+import (
+	"errors"
+	"net"
+
+	"golang.org/x/sys/windows"
+)
+
+// listenShared is not attempted on Windows. A socket bound to the Tailscale
+// IP already shares its port with one bound to all addresses there, and
+// SO_REUSEADDR would take the port from a program holding the same address.
+func listenShared(string, *net.UDPAddr) (*net.UDPConn, error) {
+	return nil, errSharingUnsupported
+}
+
+// addrInUse reports whether err is the address being bound already in use.
+func addrInUse(err error) bool {
+	return errors.Is(err, windows.WSAEADDRINUSE)
+}
@@ -0,0 +1,105 @@
+package peer
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/kradalby/wc3ts/config"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// staticResolveTimeout bounds the lookup of a static hostname per probe.
+const staticResolveTimeout = time.Second
+
+// SetStaticHosts sets hosts outside the tailnet that are probed alongside
+// Tailscale peers. Their games are remote games named after the host.
+func (m *Manager) SetStaticHosts(hosts []config.StaticHost) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.staticHosts = hosts
+	m.staticNames = make(map[netip.Addr]string)
+}
+
+// SetLANInstances sets the wc3ts instances found on the local LAN over
+// mDNS. They are probed like static hosts.
+func (m *Manager) SetLANInstances(hosts []config.StaticHost) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lanInstances = hosts
+}
+
+// probeStaticHosts resolves the static hosts and LAN instances and sends SearchGame to each
+// with every version in versions. Static hosts are usually not reachable
+// from the Tailscale IP, so the unbound socket is used when there is one.
+func (m *Manager) probeStaticHosts(versions []w3gs.GameVersion) {
+	m.mu.RLock()
+	hosts := slices.Concat(m.staticHosts, m.lanInstances)
+	m.mu.RUnlock()
+
+	conn := &m.W3GSPacketConn
+	if m.local != nil {
+		conn = m.local
+	}
+
+	for _, h := range hosts {
+		ip, ok := resolveStaticHost(h.Host)
+		if !ok {
+			continue
+		}
+
+		m.mu.Lock()
+		m.staticNames[ip] = h.Name
+		m.mu.Unlock()
+
+		if m.IsMuted(ip) {
+			continue
+		}
+
+		port := h.Port
+		if port == 0 {
+			port = m.probePort()
+		}
+
+		addr := &net.UDPAddr{IP: ip.AsSlice(), Port: port}
+
+		for _, v := range versions {
+			_, err := conn.Send(addr, &w3gs.SearchGame{GameVersion: v})
+			if err != nil {
+				slog.Debug("failed to probe static host", "host", h.Name, "addr", addr, "error", err)
+
+				break
+			}
+		}
+	}
+}
+
+// resolveStaticHost returns the first IPv4 address of host.
+func resolveStaticHost(host string) (netip.Addr, bool) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return ip.Unmap(), ip.Unmap().Is4()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), staticResolveTimeout)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip4", host)
+	if err != nil || len(ips) == 0 {
+		slog.Debug("failed to resolve static host", "host", host, "error", err)
+
+		return netip.Addr{}, false
+	}
+
+	return ips[0].Unmap(), true
+}
+
+// staticHostName returns the name of the static host at ip, if any.
+// Must be called with m.mu held.
+func (m *Manager) staticHostName(ip netip.Addr) string {
+	return m.staticNames[ip]
+}
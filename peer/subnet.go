@@ -0,0 +1,197 @@
+package peer
+
+import (
+	"log/slog"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/kradalby/wc3ts/lan"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// subnetSweepInterval is how often every address of the probed subnets is
+// searched. Hosts that answered are searched every probe interval in
+// between, so the many addresses hosting nothing cost one search a sweep.
+const subnetSweepInterval = 30 * time.Second
+
+// subnetSweepPace is the pause between the addresses of a sweep, so the
+// searches trickle through the subnet router instead of arriving at once.
+const subnetSweepPace = 2 * time.Millisecond
+
+// subnetHostTimeout is how long a subnet host is searched every probe
+// interval after its last answer.
+const subnetHostTimeout = 2 * subnetSweepInterval
+
+// pointToPointBits is the shortest prefix without network and broadcast
+// addresses.
+const pointToPointBits = 31
+
+// SetProbeSubnets sets IPv4 subnets outside the tailnet whose addresses
+// are all probed, such as LANs behind Tailscale subnet routers. Their
+// games are remote games named after the host's address. Only subnets a
+// peer routes are probed.
+func (m *Manager) SetProbeSubnets(prefixes []netip.Prefix) {
+	m.mu.Lock()
+	m.subnets = prefixes
+	m.subnetHosts = make(map[netip.Addr]time.Time)
+	m.routedSubnets = make(map[netip.Prefix]bool)
+	m.lastSweep = time.Time{}
+	m.mu.Unlock()
+
+	m.checkSubnetRoutes()
+}
+
+// checkSubnetRoutes looks up which of the probed subnets a peer routes,
+// warning once about each that none does. Sweeping those would search
+// whatever network this machine is on instead.
+func (m *Manager) checkSubnetRoutes() {
+	if m.discovery == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, prefix := range m.subnets {
+		routed, ok := m.discovery.Routed(prefix)
+		if !ok {
+			return
+		}
+
+		if was, checked := m.routedSubnets[prefix]; checked && was == routed {
+			continue
+		}
+
+		m.routedSubnets[prefix] = routed
+
+		if !routed {
+			slog.Warn("no peer routes the subnet to probe, skipping it", "subnet", prefix)
+
+			continue
+		}
+
+		slog.Info("probing subnet routed by a peer", "subnet", prefix)
+
+		// Sweep the newly routed subnet right away
+		m.lastSweep = time.Time{}
+	}
+}
+
+// probeSubnets sends SearchGame with every version in versions to the
+// hosts of the routed probed subnets: to every address once a sweep, and
+// to the hosts that answered lately in between. Sweeps are paced and sent
+// in the background. Like static hosts, they are probed from the unbound
+// socket when there is one.
+func (m *Manager) probeSubnets(versions []w3gs.GameVersion) {
+	now := time.Now()
+
+	var targets []netip.Addr
+
+	m.mu.Lock()
+
+	sweep := now.Sub(m.lastSweep) >= subnetSweepInterval
+	if sweep {
+		m.lastSweep = now
+
+		for _, prefix := range m.subnets {
+			if m.routedSubnets[prefix] {
+				targets = append(targets, subnetHosts(prefix)...)
+			}
+		}
+	} else {
+		for ip, seen := range m.subnetHosts {
+			if now.Sub(seen) > subnetHostTimeout {
+				delete(m.subnetHosts, ip)
+
+				continue
+			}
+
+			targets = append(targets, ip)
+		}
+	}
+
+	m.mu.Unlock()
+
+	if sweep {
+		go m.searchSubnetHosts(targets, versions, subnetSweepPace)
+
+		return
+	}
+
+	m.searchSubnetHosts(targets, versions, 0)
+}
+
+// searchSubnetHosts sends SearchGame with every version in versions to
+// each of targets, pausing pace between them.
+func (m *Manager) searchSubnetHosts(targets []netip.Addr, versions []w3gs.GameVersion, pace time.Duration) {
+	conn := &m.W3GSPacketConn
+	if m.local != nil {
+		conn = m.local
+	}
+
+	port := m.probePort()
+
+	for i, ip := range targets {
+		if m.IsMuted(ip) {
+			continue
+		}
+
+		if i > 0 && pace > 0 {
+			time.Sleep(pace)
+		}
+
+		addr := &net.UDPAddr{IP: ip.AsSlice(), Port: port}
+
+		for _, v := range versions {
+			_, err := conn.Send(addr, &w3gs.SearchGame{GameVersion: v})
+			if err != nil {
+				slog.Debug("failed to probe subnet host", "addr", addr, "error", err)
+
+				break
+			}
+		}
+	}
+}
+
+// subnetHosts returns the host addresses of prefix, leaving out its
+// network and broadcast addresses.
+func subnetHosts(prefix netip.Prefix) []netip.Addr {
+	var hosts []netip.Addr
+
+	broadcast := lan.SubnetBroadcast(prefix)
+
+	for ip := prefix.Addr(); prefix.Contains(ip); ip = ip.Next() {
+		if prefix.Bits() < pointToPointBits && (ip == prefix.Addr() || ip == broadcast) {
+			continue
+		}
+
+		hosts = append(hosts, ip)
+	}
+
+	return hosts
+}
+
+// subnetHostName returns the name of the host at ip if it is in a probed
+// subnet: its address.
+// Must be called with m.mu held.
+func (m *Manager) subnetHostName(ip netip.Addr) string {
+	for _, prefix := range m.subnets {
+		if prefix.Contains(ip) {
+			return ip.String()
+		}
+	}
+
+	return ""
+}
+
+// noteSubnetHost records an answer from ip if it is in a probed subnet,
+// so it is searched every probe interval.
+func (m *Manager) noteSubnetHost(ip netip.Addr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.subnetHostName(ip) != "" {
+		m.subnetHosts[ip] = time.Now()
+	}
+}
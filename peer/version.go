@@ -0,0 +1,73 @@
+package peer
+
+import (
+	"log/slog"
+	"net/netip"
+
+	"github.com/kradalby/wc3ts/config"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// VersionFunc is called with the game version detected from a local game.
+type VersionFunc func(v w3gs.GameVersion)
+
+// SetVersionFunc sets the callback for a detected game version. Without a
+// configured version, every supported version is probed for until a local
+// game reveals the one WC3 runs.
+// It must be called before Run.
+func (m *Manager) SetVersionFunc(fn VersionFunc) {
+	m.onVersion = fn
+}
+
+// detectVersion adopts v, the version of a game hosted on this machine,
+// as the game version if none is configured.
+func (m *Manager) detectVersion(v w3gs.GameVersion) {
+	m.mu.Lock()
+
+	if m.version.Version != 0 {
+		m.mu.Unlock()
+
+		return
+	}
+
+	m.version = v
+	fn := m.onVersion
+	m.mu.Unlock()
+
+	slog.Info("detected game version from a local game",
+		"product", v.Product,
+		"version", config.FormatVersion(v.Version),
+	)
+
+	if fn != nil {
+		fn(v)
+	}
+}
+
+// learnPeerVersion records the version a peer's games were found with.
+// While probing for many versions, the peer is then probed only with the
+// versions compatible with it, until it stops answering.
+func (m *Manager) learnPeerVersion(ip netip.Addr, v w3gs.GameVersion) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if learned, ok := m.peerVersion[ip]; !ok || learned != v {
+		slog.Debug("learned peer game version", "peer", ip, "version", config.FormatVersion(v.Version))
+	}
+
+	m.peerVersion[ip] = v
+}
+
+// versionsFor returns the versions to probe ip with out of versions, the
+// versions probed for.
+func (m *Manager) versionsFor(ip netip.Addr, versions []w3gs.GameVersion) []w3gs.GameVersion {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	learned, ok := m.peerVersion[ip]
+	if !ok || (m.version.Version != 0 && !m.allVersions) {
+		return versions
+	}
+
+	return config.CompatibleVersions(m.compatGroups, learned)
+}
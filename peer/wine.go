@@ -0,0 +1,40 @@
+package peer
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// SetHostAddrs sets this machine's own LAN addresses for a WC3 client running
+// under Wine or Proton. Such clients may only answer probes sent to a LAN
+// address and reply from it rather than from localhost, so these addresses
+// are probed for local games and replies from them count as local.
+// An empty list restores plain localhost probing.
+func (m *Manager) SetHostAddrs(addrs []netip.Addr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hostAddrs = addrs
+}
+
+// isHostAddr reports whether ip is one of this machine's LAN addresses.
+func (m *Manager) isHostAddr(ip netip.Addr) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return slices.Contains(m.hostAddrs, ip)
+}
+
+// probeHostAddrs sends SearchGame to this machine's LAN addresses.
+func (m *Manager) probeHostAddrs(version w3gs.GameVersion) {
+	m.mu.RLock()
+	addrs := m.hostAddrs
+	m.mu.RUnlock()
+
+	for _, ip := range addrs {
+		m.probeLocalAddr(&net.UDPAddr{IP: ip.AsSlice(), Port: m.probePort()}, version)
+	}
+}
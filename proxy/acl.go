@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kradalby/wc3ts/config"
+	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/tailscale"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+	"tailscale.com/net/tsaddr"
+)
+
+// whoisTimeout bounds the Tailscale identity lookup for a joining peer.
+const whoisTimeout = 5 * time.Second
+
+// RejectFunc is called when a join is rejected, with the game name and a
+// description of who tried to join.
+type RejectFunc func(gameName, who string)
+
+// ACL authorizes joins by the Tailscale identity behind the connection,
+// resolved with WhoIs. It is shared by the join guard and the TCP proxy,
+// so identities allowed at runtime may join through either.
+// A nil ACL allows everyone.
+type ACL struct {
+	discovery *tailscale.Discovery
+	joins     config.JoinACL
+	onReject  RejectFunc
+	mu        sync.RWMutex
+}
+
+// NewACL creates an ACL enforcing the join allowlist.
+func NewACL(discovery *tailscale.Discovery, joins config.JoinACL) *ACL {
+	return &ACL{discovery: discovery, joins: joins}
+}
+
+// SetRejectFunc sets the callback for rejected joins.
+// It must be called before the ACL is used.
+func (a *ACL) SetRejectFunc(fn RejectFunc) {
+	a.onReject = fn
+}
+
+// Allow adds an identity to the global allowlist.
+func (a *ACL) Allow(identity string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.joins.Global = append(slices.Clip(a.joins.Global), identity)
+}
+
+// authorize resolves the identity behind conn and checks it against the
+// allowlist for gameName. It returns a description of the identity.
+func (a *ACL) authorize(ctx context.Context, conn net.Conn, gameName string) (string, bool) {
+	remote, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		return a.reject(gameName, conn.RemoteAddr().String())
+	}
+
+	if a == nil {
+		return remote.Addr().String(), true
+	}
+
+	a.mu.RLock()
+	allowed := a.joins.Allowed(gameName)
+	a.mu.RUnlock()
+
+	if len(allowed) == 0 {
+		return remote.Addr().String(), true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, whoisTimeout)
+	defer cancel()
+
+	id, err := a.discovery.WhoIs(ctx, remote)
+	if err != nil {
+		slog.Debug("whois failed", "addr", remote, "error", err)
+
+		return a.reject(gameName, remote.Addr().String()+" (unknown)")
+	}
+
+	if !slices.ContainsFunc(allowed, id.Matches) {
+		return a.reject(gameName, id.String())
+	}
+
+	return id.String(), true
+}
+
+// reject reports a rejected join of who to gameName.
+func (a *ACL) reject(gameName, who string) (string, bool) {
+	if a != nil && a.onReject != nil {
+		a.onReject(gameName, who)
+	}
+
+	return who, false
+}
+
+// authorize checks joins from tailnet addresses against the proxy's ACL.
+func (p *TCPProxy) authorize(ctx context.Context, conn net.Conn, join *w3gs.Join, g *game.Game) bool {
+	if p.acl == nil || !isTailnetAddr(conn.RemoteAddr()) {
+		return true
+	}
+
+	who, ok := p.acl.authorize(ctx, conn, g.Info.GameName)
+	if !ok {
+		slog.Warn("rejected proxied join: not on allowlist",
+			"game", g.Info.GameName,
+			"who", who,
+			"player", join.PlayerName,
+		)
+
+		return false
+	}
+
+	slog.Info("authorized proxied join", "game", g.Info.GameName, "who", who, "player", join.PlayerName)
+
+	return true
+}
+
+// isTailnetAddr reports whether addr is a Tailscale address.
+func isTailnetAddr(addr net.Addr) bool {
+	ap, err := netip.ParseAddrPort(addr.String())
+
+	return err == nil && tsaddr.IsTailscaleIP(ap.Addr().Unmap())
+}
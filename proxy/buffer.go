@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/kradalby/wc3ts/packet"
+)
+
+// defaultBufferSize is the relay copy buffer size, the same as io.Copy.
+const defaultBufferSize = 32 * 1024
+
+// BufferPool reuses relay copy buffers of one size, so relays to games
+// with many players and spectators do not allocate per connection.
+type BufferPool struct {
+	pool sync.Pool
+}
+
+// defaultBuffers serves relays whose owner has no pool of its own.
+var defaultBuffers = NewBufferPool(defaultBufferSize)
+
+// NewBufferPool creates a pool of size byte buffers. Sizes below the
+// largest W3GS packet are raised to it.
+func NewBufferPool(size int) *BufferPool {
+	size = max(size, packet.MaxSize)
+
+	b := &BufferPool{}
+	b.pool.New = func() any {
+		buf := make([]byte, size)
+
+		return &buf
+	}
+
+	return b
+}
+
+// copy copies src to dst like io.Copy with a buffer from the pool. It is
+// safe to call on a nil pool, which uses the default one.
+func (b *BufferPool) copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := b.get()
+	defer b.put(buf)
+
+	return io.CopyBuffer(dst, src, *buf)
+}
+
+// copyUntil copies src to dst and observe until done reports true. It
+// reports whether src reached EOF first.
+func (b *BufferPool) copyUntil(dst io.Writer, src io.Reader, observe io.Writer, done func() bool) (int64, bool, error) {
+	buf := b.get()
+	defer b.put(buf)
+
+	var written int64
+
+	for !done() {
+		nr, err := src.Read(*buf)
+		if nr > 0 {
+			_, _ = observe.Write((*buf)[:nr])
+
+			nw, werr := dst.Write((*buf)[:nr])
+			written += int64(nw)
+
+			if werr != nil {
+				return written, false, werr
+			}
+
+			if nw != nr {
+				return written, false, io.ErrShortWrite
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return written, true, nil
+		}
+
+		if err != nil {
+			return written, false, err
+		}
+	}
+
+	return written, false, nil
+}
+
+// get takes a buffer from the pool, or the default pool if b is nil.
+func (b *BufferPool) get() *[]byte {
+	if b == nil {
+		b = defaultBuffers
+	}
+
+	buf, ok := b.pool.Get().(*[]byte)
+	if !ok {
+		fresh := make([]byte, defaultBufferSize)
+		buf = &fresh
+	}
+
+	return buf
+}
+
+// put returns a buffer taken with get.
+func (b *BufferPool) put(buf *[]byte) {
+	if b == nil {
+		b = defaultBuffers
+	}
+
+	b.pool.Put(buf)
+}
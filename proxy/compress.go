@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/kradalby/wc3ts/packet"
+)
+
+// helloTimeout bounds the wait for a host's hello in return.
+const helloTimeout = 2 * time.Second
+
+// errNoHello is returned when a host answers the hello with anything else.
+var errNoHello = errors.New("host is not a wc3ts guard")
+
+// RelayedFunc reports whether the Tailscale path to ip is relayed.
+type RelayedFunc func(ctx context.Context, ip netip.Addr) (bool, error)
+
+// GuardFunc reports whether the peer at ip told us its guard accepts
+// compressed sessions.
+type GuardFunc func(ip netip.Addr) bool
+
+// SetCompress compresses sessions to hosts that guarded reports as wc3ts
+// guards accepting compression while relayed reports the path to them as
+// relayed, where bandwidth is scarce. A nil relayed disables compression.
+// It must be called before Run.
+func (p *TCPProxy) SetCompress(relayed RelayedFunc, guarded GuardFunc) {
+	p.relayed = relayed
+	p.guarded = guarded
+}
+
+// wantsCompression reports whether a session to host should be compressed.
+// Only hosts that announced a compressing guard are asked, so others never
+// see the hello.
+func (p *TCPProxy) wantsCompression(ctx context.Context, host netip.AddrPort) bool {
+	if p.relayed == nil || !p.guarded(host.Addr()) {
+		return false
+	}
+
+	relayed, err := p.relayed(ctx, host.Addr())
+	if err != nil {
+		slog.Debug("failed to check Tailscale path", "host", host, "error", err)
+	}
+
+	return relayed
+}
+
+// dialHost connects to host, compressing the session if it is wanted.
+func (p *TCPProxy) dialHost(ctx context.Context, dialer *net.Dialer, host netip.AddrPort) (net.Conn, error) {
+	conn, err := p.dial(ctx, dialer, host)
+	if err != nil || !p.wantsCompression(ctx, host) {
+		return conn, err
+	}
+
+	compressed, err := sendHello(conn)
+	if err != nil {
+		_ = conn.Close()
+
+		return nil, fmt.Errorf("failed to negotiate compression with %s: %w", host, err)
+	}
+
+	slog.Info("compressing relayed session", "host", host)
+
+	return compressed, nil
+}
+
+// dial connects to host and tunes the socket.
+func (p *TCPProxy) dial(ctx context.Context, dialer *net.Dialer, host netip.AddrPort) (net.Conn, error) {
+	conn, err := dialer.DialContext(ctx, "tcp", host.String())
+	if err != nil {
+		return nil, err
+	}
+
+	p.tcp.apply(conn)
+
+	return conn, nil
+}
+
+// sendHello asks the host on conn to compress the session, and returns
+// the compressed connection if it agrees.
+func sendHello(conn net.Conn) (net.Conn, error) {
+	err := conn.SetDeadline(time.Now().Add(helloTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.Write(packet.Hello(packet.HelloFlate))
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := packet.ReadStream(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if !packet.IsHello(reply) || packet.HelloFlags(reply)&packet.HelloFlate == 0 {
+		return nil, errNoHello
+	}
+
+	err = conn.SetDeadline(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	return newFlateConn(conn), nil
+}
+
+// acceptHello reads the first packet from a guarded connection. If it is
+// a hello asking for compression, the hello is answered and the rest of
+// the connection is decompressed; otherwise the packet is put back, so
+// the connection reads as if it had not been touched.
+func acceptHello(conn net.Conn) (net.Conn, error) {
+	first, err := readFirstPacket(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if !packet.IsHello(first) {
+		return &replayConn{Conn: conn, r: io.MultiReader(bytes.NewReader(first), conn)}, nil
+	}
+
+	flags := packet.HelloFlags(first) & packet.HelloFlate
+
+	_, err = conn.Write(packet.Hello(flags))
+	if err != nil || flags == 0 {
+		return conn, err
+	}
+
+	slog.Info("compressing guarded session", "client", conn.RemoteAddr())
+
+	return newFlateConn(conn), nil
+}
+
+// replayConn is a connection whose first packet was read and put back.
+type replayConn struct {
+	net.Conn
+
+	r io.Reader
+}
+
+// Read reads the put back packet, then the connection.
+func (c *replayConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// flateConn deflates what is written to a connection and inflates what is
+// read from it. Every write is flushed, so game packets are never held
+// back waiting for more data.
+type flateConn struct {
+	net.Conn
+
+	r  io.ReadCloser
+	w  *flate.Writer
+	mu sync.Mutex // guards w
+}
+
+// newFlateConn compresses the traffic on conn.
+func newFlateConn(conn net.Conn) *flateConn {
+	w, _ := flate.NewWriter(conn, flate.BestSpeed) // Only fails for invalid levels
+
+	return &flateConn{Conn: conn, r: flate.NewReader(conn), w: w}
+}
+
+// Read returns inflated data.
+func (c *flateConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// Write deflates b and flushes it to the connection.
+func (c *flateConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+
+	err = c.w.Flush()
+	if err != nil {
+		return 0, fmt.Errorf("flush compressed stream: %w", err)
+	}
+
+	return n, nil
+}
+
+// CloseWrite ends the compressed stream and shuts down the writing side
+// of the connection.
+func (c *flateConn) CloseWrite() error {
+	c.mu.Lock()
+	err := c.w.Close()
+	c.mu.Unlock()
+
+	if cw, ok := c.Conn.(closeWriter); ok {
+		err = errors.Join(err, cw.CloseWrite())
+	}
+
+	return err
+}
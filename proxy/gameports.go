@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"net"
+
+	"github.com/kradalby/wc3ts/game"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// gameListener is the dedicated listener set of one remote game.
+type gameListener struct {
+	listeners []net.Listener
+	port      int
+	closeUDP  func() // closes the UDP relay sockets on port, if any
+}
+
+// close closes the game's listeners. Joins already accepted keep running.
+func (l *gameListener) close() {
+	for _, listener := range l.listeners {
+		_ = listener.Close()
+	}
+
+	if l.closeUDP != nil {
+		l.closeUDP()
+	}
+}
+
+// SetGamePorts sets whether each rebroadcast remote game gets a dedicated
+// port, so its joins are routed by port instead of by HostCounter. The
+// shared port keeps serving joins to games without one.
+// It must be called before Run.
+func (p *TCPProxy) SetGamePorts(enabled bool) {
+	if !enabled {
+		p.gameUpdates = nil
+
+		return
+	}
+
+	p.gameUpdates = make(chan []game.Game, 1)
+	p.gameListeners = make(map[string]*gameListener)
+}
+
+// OnGamesChanged opens dedicated ports for new remote games and closes
+// those of removed ones, if per-game ports are enabled. Only the latest
+// list is kept if Run falls behind.
+func (p *TCPProxy) OnGamesChanged(games []game.Game) {
+	if p.gameUpdates == nil {
+		return
+	}
+
+	for {
+		select {
+		case p.gameUpdates <- games:
+			return
+		default:
+		}
+
+		// Drop the stale update Run has not picked up yet
+		select {
+		case <-p.gameUpdates:
+		default:
+		}
+	}
+}
+
+// GamePort returns the dedicated port of the game with key, or zero if it
+// has none and joins go to the shared port.
+func (p *TCPProxy) GamePort(key string) uint16 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if l, ok := p.gameListeners[key]; ok {
+		return safePort(l.port)
+	}
+
+	return 0
+}
+
+// syncGameListeners keeps a dedicated listener open for every remote game
+// that is rebroadcast through the proxy, and closes the rest.
+func (p *TCPProxy) syncGameListeners(ctx context.Context, games []game.Game) {
+	if p.gameUpdates == nil {
+		return
+	}
+
+	wanted := make(map[string]*game.Game, len(games))
+
+	for i := range games {
+		if g := &games[i]; g.Source == game.SourceRemote && !g.Direct {
+			wanted[g.Key()] = g
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, l := range p.gameListeners {
+		if _, ok := wanted[key]; !ok {
+			l.close()
+			delete(p.gameListeners, key)
+
+			slog.Debug("closed game port", "game", key, "port", l.port)
+		}
+	}
+
+	for key, g := range wanted {
+		if _, ok := p.gameListeners[key]; ok {
+			continue
+		}
+
+		listeners, port, err := listenAll(ctx, p.bindAddrs, 0)
+		if err != nil {
+			slog.Warn("failed to open game port, using the shared proxy port",
+				"game", g.Info.GameName,
+				"error", err,
+			)
+
+			continue
+		}
+
+		l := &gameListener{listeners: listeners, port: port}
+
+		// In-game datagrams go to the port WC3 joined, so the relay must
+		// listen there too
+		if p.udp != nil {
+			l.closeUDP, err = p.udp.Listen(ctx, p.bindAddrs, port)
+			if err != nil {
+				slog.Warn("failed to relay UDP on game port, using the shared proxy port",
+					"game", g.Info.GameName,
+					"error", err,
+				)
+
+				l.close()
+
+				continue
+			}
+		}
+
+		p.gameListeners[key] = l
+
+		for _, listener := range listeners {
+			go p.acceptLoop(ctx, listener, key)
+		}
+
+		slog.Info("opened game port",
+			"game", g.Info.GameName,
+			"peerIP", g.PeerIP,
+			"port", port,
+		)
+	}
+}
+
+// findGame returns the remote game a Join is for: the game owning the port
+// it arrived on if key is set, and otherwise the game its HostCounter and
+// EntryKey match.
+func (p *TCPProxy) findGame(join *w3gs.Join, key string) *game.Game {
+	if key == "" {
+		return p.registry.FindForJoin(join.HostCounter, join.EntryKey)
+	}
+
+	g, ok := p.registry.Get(key)
+	if !ok || g.Source != game.SourceRemote {
+		return nil
+	}
+
+	return &g
+}
@@ -0,0 +1,541 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/kradalby/wc3ts/packet"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// GProxy++ (GPS) packet IDs. GPS packets use the W3GS framing with the
+// packet.GPSSig signature and are never forwarded to the host.
+const (
+	gpsInit      = 1
+	gpsReconnect = 2
+	gpsAck       = 3
+	gpsReject    = 4
+)
+
+// GPS reject reasons.
+const (
+	gpsRejectInvalid  = 1
+	gpsRejectNotFound = 2
+)
+
+// GPS packet sizes, including the header.
+const (
+	gpsInitSize      = packet.HeaderSize + 8 // port, PID, key, empty actions
+	gpsReconnectSize = packet.HeaderSize + 9 // PID, key, last packet
+	gpsCountSize     = packet.HeaderSize + 4 // a single uint32
+)
+
+// gpsAckInterval is how many packets from the client are acknowledged at
+// once, letting it drop them from its own buffer.
+const gpsAckInterval = 10
+
+// gpsMaxBuffer bounds the unacknowledged packets kept for a client, in
+// bytes. A client away long enough to miss more cannot resume.
+const gpsMaxBuffer = 4 << 20
+
+// Reconnect errors.
+var (
+	errSessionClosed = errors.New("session closed")
+	errHostWrite     = errors.New("write to host failed")
+	errMissedPackets = errors.New("packets no longer buffered")
+)
+
+// gpsSession is a proxied connection whose client can reconnect with the
+// GProxy++ protocol after its connection drops. The host connection stays
+// open while the client is away, and packets for the client are buffered
+// until it acknowledges them so none are lost in between.
+//
+// Packets are counted in both directions from the client's GPS_INIT,
+// excluding GPS packets; the counts identify where to resume.
+type gpsSession struct {
+	host    net.Conn
+	observe io.Writer
+	wait    time.Duration
+	port    uint16
+	key     uint32
+
+	// origin is the address of the client that joined. Only it may
+	// reconnect, so a guessed key cannot take over the session.
+	origin netip.Addr
+
+	// authorize runs the join checks again for a reconnecting client.
+	authorize func(conn net.Conn) bool
+
+	// resumed is signalled when a reconnected client takes over.
+	resumed chan struct{}
+
+	// done is closed when the host connection ends.
+	done chan struct{}
+
+	mu       sync.Mutex
+	client   net.Conn // nil while the client is away
+	pid      uint8
+	pidKnown bool
+	enabled  bool     // the client sent GPS_INIT
+	buffer   [][]byte // unacknowledged packets sent to the client
+	size     int      // bytes in buffer
+	first    uint32   // number of buffer[0]
+	sent     uint32   // packets sent to the client
+	received uint32   // packets received from the client
+	closed   bool
+}
+
+// newGPSSession creates a session relaying between client and host. Port
+// is where the client reconnects.
+func newGPSSession(client, host net.Conn, observe io.Writer, port uint16, wait time.Duration) *gpsSession {
+	return &gpsSession{
+		host:      host,
+		observe:   observe,
+		wait:      wait,
+		port:      port,
+		key:       rand.Uint32(), //nolint:gosec // Unpredictable enough to tell sessions apart
+		origin:    addrIP(client.RemoteAddr()),
+		authorize: func(net.Conn) bool { return true },
+		resumed:   make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		client:    client,
+	}
+}
+
+// relaySession relays between client and host like relay, but lets the
+// client reconnect for up to the reconnect wait if it speaks GProxy++.
+// authorize checks a reconnecting client as the join was checked.
+func (p *TCPProxy) relaySession(
+	ctx context.Context,
+	client, host net.Conn,
+	observe io.Writer,
+	authorize func(conn net.Conn) bool,
+) {
+	s := newGPSSession(client, host, observe, safePort(p.port), p.reconnectWait)
+	s.authorize = authorize
+
+	p.mu.Lock()
+	p.reconnects[s.key] = s
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.reconnects, s.key)
+		p.mu.Unlock()
+	}()
+
+	go s.hostLoop()
+
+	conn := client
+
+	for {
+		err := s.readClient(conn)
+
+		next, ok := s.await(ctx, conn, err)
+		if !ok {
+			break
+		}
+
+		conn = next
+	}
+
+	s.mu.Lock()
+	s.closed = true
+
+	if s.client != nil && s.client != client {
+		_ = s.client.Close()
+	}
+	s.mu.Unlock()
+
+	if tc, ok := host.(closeWriter); ok {
+		_ = tc.CloseWrite()
+	}
+
+	<-s.done
+}
+
+// resumeSession hands a reconnecting client to its session, throttled and
+// impaired like the connection it replaces. Only the client that joined
+// may reconnect, and it must still pass the join checks. The connection
+// is closed if the session cannot be resumed.
+func (p *TCPProxy) resumeSession(conn net.Conn, data []byte) {
+	pid, key, last, ok := parseGPSReconnect(data)
+	if !ok {
+		rejectGPS(conn, gpsRejectInvalid)
+
+		return
+	}
+
+	p.mu.Lock()
+	s := p.reconnects[key]
+	p.mu.Unlock()
+
+	if s == nil {
+		slog.Warn("GProxy reconnect for unknown session", "client", conn.RemoteAddr(), "pid", pid)
+		rejectGPS(conn, gpsRejectNotFound)
+
+		return
+	}
+
+	if ip := addrIP(conn.RemoteAddr()); ip != s.origin {
+		slog.Warn("GProxy reconnect from another address",
+			"client", conn.RemoteAddr(),
+			"origin", s.origin,
+			"pid", pid,
+		)
+		rejectGPS(conn, gpsRejectNotFound)
+
+		return
+	}
+
+	if !s.authorize(conn) {
+		rejectGPS(conn, gpsRejectInvalid)
+
+		return
+	}
+
+	p.mu.Lock()
+	perConn := p.connRate
+	p.mu.Unlock()
+
+	conn = p.impair.Conn(throttle(conn, NewLimiter(perConn), p.download))
+
+	err := s.resume(conn, pid, last)
+	if err != nil {
+		slog.Warn("GProxy reconnect failed", "client", conn.RemoteAddr(), "pid", pid, "error", err)
+		rejectGPS(conn, gpsRejectNotFound)
+
+		return
+	}
+
+	slog.Info("GProxy client reconnected", "client", conn.RemoteAddr(), "pid", pid, "lastPacket", last)
+}
+
+// hostLoop forwards packets from the host to the current client, keeping
+// them until acknowledged once the client has enabled reconnecting.
+func (s *gpsSession) hostLoop() {
+	defer close(s.done)
+
+	for {
+		data, err := packet.ReadStream(s.host)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				slog.Debug("relay error (remote -> client)", "error", err)
+			}
+
+			s.hostClosed()
+
+			return
+		}
+
+		if s.observe != nil {
+			_, _ = s.observe.Write(data)
+		}
+
+		s.fromHost(data)
+	}
+}
+
+// fromHost sends a host packet to the client, or only buffers it while
+// the client is away.
+func (s *gpsSession) fromHost(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if packet.ID(data) == w3gs.PidSlotInfoJoin {
+		pkt, err := packet.Parse(data)
+		if join, ok := pkt.(*w3gs.SlotInfoJoin); err == nil && ok {
+			s.pid, s.pidKnown = join.PlayerID, true
+		}
+	}
+
+	if s.enabled {
+		s.push(data)
+	}
+
+	if s.client == nil {
+		return
+	}
+
+	_, err := s.client.Write(data)
+	if err != nil {
+		// The client loop notices the closed connection
+		_ = s.client.Close()
+	}
+}
+
+// push buffers a packet sent to the client, dropping the oldest packets
+// once the buffer is full.
+func (s *gpsSession) push(data []byte) {
+	s.buffer = append(s.buffer, data)
+	s.size += len(data)
+	s.sent++
+
+	for s.size > gpsMaxBuffer && len(s.buffer) > 0 {
+		s.size -= len(s.buffer[0])
+		s.buffer = s.buffer[1:]
+		s.first++
+	}
+}
+
+// ack drops the packets the client has received.
+func (s *gpsSession) ack(last uint32) {
+	for s.first < last && len(s.buffer) > 0 {
+		s.size -= len(s.buffer[0])
+		s.buffer[0] = nil
+		s.buffer = s.buffer[1:]
+		s.first++
+	}
+}
+
+// hostClosed ends the session when the host connection ends.
+func (s *gpsSession) hostClosed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+
+	if s.client == nil {
+		return
+	}
+
+	if tc, ok := s.client.(closeWriter); ok {
+		_ = tc.CloseWrite()
+	} else {
+		_ = s.client.Close()
+	}
+}
+
+// readClient forwards packets from conn to the host until conn fails.
+func (s *gpsSession) readClient(conn net.Conn) error {
+	for {
+		data, err := packet.ReadStream(conn)
+		if err != nil {
+			return err
+		}
+
+		if data[0] == packet.GPSSig {
+			s.handleGPS(conn, data)
+
+			continue
+		}
+
+		_, err = s.host.Write(data)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errHostWrite, err)
+		}
+
+		s.fromClient(conn)
+	}
+}
+
+// fromClient counts a packet forwarded from the client and periodically
+// acknowledges them.
+func (s *gpsSession) fromClient(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.enabled {
+		return
+	}
+
+	s.received++
+
+	if s.received%gpsAckInterval == 0 && s.client == conn {
+		_, _ = conn.Write(gpsCount(gpsAck, s.received))
+	}
+}
+
+// handleGPS handles a GPS packet from the client.
+func (s *gpsSession) handleGPS(conn net.Conn, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch data[1] {
+	case gpsInit:
+		// The PID is only known once the host accepted the join
+		if s.enabled || !s.pidKnown || s.client != conn {
+			return
+		}
+
+		s.enabled = true
+
+		_, _ = conn.Write(s.initReply())
+
+		slog.Info("GProxy reconnect enabled", "client", conn.RemoteAddr(), "pid", s.pid)
+	case gpsAck:
+		if len(data) >= gpsCountSize {
+			s.ack(binary.LittleEndian.Uint32(data[packet.HeaderSize:]))
+		}
+	}
+}
+
+// initReply builds the GPS_INIT reply telling the client where and how to
+// reconnect.
+func (s *gpsSession) initReply() []byte {
+	b := gpsHeader(gpsInit, gpsInitSize)
+	b = binary.LittleEndian.AppendUint16(b, s.port)
+	b = append(b, s.pid)
+	b = binary.LittleEndian.AppendUint32(b, s.key)
+
+	// No empty actions are sent to hide the lag screen
+	return append(b, 0)
+}
+
+// await decides what happens after conn failed with err. It returns the
+// connection to continue with, or false if the session is over.
+func (s *gpsSession) await(ctx context.Context, conn net.Conn, err error) (net.Conn, bool) {
+	s.mu.Lock()
+
+	// A reconnect can arrive before the old connection is seen to fail
+	if s.client != nil && s.client != conn {
+		next := s.client
+		s.mu.Unlock()
+
+		// The reconnect is already taken over
+		select {
+		case <-s.resumed:
+		default:
+		}
+
+		return next, true
+	}
+
+	if !s.enabled || s.closed || errors.Is(err, errHostWrite) {
+		s.mu.Unlock()
+
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+			slog.Debug("relay error (client -> remote)", "error", err)
+		}
+
+		return nil, false
+	}
+
+	s.client = nil
+	s.mu.Unlock()
+
+	slog.Info("GProxy client disconnected, waiting for it to reconnect",
+		"client", conn.RemoteAddr(), "pid", s.pid, "wait", s.wait, "error", err)
+
+	timer := time.NewTimer(s.wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.resumed:
+			s.mu.Lock()
+			next := s.client
+			s.mu.Unlock()
+
+			if next != nil {
+				return next, true
+			}
+		case <-timer.C:
+			slog.Info("GProxy client did not reconnect", "client", conn.RemoteAddr(), "pid", s.pid)
+
+			return nil, false
+		case <-s.done:
+			return nil, false
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// resume attaches a reconnected client that has received last packets,
+// replaying the packets it missed.
+func (s *gpsSession) resume(conn net.Conn, pid uint8, last uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.closed || !s.enabled:
+		return errSessionClosed
+	case pid != s.pid:
+		return fmt.Errorf("%w: PID %d", errSessionClosed, pid)
+	case last < s.first || last > s.sent:
+		return fmt.Errorf("%w: client has %d, buffer starts at %d", errMissedPackets, last, s.first)
+	}
+
+	s.ack(last)
+
+	_, err := conn.Write(gpsCount(gpsReconnect, s.received))
+	if err != nil {
+		return err
+	}
+
+	for _, data := range s.buffer {
+		_, err = conn.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	old := s.client
+	s.client = conn
+
+	if old != nil {
+		_ = old.Close()
+	}
+
+	select {
+	case s.resumed <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// parseGPSReconnect parses a GPS_RECONNECT from a client.
+func parseGPSReconnect(data []byte) (pid uint8, key, last uint32, ok bool) {
+	if len(data) < gpsReconnectSize || data[0] != packet.GPSSig || data[1] != gpsReconnect {
+		return 0, 0, 0, false
+	}
+
+	body := data[packet.HeaderSize:]
+
+	return body[0], binary.LittleEndian.Uint32(body[1:5]), binary.LittleEndian.Uint32(body[5:9]), true
+}
+
+// isGPSReconnect reports whether data is a GPS_RECONNECT packet.
+func isGPSReconnect(data []byte) bool {
+	return len(data) >= packet.HeaderSize && data[0] == packet.GPSSig && data[1] == gpsReconnect
+}
+
+// rejectGPS tells a reconnecting client its session cannot be resumed.
+func rejectGPS(conn net.Conn, reason uint32) {
+	_, _ = conn.Write(gpsCount(gpsReject, reason))
+	_ = conn.Close()
+}
+
+// gpsHeader starts a GPS packet of the given total size.
+func gpsHeader(id byte, size int) []byte {
+	b := make([]byte, 0, size)
+	b = append(b, packet.GPSSig, id)
+
+	return binary.LittleEndian.AppendUint16(b, uint16(size)) //nolint:gosec // GPS packets are tiny
+}
+
+// gpsCount builds a GPS packet carrying a single uint32.
+func gpsCount(id byte, n uint32) []byte {
+	return binary.LittleEndian.AppendUint32(gpsHeader(id, gpsCountSize), n)
+}
+
+// addrIP returns the IP of addr, or the zero Addr if it has none.
+func addrIP(addr net.Addr) netip.Addr {
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return ap.Addr().Unmap()
+}
@@ -0,0 +1,255 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/kradalby/wc3ts/capture"
+	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/impair"
+	"github.com/kradalby/wc3ts/packet"
+)
+
+// Guard authorizes joins to locally hosted games by Tailscale identity.
+//
+// Local games are advertised to peers with the guard's port, so remote
+// players connect to the guard. It resolves each connection with WhoIs and
+// only forwards it to the local game if the identity is on the allowlist.
+// Without an ACL every join is forwarded, which bridges joins over IPv6 to
+// WC3, which only listens on IPv4. Games hosted by other machines on the
+// LAN are offered to peers through the guard too, which forwards their
+// joins to the host.
+type Guard struct {
+	listeners []net.Listener
+	registry  *game.Registry
+	acl       *ACL
+	impair    *impair.Impairer
+	capture   *capture.Recorder
+	idle      time.Duration
+	copier    copier
+	port      int
+}
+
+// NewGuard creates a guard listening on the Tailscale IPs localIPs, all on
+// the same port, admitting the joins acl allows.
+// If imp is non-nil, relayed traffic is impaired in both directions.
+func NewGuard(
+	ctx context.Context,
+	registry *game.Registry,
+	localIPs []netip.Addr,
+	acl *ACL,
+	imp *impair.Impairer,
+) (*Guard, error) {
+	g := &Guard{
+		registry: registry,
+		acl:      acl,
+		impair:   imp,
+	}
+
+	lc := &net.ListenConfig{}
+
+	for _, ip := range localIPs {
+		// The first listener picks the port, the rest reuse it
+		listener, err := lc.Listen(ctx, "tcp", netip.AddrPortFrom(ip, safePort(g.port)).String())
+		if err != nil {
+			_ = g.Close()
+
+			return nil, fmt.Errorf("failed to create guard listener on %s: %w", ip, err)
+		}
+
+		g.listeners = append(g.listeners, listener)
+
+		addr, ok := listener.Addr().(*net.TCPAddr)
+		if !ok {
+			_ = g.Close()
+
+			return nil, ErrUnexpectedListenerType
+		}
+
+		g.port = addr.Port
+	}
+
+	return g, nil
+}
+
+// Port returns the port the guard is listening on.
+func (g *Guard) Port() int {
+	return g.port
+}
+
+// SetIdleTimeout sets how long a relay may go without data from either
+// side before it is closed; zero keeps idle relays open.
+// It must be called before Run.
+func (g *Guard) SetIdleTimeout(timeout time.Duration) {
+	g.idle = timeout
+}
+
+// SetBufferPool sets the pool relay buffers are taken from.
+// It must be called before Run.
+func (g *Guard) SetBufferPool(bufs *BufferPool) {
+	g.copier.buffers = bufs
+}
+
+// SetSplice sets whether relays splice sockets in the kernel on Linux
+// when nothing needs to see the traffic. It must be called before Run.
+func (g *Guard) SetSplice(enabled bool) {
+	g.copier.splice = enabled
+}
+
+// SetCapture sets the recorder capturing guarded traffic.
+// It must be called before Run.
+func (g *Guard) SetCapture(rec *capture.Recorder) {
+	g.capture = rec
+}
+
+// Run accepts and authorizes connections until the context is cancelled.
+func (g *Guard) Run(ctx context.Context) error {
+	for _, listener := range g.listeners {
+		go g.acceptLoop(ctx, listener)
+	}
+
+	<-ctx.Done()
+
+	_ = g.Close()
+
+	return ctx.Err()
+}
+
+// Close closes all listeners.
+func (g *Guard) Close() error {
+	errs := make([]error, 0, len(g.listeners))
+
+	for _, listener := range g.listeners {
+		errs = append(errs, listener.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// acceptLoop accepts connections from listener until it is closed.
+func (g *Guard) acceptLoop(ctx context.Context, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			slog.Error("failed to accept guarded connection", "error", err)
+
+			continue
+		}
+
+		go g.handleConnection(ctx, conn)
+	}
+}
+
+// handleConnection authorizes a single join and relays it to the local game.
+func (g *Guard) handleConnection(ctx context.Context, clientConn net.Conn) {
+	defer func() { _ = clientConn.Close() }()
+
+	// Proxies of other wc3ts instances may ask to compress the session
+	conn, err := acceptHello(clientConn)
+	if err != nil {
+		slog.Debug("failed to read first packet", "client", clientConn.RemoteAddr(), "error", err)
+
+		return
+	}
+
+	clientConn = g.capture.Conn(conn, "guard")
+
+	joinPkt, initialPacket, err := readJoinPacket(clientConn)
+	if err != nil {
+		slog.Debug("failed to read Join packet",
+			"client", clientConn.RemoteAddr(),
+			"error", err,
+		)
+
+		return
+	}
+
+	localGame, host := g.target(joinPkt.HostCounter)
+	if localGame == nil {
+		slog.Warn("no local game found for guarded join",
+			"client", clientConn.RemoteAddr(),
+			"hostCounter", joinPkt.HostCounter,
+		)
+
+		return
+	}
+
+	// LAN games are offered under their LocalCounter; restore the host's
+	if localGame.Source == game.SourceLAN {
+		initialPacket, err = packet.WithJoinHostCounter(initialPacket, localGame.Info.HostCounter)
+		if err != nil {
+			slog.Warn("dropping malformed guarded join", "client", clientConn.RemoteAddr(), "error", err)
+
+			return
+		}
+	}
+
+	gameName := localGame.Info.GameName
+
+	who, ok := g.acl.authorize(ctx, clientConn, gameName)
+	if !ok {
+		slog.Warn("rejected join: not on allowlist",
+			"game", gameName,
+			"who", who,
+			"player", joinPkt.PlayerName,
+		)
+
+		return
+	}
+
+	slog.Info("authorized join", "game", gameName, "who", who, "player", joinPkt.PlayerName)
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	hostConn, err := dialer.DialContext(ctx, "tcp4", host.String())
+	if err != nil {
+		slog.Error("failed to connect to local game", "game", gameName, "error", err)
+
+		return
+	}
+
+	hostConn = g.capture.Conn(hostConn, "guard")
+
+	defer func() { _ = hostConn.Close() }()
+
+	_, err = hostConn.Write(initialPacket)
+	if err != nil {
+		slog.Error("failed to forward Join packet", "error", err)
+
+		return
+	}
+
+	idle := newIdleTimer(g.idle, func() {
+		slog.Info("closing idle guarded connection", "game", gameName, "who", who, "timeout", g.idle)
+
+		_ = clientConn.Close()
+		_ = hostConn.Close()
+	})
+	defer idle.stop()
+
+	relay(g.impair.Conn(idle.wrap(clientConn)), g.impair.Conn(idle.wrap(hostConn)), nil, g.copier)
+}
+
+// target finds the game a guarded join with hostCounter is addressed to
+// and the address of its host: a local game on localhost, or a bridged
+// LAN game, offered under its LocalCounter, on its machine.
+func (g *Guard) target(hostCounter uint32) (*game.Game, netip.AddrPort) {
+	if local := g.registry.FindLocalByHostCounter(hostCounter); local != nil {
+		return local, netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), local.Info.GamePort)
+	}
+
+	if bridged := g.registry.FindLANByLocalCounter(hostCounter); bridged != nil {
+		return bridged, netip.AddrPortFrom(bridged.PeerIP, bridged.Info.GamePort)
+	}
+
+	return nil, netip.AddrPort{}
+}
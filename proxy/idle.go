@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// idleTimer calls onIdle once nothing has been read from any one of its
+// connections for the timeout, so relays to a vanished client or a crashed
+// host are torn down instead of blocking forever. Both sides of a game
+// keep talking, even in the lobby, so either one going quiet means the
+// relay is dead. A nil idleTimer never fires.
+type idleTimer struct {
+	timeout time.Duration
+	start   time.Time
+	conns   []*idleConn
+	timer   *time.Timer
+	onIdle  func()
+	mu      sync.Mutex
+}
+
+// newIdleTimer starts an idle timer, or returns nil if timeout is zero.
+func newIdleTimer(timeout time.Duration, onIdle func()) *idleTimer {
+	if timeout <= 0 {
+		return nil
+	}
+
+	t := &idleTimer{timeout: timeout, start: time.Now(), onIdle: onIdle}
+	t.timer = time.AfterFunc(timeout, t.check)
+
+	return t
+}
+
+// check fires onIdle if the timeout has passed since the last read of the
+// quietest connection, and otherwise waits for the rest of it. Reads never
+// touch the timer itself.
+func (t *idleTimer) check() {
+	t.mu.Lock()
+
+	oldest := time.Now()
+	if len(t.conns) == 0 {
+		oldest = t.start
+	}
+
+	for _, c := range t.conns {
+		if last := time.Unix(0, c.last.Load()); last.Before(oldest) {
+			oldest = last
+		}
+	}
+
+	t.mu.Unlock()
+
+	remaining := time.Until(oldest.Add(t.timeout))
+	if remaining > 0 {
+		t.timer.Reset(remaining)
+
+		return
+	}
+
+	t.onIdle()
+}
+
+// stop stops the timer. It is safe to call on a nil idleTimer.
+func (t *idleTimer) stop() {
+	if t == nil {
+		return
+	}
+
+	t.timer.Stop()
+}
+
+// wrap returns conn with its reads resetting the timer.
+func (t *idleTimer) wrap(conn net.Conn) net.Conn {
+	if t == nil {
+		return conn
+	}
+
+	c := &idleConn{Conn: conn}
+	c.last.Store(time.Now().UnixNano())
+
+	t.mu.Lock()
+	t.conns = append(t.conns, c)
+	t.mu.Unlock()
+
+	return c
+}
+
+// idleConn records when it last read data.
+type idleConn struct {
+	net.Conn
+
+	last atomic.Int64 // unix nanoseconds of the last read
+}
+
+// Read marks the connection active whenever data arrives.
+func (c *idleConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.last.Store(time.Now().UnixNano())
+	}
+
+	return n, err
+}
+
+// CloseWrite half-closes the connection if it supports it.
+func (c *idleConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"encoding/binary"
+
+	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/packet"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// playerFunc is called when a player joins the lobby, or leaves it with
+// an empty name.
+type playerFunc func(id uint8, name string)
+
+// inspector watches the host-to-client W3GS stream of a proxied session and
+// reports the game's lifecycle state from the countdown packets, and the
+// lobby's players from the player and slot packets. It only reads the
+// stream; the relayed bytes are never modified.
+type inspector struct {
+	buf      []byte
+	self     string
+	onState  func(game.State)
+	onPlayer playerFunc
+	done     bool
+}
+
+// newInspector creates an inspector calling onState on state changes and
+// onPlayer as players join and leave. self is the name the session's own
+// player joined with, which the host only reports by player ID.
+func newInspector(self string, onState func(game.State), onPlayer playerFunc) *inspector {
+	return &inspector{self: self, onState: onState, onPlayer: onPlayer}
+}
+
+// Write consumes a chunk of the stream. It never fails so the relay is not
+// affected if the stream cannot be parsed.
+func (i *inspector) Write(p []byte) (int, error) {
+	if i.done {
+		return len(p), nil
+	}
+
+	i.buf = append(i.buf, p...)
+
+	for len(i.buf) >= packet.HeaderSize {
+		length := int(binary.LittleEndian.Uint16(i.buf[2:4]))
+
+		// Stop inspecting rather than guess at an unframed stream
+		if i.buf[0] != w3gs.ProtocolSig || length < packet.HeaderSize {
+			i.stop()
+
+			break
+		}
+
+		if len(i.buf) < length {
+			break
+		}
+
+		switch i.buf[1] {
+		case w3gs.PidSlotInfoJoin, w3gs.PidPlayerInfo, w3gs.PidPlayerLeft:
+			i.player(i.buf[:length])
+		case w3gs.PidCountDownStart:
+			i.onState(game.StateStarting)
+		case w3gs.PidCountDownEnd:
+			i.onState(game.StateInProgress)
+
+			// Nothing more to learn once the game has loaded
+			i.stop()
+
+			return len(p), nil
+		}
+
+		i.buf = i.buf[length:]
+	}
+
+	return len(p), nil
+}
+
+// player reports the player joining or leaving in a lobby packet.
+// Packets that fail to parse are skipped.
+func (i *inspector) player(data []byte) {
+	pkt, _, err := w3gs.Deserialize(data, w3gs.Encoding{})
+	if err != nil {
+		return
+	}
+
+	switch pkt := pkt.(type) {
+	case *w3gs.SlotInfoJoin:
+		i.onPlayer(pkt.PlayerID, i.self)
+	case *w3gs.PlayerInfo:
+		i.onPlayer(pkt.PlayerID, pkt.PlayerName)
+	case *w3gs.PlayerLeft:
+		i.onPlayer(pkt.PlayerID, "")
+	}
+}
+
+// finished reports whether inspection has ended, so the rest of the
+// stream may bypass the inspector.
+func (i *inspector) finished() bool {
+	return i.done
+}
+
+// stop ends inspection and releases the buffer.
+func (i *inspector) stop() {
+	i.done = true
+	i.buf = nil
+}
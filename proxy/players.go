@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"maps"
+	"slices"
+)
+
+// setPlayer records the player with id in the lobby of the game with key,
+// or removes it if name is empty, and publishes the game's player list.
+// Every session to a game sees the same lobby, so their reports are merged.
+func (p *TCPProxy) setPlayer(key string, id uint8, name string) {
+	p.playersMu.Lock()
+	defer p.playersMu.Unlock()
+
+	roster := p.players[key]
+	if roster == nil {
+		roster = make(map[uint8]string)
+		p.players[key] = roster
+	}
+
+	if name == "" {
+		delete(roster, id)
+	} else {
+		roster[id] = name
+	}
+
+	p.registry.SetPlayers(key, playerNames(roster))
+}
+
+// clearPlayers forgets the players of the game with key once no session
+// is left to report them.
+func (p *TCPProxy) clearPlayers(key string) {
+	p.playersMu.Lock()
+	defer p.playersMu.Unlock()
+
+	delete(p.players, key)
+	p.registry.SetPlayers(key, nil)
+}
+
+// playerNames returns the names in roster ordered by player ID, which is
+// the order the host assigned them in.
+func playerNames(roster map[uint8]string) []string {
+	if len(roster) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(roster))
+	for _, id := range slices.Sorted(maps.Keys(roster)) {
+		names = append(names, roster[id])
+	}
+
+	return names
+}
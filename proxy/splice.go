@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"io"
+	"net"
+)
+
+// finisher is implemented by observers that stop needing the stream.
+type finisher interface {
+	finished() bool
+}
+
+// copier copies one direction of a relay.
+type copier struct {
+	buffers *BufferPool
+
+	// splice moves data between sockets in the kernel where possible.
+	splice bool
+}
+
+// copy copies src to dst until EOF. If observe is non-nil, the copied data
+// is also written to it. With splicing enabled and both ends bare TCP
+// sockets, data is copied through userspace only while observe still
+// needs it; io.Copy then splices the rest in the kernel on Linux.
+func (c copier) copy(dst, src net.Conn, observe io.Writer) (int64, error) {
+	f, ok := observe.(finisher)
+
+	if !c.splice || !spliceable(dst, src) || (observe != nil && !ok) {
+		if observe != nil {
+			return c.buffers.copy(dst, io.TeeReader(src, observe))
+		}
+
+		return c.buffers.copy(dst, src)
+	}
+
+	var written int64
+
+	if observe != nil {
+		n, eof, err := c.buffers.copyUntil(dst, src, observe, f.finished)
+
+		written += n
+		if eof || err != nil {
+			return written, err
+		}
+	}
+
+	n, err := io.Copy(dst, src)
+
+	return written + n, err
+}
+
+// spliceable reports whether all conns are bare TCP sockets, which the
+// kernel can move data between. Wrapped ones, such as throttled, counted
+// or idle-watched connections, need the data in userspace.
+func spliceable(conns ...net.Conn) bool {
+	for _, conn := range conns {
+		if _, ok := conn.(*net.TCPConn); !ok {
+			return false
+		}
+	}
+
+	return true
+}
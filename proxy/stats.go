@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kradalby/wc3ts/packet"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
+)
+
+// flowStats counts the bytes and packets of one direction of a relay.
+type flowStats struct {
+	bytes   atomic.Uint64
+	packets atomic.Uint64
+
+	mu     sync.Mutex
+	frames frameCounter
+}
+
+// add counts a chunk of the stream.
+func (s *flowStats) add(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+
+	s.bytes.Add(uint64(len(p))) //nolint:gosec // lengths are never negative
+
+	s.mu.Lock()
+	n := s.frames.count(p)
+	s.mu.Unlock()
+
+	if n > 0 {
+		s.packets.Add(uint64(n)) //nolint:gosec // counts are never negative
+	}
+}
+
+// frameCounter counts the complete W3GS and GProxy++ frames of a stream
+// that arrives in chunks of any size. If the stream stops looking framed,
+// counting stops rather than guessing.
+type frameCounter struct {
+	header [packet.HeaderSize]byte
+	have   int  // header bytes buffered
+	remain int  // body bytes left of the current frame
+	lost   bool // framing was lost
+}
+
+// count consumes p and returns the number of frames it completed.
+func (f *frameCounter) count(p []byte) int {
+	n := 0
+
+	for len(p) > 0 && !f.lost {
+		if f.remain > 0 {
+			k := min(f.remain, len(p))
+			f.remain -= k
+			p = p[k:]
+
+			if f.remain == 0 {
+				n++
+			}
+
+			continue
+		}
+
+		k := copy(f.header[f.have:], p)
+		f.have += k
+		p = p[k:]
+
+		if f.have < packet.HeaderSize {
+			break
+		}
+
+		f.have = 0
+
+		length := int(binary.LittleEndian.Uint16(f.header[2:4]))
+		if (f.header[0] != w3gs.ProtocolSig && f.header[0] != packet.GPSSig) || length < packet.HeaderSize {
+			f.lost = true
+
+			break
+		}
+
+		f.remain = length - packet.HeaderSize
+		if f.remain == 0 {
+			n++
+		}
+	}
+
+	return n
+}
@@ -7,12 +7,19 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net"
-	"strconv"
+	"net/netip"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/kradalby/wc3ts/capture"
 	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/history"
+	"github.com/kradalby/wc3ts/impair"
+	"github.com/kradalby/wc3ts/packet"
 	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
 )
 
@@ -22,9 +29,6 @@ const relayGoroutines = 2
 // Default timeout for connecting to remote hosts.
 const dialTimeout = 10 * time.Second
 
-// maxJoinPacketSize is the maximum expected size of a Join packet.
-const maxJoinPacketSize = 512
-
 // readTimeout is the timeout for reading the initial Join packet.
 const readTimeout = 5 * time.Second
 
@@ -37,38 +41,164 @@ var ErrUnexpectedListenerType = errors.New("unexpected listener address type")
 // ErrUnexpectedPacketType is returned when the first packet is not a Join packet.
 var ErrUnexpectedPacketType = errors.New("expected Join packet")
 
+// closeWriter is implemented by connections that support half-close.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// JoinFunc is called when a player's join to a remote game is proxied.
+type JoinFunc func(g game.Game, player string, client net.Addr)
+
 // TCPProxy proxies TCP connections to remote game hosts.
 type TCPProxy struct {
-	listener net.Listener
-	registry *game.Registry
-	port     int
+	listeners []net.Listener
+	bindAddrs []netip.Addr
+	registry  *game.Registry
+	impair    *impair.Impairer
+	history   *history.Recorder
+	audit     *history.AuditLog
+	capture   *capture.Recorder
+	udp       *UDPRelay
+	conns     *ConnectionTracker
+	acl       *ACL
+	copier    copier
+	tcp       TCPOptions
+	onJoin    JoinFunc
+	sessions  map[string]int // game key -> active proxied sessions
+
+	// connRate caps each connection per direction; upload and download
+	// cap traffic to and from all hosts together.
+	connRate int
+	upload   *Limiter
+	download *Limiter
+
+	// reconnectWait is how long GProxy++ clients may take to reconnect;
+	// zero disables reconnecting.
+	reconnectWait time.Duration
+	idleTimeout   time.Duration
+	reconnects    map[uint32]*gpsSession // reconnect key -> session
+	active        sync.WaitGroup         // open client connections
+	open          atomic.Int32
+	maxConns      int          // open connection limit, zero for none
+	rejected      atomic.Int64 // connections rejected since the last admitted
+	port          int
+	mu            sync.Mutex
+
+	// gameUpdates carries the games to keep dedicated listeners for to
+	// Run; nil when per-game ports are disabled. gameListeners holds them
+	// by game key and is guarded by mu.
+	gameUpdates   chan []game.Game
+	gameListeners map[string]*gameListener
+
+	// relayed reports relayed Tailscale paths, over which sessions to
+	// the hosts guarded reports are compressed; nil disables compression.
+	relayed RelayedFunc
+	guarded GuardFunc
+
+	// players holds the lobby's players by ID per game key, as reported by
+	// the sessions' inspectors.
+	players   map[string]map[uint8]string
+	playersMu sync.Mutex
 }
 
-// NewTCPProxy creates a new TCP proxy.
-func NewTCPProxy(ctx context.Context, registry *game.Registry) (*TCPProxy, error) {
-	// Listen on all interfaces with a random available port.
+// NewTCPProxy creates a new TCP proxy listening on bindAddrs.
+// An empty bindAddrs listens on all interfaces. All listeners share one port.
+// A non-zero port pins it, falling back to a random port if it is taken.
+// If imp is non-nil, relayed traffic is impaired in both directions.
+func NewTCPProxy(
+	ctx context.Context,
+	registry *game.Registry,
+	bindAddrs []netip.Addr,
+	port int,
+	imp *impair.Impairer,
+) (*TCPProxy, error) {
+	// Listen on all interfaces by default.
 	// This is required because WC3 connects to the source IP of the UDP broadcast,
 	// which is the LAN interface, not localhost.
-	lc := &net.ListenConfig{}
+	if len(bindAddrs) == 0 {
+		bindAddrs = []netip.Addr{netip.IPv4Unspecified()}
+	}
+
+	p := &TCPProxy{
+		bindAddrs:  bindAddrs,
+		registry:   registry,
+		impair:     imp,
+		sessions:   make(map[string]int),
+		players:    make(map[string]map[uint8]string),
+		reconnects: make(map[uint32]*gpsSession),
+		conns:      NewConnectionTracker(),
+		tcp:        TCPOptions{NoDelay: true, KeepAlive: defaultKeepAlive},
+		upload:     &Limiter{last: time.Now()},
+		download:   &Limiter{last: time.Now()},
+		port:       port,
+	}
+
+	err := p.listen(ctx, bindAddrs)
+	if err != nil && port != 0 && errors.Is(err, syscall.EADDRINUSE) {
+		slog.Warn("TCP proxy port is taken, using a random port", "port", port, "error", err)
+
+		p.port = 0
+		err = p.listen(ctx, bindAddrs)
+	}
 
-	listener, err := lc.Listen(ctx, "tcp", "0.0.0.0:0")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create TCP listener: %w", err)
+		return nil, err
 	}
 
-	// Extract the port from the listener address
-	addr, ok := listener.Addr().(*net.TCPAddr)
-	if !ok {
-		_ = listener.Close()
+	return p, nil
+}
 
-		return nil, ErrUnexpectedListenerType
+// listen opens the shared listeners on each of bindAddrs.
+func (p *TCPProxy) listen(ctx context.Context, bindAddrs []netip.Addr) error {
+	listeners, port, err := listenAll(ctx, bindAddrs, p.port)
+	if err != nil {
+		return err
 	}
 
-	return &TCPProxy{
-		listener: listener,
-		registry: registry,
-		port:     addr.Port,
-	}, nil
+	p.listeners = listeners
+	p.port = port
+
+	return nil
+}
+
+// listenAll opens a listener on each of bindAddrs, closing them all on error.
+// It returns the listeners and the port they share.
+func listenAll(ctx context.Context, bindAddrs []netip.Addr, port int) ([]net.Listener, int, error) {
+	lc := &net.ListenConfig{}
+
+	var listeners []net.Listener
+
+	closeAll := func() {
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+	}
+
+	for _, ip := range bindAddrs {
+		// The first listener picks the port unless pinned, the rest reuse it
+		listener, err := lc.Listen(ctx, "tcp4", netip.AddrPortFrom(ip, safePort(port)).String())
+		if err != nil {
+			closeAll()
+
+			return nil, 0, fmt.Errorf("failed to create TCP listener on %s: %w", ip, err)
+		}
+
+		listeners = append(listeners, listener)
+
+		// Extract the port from the listener address
+		addr, ok := listener.Addr().(*net.TCPAddr)
+		if !ok {
+			closeAll()
+
+			return nil, 0, ErrUnexpectedListenerType
+		}
+
+		port = addr.Port
+
+		slog.Debug("TCP proxy listening", "addr", listener.Addr())
+	}
+
+	return listeners, port, nil
 }
 
 // Port returns the port the proxy is listening on.
@@ -76,26 +206,144 @@ func (p *TCPProxy) Port() int {
 	return p.port
 }
 
+// SetHistory sets the recorder for finished proxy sessions.
+// It must be called before Run.
+func (p *TCPProxy) SetHistory(rec *history.Recorder) {
+	p.history = rec
+}
+
+// SetAuditLog sets the log every join is recorded in.
+// It must be called before Run.
+func (p *TCPProxy) SetAuditLog(log *history.AuditLog) {
+	p.audit = log
+}
+
+// SetCapture sets the recorder capturing proxied traffic.
+// It must be called before Run.
+func (p *TCPProxy) SetCapture(rec *capture.Recorder) {
+	p.capture = rec
+}
+
+// Connections returns the tracker of the proxy's active connections.
+func (p *TCPProxy) Connections() *ConnectionTracker {
+	return p.conns
+}
+
+// SetUDPRelay sets the relay that forwards in-game datagrams of proxied
+// clients to their hosts. It must be called before Run.
+func (p *TCPProxy) SetUDPRelay(relay *UDPRelay) {
+	p.udp = relay
+}
+
+// SetReconnectWait lets clients using GProxy++ reconnect to a proxied game
+// within wait after their connection drops. Zero disables reconnecting.
+// It must be called before Run.
+func (p *TCPProxy) SetReconnectWait(wait time.Duration) {
+	p.reconnectWait = wait
+}
+
+// SetIdleTimeout sets how long a relay may go without data from either
+// side before it is closed; zero keeps idle relays open.
+// It must be called before Run.
+func (p *TCPProxy) SetIdleTimeout(timeout time.Duration) {
+	p.idleTimeout = timeout
+}
+
+// SetBufferPool sets the pool relay buffers are taken from.
+// It must be called before Run.
+func (p *TCPProxy) SetBufferPool(bufs *BufferPool) {
+	p.copier.buffers = bufs
+}
+
+// SetMaxConnections limits the open client connections; more are closed
+// as soon as they are accepted. Zero removes the limit.
+// It must be called before Run.
+func (p *TCPProxy) SetMaxConnections(limit int) {
+	p.maxConns = limit
+}
+
+// SetTCPOptions sets how the client and host sockets of proxied
+// connections are tuned. It must be called before Run.
+func (p *TCPProxy) SetTCPOptions(opts TCPOptions) {
+	p.tcp = opts
+}
+
+// SetSplice sets whether relays splice sockets in the kernel on Linux
+// when nothing needs to see the traffic. It must be called before Run.
+func (p *TCPProxy) SetSplice(enabled bool) {
+	p.copier.splice = enabled
+}
+
+// SetThrottle caps each proxied connection at perConn and all of them
+// together at total bytes per second in each direction; zero disables a
+// limit. It may be called while running: the total applies at once, the
+// per-connection limit to new connections.
+func (p *TCPProxy) SetThrottle(perConn, total int) {
+	p.mu.Lock()
+	p.connRate = perConn
+	p.mu.Unlock()
+
+	p.upload.SetRate(total)
+	p.download.SetRate(total)
+}
+
+// SetACL sets the allowlist for joins from tailnet addresses, for when the
+// proxy is reachable over Tailscale. Clients on the LAN are not checked.
+// It must be called before Run.
+func (p *TCPProxy) SetACL(acl *ACL) {
+	p.acl = acl
+}
+
+// SetJoinFunc sets a function called for every proxied join.
+// It must be called before Run.
+func (p *TCPProxy) SetJoinFunc(fn JoinFunc) {
+	p.onJoin = fn
+}
+
 // Run starts accepting connections and proxying them.
 // It blocks until the context is cancelled.
 func (p *TCPProxy) Run(ctx context.Context) error {
 	// Accept connections in background
-	go p.acceptLoop(ctx)
+	for _, listener := range p.listeners {
+		go p.acceptLoop(ctx, listener, "")
+	}
 
-	<-ctx.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			p.syncGameListeners(ctx, nil)
 
-	return p.Close()
+			return p.Close()
+		case games := <-p.gameUpdates:
+			p.syncGameListeners(ctx, games)
+		}
+	}
 }
 
-// Close stops the proxy and closes all connections.
+// Close stops the proxy and closes all listeners.
 func (p *TCPProxy) Close() error {
-	return p.listener.Close()
+	errs := make([]error, 0, len(p.listeners))
+
+	for _, listener := range p.listeners {
+		errs = append(errs, listener.Close())
+	}
+
+	return errors.Join(errs...)
 }
 
-// acceptLoop accepts incoming connections.
-func (p *TCPProxy) acceptLoop(ctx context.Context) {
+// safePort converts a listener port to uint16, returning 0 when out of range.
+func safePort(port int) uint16 {
+	if port < 0 || port > math.MaxUint16 {
+		return 0
+	}
+
+	return uint16(port)
+}
+
+// acceptLoop accepts incoming connections on listener.
+func (p *TCPProxy) acceptLoop(ctx context.Context, listener net.Listener, key string) {
 	for {
-		conn, err := p.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			// Check if listener was closed
 			if errors.Is(err, net.ErrClosed) {
@@ -109,12 +357,90 @@ func (p *TCPProxy) acceptLoop(ctx context.Context) {
 			continue
 		}
 
-		go p.handleConnection(ctx, conn)
+		if !p.admit(conn) {
+			continue
+		}
+
+		p.active.Go(func() {
+			defer p.open.Add(-1)
+
+			p.handleConnection(ctx, conn, key)
+		})
 	}
 }
 
-// handleConnection handles a single client connection.
-func (p *TCPProxy) handleConnection(ctx context.Context, clientConn net.Conn) {
+// admit counts conn as open, or closes it if the proxy already has
+// maxConns open connections. Only the first rejection of a burst is
+// logged as a warning, so a port scan cannot flood the log.
+func (p *TCPProxy) admit(conn net.Conn) bool {
+	open := p.open.Add(1)
+	if p.maxConns <= 0 || int(open) <= p.maxConns {
+		if n := p.rejected.Swap(0); n > 0 {
+			slog.Info("accepting proxied connections again", "rejected", n)
+		}
+
+		return true
+	}
+
+	p.open.Add(-1)
+	_ = conn.Close()
+
+	if p.rejected.Add(1) == 1 {
+		slog.Warn("rejecting proxied connection: too many open connections",
+			"client", conn.RemoteAddr(),
+			"limit", p.maxConns,
+		)
+	} else {
+		slog.Debug("rejecting proxied connection", "client", conn.RemoteAddr(), "limit", p.maxConns)
+	}
+
+	return false
+}
+
+// Open returns the number of open client connections.
+func (p *TCPProxy) Open() int {
+	return int(p.open.Load())
+}
+
+// Drain waits up to timeout for open connections to finish, for use after
+// Run returns: closing the listeners does not end connections in progress.
+// It returns the number of connections still open.
+func (p *TCPProxy) Drain(timeout time.Duration) int {
+	done := make(chan struct{})
+
+	go func() {
+		p.active.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return 0
+	case <-time.After(timeout):
+		return p.Open()
+	}
+}
+
+// handleConnection handles a single client connection. Connections to a
+// game's dedicated port carry its key; on the shared port key is empty and
+// the game is found by the Join's HostCounter.
+func (p *TCPProxy) handleConnection(ctx context.Context, clientConn net.Conn, key string) {
+	p.tcp.apply(clientConn)
+
+	clientConn = p.capture.Conn(clientConn, "proxy")
+
+	slog.Info("received TCP connection",
+		"client", clientConn.RemoteAddr(),
+	)
+
+	initialPacket, err := readFirstPacket(clientConn)
+	if err == nil && isGPSReconnect(initialPacket) && p.reconnectWait > 0 {
+		// The session takes over the connection
+		p.resumeSession(clientConn, initialPacket)
+
+		return
+	}
+
 	defer func() {
 		err := clientConn.Close()
 		if err != nil {
@@ -122,12 +448,12 @@ func (p *TCPProxy) handleConnection(ctx context.Context, clientConn net.Conn) {
 		}
 	}()
 
-	slog.Info("received TCP connection",
-		"client", clientConn.RemoteAddr(),
-	)
+	// Parse the initial Join packet
+	var joinPkt *w3gs.Join
+	if err == nil {
+		joinPkt, err = parseJoinPacket(initialPacket)
+	}
 
-	// Read and parse the initial Join packet
-	joinPkt, initialPacket, err := p.readJoinPacket(clientConn)
 	if err != nil {
 		slog.Error("failed to read Join packet",
 			"client", clientConn.RemoteAddr(),
@@ -143,8 +469,7 @@ func (p *TCPProxy) handleConnection(ctx context.Context, clientConn net.Conn) {
 		"playerName", joinPkt.PlayerName,
 	)
 
-	// Find the game by HostCounter
-	remoteGame := p.registry.FindByHostCounter(joinPkt.HostCounter)
+	remoteGame := p.findGame(joinPkt, key)
 	if remoteGame == nil {
 		// Log all remote games for debugging
 		allGames := p.registry.Games()
@@ -160,6 +485,7 @@ func (p *TCPProxy) handleConnection(ctx context.Context, clientConn net.Conn) {
 		slog.Warn("no remote game found for HostCounter",
 			"client", clientConn.RemoteAddr(),
 			"hostCounter", joinPkt.HostCounter,
+			"gameKey", key,
 		)
 
 		return
@@ -168,21 +494,43 @@ func (p *TCPProxy) handleConnection(ctx context.Context, clientConn net.Conn) {
 	slog.Info("found remote game",
 		"game", remoteGame.Info.GameName,
 		"hostCounter", remoteGame.Info.HostCounter,
+		"localCounter", remoteGame.LocalCounter,
 		"peerIP", remoteGame.PeerIP,
 		"gamePort", remoteGame.Info.GamePort,
 	)
 
+	if !p.authorize(ctx, clientConn, joinPkt, remoteGame) {
+		p.refuse(clientConn, joinPkt, remoteGame, "not on join allowlist")
+
+		return
+	}
+
+	// The client joins the counter the game was rebroadcast under; the host
+	// only knows its own
+	if joinPkt.HostCounter != remoteGame.Info.HostCounter {
+		initialPacket, err = packet.WithJoinHostCounter(initialPacket, remoteGame.Info.HostCounter)
+		if err != nil {
+			slog.Warn("dropping malformed join", "client", clientConn.RemoteAddr(), "error", err)
+
+			return
+		}
+	}
+
 	// Connect to the remote host
-	remoteConn, err := p.connectToRemote(ctx, remoteGame)
+	remoteConn, hostIP, err := p.connectToRemote(ctx, remoteGame)
 	if err != nil {
 		slog.Error("failed to connect to remote game",
 			"game", remoteGame.Info.GameName,
 			"error", err,
 		)
 
+		p.refuse(clientConn, joinPkt, remoteGame, "host unreachable: "+err.Error())
+
 		return
 	}
 
+	remoteConn = p.capture.Conn(remoteConn, "proxy")
+
 	defer func() {
 		err := remoteConn.Close()
 		if err != nil {
@@ -192,6 +540,7 @@ func (p *TCPProxy) handleConnection(ctx context.Context, clientConn net.Conn) {
 
 	slog.Info("proxying connection",
 		"client", clientConn.RemoteAddr(),
+		"host", hostIP,
 		"game", remoteGame.Info.GameName,
 		"hostCounter", joinPkt.HostCounter,
 		"player", joinPkt.PlayerName,
@@ -202,47 +551,222 @@ func (p *TCPProxy) handleConnection(ctx context.Context, clientConn net.Conn) {
 	if err != nil {
 		slog.Error("failed to forward Join packet", "error", err)
 
+		p.refuse(clientConn, joinPkt, remoteGame, "host error: "+err.Error())
+
 		return
 	}
 
-	// Bidirectional relay for the rest of the traffic
-	p.relay(clientConn, remoteConn)
+	if p.onJoin != nil {
+		p.onJoin(*remoteGame, joinPkt.PlayerName, clientConn.RemoteAddr())
+	}
+
+	tracked := p.conns.add(clientConn.RemoteAddr(), joinPkt.PlayerName, remoteGame)
+	defer p.conns.remove(tracked)
+
+	p.mu.Lock()
+	perConn := p.connRate
+	p.mu.Unlock()
+
+	var idled atomic.Bool
+
+	idle := newIdleTimer(p.idleTimeout, func() {
+		slog.Info("closing idle proxied connection",
+			"client", clientConn.RemoteAddr(),
+			"game", remoteGame.Info.GameName,
+			"timeout", p.idleTimeout,
+		)
+
+		idled.Store(true)
+		_ = clientConn.Close()
+		_ = remoteConn.Close()
+	})
+	defer idle.stop()
+
+	hostConn := idle.wrap(throttle(remoteConn, NewLimiter(perConn), p.upload))
+	clientSide := throttle(clientConn, NewLimiter(perConn), p.download)
+
+	// Relays between bare sockets are spliced and counted once they end;
+	// any other is counted as it goes
+	spliced := p.copier.splice && p.reconnectWait <= 0 && p.impair == nil && spliceable(hostConn, clientSide)
+	if !spliced {
+		hostConn = tracked.wrap(hostConn)
+	}
+
+	start := time.Now()
+	key = remoteGame.Key()
+
+	p.sessionStarted(key)
+	defer p.sessionEnded(key)
+
+	if client, ok := clientConn.RemoteAddr().(*net.TCPAddr); ok {
+		host := netip.AddrPortFrom(hostIP, remoteGame.Info.GamePort)
+		defer p.udp.Route(client.AddrPort().Addr(), host)()
+	}
+
+	// Bidirectional relay for the rest of the traffic, following the
+	// game's lifecycle from the host's packets
+	observe := newInspector(joinPkt.PlayerName, func(state game.State) {
+		if p.registry.SetState(key, state) {
+			slog.Info("game state changed", "game", remoteGame.Info.GameName, "state", state)
+		}
+	}, func(id uint8, name string) {
+		p.setPlayer(key, id, name)
+	})
+
+	reason := "session closed"
+
+	if p.reconnectWait > 0 {
+		authorize := func(conn net.Conn) bool {
+			return p.authorize(ctx, conn, joinPkt, remoteGame)
+		}
+
+		p.relaySession(ctx, p.impair.Conn(clientSide), p.impair.Conn(hostConn), observe, authorize)
+	} else {
+		// GProxy++ sessions replace the client connection and wait for it
+		// themselves, so only plain relays watch the client
+		var sent, received int64
+
+		reason, sent, received = relay(p.impair.Conn(idle.wrap(clientSide)), p.impair.Conn(hostConn), observe, p.copier)
+
+		if spliced {
+			tracked.spliced(received, sent)
+		}
+	}
+
+	if idled.Load() {
+		reason = "idle timeout"
+	}
+
+	stats := tracked.snapshot()
+	end := time.Now()
+
+	slog.Info("proxied connection closed",
+		"client", clientConn.RemoteAddr(),
+		"game", remoteGame.Info.GameName,
+		"player", joinPkt.PlayerName,
+		"duration", end.Sub(start).Round(time.Second),
+		"bytesIn", stats.BytesIn,
+		"packetsIn", stats.PacketsIn,
+		"bytesOut", stats.BytesOut,
+		"packetsOut", stats.PacketsOut,
+		"reason", reason,
+	)
+
+	session := history.Session{
+		Start:      start,
+		End:        end,
+		Game:       remoteGame.Info.GameName,
+		Host:       remoteGame.PeerName,
+		PeerIP:     remoteGame.PeerIP,
+		Player:     joinPkt.PlayerName,
+		Client:     clientConn.RemoteAddr().String(),
+		Reason:     reason,
+		BytesIn:    stats.BytesIn,
+		BytesOut:   stats.BytesOut,
+		PacketsIn:  stats.PacketsIn,
+		PacketsOut: stats.PacketsOut,
+	}
+
+	p.history.RecordSession(session)
+	p.audit.Record(session)
 }
 
-// readJoinPacket reads and parses the initial Join packet from the client.
-func (p *TCPProxy) readJoinPacket(conn net.Conn) (*w3gs.Join, []byte, error) {
+// refuse records a join to g that was not proxied in the audit log.
+func (p *TCPProxy) refuse(clientConn net.Conn, join *w3gs.Join, g *game.Game, reason string) {
+	now := time.Now()
+
+	p.audit.Record(history.Session{
+		Start:  now,
+		End:    now,
+		Game:   g.Info.GameName,
+		Host:   g.PeerName,
+		PeerIP: g.PeerIP,
+		Player: join.PlayerName,
+		Client: clientConn.RemoteAddr().String(),
+		Reason: reason,
+	})
+}
+
+// sessionStarted counts a proxied session to the game with key.
+func (p *TCPProxy) sessionStarted(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sessions[key]++
+}
+
+// sessionEnded ends the game with key once its last proxied session closes
+// after the game started.
+func (p *TCPProxy) sessionEnded(key string) {
+	p.mu.Lock()
+	p.sessions[key]--
+	last := p.sessions[key] <= 0
+
+	if last {
+		delete(p.sessions, key)
+	}
+	p.mu.Unlock()
+
+	if !last {
+		return
+	}
+
+	p.clearPlayers(key)
+
+	if g, ok := p.registry.Get(key); ok && g.State.Started() {
+		p.registry.SetState(key, game.StateEnded)
+	}
+}
+
+// readFirstPacket reads the initial packet from the client: a Join, or a
+// GProxy++ reconnect.
+func readFirstPacket(conn net.Conn) ([]byte, error) {
 	// Set read deadline for the initial packet
 	err := conn.SetReadDeadline(time.Now().Add(readTimeout))
 	if err != nil {
-		return nil, nil, fmt.Errorf("set read deadline: %w", err)
+		return nil, fmt.Errorf("set read deadline: %w", err)
 	}
 
-	// Read the first packet
-	buf := make([]byte, maxJoinPacketSize)
-
-	n, err := conn.Read(buf)
+	// Read the first packet (header-validated, bounded size)
+	initialPacket, err := packet.ReadStream(conn)
 	if err != nil {
-		return nil, nil, fmt.Errorf("read packet: %w", err)
+		return nil, fmt.Errorf("read packet: %w", err)
 	}
 
 	// Clear the read deadline for future reads
 	err = conn.SetReadDeadline(time.Time{})
 	if err != nil {
-		return nil, nil, fmt.Errorf("clear read deadline: %w", err)
+		return nil, fmt.Errorf("clear read deadline: %w", err)
 	}
 
-	initialPacket := buf[:n]
+	return initialPacket, nil
+}
+
+// readJoinPacket reads and parses the initial Join packet from the client.
+func readJoinPacket(conn net.Conn) (*w3gs.Join, []byte, error) {
+	initialPacket, err := readFirstPacket(conn)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// Parse the packet
-	pkt, _, err := w3gs.Deserialize(initialPacket, w3gs.Encoding{})
+	joinPkt, err := parseJoinPacket(initialPacket)
 	if err != nil {
-		return nil, nil, fmt.Errorf("deserialize packet: %w", err)
+		return nil, nil, err
 	}
 
+	return joinPkt, initialPacket, nil
+}
+
+// parseJoinPacket parses the initial Join packet from the client.
+func parseJoinPacket(initialPacket []byte) (*w3gs.Join, error) {
 	// Expect a Join packet
-	joinPkt, ok := pkt.(*w3gs.Join)
-	if !ok {
-		return nil, nil, ErrUnexpectedPacketType
+	if packet.ID(initialPacket) != packet.IDJoin {
+		return nil, ErrUnexpectedPacketType
+	}
+
+	joinPkt, err := packet.ParseJoin(initialPacket)
+	if err != nil {
+		return nil, fmt.Errorf("parse Join packet: %w", err)
 	}
 
 	slog.Debug("received Join packet",
@@ -250,26 +774,66 @@ func (p *TCPProxy) readJoinPacket(conn net.Conn) (*w3gs.Join, []byte, error) {
 		"playerName", joinPkt.PlayerName,
 	)
 
-	return joinPkt, initialPacket, nil
+	return joinPkt, nil
 }
 
-// connectToRemote establishes a connection to the remote game host.
-func (p *TCPProxy) connectToRemote(ctx context.Context, g *game.Game) (net.Conn, error) {
-	remoteAddr := net.JoinHostPort(
-		g.PeerIP.String(),
-		strconv.Itoa(int(g.Info.GamePort)),
-	)
-
+// connectToRemote establishes a connection to the remote game host. If its
+// Tailscale address cannot be reached, the host's other known addresses are
+// tried in turn. It returns the address that answered.
+func (p *TCPProxy) connectToRemote(ctx context.Context, g *game.Game) (net.Conn, netip.Addr, error) {
 	dialer := &net.Dialer{
 		Timeout: dialTimeout,
 	}
 
-	return dialer.DialContext(ctx, "tcp", remoteAddr)
+	var errs []error
+
+	for i, ip := range append([]netip.Addr{g.PeerIP}, g.AltIPs...) {
+		remoteAddr := netip.AddrPortFrom(ip, g.Info.GamePort)
+
+		conn, err := p.dialHost(ctx, dialer, remoteAddr)
+		if err != nil {
+			slog.Debug("failed to reach host", "game", g.Info.GameName, "addr", remoteAddr, "error", err)
+
+			errs = append(errs, err)
+
+			continue
+		}
+
+		if i > 0 {
+			slog.Info("reached host on alternate address",
+				"game", g.Info.GameName,
+				"addr", remoteAddr,
+				"peerIP", g.PeerIP,
+			)
+		}
+
+		return conn, ip, nil
+	}
+
+	return nil, netip.Addr{}, errors.Join(errs...)
 }
 
-// relay copies data bidirectionally between two connections.
-func (p *TCPProxy) relay(conn1, conn2 net.Conn) {
-	var wg sync.WaitGroup
+// relay copies data bidirectionally between two connections with c.
+// If observe is non-nil, data read from conn2 is also written to it.
+// It returns why the relay ended, taken from the side that stopped first
+// (conn1 is the client and conn2 the host), and the bytes copied from
+// conn1 to conn2 and back.
+func relay(conn1, conn2 net.Conn, observe io.Writer, c copier) (string, int64, int64) {
+	var (
+		wg             sync.WaitGroup
+		once           sync.Once
+		reason         string
+		sent, received int64
+	)
+
+	ended := func(side string, err error) {
+		once.Do(func() {
+			reason = side + " closed"
+			if err != nil && !errors.Is(err, net.ErrClosed) {
+				reason = side + " error: " + err.Error()
+			}
+		})
+	}
 
 	wg.Add(relayGoroutines)
 
@@ -277,15 +841,19 @@ func (p *TCPProxy) relay(conn1, conn2 net.Conn) {
 	go func() {
 		defer wg.Done()
 
-		_, err := io.Copy(conn2, conn1)
+		var err error
+
+		sent, err = c.copy(conn2, conn1, nil)
 		if err != nil && !errors.Is(err, net.ErrClosed) {
 			slog.Debug("relay error (client -> remote)",
 				"error", err,
 			)
 		}
 
+		ended("client", err)
+
 		// Close the write side when done reading
-		if tc, ok := conn2.(*net.TCPConn); ok {
+		if tc, ok := conn2.(closeWriter); ok {
 			_ = tc.CloseWrite()
 		}
 	}()
@@ -294,18 +862,24 @@ func (p *TCPProxy) relay(conn1, conn2 net.Conn) {
 	go func() {
 		defer wg.Done()
 
-		_, err := io.Copy(conn1, conn2)
+		var err error
+
+		received, err = c.copy(conn1, conn2, observe)
 		if err != nil && !errors.Is(err, net.ErrClosed) {
 			slog.Debug("relay error (remote -> client)",
 				"error", err,
 			)
 		}
 
+		ended("host", err)
+
 		// Close the write side when done reading
-		if tc, ok := conn1.(*net.TCPConn); ok {
+		if tc, ok := conn1.(closeWriter); ok {
 			_ = tc.CloseWrite()
 		}
 	}()
 
 	wg.Wait()
+
+	return reason, sent, received
 }
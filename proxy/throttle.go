@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Limiter caps throughput with a token bucket holding up to one second of
+// traffic. A nil Limiter does not limit.
+type Limiter struct {
+	rate   float64 // bytes per second, zero for no limit
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+// NewLimiter creates a limiter allowing bytesPerSec, or returns nil if
+// bytesPerSec is not positive.
+func NewLimiter(bytesPerSec int) *Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+
+	return &Limiter{
+		rate:   float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// SetRate changes the allowed bytes per second; zero removes the limit.
+// It is safe to call on a nil Limiter, which stays unlimited.
+func (l *Limiter) SetRate(bytesPerSec int) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate = float64(max(bytesPerSec, 0))
+	l.tokens = min(l.tokens, l.rate)
+}
+
+// reserve takes n bytes from the bucket and returns how long to wait
+// before sending them. The bucket may go into debt, so writes larger than
+// the bucket are delayed rather than refused.
+func (l *Limiter) reserve(n int) time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate == 0 {
+		return 0
+	}
+
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// throttledConn delays writes to stay within its limiters.
+type throttledConn struct {
+	net.Conn
+
+	limiters []*Limiter
+}
+
+// throttle wraps conn so writes are limited by each non-nil limiter.
+func throttle(conn net.Conn, limiters ...*Limiter) net.Conn {
+	active := make([]*Limiter, 0, len(limiters))
+
+	for _, l := range limiters {
+		if l != nil {
+			active = append(active, l)
+		}
+	}
+
+	if len(active) == 0 {
+		return conn
+	}
+
+	return &throttledConn{Conn: conn, limiters: active}
+}
+
+// Write waits for the slowest limiter, then writes b.
+func (c *throttledConn) Write(b []byte) (int, error) {
+	c.wait(len(b))
+
+	return c.Conn.Write(b)
+}
+
+// wait sleeps until n bytes fit every limiter.
+func (c *throttledConn) wait(n int) {
+	var wait time.Duration
+
+	for _, l := range c.limiters {
+		wait = max(wait, l.reserve(n))
+	}
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// CloseWrite half-closes the connection if it supports it.
+func (c *throttledConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+
+	return nil
+}
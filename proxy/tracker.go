@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"cmp"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kradalby/wc3ts/game"
+)
+
+// Connection is a snapshot of an active proxied connection.
+type Connection struct {
+	ID uint64 `json:"id"`
+
+	// Client is the address of the local WC3 client.
+	Client string `json:"client"`
+
+	// Player is the player name from the client's Join packet, encoded
+	// like game names.
+	Player string `json:"player"`
+
+	// Game is the game name, encoded like game.Game names.
+	Game    string `json:"game"`
+	GameKey string `json:"gameKey"`
+
+	// Host is the name of the peer hosting the game.
+	Host   string     `json:"host"`
+	HostIP netip.Addr `json:"hostIP"`
+
+	// BytesIn and PacketsIn count traffic from the host, BytesOut and
+	// PacketsOut traffic to it. Spliced relays are counted in bytes once
+	// they end, without packets.
+	BytesIn    uint64 `json:"bytesIn"`
+	BytesOut   uint64 `json:"bytesOut"`
+	PacketsIn  uint64 `json:"packetsIn"`
+	PacketsOut uint64 `json:"packetsOut"`
+
+	Start time.Time `json:"start"`
+}
+
+// ConnectionTracker records the active relays of a TCP proxy.
+type ConnectionTracker struct {
+	mu     sync.Mutex
+	nextID uint64
+	conns  map[uint64]*trackedConn
+}
+
+// trackedConn is an active connection and its live traffic counters.
+type trackedConn struct {
+	info Connection
+	in   flowStats
+	out  flowStats
+}
+
+// NewConnectionTracker creates an empty tracker.
+func NewConnectionTracker() *ConnectionTracker {
+	return &ConnectionTracker{conns: make(map[uint64]*trackedConn)}
+}
+
+// Connections returns the active connections, oldest first.
+func (t *ConnectionTracker) Connections() []Connection {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conns := make([]Connection, 0, len(t.conns))
+
+	for _, tc := range t.conns {
+		conns = append(conns, tc.snapshot())
+	}
+
+	slices.SortFunc(conns, func(a, b Connection) int {
+		return cmp.Or(a.Start.Compare(b.Start), cmp.Compare(a.ID, b.ID))
+	})
+
+	return conns
+}
+
+// Len returns the number of active connections.
+func (t *ConnectionTracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.conns)
+}
+
+// add starts tracking a connection of client as player to g.
+func (t *ConnectionTracker) add(client net.Addr, player string, g *game.Game) *trackedConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.nextID++
+
+	tc := &trackedConn{info: Connection{
+		ID:      t.nextID,
+		Client:  client.String(),
+		Player:  player,
+		Game:    g.Info.GameName,
+		GameKey: g.Key(),
+		Host:    g.PeerName,
+		HostIP:  g.PeerIP,
+		Start:   time.Now(),
+	}}
+
+	t.conns[tc.info.ID] = tc
+
+	return tc
+}
+
+// remove stops tracking tc.
+func (t *ConnectionTracker) remove(tc *trackedConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.conns, tc.info.ID)
+}
+
+// snapshot returns the connection with its current counters.
+func (tc *trackedConn) snapshot() Connection {
+	c := tc.info
+	c.BytesIn = tc.in.bytes.Load()
+	c.BytesOut = tc.out.bytes.Load()
+	c.PacketsIn = tc.in.packets.Load()
+	c.PacketsOut = tc.out.packets.Load()
+
+	return c
+}
+
+// wrap returns the host connection of tc, counting the bytes and packets
+// read from and written to it. Counting on the host side keeps the totals across
+// client reconnects.
+func (tc *trackedConn) wrap(conn net.Conn) net.Conn {
+	return &countingConn{Conn: conn, tc: tc}
+}
+
+// spliced counts the bytes of a relay that bypassed the host connection
+// wrapper to be spliced.
+func (tc *trackedConn) spliced(in, out int64) {
+	tc.in.bytes.Add(uint64(in))   //nolint:gosec // Copied byte counts are never negative
+	tc.out.bytes.Add(uint64(out)) //nolint:gosec // Copied byte counts are never negative
+}
+
+// countingConn counts the traffic of a host connection.
+type countingConn struct {
+	net.Conn
+
+	tc *trackedConn
+}
+
+// Read counts traffic received from the host.
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.tc.in.add(b[:n])
+
+	return n, err
+}
+
+// Write counts traffic sent to the host.
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.tc.out.add(b[:n])
+
+	return n, err
+}
+
+// CloseWrite half-closes the host connection if it supports it.
+func (c *countingConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+
+	return nil
+}
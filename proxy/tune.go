@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/kradalby/wc3ts/lan"
+)
+
+// defaultKeepAlive matches the keepalive interval Go uses by default.
+const defaultKeepAlive = 15 * time.Second
+
+// TCPOptions tunes the sockets of proxied connections.
+type TCPOptions struct {
+	// NoDelay disables Nagle's algorithm so the small W3GS packets are
+	// sent at once rather than coalesced, which stutters over DERP.
+	NoDelay bool
+
+	// KeepAlive is the interval of keepalive probes, which detect peers
+	// that vanished without closing. Zero disables keepalives.
+	KeepAlive time.Duration
+
+	// DSCP is the codepoint the sockets' packets are marked with, so
+	// routers with QoS prioritize them. Zero leaves them unmarked.
+	DSCP int
+}
+
+// apply sets the options on conn if it is a TCP connection.
+func (o TCPOptions) apply(conn net.Conn) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	err := tcp.SetNoDelay(o.NoDelay)
+	if err == nil {
+		err = tcp.SetKeepAlive(o.KeepAlive > 0)
+	}
+
+	if err == nil && o.KeepAlive > 0 {
+		err = tcp.SetKeepAlivePeriod(o.KeepAlive)
+	}
+
+	if err == nil {
+		err = lan.SetDSCP(tcp, o.DSCP)
+	}
+
+	if err != nil {
+		slog.Debug("failed to tune TCP connection", "addr", conn.RemoteAddr(), "error", err)
+	}
+}
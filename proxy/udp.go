@@ -0,0 +1,374 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kradalby/wc3ts/capture"
+	"github.com/kradalby/wc3ts/impair"
+	"github.com/kradalby/wc3ts/lan"
+	"github.com/kradalby/wc3ts/packet"
+)
+
+// udpIdleTimeout is how long a UDP mapping is kept without traffic in
+// either direction.
+const udpIdleTimeout = 2 * time.Minute
+
+// udpBufferSize fits any UDP datagram, so none is relayed truncated.
+const udpBufferSize = packet.MaxDatagramSize
+
+// udpComponent names the relay in captures.
+const udpComponent = "udp-relay"
+
+// errNoUDPRoute is returned for datagrams from clients not in a game.
+var errNoUDPRoute = errors.New("no proxied game for client")
+
+// UDPRelay relays W3GS datagrams that the local client sends to the proxy
+// port during a game. WC3 sends them to the address it joined, which is
+// the proxy, so without the relay they never reach the host.
+//
+// Datagrams are routed by the client's IP to the host of the game it
+// joined through the TCP proxy. Each client address gets its own upstream
+// socket, NAT style, so replies are sent back to the right client.
+type UDPRelay struct {
+	conns    []net.PacketConn
+	impair   *impair.Impairer
+	capture  *capture.Recorder
+	dscp     int
+	routes   map[netip.Addr][]netip.AddrPort // client IP -> hosts, latest last
+	mappings map[netip.AddrPort]*udpMapping  // client address -> upstream
+	mu       sync.Mutex
+}
+
+// udpMapping is the upstream socket of one client address.
+type udpMapping struct {
+	upstream net.PacketConn
+	host     netip.AddrPort
+	last     atomic.Int64 // unix nanoseconds of the last datagram
+}
+
+// NewUDPRelay creates a UDP relay listening on port of each of bindAddrs,
+// normally the TCP proxy's port. An empty bindAddrs listens on all
+// interfaces. If imp is non-nil, relayed datagrams are impaired.
+func NewUDPRelay(
+	ctx context.Context,
+	bindAddrs []netip.Addr,
+	port int,
+	imp *impair.Impairer,
+) (*UDPRelay, error) {
+	if len(bindAddrs) == 0 {
+		bindAddrs = []netip.Addr{netip.IPv4Unspecified()}
+	}
+
+	conns, err := listenPacketAll(ctx, bindAddrs, port)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UDPRelay{
+		conns:    conns,
+		impair:   imp,
+		routes:   make(map[netip.Addr][]netip.AddrPort),
+		mappings: make(map[netip.AddrPort]*udpMapping),
+	}, nil
+}
+
+// listenPacketAll opens a UDP socket on port of each of bindAddrs.
+func listenPacketAll(ctx context.Context, bindAddrs []netip.Addr, port int) ([]net.PacketConn, error) {
+	lc := &net.ListenConfig{}
+
+	var conns []net.PacketConn
+
+	for _, ip := range bindAddrs {
+		conn, err := lc.ListenPacket(ctx, "udp4", netip.AddrPortFrom(ip, safePort(port)).String())
+		if err != nil {
+			for _, c := range conns {
+				_ = c.Close()
+			}
+
+			return nil, fmt.Errorf("failed to create UDP relay on %s: %w", ip, err)
+		}
+
+		conns = append(conns, conn)
+
+		slog.Debug("UDP relay listening", "addr", conn.LocalAddr())
+	}
+
+	return conns, nil
+}
+
+// Listen relays datagrams sent to port of each of bindAddrs as well, for a
+// game proxied on a port of its own: WC3 sends them to the port it joined.
+// The sockets are closed by the returned function. An empty bindAddrs
+// listens on all interfaces.
+func (r *UDPRelay) Listen(ctx context.Context, bindAddrs []netip.Addr, port int) (func(), error) {
+	if len(bindAddrs) == 0 {
+		bindAddrs = []netip.Addr{netip.IPv4Unspecified()}
+	}
+
+	conns, err := listenPacketAll(ctx, bindAddrs, port)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, conn := range conns {
+		r.mark(conn)
+
+		go r.readLoop(r.impair.PacketConn(r.capture.PacketConn(conn, udpComponent)))
+	}
+
+	return func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}, nil
+}
+
+// SetCapture sets the recorder capturing relayed datagrams.
+// It must be called before Run.
+func (r *UDPRelay) SetCapture(rec *capture.Recorder) {
+	r.capture = rec
+}
+
+// SetDSCP marks relayed datagrams in both directions with the DSCP
+// codepoint dscp; zero leaves them unmarked. It must be called before Run.
+func (r *UDPRelay) SetDSCP(dscp int) {
+	r.dscp = dscp
+
+	for _, conn := range r.conns {
+		r.mark(conn)
+	}
+}
+
+// mark sets the relay's DSCP codepoint on conn.
+func (r *UDPRelay) mark(conn net.PacketConn) {
+	c, ok := conn.(net.Conn)
+	if !ok {
+		return
+	}
+
+	err := lan.SetDSCP(c, r.dscp)
+	if err != nil {
+		slog.Warn("failed to set DSCP on UDP relay socket", "addr", conn.LocalAddr(), "error", err)
+	}
+}
+
+// Run relays datagrams until the context is cancelled.
+func (r *UDPRelay) Run(ctx context.Context) error {
+	for _, conn := range r.conns {
+		go r.readLoop(r.impair.PacketConn(r.capture.PacketConn(conn, udpComponent)))
+	}
+
+	<-ctx.Done()
+
+	return r.Close()
+}
+
+// Close closes the listeners and all upstream sockets.
+func (r *UDPRelay) Close() error {
+	errs := make([]error, 0, len(r.conns))
+
+	for _, conn := range r.conns {
+		errs = append(errs, conn.Close())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for client, m := range r.mappings {
+		errs = append(errs, m.upstream.Close())
+		delete(r.mappings, client)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Route sends datagrams from client to host until the returned function is
+// called. Routes nest: the latest route of a client wins, and removing it
+// restores the previous one. It is safe to call on a nil relay.
+func (r *UDPRelay) Route(client netip.Addr, host netip.AddrPort) func() {
+	if r == nil {
+		return func() {}
+	}
+
+	client = client.Unmap()
+
+	r.mu.Lock()
+	r.routes[client] = append(r.routes[client], host)
+	r.mu.Unlock()
+
+	slog.Debug("UDP route added", "client", client, "host", host)
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		hosts := r.routes[client]
+
+		i := slices.Index(hosts, host)
+		if i < 0 {
+			return
+		}
+
+		hosts = slices.Delete(hosts, i, i+1)
+		if len(hosts) == 0 {
+			delete(r.routes, client)
+
+			return
+		}
+
+		r.routes[client] = hosts
+	}
+}
+
+// readLoop forwards datagrams from local clients to their hosts.
+func (r *UDPRelay) readLoop(conn net.PacketConn) {
+	buf := make([]byte, udpBufferSize)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			slog.Debug("UDP relay read error", "error", err)
+
+			continue
+		}
+
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		client := udpAddr.AddrPort()
+		client = netip.AddrPortFrom(client.Addr().Unmap(), client.Port())
+
+		m, err := r.mapping(conn, client)
+		if err != nil {
+			slog.Debug("dropping UDP datagram", "client", client, "error", err)
+
+			continue
+		}
+
+		m.last.Store(time.Now().UnixNano())
+
+		_, err = m.upstream.WriteTo(buf[:n], net.UDPAddrFromAddrPort(m.host))
+		if err != nil {
+			slog.Debug("UDP relay write error", "host", m.host, "error", err)
+		}
+	}
+}
+
+// mapping returns the upstream of client, creating it if needed. A mapping
+// whose host no longer matches the client's route is replaced.
+func (r *UDPRelay) mapping(conn net.PacketConn, client netip.AddrPort) (*udpMapping, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hosts := r.routes[client.Addr()]
+	if len(hosts) == 0 {
+		return nil, errNoUDPRoute
+	}
+
+	host := hosts[len(hosts)-1]
+
+	m, ok := r.mappings[client]
+	if ok && m.host == host {
+		return m, nil
+	}
+
+	if ok {
+		_ = m.upstream.Close()
+	}
+
+	network := "udp4"
+	if host.Addr().Is6() {
+		network = "udp6"
+	}
+
+	upstream, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mark(upstream)
+
+	m = &udpMapping{
+		upstream: r.impair.PacketConn(r.capture.PacketConn(upstream, udpComponent)),
+		host:     host,
+	}
+	m.last.Store(time.Now().UnixNano())
+	r.mappings[client] = m
+
+	slog.Info("relaying UDP", "client", client, "host", host, "upstream", upstream.LocalAddr())
+
+	go r.replyLoop(conn, client, m)
+
+	return m, nil
+}
+
+// replyLoop forwards datagrams from the host back to client until the
+// mapping is idle for udpIdleTimeout or closed.
+func (r *UDPRelay) replyLoop(conn net.PacketConn, client netip.AddrPort, m *udpMapping) {
+	defer r.expire(client, m)
+
+	buf := make([]byte, udpBufferSize)
+	clientAddr := net.UDPAddrFromAddrPort(client)
+
+	for {
+		last := time.Unix(0, m.last.Load())
+
+		err := m.upstream.SetReadDeadline(last.Add(udpIdleTimeout))
+		if err != nil {
+			return
+		}
+
+		n, addr, err := m.upstream.ReadFrom(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() &&
+				time.Since(time.Unix(0, m.last.Load())) < udpIdleTimeout {
+				continue
+			}
+
+			return
+		}
+
+		// Only the host may answer through the mapping
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok || udpAddr.AddrPort().Addr().Unmap() != m.host.Addr() {
+			continue
+		}
+
+		m.last.Store(time.Now().UnixNano())
+
+		_, err = conn.WriteTo(buf[:n], clientAddr)
+		if err != nil {
+			slog.Debug("UDP relay reply error", "client", client, "error", err)
+		}
+	}
+}
+
+// expire removes the mapping of client if it is still m.
+func (r *UDPRelay) expire(client netip.AddrPort, m *udpMapping) {
+	_ = m.upstream.Close()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mappings[client] == m {
+		delete(r.mappings, client)
+
+		slog.Debug("UDP mapping expired", "client", client, "host", m.host)
+	}
+}
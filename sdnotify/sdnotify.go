@@ -0,0 +1,67 @@
+// Package sdnotify implements the systemd service notification protocol,
+// so wc3ts can run as a Type=notify unit with a watchdog.
+//
+// All functions are no-ops when not started by systemd.
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notification states.
+const (
+	Ready     = "READY=1"
+	Stopping  = "STOPPING=1"
+	Watchdog  = "WATCHDOG=1"
+	statusKey = "STATUS="
+)
+
+// Notify sends state to the systemd notification socket. It returns nil
+// without doing anything if NOTIFY_SOCKET is not set.
+func Notify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+
+	// A leading @ names a socket in the abstract namespace
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = conn.Close() }()
+
+	_, err = conn.Write([]byte(state))
+
+	return err
+}
+
+// Status returns a notification setting the free-form status shown by
+// systemctl status.
+func Status(s string) string {
+	return statusKey + s
+}
+
+// WatchdogInterval returns the watchdog timeout configured by systemd for
+// this process, or zero if the watchdog is disabled.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	pid := os.Getenv("WATCHDOG_PID")
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
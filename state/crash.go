@@ -0,0 +1,57 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/kradalby/wc3ts/paths"
+	"github.com/kradalby/wc3ts/version"
+)
+
+// WriteCrashReport writes the panic value and stack trace to a new file in
+// the crash directory and returns its path.
+func WriteCrashReport(r any, stack []byte) (string, error) {
+	p, err := paths.Get()
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(p.Crashes, dirPerm)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	path := filepath.Join(p.Crashes, "crash-"+now.Format("20060102-150405")+".txt")
+	v := version.Get()
+
+	report := fmt.Sprintf("wc3ts %s (%s) %s/%s %s\ntime: %s\nargs: %q\n\npanic: %v\n\n%s",
+		v.Version, v.Commit, runtime.GOOS, runtime.GOARCH, v.GoVer, now.Format(time.RFC3339), os.Args, r, stack)
+
+	err = os.WriteFile(path, []byte(report), filePerm)
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// RecoverCrash writes a crash report for a panic in the calling goroutine
+// and re-panics. Use it deferred at the top of main and long-lived goroutines.
+func RecoverCrash() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	path, err := WriteCrashReport(r, debug.Stack())
+	if err == nil {
+		fmt.Fprintf(os.Stderr, "wc3ts crashed; report written to %s\n", path)
+	}
+
+	panic(r)
+}
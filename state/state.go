@@ -0,0 +1,77 @@
+// Package state persists small pieces of data between runs, such as TUI
+// preferences, as JSON files in the state directory.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/kradalby/wc3ts/paths"
+)
+
+// File permissions for the state directory and its files.
+const (
+	dirPerm  = 0o755
+	filePerm = 0o644
+)
+
+// Load decodes the state file name into v. A missing file leaves v
+// unchanged and is not an error.
+func Load(name string, v any) error {
+	path, err := filePath(name)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// Save encodes v to the state file name, replacing it atomically so a
+// crash never leaves a truncated file behind.
+func Save(name string, v any) error {
+	path, err := filePath(name)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), dirPerm)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+
+	err = os.WriteFile(tmp, data, filePerm)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// filePath returns the path of the state file name.
+func filePath(name string) (string, error) {
+	p, err := paths.Get()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(p.State, name), nil
+}
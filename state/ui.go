@@ -0,0 +1,23 @@
+package state
+
+import "github.com/kradalby/wc3ts/paths"
+
+// UI holds TUI preferences restored on the next start.
+type UI struct {
+	// Version is the game version last selected in the TUI. Zero if none.
+	Version uint32 `json:"version,omitempty"`
+}
+
+// LoadUI returns the saved TUI preferences, or zero values if there are none.
+func LoadUI() (UI, error) {
+	var ui UI
+
+	err := Load(paths.UIFile, &ui)
+
+	return ui, err
+}
+
+// SaveUI saves the TUI preferences.
+func SaveUI(ui UI) error {
+	return Save(paths.UIFile, ui)
+}
@@ -7,6 +7,7 @@ import (
 	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"tailscale.com/client/local"
 	"tailscale.com/ipn"
@@ -22,14 +23,25 @@ type Peer struct {
 	// Name is the peer's hostname.
 	Name string
 
-	// IP is the peer's Tailscale IPv4 address.
+	// IP is the address the peer is probed and joined on: its Tailscale
+	// IPv4 address, or its IPv6 address on IPv6-only tailnets.
 	IP netip.Addr
 
+	// IP6 is the peer's Tailscale IPv6 address, if it has one.
+	IP6 netip.Addr
+
 	// Online indicates if the peer is currently connected.
 	Online bool
 
 	// OS is the peer's operating system (e.g., "windows", "macOS", "linux").
 	OS string
+
+	// Tags are the peer's ACL tags, e.g. "tag:lan-party".
+	Tags []string
+
+	// RTT is the round trip time of the last Tailscale ping of the peer,
+	// zero if it has not answered one.
+	RTT time.Duration
 }
 
 // OnPeersChangedFunc is called when the peer list changes.
@@ -41,6 +53,11 @@ type Discovery struct {
 	watcher  *local.IPNBusWatcher
 	peers    []Peer
 	selfIP   netip.Addr
+	selfIP6  netip.Addr
+	netcheck netcheck
+	filter   PeerFilter
+	netmap   *netmap.NetworkMap
+	rtt      map[netip.Addr]time.Duration
 	onChange OnPeersChangedFunc
 	mu       sync.RWMutex
 }
@@ -97,7 +114,8 @@ func (d *Discovery) Peers() []Peer {
 	return result
 }
 
-// SelfIP returns this node's Tailscale IPv4 address.
+// SelfIP returns this node's Tailscale IPv4 address, or its IPv6 address
+// on IPv6-only tailnets.
 // Returns zero addr if not yet known from netmap updates.
 func (d *Discovery) SelfIP() netip.Addr {
 	d.mu.RLock()
@@ -106,7 +124,17 @@ func (d *Discovery) SelfIP() netip.Addr {
 	return d.selfIP
 }
 
-// FetchSelfIP queries the Tailscale daemon for our IP address.
+// SelfIP6 returns this node's Tailscale IPv6 address.
+// Returns zero addr if it has none or it is not yet known.
+func (d *Discovery) SelfIP6() netip.Addr {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.selfIP6
+}
+
+// FetchSelfIP queries the Tailscale daemon for our IP address, as
+// returned by SelfIP.
 // This can be called before Run() to get the IP synchronously.
 func (d *Discovery) FetchSelfIP(ctx context.Context) (netip.Addr, error) {
 	status, err := d.client.Status(ctx)
@@ -114,17 +142,36 @@ func (d *Discovery) FetchSelfIP(ctx context.Context) (netip.Addr, error) {
 		return netip.Addr{}, err
 	}
 
-	for _, ip := range status.TailscaleIPs {
-		if ip.Is4() {
-			d.mu.Lock()
-			d.selfIP = ip
-			d.mu.Unlock()
+	ip, ip6 := pickAddrs(status.TailscaleIPs)
 
-			return ip, nil
-		}
+	d.mu.Lock()
+	d.selfIP = ip
+	d.selfIP6 = ip6
+	d.mu.Unlock()
+
+	return ip, nil
+}
+
+// SetFilter sets which peers are probed, in addition to the built-in
+// filters. It may be changed while running; the peer list is re-filtered
+// and reported to the change callback.
+func (d *Discovery) SetFilter(filter PeerFilter) {
+	d.mu.Lock()
+	d.filter = filter
+	nm := d.netmap
+	d.mu.Unlock()
+
+	if nm != nil {
+		d.updateFromNetMap(nm)
 	}
+}
 
-	return netip.Addr{}, nil
+// peerFilter returns the configured peer filter.
+func (d *Discovery) peerFilter() PeerFilter {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.filter
 }
 
 // Close stops the discovery watcher.
@@ -143,9 +190,12 @@ func (d *Discovery) Close() error {
 func (d *Discovery) updateFromNetMap(nm *netmap.NetworkMap) {
 	d.extractSelfIP(nm)
 	peers := d.extractPeers(nm)
+	nc := netcheckFromNetMap(nm)
 
 	d.mu.Lock()
 	d.peers = peers
+	d.netcheck = nc
+	d.netmap = nm
 	d.mu.Unlock()
 
 	if d.onChange != nil {
@@ -159,27 +209,24 @@ func (d *Discovery) extractSelfIP(nm *netmap.NetworkMap) {
 		return
 	}
 
-	addrs := nm.SelfNode.Addresses()
-
-	for i := range addrs.Len() {
-		addr := addrs.At(i).Addr()
-		if addr.Is4() {
-			d.mu.Lock()
-			d.selfIP = addr
-			d.mu.Unlock()
+	ip, ip6 := pickAddrs(nodeAddrs(nm.SelfNode))
 
-			return
-		}
-	}
+	d.mu.Lock()
+	d.selfIP = ip
+	d.selfIP6 = ip6
+	d.mu.Unlock()
 }
 
-// extractPeers extracts peer information from the network map.
+// extractPeers extracts the peers eligible for probing from the network map.
 func (d *Discovery) extractPeers(nm *netmap.NetworkMap) []Peer {
 	var peers []Peer
 
+	filter := d.peerFilter()
+
 	for _, p := range nm.Peers {
-		peer, ok := d.extractPeer(p)
-		if ok {
+		peer, reason, ok := classifyPeer(p, filter)
+		if ok && reason == "" {
+			peer.RTT = d.peerRTT(peer.IP)
 			peers = append(peers, peer)
 		}
 	}
@@ -187,50 +234,73 @@ func (d *Discovery) extractPeers(nm *netmap.NetworkMap) []Peer {
 	return peers
 }
 
-// extractPeer extracts a single peer's information if valid.
-func (d *Discovery) extractPeer(p tailcfg.NodeView) (Peer, bool) {
+// classifyPeer extracts a peer's information and the reason it is not
+// probed, which is empty for eligible peers. ok is false for invalid nodes.
+func classifyPeer(p tailcfg.NodeView, filter PeerFilter) (Peer, FilterReason, bool) {
 	if !p.Valid() {
-		return Peer{}, false
+		return Peer{}, "", false
 	}
 
-	// Check if peer is online
-	online := p.Online().GetOr(false)
-	if !online {
-		return Peer{}, false
-	}
-
-	// Filter out Mullvad exit nodes
-	tags := p.Tags()
-	if slices.Contains(tags.AsSlice(), mullvadExitNodeTag) {
-		return Peer{}, false
+	peer := Peer{
+		Name:   p.ComputedName(),
+		Online: p.Online().GetOr(false),
+		Tags:   p.Tags().AsSlice(),
 	}
 
 	// Extract OS from hostinfo
-	os := ""
 	if hi := p.Hostinfo(); hi.Valid() {
-		os = hi.OS()
+		peer.OS = hi.OS()
 	}
 
-	// Filter out mobile devices (iOS, Android) - they cannot run WC3
-	osLower := strings.ToLower(os)
-	if osLower == "ios" || osLower == "android" {
-		return Peer{}, false
+	peer.IP, peer.IP6 = pickAddrs(nodeAddrs(p))
+
+	osLower := strings.ToLower(peer.OS)
+
+	switch {
+	case !peer.Online:
+		return peer, FilterOffline, true
+	case slices.Contains(peer.Tags, mullvadExitNodeTag):
+		return peer, FilterMullvad, true
+	case osLower == "ios" || osLower == "android":
+		// Mobile devices cannot run WC3
+		return peer, FilterMobile, true
+	case !peer.IP.IsValid():
+		return peer, FilterNoIP, true
 	}
 
-	// Find first IPv4 address
-	addrs := p.Addresses()
+	return peer, filter.reason(peer), true
+}
+
+// nodeAddrs returns the Tailscale addresses of a node.
+func nodeAddrs(n tailcfg.NodeView) []netip.Addr {
+	prefixes := n.Addresses()
+	addrs := make([]netip.Addr, 0, prefixes.Len())
+
+	for i := range prefixes.Len() {
+		addrs = append(addrs, prefixes.At(i).Addr())
+	}
 
-	for i := range addrs.Len() {
-		addr := addrs.At(i).Addr()
-		if addr.Is4() {
-			return Peer{
-				Name:   p.ComputedName(),
-				IP:     addr,
-				Online: online,
-				OS:     os,
-			}, true
+	return addrs
+}
+
+// pickAddrs returns the address to reach a node on, preferring IPv4 since
+// WC3 itself only speaks IPv4, and the node's IPv6 address. On IPv6-only
+// tailnets ip is the IPv6 address.
+func pickAddrs(addrs []netip.Addr) (netip.Addr, netip.Addr) {
+	var ip, ip6 netip.Addr
+
+	for _, addr := range addrs {
+		switch {
+		case addr.Is4() && !ip.Is4():
+			ip = addr
+		case addr.Is6() && !ip6.IsValid():
+			ip6 = addr
 		}
 	}
 
-	return Peer{}, false
+	if !ip.IsValid() {
+		ip = ip6
+	}
+
+	return ip, ip6
 }
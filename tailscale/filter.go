@@ -0,0 +1,52 @@
+package tailscale
+
+import (
+	"net/netip"
+	"path"
+	"slices"
+	"strings"
+)
+
+// PeerFilter selects the peers probed for games by hostname glob, IP,
+// CIDR prefix or ACL tag (entries starting with "tag:"), compared
+// case-insensitively.
+type PeerFilter struct {
+	// Allow, if not empty, limits probing to peers matching an entry.
+	Allow []string
+
+	// Deny excludes peers matching an entry, even if allowed.
+	Deny []string
+}
+
+// reason returns why peer is filtered out, or "" if it may be probed.
+func (f PeerFilter) reason(peer Peer) FilterReason {
+	switch {
+	case slices.ContainsFunc(f.Deny, peer.matches):
+		return FilterDenied
+	case len(f.Allow) > 0 && !slices.ContainsFunc(f.Allow, peer.matches):
+		return FilterNotAllowed
+	}
+
+	return ""
+}
+
+// matches reports whether a filter entry names the peer.
+func (p Peer) matches(entry string) bool {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+
+	if strings.HasPrefix(entry, "tag:") {
+		return slices.ContainsFunc(p.Tags, func(tag string) bool { return strings.EqualFold(entry, tag) })
+	}
+
+	if prefix, err := netip.ParsePrefix(entry); err == nil {
+		return (p.IP.IsValid() && prefix.Contains(p.IP)) || (p.IP6.IsValid() && prefix.Contains(p.IP6))
+	}
+
+	if ip, err := netip.ParseAddr(entry); err == nil {
+		return p.IP == ip || (p.IP6.IsValid() && p.IP6 == ip)
+	}
+
+	ok, _ := path.Match(entry, strings.ToLower(p.Name))
+
+	return ok
+}
@@ -0,0 +1,175 @@
+package tailscale
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/types/netmap"
+)
+
+// Health is a compact summary of the network path quality as measured by
+// tailscaled's netcheck and the current peer connections.
+type Health struct {
+	// DERPRegion is the code of the home (nearest) DERP region.
+	DERPRegion string
+
+	// DERPLatency is the latency to the home DERP region, zero if unknown.
+	DERPLatency time.Duration
+
+	// UDP reports whether UDP works: "ok", "blocked" or "" if unknown.
+	// Without UDP every connection is relayed through DERP.
+	UDP string
+
+	// Direct is the number of active peers reached over a direct path.
+	Direct int
+
+	// Relayed is the number of active peers reached through a relay.
+	Relayed int
+
+	// CheckedAt is when the summary was taken.
+	CheckedAt time.Time
+}
+
+// UDP states.
+const (
+	UDPOK      = "ok"
+	UDPBlocked = "blocked"
+)
+
+// String formats the summary on a single line.
+func (h Health) String() string {
+	parts := make([]string, 0, 3) //nolint:mnd
+
+	derp := "DERP ?"
+	if h.DERPRegion != "" {
+		derp = "DERP " + h.DERPRegion
+	}
+
+	if h.DERPLatency > 0 {
+		derp += " " + h.DERPLatency.Round(time.Millisecond).String()
+	}
+
+	parts = append(parts, derp)
+
+	if h.UDP != "" {
+		parts = append(parts, "UDP "+h.UDP)
+	}
+
+	parts = append(parts, fmt.Sprintf("%d direct, %d relayed", h.Direct, h.Relayed))
+
+	return strings.Join(parts, " | ")
+}
+
+// netcheck holds the latest netcheck results reported in the netmap.
+type netcheck struct {
+	valid       bool
+	region      string
+	latency     time.Duration
+	udp         string
+	regionCodes map[int]string
+}
+
+// FetchHealth returns the current network health. If no netmap has been
+// received yet, it subscribes to the IPN bus briefly to get one.
+func (d *Discovery) FetchHealth(ctx context.Context) (Health, error) {
+	d.mu.RLock()
+	nc := d.netcheck
+	d.mu.RUnlock()
+
+	if !nc.valid {
+		nm, err := d.fetchNetMap(ctx)
+		if err != nil {
+			return Health{}, err
+		}
+
+		nc = netcheckFromNetMap(nm)
+	}
+
+	status, err := d.client.Status(ctx)
+	if err != nil {
+		return Health{}, err
+	}
+
+	h := Health{
+		DERPRegion:  nc.region,
+		DERPLatency: nc.latency,
+		UDP:         nc.udp,
+		CheckedAt:   time.Now(),
+	}
+
+	if h.DERPRegion == "" && status.Self != nil {
+		h.DERPRegion = status.Self.Relay
+	}
+
+	for _, p := range status.Peer {
+		switch {
+		case !p.Online:
+		case p.CurAddr != "":
+			h.Direct++
+		case p.Active:
+			h.Relayed++
+		}
+	}
+
+	return h, nil
+}
+
+// fetchNetMap subscribes to the IPN bus and returns the initial netmap.
+func (d *Discovery) fetchNetMap(ctx context.Context) (*netmap.NetworkMap, error) {
+	watcher, err := d.client.WatchIPNBus(ctx, ipn.NotifyInitialNetMap)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = watcher.Close() }()
+
+	for {
+		notify, err := watcher.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if notify.NetMap != nil {
+			return notify.NetMap, nil
+		}
+	}
+}
+
+// netcheckFromNetMap extracts netcheck results from our own node's NetInfo.
+func netcheckFromNetMap(nm *netmap.NetworkMap) netcheck {
+	nc := netcheck{regionCodes: make(map[int]string)}
+
+	if nm.DERPMap != nil {
+		for id, region := range nm.DERPMap.Regions {
+			nc.regionCodes[id] = region.RegionCode
+		}
+	}
+
+	if !nm.SelfNode.Valid() || !nm.SelfNode.Hostinfo().Valid() {
+		return nc
+	}
+
+	ni := nm.SelfNode.Hostinfo().NetInfo()
+	if !ni.Valid() {
+		return nc
+	}
+
+	nc.valid = true
+	nc.region = nc.regionCodes[ni.PreferredDERP()]
+
+	if secs, ok := ni.DERPLatency().GetOk(fmt.Sprintf("%d-v4", ni.PreferredDERP())); ok {
+		nc.latency = time.Duration(secs * float64(time.Second))
+	}
+
+	if udp, ok := ni.WorkingUDP().Get(); ok {
+		nc.udp = UDPBlocked
+		if udp {
+			nc.udp = UDPOK
+		}
+	}
+
+	return nc
+}
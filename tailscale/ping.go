@@ -0,0 +1,107 @@
+package tailscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sync"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+const (
+	// pingTimeout bounds a single ping of a peer.
+	pingTimeout = 5 * time.Second
+
+	// pingConcurrency is how many peers are pinged at once.
+	pingConcurrency = 8
+)
+
+// ErrPingFailed is returned when tailscaled could not ping a peer.
+var ErrPingFailed = errors.New("ping failed")
+
+// Ping measures the round trip time to the peer at ip with a disco ping,
+// which travels the same path as game traffic but does not need wc3ts to
+// run on the peer.
+func (d *Discovery) Ping(ctx context.Context, ip netip.Addr) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	res, err := d.client.Ping(ctx, ip, tailcfg.PingDisco)
+	if err != nil {
+		return 0, err
+	}
+
+	if res.Err != "" {
+		return 0, fmt.Errorf("%w: %s", ErrPingFailed, res.Err)
+	}
+
+	return time.Duration(res.LatencySeconds * float64(time.Second)), nil
+}
+
+// PingPeers pings every online peer and returns the peer list with the
+// measured round trip times. Peers that do not answer have no RTT. The
+// times are kept on the peers until the next PingPeers.
+func (d *Discovery) PingPeers(ctx context.Context) []Peer {
+	peers := d.Peers()
+	rtts := make([]time.Duration, len(peers))
+	sem := make(chan struct{}, pingConcurrency)
+
+	var wg sync.WaitGroup
+
+	for i := range peers {
+		if !peers[i].Online {
+			continue
+		}
+
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			rtt, err := d.Ping(ctx, peers[i].IP)
+			if err != nil {
+				slog.Debug("peer ping failed", "peer", peers[i].Name, "ip", peers[i].IP, "error", err)
+
+				return
+			}
+
+			rtts[i] = rtt
+		})
+	}
+
+	wg.Wait()
+
+	rtt := make(map[netip.Addr]time.Duration, len(peers))
+
+	for i := range peers {
+		if rtts[i] > 0 {
+			rtt[peers[i].IP] = rtts[i]
+		}
+	}
+
+	// The peer list may have changed while pinging
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.rtt = rtt
+
+	for i := range d.peers {
+		d.peers[i].RTT = rtt[d.peers[i].IP]
+	}
+
+	result := make([]Peer, len(d.peers))
+	copy(result, d.peers)
+
+	return result
+}
+
+// peerRTT returns the last measured round trip time to the peer at ip.
+func (d *Discovery) peerRTT(ip netip.Addr) time.Duration {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.rtt[ip]
+}
@@ -0,0 +1,124 @@
+package tailscale
+
+import (
+	"cmp"
+	"context"
+	"net/netip"
+	"slices"
+)
+
+// FilterReason explains why a peer is not probed for games.
+type FilterReason string
+
+// Reasons a peer is filtered out.
+const (
+	FilterOffline FilterReason = "offline"
+	FilterMullvad FilterReason = "mullvad exit node"
+	FilterMobile  FilterReason = "mobile device"
+	FilterNoIP    FilterReason = "no Tailscale address"
+
+	FilterDenied     FilterReason = "denied by -peer-deny"
+	FilterNotAllowed FilterReason = "not in -peer-allow"
+)
+
+// PeerStatus is a tailnet peer and whether it is probed for games.
+type PeerStatus struct {
+	Peer
+
+	// Filtered is why the peer is not probed; empty if it is.
+	Filtered FilterReason
+}
+
+// FetchPeerStatus returns every peer in the netmap, including those
+// filtered out of probing, sorted by name.
+func (d *Discovery) FetchPeerStatus(ctx context.Context) ([]PeerStatus, error) {
+	nm, err := d.fetchNetMap(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := d.peerFilter()
+	statuses := make([]PeerStatus, 0, len(nm.Peers))
+
+	for _, p := range nm.Peers {
+		peer, reason, ok := classifyPeer(p, filter)
+		if ok {
+			statuses = append(statuses, PeerStatus{Peer: peer, Filtered: reason})
+		}
+	}
+
+	slices.SortFunc(statuses, func(a, b PeerStatus) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	return statuses, nil
+}
+
+// FetchEndpoints returns the underlay address each online peer is reached
+// at directly, keyed by each of its Tailscale addresses. Peers reached through
+// a DERP relay are left out.
+func (d *Discovery) FetchEndpoints(ctx context.Context) (map[netip.Addr]netip.AddrPort, error) {
+	status, err := d.client.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make(map[netip.Addr]netip.AddrPort)
+
+	for _, p := range status.Peer {
+		if !p.Online || p.CurAddr == "" {
+			continue
+		}
+
+		addr, err := netip.ParseAddrPort(p.CurAddr)
+		if err != nil {
+			continue
+		}
+
+		for _, ip := range p.TailscaleIPs {
+			endpoints[ip] = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+		}
+	}
+
+	return endpoints, nil
+}
+
+// Relayed reports whether traffic to the peer with the Tailscale address ip
+// goes through a DERP relay because no direct path is established. Addresses
+// of no peer, such as static hosts, are never relayed.
+func (d *Discovery) Relayed(ctx context.Context, ip netip.Addr) (bool, error) {
+	status, err := d.client.Status(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, p := range status.Peer {
+		if slices.Contains(p.TailscaleIPs, ip) {
+			return p.CurAddr == "", nil
+		}
+	}
+
+	return false, nil
+}
+
+// Routed reports whether a peer is the primary subnet router of a route
+// covering prefix, so that traffic to it is carried over Tailscale. The
+// second result is false until the first network map has arrived.
+func (d *Discovery) Routed(prefix netip.Prefix) (bool, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.netmap == nil {
+		return false, false
+	}
+
+	for _, p := range d.netmap.Peers {
+		for _, route := range p.PrimaryRoutes().All() {
+			if route.Bits() <= prefix.Bits() && route.Contains(prefix.Addr()) {
+				return true, true
+			}
+		}
+	}
+
+	return false, true
+}
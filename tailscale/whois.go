@@ -0,0 +1,78 @@
+package tailscale
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// Identity is the tailnet user and node behind a connection.
+type Identity struct {
+	// Login is the user's login name, e.g. "alice@example.com".
+	Login string
+
+	// Node is the node's MagicDNS name, e.g. "alice-pc".
+	Node string
+
+	// IP is the node's Tailscale address the connection came from.
+	IP netip.Addr
+
+	// Addrs are all of the node's Tailscale addresses, so a node allowed
+	// by its IPv4 address also matches when joining over IPv6.
+	Addrs []netip.Addr
+
+	// Tags are the node's ACL tags, e.g. "tag:lan-party".
+	Tags []string
+}
+
+// String returns a short description for logs.
+func (id Identity) String() string {
+	if id.Login == "" {
+		return fmt.Sprintf("%s (%s)", id.Node, id.IP)
+	}
+
+	return fmt.Sprintf("%s on %s (%s)", id.Login, id.Node, id.IP)
+}
+
+// Matches reports whether entry names this identity. An entry may be a login
+// name, a node name, a Tailscale IP or an ACL tag, compared case-insensitively.
+func (id Identity) Matches(entry string) bool {
+	entry = strings.TrimSpace(entry)
+
+	return strings.EqualFold(entry, id.Login) ||
+		strings.EqualFold(entry, id.Node) ||
+		(id.IP.IsValid() && entry == id.IP.String()) ||
+		slices.ContainsFunc(id.Addrs, func(ip netip.Addr) bool { return entry == ip.String() }) ||
+		slices.ContainsFunc(id.Tags, func(tag string) bool { return strings.EqualFold(entry, tag) })
+}
+
+// WhoIs resolves the tailnet identity of the peer at addr.
+func (d *Discovery) WhoIs(ctx context.Context, addr netip.AddrPort) (Identity, error) {
+	resp, err := d.client.WhoIs(ctx, addr.String())
+	if err != nil {
+		return Identity{}, err
+	}
+
+	id := Identity{IP: addr.Addr()}
+
+	if resp.UserProfile != nil {
+		id.Login = resp.UserProfile.LoginName
+	}
+
+	if node := resp.Node; node != nil {
+		id.Node = node.ComputedName
+		if id.Node == "" {
+			id.Node, _, _ = strings.Cut(node.Name, ".")
+		}
+
+		id.Tags = node.Tags
+
+		for _, prefix := range node.Addresses {
+			id.Addrs = append(id.Addrs, prefix.Addr())
+		}
+	}
+
+	return id, nil
+}
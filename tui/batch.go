@@ -0,0 +1,96 @@
+package tui
+
+import (
+	"net/netip"
+	"slices"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// PeerAction is an action applied to a set of peers.
+type PeerAction int
+
+// Peer actions.
+const (
+	// PeerActionProbe probes the peers for games immediately.
+	PeerActionProbe PeerAction = iota
+	// PeerActionAllow adds the peers to the join allowlist.
+	PeerActionAllow
+	// PeerActionMute toggles ignoring the peers' games.
+	PeerActionMute
+)
+
+// MutedMsg carries the peers whose games are ignored.
+type MutedMsg struct {
+	Muted []netip.Addr
+}
+
+// toggleMark marks or unmarks the peer under the cursor for a batch action.
+func (m Model) toggleMark() Model {
+	cursor := m.peerTable.Cursor()
+	if m.focus != FocusPeers || cursor < 0 || cursor >= len(m.peers) {
+		return m
+	}
+
+	ip := m.peers[cursor].IP
+	if m.marked[ip] {
+		delete(m.marked, ip)
+	} else {
+		m.marked[ip] = true
+	}
+
+	m.peerTable.SetRows(m.peerRows())
+	m.peerTable.MoveDown(1)
+
+	return m
+}
+
+// applyPeerAction returns a command applying action to the marked peers, or
+// to the peer under the cursor if none are marked, and clears the marks.
+func (m Model) applyPeerAction(action PeerAction) (Model, tea.Cmd) {
+	if m.focus != FocusPeers || m.peerActionCb == nil {
+		return m, nil
+	}
+
+	targets := make([]netip.Addr, 0, len(m.marked))
+
+	for i := range m.peers {
+		if m.marked[m.peers[i].IP] {
+			targets = append(targets, m.peers[i].IP)
+		}
+	}
+
+	if len(targets) == 0 {
+		cursor := m.peerTable.Cursor()
+		if cursor < 0 || cursor >= len(m.peers) {
+			return m, nil
+		}
+
+		targets = append(targets, m.peers[cursor].IP)
+	}
+
+	clear(m.marked)
+	m.peerTable.SetRows(m.peerRows())
+
+	peerActionCb := m.peerActionCb
+
+	return m, callback(func() { peerActionCb(action, targets) })
+}
+
+// markedCount returns the number of marked peers that are still listed.
+func (m Model) markedCount() int {
+	count := 0
+
+	for i := range m.peers {
+		if m.marked[m.peers[i].IP] {
+			count++
+		}
+	}
+
+	return count
+}
+
+// isMuted reports whether the peer's games are ignored.
+func (m Model) isMuted(ip netip.Addr) bool {
+	return slices.Contains(m.muted, ip)
+}
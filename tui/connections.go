@@ -0,0 +1,128 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/table"
+	"github.com/kradalby/wc3ts/proxy"
+)
+
+// Connections table column widths.
+const (
+	colWidthPlayer   = 15
+	colWidthClient   = 21
+	colWidthBytes    = 9
+	colWidthPackets  = 13
+	colWidthDuration = 9
+)
+
+// byteUnit is the step between byte size units.
+const byteUnit = 1024
+
+// ConnectionsMsg carries the active proxied connections.
+type ConnectionsMsg struct {
+	Connections []proxy.Connection
+}
+
+// newConnectionTable creates the table of the connections view.
+func newConnectionTable() table.Model {
+	return table.New(
+		table.WithColumns([]table.Column{
+			{Title: "Player", Width: colWidthPlayer},
+			{Title: "Client", Width: colWidthClient},
+			{Title: "Game", Width: colWidthGame},
+			{Title: "Host", Width: colWidthHost},
+			{Title: "In", Width: colWidthBytes},
+			{Title: "Out", Width: colWidthBytes},
+			{Title: "Packets", Width: colWidthPackets},
+			{Title: "Duration", Width: colWidthDuration},
+		}),
+		table.WithRows([]table.Row{}),
+		table.WithFocused(true),
+		table.WithHeight(minTableHeight),
+	)
+}
+
+// connectionRows converts connections to table rows.
+func (m Model) connectionRows() []table.Row {
+	rows := make([]table.Row, 0, len(m.connections))
+
+	for _, c := range m.connections {
+		host := c.Host
+		if host == "" {
+			host = c.HostIP.String()
+		}
+
+		rows = append(rows, table.Row{
+			m.charset.Decode(c.Player),
+			c.Client,
+			m.charset.Decode(c.Game),
+			host,
+			formatBytes(c.BytesIn),
+			formatBytes(c.BytesOut),
+			fmt.Sprintf("%d/%d", c.PacketsIn, c.PacketsOut),
+			formatElapsed(time.Since(c.Start)),
+		})
+	}
+
+	return rows
+}
+
+// viewConnections renders the connections view.
+func (m Model) viewConnections(s styles) string {
+	var b strings.Builder
+
+	b.WriteString(s.title.Render("Connections"))
+	b.WriteString("\n\n")
+
+	if len(m.connections) == 0 {
+		b.WriteString(s.logLine.Render("  No players are connected through the proxy."))
+		b.WriteString("\n")
+	} else {
+		b.WriteString(m.connTable.View())
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n")
+	b.WriteString(s.statusBar.Render(fmt.Sprintf("%d active | TCP Proxy: %d", len(m.connections), m.proxyPort)))
+	b.WriteString("\n")
+	b.WriteString(s.help.Render(
+		"↑/↓: navigate | In: from host, Out: to host, Packets: in/out | Press Escape to return"))
+
+	return b.String()
+}
+
+// formatBytes formats a byte count with a binary unit, e.g. "1.5 MiB".
+func formatBytes(n uint64) string {
+	if n < byteUnit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	value := float64(n) / byteUnit
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+
+	i := 0
+	for value >= byteUnit && i < len(units)-1 {
+		value /= byteUnit
+		i++
+	}
+
+	return fmt.Sprintf("%.1f %s", value, units[i])
+}
+
+// formatElapsed formats a running duration as h:mm:ss or m:ss.
+func formatElapsed(d time.Duration) string {
+	d = d.Truncate(time.Second)
+
+	h := int(d.Hours())
+	mins := int(d.Minutes()) % 60 //nolint:mnd
+	secs := int(d.Seconds()) % 60 //nolint:mnd
+
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, mins, secs)
+	}
+
+	return fmt.Sprintf("%d:%02d", mins, secs)
+}
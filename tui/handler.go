@@ -11,17 +11,24 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 )
 
+// Sender receives TUI messages. *tea.Program is a Sender.
+type Sender interface {
+	Send(msg tea.Msg)
+}
+
 // Handler is a slog.Handler that sends logs to the TUI.
 type Handler struct {
-	program *tea.Program
-	level   slog.Level
+	program Sender
+	level   slog.Leveler
 	attrs   []slog.Attr
 	groups  []string
 	ready   *atomic.Bool
 }
 
-// NewHandler creates a new TUI log handler.
-func NewHandler(program *tea.Program, level slog.Level) *Handler {
+// NewHandler creates a new TUI log handler sending to program, which is
+// usually a *tea.Program. The level may be a *slog.LevelVar to allow
+// changing it at runtime.
+func NewHandler(program Sender, level slog.Leveler) *Handler {
 	return &Handler{
 		program: program,
 		level:   level,
@@ -37,7 +44,7 @@ func (h *Handler) SetReady() {
 
 // Enabled reports whether the handler handles records at the given level.
 func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
-	return level >= h.level
+	return level >= h.level.Level()
 }
 
 // Handle formats and sends the log record to the TUI.
@@ -2,10 +2,15 @@
 package tui
 
 import (
+	"net/netip"
+
 	"github.com/charmbracelet/bubbles/table"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/kradalby/wc3ts/config"
 	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/mapfile"
+	"github.com/kradalby/wc3ts/proxy"
 	"github.com/kradalby/wc3ts/tailscale"
 	"github.com/kradalby/wc3ts/version"
 	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
@@ -18,15 +23,23 @@ const (
 	colWidthOS      = 10
 	colWidthStatus  = 10
 	colWidthGames   = 8
-	colWidthGame    = 30
+	colWidthPing    = 7
+	colWidthGame    = 24
 	colWidthHost    = 15
 	colWidthPlayers = 10
 	colWidthSource  = 10
-	minTableHeight  = 3
-	minLogHeight    = 3
-	maxLogLines     = 10
+	colWidthMap     = 4
+	colWidthState   = 11
+	// minColumnWidth is the narrowest a name column is fitted to.
+	minColumnWidth = 8
+	minTableHeight = 3
+	minLogHeight   = 3
+	maxLogLines    = 10
+	// minWidth and minHeight are the smallest terminal the layout supports.
+	minWidth  = 80
+	minHeight = 24
 	// fixedUIHeight accounts for title, headers, status bar, help, and spacing.
-	fixedUIHeight = 11
+	fixedUIHeight = 12
 	// Layout percentages for splitting available height.
 	peerTablePct = 35
 	gameTablePct = 35
@@ -41,6 +54,7 @@ const (
 	ViewModeList ViewMode = iota
 	ViewModeDetailPeer
 	ViewModeDetailGame
+	ViewModeConnections
 )
 
 // FocusedPanel indicates which panel has focus.
@@ -59,9 +73,17 @@ type Model struct {
 	peerGames    map[string]int // IP -> game count
 	version      w3gs.GameVersion
 	buildVersion version.Info
+	charset      game.Charset
+	library      *mapfile.Library          // nil when no Maps directory is configured
+	mapInfo      *mapfile.Info             // local metadata for the selected game's map
+	mapStatus    map[string]mapfile.Status // map path -> local availability
 	proxyPort    int
+	lanPort      int
+	peerPort     int // fallback port peers are answered on, zero if none
 	peerTable    table.Model
 	gameTable    table.Model
+	connTable    table.Model
+	connections  []proxy.Connection
 	logs         []string
 	logHeight    int // calculated log area height
 	width        int
@@ -70,10 +92,20 @@ type Model struct {
 	quitting     bool
 	focus        FocusedPanel
 	viewMode     ViewMode
-	selectedPeer *tailscale.Peer // selected peer for detail view
-	selectedGame *game.Game      // selected game for detail view
-	versionCb    func(uint32)    // callback to notify version changes
-	refreshCb    func()          // callback to trigger manual refresh
+	selectedPeer *tailscale.Peer                // selected peer for detail view
+	selectedGame *game.Game                     // selected game for detail view
+	versionCb    func(uint32)                   // callback to notify version changes
+	refreshCb    func()                         // callback to trigger manual refresh
+	pauseCb      func()                         // callback to toggle pausing discovery
+	privateCb    func(string, bool)             // callback to make a local game private
+	peerActionCb func(PeerAction, []netip.Addr) // callback to apply a batch action
+	marked       map[netip.Addr]bool            // peers marked for a batch action
+	muted        []netip.Addr                   // peers whose games are ignored
+	paused       []string                       // names of paused subsystems
+	update       string                         // newer release version, if any
+	notice       string                         // latest notice for the user, e.g. a rejected join
+	warning      string                         // problem kept in view, e.g. a port conflict
+	health       *tailscale.Health
 }
 
 // PeersMsg is sent when the peer list changes.
@@ -91,20 +123,79 @@ type LogMsg struct {
 	Message string
 }
 
-// PortMsg is sent to update the proxy port after initialization.
+// MapInfoMsg carries local map metadata loaded for the game detail view.
+type MapInfoMsg struct {
+	MapPath string
+	Info    *mapfile.Info
+}
+
+// MapStatusMsg carries local availability for advertised maps.
+type MapStatusMsg struct {
+	Status map[string]mapfile.Status
+}
+
+// PausedMsg is sent when subsystems are paused or resumed.
+type PausedMsg struct {
+	Paused []string
+}
+
+// HealthMsg carries the latest network health summary.
+type HealthMsg struct {
+	Health tailscale.Health
+}
+
+// NoticeMsg carries a notice to show in the title bar, such as a rejected join.
+type NoticeMsg struct {
+	Text string
+}
+
+// WarningMsg carries a problem to keep in view below the title bar, such
+// as the LAN port being held by another program.
+type WarningMsg struct {
+	Text string
+}
+
+// UpdateMsg is sent when a newer release is available.
+type UpdateMsg struct {
+	Version string
+}
+
+// VersionMsg is sent when the game version changes outside the TUI, such
+// as on a config reload.
+type VersionMsg struct {
+	Version w3gs.GameVersion
+}
+
+// PortMsg is sent to update the proxy and LAN ports after initialization.
+// A zero LANPort leaves the LAN port unchanged. PeerPort is the port peers
+// are answered on when the LAN port was taken, zero otherwise.
 type PortMsg struct {
-	Port int
+	Port     int
+	LANPort  int
+	PeerPort int
 }
 
 // NewModel creates a new TUI model.
+// The charset is used to decode game and host names for display.
+// The library, if non-nil, provides local map metadata in the game detail view.
 // The versionCb callback is called when the user changes the game version.
 // The refreshCb callback is called when the user requests a manual refresh.
+// The pauseCb callback is called when the user toggles pausing discovery.
+// The privateCb callback is called with a game key when the user toggles
+// whether a local game is hidden from remote peers.
+// The peerActionCb callback is called with the marked peers when the user
+// applies a batch action.
 func NewModel(
 	proxyPort int,
 	gameVersion w3gs.GameVersion,
 	buildVersion version.Info,
+	charset game.Charset,
+	library *mapfile.Library,
 	versionCb func(uint32),
 	refreshCb func(),
+	pauseCb func(),
+	privateCb func(string, bool),
+	peerActionCb func(PeerAction, []netip.Addr),
 ) Model {
 	peerColumns := []table.Column{
 		{Title: "Name", Width: colWidthName},
@@ -112,6 +203,7 @@ func NewModel(
 		{Title: "OS", Width: colWidthOS},
 		{Title: "Status", Width: colWidthStatus},
 		{Title: "Games", Width: colWidthGames},
+		{Title: "Ping", Width: colWidthPing},
 	}
 
 	gameColumns := []table.Column{
@@ -119,6 +211,8 @@ func NewModel(
 		{Title: "Host", Width: colWidthHost},
 		{Title: "Players", Width: colWidthPlayers},
 		{Title: "Source", Width: colWidthSource},
+		{Title: "State", Width: colWidthState},
+		{Title: "Map", Width: colWidthMap},
 	}
 
 	peerTable := table.New(
@@ -147,23 +241,35 @@ func NewModel(
 		Background(lipgloss.Color("57")).
 		Bold(false)
 
+	connTable := newConnectionTable()
+
 	peerTable.SetStyles(s)
 	gameTable.SetStyles(s)
+	connTable.SetStyles(s)
 
 	return Model{
 		peers:        make([]tailscale.Peer, 0),
 		games:        make([]game.Game, 0),
 		peerGames:    make(map[string]int),
+		mapStatus:    make(map[string]mapfile.Status),
 		version:      gameVersion,
 		buildVersion: buildVersion,
+		charset:      charset,
+		library:      library,
 		proxyPort:    proxyPort,
+		lanPort:      config.DefaultLANPort,
 		peerTable:    peerTable,
 		gameTable:    gameTable,
+		connTable:    connTable,
 		logs:         make([]string, 0, maxLogLines),
 		focus:        FocusPeers,
 		viewMode:     ViewModeList,
 		versionCb:    versionCb,
 		refreshCb:    refreshCb,
+		pauseCb:      pauseCb,
+		privateCb:    privateCb,
+		peerActionCb: peerActionCb,
+		marked:       make(map[netip.Addr]bool),
 	}
 }
 
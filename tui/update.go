@@ -2,14 +2,18 @@ package tui
 
 import (
 	"fmt"
+	"maps"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/table"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/kradalby/wc3ts/config"
 	"github.com/kradalby/wc3ts/game"
+	"github.com/kradalby/wc3ts/mapfile"
+	"github.com/nielsAD/gowarcraft3/protocol/w3gs"
 )
 
 // Update handles messages and updates the model.
@@ -23,6 +27,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.height = msg.Height
 		m.ready = true
 
+		fitColumn(&m.peerTable, 0, m.width)
+		fitColumn(&m.gameTable, 0, m.width)
+
 		// Calculate available height for tables and logs
 		// Reserve space for: title, section headers, status bar, help, and spacing
 		availableHeight := m.height - fixedUIHeight
@@ -47,6 +54,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 			m.peerTable.SetHeight(peerHeight)
 			m.gameTable.SetHeight(gameHeight)
+			m.connTable.SetHeight(peerHeight + gameHeight)
 		}
 
 		return m, nil
@@ -60,11 +68,12 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case GamesMsg:
 		m.games = msg.Games
+		m = m.refreshSelectedGame()
 		m.updatePeerGameCounts()
 		m.gameTable.SetRows(m.gameRows())
 		m.peerTable.SetRows(m.peerRows()) // Update peers to show game counts
 
-		return m, nil
+		return m, m.checkMaps()
 
 	case LogMsg:
 		m.logs = append(m.logs, msg.Message)
@@ -75,9 +84,70 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		return m, nil
 
+	case PausedMsg:
+		m.paused = msg.Paused
+
+		return m, nil
+
+	case HealthMsg:
+		m.health = &msg.Health
+
+		return m, nil
+
+	case UpdateMsg:
+		m.update = msg.Version
+
+		return m, nil
+
+	case NoticeMsg:
+		m.notice = msg.Text
+
+		return m, nil
+
+	case WarningMsg:
+		m.warning = msg.Text
+
+		return m, nil
+
+	case VersionMsg:
+		m.version = msg.Version
+
+		return m, nil
+
+	case ConnectionsMsg:
+		m.connections = msg.Connections
+		m.connTable.SetRows(m.connectionRows())
+
+		return m, nil
+
+	case MutedMsg:
+		m.muted = msg.Muted
+		m.peerTable.SetRows(m.peerRows())
+
+		return m, nil
+
 	case PortMsg:
 		m.proxyPort = msg.Port
 
+		if msg.LANPort != 0 {
+			m.lanPort = msg.LANPort
+		}
+
+		m.peerPort = msg.PeerPort
+
+		return m, nil
+
+	case MapStatusMsg:
+		maps.Copy(m.mapStatus, msg.Status)
+		m.gameTable.SetRows(m.gameRows())
+
+		return m, nil
+
+	case MapInfoMsg:
+		if m.selectedGame != nil && m.selectedGame.Info.GameSettings.MapPath == msg.MapPath {
+			m.mapInfo = msg.Info
+		}
+
 		return m, nil
 	}
 
@@ -92,6 +162,7 @@ func (m Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			m.viewMode = ViewModeList
 			m.selectedPeer = nil
 			m.selectedGame = nil
+			m.mapInfo = nil
 
 			return m, nil
 		}
@@ -99,6 +170,23 @@ func (m Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		return m, nil
 	}
 
+	if m.viewMode == ViewModeConnections {
+		switch msg.String() {
+		case "up", "k":
+			m.connTable.MoveUp(1)
+		case "down", "j":
+			m.connTable.MoveDown(1)
+		case "c":
+			m.viewMode = ViewModeList
+		case "q", "ctrl+c":
+			m.quitting = true
+
+			return m, tea.Quit
+		}
+
+		return m, nil
+	}
+
 	// In detail view, only handle escape (already handled above)
 	if m.viewMode != ViewModeList {
 		return m, nil
@@ -153,6 +241,40 @@ func (m Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		}
 
 		return m, nil
+
+	case "p":
+		// Pause or resume discovery
+		if m.pauseCb != nil {
+			return m, callback(m.pauseCb)
+		}
+
+		return m, nil
+
+	case "c":
+		// Show who is connected through the proxy
+		m.viewMode = ViewModeConnections
+
+		return m, nil
+
+	case "g":
+		// Hide or show the selected local game to remote peers
+		return m, m.togglePrivate()
+
+	case " ", "space":
+		// Mark the peer for a batch action
+		return m.toggleMark(), nil
+
+	case "n":
+		// Probe the marked peers now
+		return m.applyPeerAction(PeerActionProbe)
+
+	case "a":
+		// Allow the marked peers to join local games
+		return m.applyPeerAction(PeerActionAllow)
+
+	case "m":
+		// Mute or unmute the marked peers
+		return m.applyPeerAction(PeerActionMute)
 	}
 
 	// Handle enter key separately using KeyType for reliability
@@ -163,7 +285,7 @@ func (m Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			m.refreshCb()
 		}
 
-		return m, nil
+		return m, m.loadMapInfo()
 	}
 
 	return m, nil
@@ -184,6 +306,23 @@ func (m Model) toggleFocus() Model {
 	return m
 }
 
+// fitColumn gives column i of t the window width the other columns leave,
+// so the names in it use wide windows and rows never wrap in narrow ones.
+func fitColumn(t *table.Model, i, width int) {
+	cols := t.Columns()
+	rest := 0
+
+	for j, c := range cols {
+		if j != i {
+			rest += c.Width
+		}
+	}
+
+	// Every cell is padded by a space on either side
+	cols[i].Width = max(width-rest-2*len(cols), minColumnWidth)
+	t.SetColumns(cols)
+}
+
 // navigateUp moves selection up in the focused table.
 func (m Model) navigateUp() Model {
 	if m.focus == FocusPeers {
@@ -281,6 +420,106 @@ func (m Model) showDetailView() Model {
 	return m
 }
 
+// refreshSelectedGame updates the game shown in the detail view to its
+// latest state, keeping the last known one if it has gone.
+func (m Model) refreshSelectedGame() Model {
+	if m.selectedGame == nil {
+		return m
+	}
+
+	key := m.selectedGame.Key()
+
+	for i := range m.games {
+		if m.games[i].Key() == key {
+			g := m.games[i]
+			m.selectedGame = &g
+
+			break
+		}
+	}
+
+	return m
+}
+
+// togglePrivate returns a command toggling ghost mode for the selected game
+// if it is local.
+func (m Model) togglePrivate() tea.Cmd {
+	if m.focus != FocusGames || m.privateCb == nil {
+		return nil
+	}
+
+	cursor := m.gameTable.Cursor()
+	if cursor < 0 || cursor >= len(m.games) {
+		return nil
+	}
+
+	g := m.games[cursor]
+	if g.Source != game.SourceLocal {
+		return nil
+	}
+
+	privateCb := m.privateCb
+
+	return callback(func() { privateCb(g.Key(), !g.Private) })
+}
+
+// callback returns a command running fn off the UI goroutine. Callbacks may
+// send messages back to the program, which would block if run from Update.
+func callback(fn func()) tea.Cmd {
+	return func() tea.Msg {
+		fn()
+
+		return nil
+	}
+}
+
+// loadMapInfo returns a command that reads local metadata for the selected
+// game's map, off the UI goroutine since it may need to decompress the archive.
+func (m Model) loadMapInfo() tea.Cmd {
+	if m.library == nil || m.selectedGame == nil {
+		return nil
+	}
+
+	library := m.library
+	mapPath := m.selectedGame.Info.GameSettings.MapPath
+
+	return func() tea.Msg {
+		return MapInfoMsg{MapPath: mapPath, Info: library.Lookup(mapPath)}
+	}
+}
+
+// checkMaps returns a command that checks local availability of any
+// advertised maps not yet checked.
+func (m Model) checkMaps() tea.Cmd {
+	if m.library == nil {
+		return nil
+	}
+
+	var pending []w3gs.GameSettings
+
+	for i := range m.games {
+		settings := m.games[i].Info.GameSettings
+		if _, ok := m.mapStatus[settings.MapPath]; !ok {
+			pending = append(pending, settings)
+		}
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	library := m.library
+
+	return func() tea.Msg {
+		status := make(map[string]mapfile.Status, len(pending))
+		for _, settings := range pending {
+			status[settings.MapPath] = library.Check(settings)
+		}
+
+		return MapStatusMsg{Status: status}
+	}
+}
+
 // OS priority constants for sorting.
 const (
 	osPriorityWindows = 0
@@ -342,6 +581,10 @@ func (m Model) peerRows() []table.Row {
 			status = "Online"
 		}
 
+		if m.isMuted(peer.IP) {
+			status = "Muted"
+		}
+
 		gameCount := m.peerGames[peer.IP.String()]
 		games := "-"
 
@@ -357,12 +600,23 @@ func (m Model) peerRows() []table.Row {
 			osDisplay = "-"
 		}
 
+		ping := "-"
+		if peer.RTT > 0 {
+			ping = peer.RTT.Round(time.Millisecond).String()
+		}
+
+		name := peer.Name
+		if m.marked[peer.IP] {
+			name = "* " + name
+		}
+
 		rows = append(rows, table.Row{
-			peer.Name,
+			name,
 			peer.IP.String(),
 			osDisplay,
 			status,
 			games,
+			ping,
 		})
 	}
 
@@ -377,17 +631,31 @@ func (m Model) gameRows() []table.Row {
 		g := &m.games[i]
 		host := "Local"
 
-		if g.Source == game.SourceRemote {
+		if g.Source != game.SourceLocal {
 			host = g.PeerName
 		}
 
 		players := fmt.Sprintf("%d/%d", g.Info.SlotsUsed, g.Info.SlotsTotal)
 
+		mapStatus := "-"
+		if status, ok := m.mapStatus[g.Info.GameSettings.MapPath]; ok {
+			mapStatus = status.Symbol()
+		}
+
+		source := string(g.Source)
+		if g.Private {
+			source = "private"
+		} else if g.Unreachable {
+			source = "unreachable"
+		}
+
 		rows = append(rows, table.Row{
-			g.Info.GameName,
+			m.charset.Decode(g.Info.GameName),
 			host,
 			players,
-			string(g.Source),
+			source,
+			string(g.State),
+			mapStatus,
 		})
 	}
 
@@ -15,6 +15,7 @@ const (
 	detailBoxPaddingVert  = 1
 	detailBoxPaddingHoriz = 2
 	detailLabelWidth      = 14
+	descriptionWidth      = 50
 )
 
 // styles holds the TUI styling configuration.
@@ -27,6 +28,7 @@ type styles struct {
 	detailBox   lipgloss.Style
 	detailLabel lipgloss.Style
 	detailValue lipgloss.Style
+	warning     lipgloss.Style
 }
 
 // newStyles creates the TUI styles.
@@ -55,6 +57,9 @@ func newStyles() styles {
 			Width(detailLabelWidth),
 		detailValue: lipgloss.NewStyle().
 			Foreground(lipgloss.Color("255")),
+		warning: lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("208")),
 	}
 }
 
@@ -70,12 +75,18 @@ func (m Model) View() string {
 
 	s := newStyles()
 
+	if m.width < minWidth || m.height < minHeight {
+		return m.viewTooSmall(s)
+	}
+
 	// Handle detail views
 	switch m.viewMode {
 	case ViewModeDetailPeer:
 		return m.viewPeerDetail(s)
 	case ViewModeDetailGame:
 		return m.viewGameDetail(s)
+	case ViewModeConnections:
+		return m.viewConnections(s)
 	case ViewModeList:
 		// Fall through to render list view below
 	}
@@ -94,7 +105,23 @@ func (m Model) View() string {
 		versionInfo,
 	)
 
-	b.WriteString(titleBar)
+	if m.warning != "" {
+		titleBar += "  " + s.warning.Render("⚠ "+m.warning)
+	}
+
+	if m.update != "" {
+		titleBar += "  " + s.help.Render("update available: "+m.update)
+	}
+
+	if m.notice != "" {
+		titleBar += "  " + s.help.Render(m.notice)
+	}
+
+	// Every line is cut at the window width: a wrapped one would push the
+	// fixed layout off screen
+	line := lipgloss.NewStyle().MaxWidth(m.width)
+
+	b.WriteString(line.Render(titleBar))
 	b.WriteString("\n\n")
 
 	// Peers section
@@ -128,15 +155,20 @@ func (m Model) View() string {
 			startIdx = len(m.logs) - displayLines
 		}
 
+		// Truncate rather than wrap so long lines can't push the layout off screen
+		logLine := s.logLine.MaxWidth(m.width)
+
 		for _, line := range m.logs[startIdx:] {
-			b.WriteString(s.logLine.Render("  " + line))
+			b.WriteString(logLine.Render("  " + line))
 			b.WriteString("\n")
 		}
 	}
 
 	// Status bar
 	statusBar := m.statusBar()
-	b.WriteString(s.statusBar.Render(statusBar))
+	b.WriteString(line.Render(s.statusBar.Render(statusBar)))
+	b.WriteString("\n")
+	b.WriteString(line.Render(s.statusBar.Render(m.healthLine())))
 	b.WriteString("\n")
 
 	// Help
@@ -145,15 +177,33 @@ func (m Model) View() string {
 		focusIndicator = "games"
 	}
 
-	help := s.help.Render(fmt.Sprintf(
-		"↑/↓: navigate | tab: switch (%s) | enter: details | r: refresh | [/]: version | s: sort | q: quit",
+	help := fmt.Sprintf(
+		"↑/↓: navigate | tab: switch (%s) | enter: details | space: mark | r: refresh | p: pause | "+
+			"g: private | c: connections | [/]: version | s: sort | q: quit",
 		focusIndicator,
-	))
-	b.WriteString(help)
+	)
+	if lipgloss.Width(help) > m.width {
+		help = fmt.Sprintf("tab: %s | enter: details | q: quit | ↑↓ space r p g c [ ] s", focusIndicator)
+	}
+
+	b.WriteString(line.Render(s.help.Render(help)))
 
 	return b.String()
 }
 
+// viewTooSmall renders a notice when the terminal is below the minimum size.
+// The normal layout is restored on the next resize that satisfies it.
+func (m Model) viewTooSmall(s styles) string {
+	msg := lipgloss.JoinVertical(
+		lipgloss.Center,
+		s.header.Render("Terminal too small"),
+		fmt.Sprintf("need %dx%d, have %dx%d", minWidth, minHeight, m.width, m.height),
+		s.help.Render("resize the window to continue"),
+	)
+
+	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, msg)
+}
+
 // viewPeerDetail renders the peer detail view.
 func (m Model) viewPeerDetail(s styles) string {
 	if m.selectedPeer == nil {
@@ -191,6 +241,10 @@ func (m Model) viewPeerDetail(s styles) string {
 
 	content.WriteString(m.detailRow(s, "Status:", status))
 
+	if peer.RTT > 0 {
+		content.WriteString(m.detailRow(s, "Ping:", peer.RTT.Round(time.Millisecond).String()))
+	}
+
 	// Count games hosted by this peer
 	gameCount := 0
 
@@ -214,7 +268,7 @@ func (m Model) viewPeerDetail(s styles) string {
 
 		for _, g := range peerGames {
 			gameLine := fmt.Sprintf("  - %s (%d/%d players)",
-				g.Info.GameName,
+				m.charset.Decode(g.Info.GameName),
 				g.Info.SlotsUsed,
 				g.Info.SlotsTotal,
 			)
@@ -253,12 +307,22 @@ func (m Model) viewGameDetail(s styles) string {
 	// Detail content
 	var content strings.Builder
 
-	content.WriteString(m.detailRow(s, "Name:", g.Info.GameName))
-	content.WriteString(m.detailRow(s, "Map:", g.Info.GameSettings.MapPath))
+	content.WriteString(m.detailRow(s, "Name:", m.charset.Decode(g.Info.GameName)))
+	content.WriteString(m.detailRow(s, "Map:", m.charset.Decode(g.Info.GameSettings.MapPath)))
 	content.WriteString(m.detailRow(s, "Players:", fmt.Sprintf("%d/%d", g.Info.SlotsUsed, g.Info.SlotsTotal)))
 
+	// Player names are only known while a join is proxied to the game
+	if len(g.Players) > 0 {
+		names := make([]string, 0, len(g.Players))
+		for _, name := range g.Players {
+			names = append(names, m.charset.Decode(name))
+		}
+
+		content.WriteString(m.detailRow(s, "In Lobby:", strings.Join(names, ", ")))
+	}
+
 	// Host player name (from WC3 game)
-	hostPlayer := g.Info.GameSettings.HostName
+	hostPlayer := m.charset.Decode(g.Info.GameSettings.HostName)
 	if hostPlayer == "" {
 		hostPlayer = "-"
 	}
@@ -269,9 +333,18 @@ func (m Model) viewGameDetail(s styles) string {
 	versionStr := fmt.Sprintf("%s 1.%d", g.Info.Product.String(), g.Info.Version)
 	content.WriteString(m.detailRow(s, "Version:", versionStr))
 	content.WriteString(m.detailRow(s, "Source:", string(g.Source)))
+	content.WriteString(m.detailRow(s, "State:", string(g.State)))
+
+	if g.Private {
+		content.WriteString(m.detailRow(s, "Private:", "hidden from remote peers"))
+	}
 
-	// Host peer info (for remote games)
-	if g.Source == game.SourceRemote {
+	if g.Unreachable {
+		content.WriteString(m.detailRow(s, "Unreachable:", "host game port refused, not advertised"))
+	}
+
+	// Host peer info (for remote and LAN games)
+	if g.Source != game.SourceLocal {
 		peerName := g.PeerName
 		if peerName == "" {
 			peerName = "-"
@@ -279,6 +352,10 @@ func (m Model) viewGameDetail(s styles) string {
 
 		content.WriteString(m.detailRow(s, "Host Peer:", peerName))
 		content.WriteString(m.detailRow(s, "Host IP:", g.PeerIP.String()))
+
+		if g.RTT > 0 {
+			content.WriteString(m.detailRow(s, "Latency:", g.RTT.Round(time.Millisecond).String()))
+		}
 	}
 
 	content.WriteString(m.detailRow(s, "Game Port:", strconv.FormatUint(uint64(g.Info.GamePort), 10)))
@@ -292,6 +369,8 @@ func (m Model) viewGameDetail(s styles) string {
 		content.WriteString(m.detailRow(s, "Last Seen:", formatDuration(time.Since(g.LastSeen))))
 	}
 
+	content.WriteString(m.mapInfoRows(s))
+
 	// Render box
 	box := s.detailBox.Render(content.String())
 	b.WriteString(box)
@@ -304,6 +383,55 @@ func (m Model) viewGameDetail(s styles) string {
 	return b.String()
 }
 
+// mapInfoRows renders local map metadata for the game detail view.
+func (m Model) mapInfoRows(s styles) string {
+	if m.library == nil {
+		return ""
+	}
+
+	var b strings.Builder
+
+	b.WriteString("\n")
+
+	info := m.mapInfo
+	if info == nil {
+		b.WriteString(m.detailRow(s, "Local Map:", "not found in "+m.library.Dir()))
+
+		return b.String()
+	}
+
+	b.WriteString(m.detailRow(s, "Local Map:", info.Path))
+	b.WriteString(m.detailRow(s, "Map Status:", m.library.Check(m.selectedGame.Info.GameSettings).String()))
+
+	if info.Name != "" {
+		b.WriteString(m.detailRow(s, "Map Name:", m.charset.Decode(info.Name)))
+	}
+
+	if info.Author != "" {
+		b.WriteString(m.detailRow(s, "Author:", m.charset.Decode(info.Author)))
+	}
+
+	if info.Width > 0 && info.Height > 0 {
+		b.WriteString(m.detailRow(s, "Dimensions:", fmt.Sprintf("%dx%d", info.Width, info.Height)))
+	}
+
+	players := info.SuggestedPlayers
+	if info.MaxPlayers > 0 {
+		players = strings.TrimSpace(fmt.Sprintf("%s (max %d)", players, info.MaxPlayers))
+	}
+
+	if players != "" {
+		b.WriteString(m.detailRow(s, "Players:", m.charset.Decode(players)))
+	}
+
+	if info.Description != "" {
+		desc := s.detailValue.Width(descriptionWidth).Render(m.charset.Decode(info.Description))
+		b.WriteString(s.detailLabel.Render("Description:") + " " + desc + "\n")
+	}
+
+	return b.String()
+}
+
 // detailRow creates a formatted detail row with label and value.
 func (m Model) detailRow(s styles, label, value string) string {
 	return s.detailLabel.Render(label) + " " + s.detailValue.Render(value) + "\n"
@@ -325,12 +453,21 @@ func formatDuration(d time.Duration) string {
 // versionString returns the version display string.
 func (m Model) versionString() string {
 	if m.version.Version == 0 {
-		return "[detecting version...]"
+		return "[version: auto, host a game to detect]"
 	}
 
 	return fmt.Sprintf("[%s 1.%d]", m.version.Product.String(), m.version.Version)
 }
 
+// healthLine returns the network health summary line.
+func (m Model) healthLine() string {
+	if m.health == nil {
+		return "Network: checking..."
+	}
+
+	return "Network: " + m.health.String()
+}
+
 // statusBar returns the status bar content.
 func (m Model) statusBar() string {
 	onlinePeers := 0
@@ -342,21 +479,50 @@ func (m Model) statusBar() string {
 	}
 
 	localGames := 0
+	privateGames := 0
 	remoteGames := 0
 
 	for i := range m.games {
 		if m.games[i].Source == "local" {
 			localGames++
+
+			if m.games[i].Private {
+				privateGames++
+			}
 		} else {
 			remoteGames++
 		}
 	}
 
-	return fmt.Sprintf(
-		"UDP 6112 | TCP Proxy: %d | Peers: %d online | Games: %d local, %d remote",
+	udp := strconv.Itoa(m.lanPort)
+	if m.peerPort != 0 {
+		udp += fmt.Sprintf(" (peers: %d)", m.peerPort)
+	}
+
+	status := fmt.Sprintf(
+		"UDP %s | TCP Proxy: %d | Peers: %d online | Games: %d local, %d remote",
+		udp,
 		m.proxyPort,
 		onlinePeers,
 		localGames,
 		remoteGames,
 	)
+
+	if marked := m.markedCount(); marked > 0 {
+		status += fmt.Sprintf(" | Marked: %d (n: probe, a: allow, m: mute)", marked)
+	}
+
+	if privateGames > 0 {
+		status += fmt.Sprintf(" | Private: %d", privateGames)
+	}
+
+	if len(m.connections) > 0 {
+		status += fmt.Sprintf(" | Connected: %d", len(m.connections))
+	}
+
+	if len(m.paused) > 0 {
+		status += " | Paused: " + strings.Join(m.paused, ", ")
+	}
+
+	return status
 }
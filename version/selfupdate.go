@@ -0,0 +1,245 @@
+package version
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// checksumsAsset is the release asset listing SHA-256 sums of all archives.
+const checksumsAsset = "checksums.txt"
+
+// maxDownloadSize bounds a downloaded release asset.
+const maxDownloadSize = 128 << 20
+
+// binaryPerm is the permission of the installed executable.
+const binaryPerm = 0o755
+
+// Self-update errors.
+var (
+	ErrNoAsset          = errors.New("release has no asset")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrNoBinary         = errors.New("archive does not contain the wc3ts binary")
+)
+
+// ArchiveName returns the name of the release archive for goos and goarch,
+// matching the GoReleaser name template.
+func (r Release) ArchiveName(goos, goarch string) string {
+	ext := ".tar.gz"
+	if goos == "windows" {
+		ext = ".zip"
+	}
+
+	return fmt.Sprintf("wc3ts_%s_%s_%s%s", strings.TrimPrefix(r.Version, "v"), goos, goarch, ext)
+}
+
+// asset returns the release asset with the given name.
+func (r Release) asset(name string) (Asset, error) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a, nil
+		}
+	}
+
+	return Asset{}, fmt.Errorf("%w %q", ErrNoAsset, name)
+}
+
+// Install downloads the release archive for this platform, verifies its
+// SHA-256 against the release checksums and atomically replaces the
+// executable at exe with the binary from the archive.
+func Install(ctx context.Context, release Release, exe string) error {
+	name := release.ArchiveName(runtime.GOOS, runtime.GOARCH)
+
+	archiveAsset, err := release.asset(name)
+	if err != nil {
+		return err
+	}
+
+	sumsAsset, err := release.asset(checksumsAsset)
+	if err != nil {
+		return err
+	}
+
+	sums, err := download(ctx, sumsAsset.URL)
+	if err != nil {
+		return err
+	}
+
+	want, err := findChecksum(sums, name)
+	if err != nil {
+		return err
+	}
+
+	archive, err := download(ctx, archiveAsset.URL)
+	if err != nil {
+		return err
+	}
+
+	got := sha256.Sum256(archive)
+	if hex.EncodeToString(got[:]) != want {
+		return fmt.Errorf("%w for %s", ErrChecksumMismatch, name)
+	}
+
+	binary, err := extractBinary(archive, runtime.GOOS)
+	if err != nil {
+		return err
+	}
+
+	return replaceExecutable(exe, binary)
+}
+
+// download fetches url, bounded by maxDownloadSize.
+func download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: download %s: %s", ErrReleaseCheck, url, resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize))
+}
+
+// findChecksum returns the hex SHA-256 of name from a checksums file in
+// sha256sum format.
+func findChecksum(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s not in %s", ErrChecksumMismatch, name, checksumsAsset)
+}
+
+// extractBinary returns the wc3ts executable from a release archive.
+func extractBinary(archive []byte, goos string) ([]byte, error) {
+	if goos == "windows" {
+		return extractZip(archive, "wc3ts.exe")
+	}
+
+	return extractTarGz(archive, "wc3ts")
+}
+
+func extractTarGz(archive []byte, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+
+	tr := tar.NewReader(gz)
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, ErrNoBinary
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if hdr.Typeflag == tar.TypeReg && filepath.Base(hdr.Name) == name {
+			return io.ReadAll(io.LimitReader(tr, maxDownloadSize))
+		}
+	}
+}
+
+func extractZip(archive []byte, name string) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range zr.File {
+		if filepath.Base(f.Name) != name {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+
+		defer func() { _ = rc.Close() }()
+
+		return io.ReadAll(io.LimitReader(rc, maxDownloadSize))
+	}
+
+	return nil, ErrNoBinary
+}
+
+// replaceExecutable writes binary next to exe and renames it into place.
+// A running executable cannot be overwritten on Windows, but it can be
+// renamed, so the old one is moved aside first and removed when possible.
+func replaceExecutable(exe string, binary []byte) error {
+	dir := filepath.Dir(exe)
+
+	tmp, err := os.CreateTemp(dir, ".wc3ts-update-*")
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	_, err = tmp.Write(binary)
+	if err == nil {
+		err = tmp.Chmod(binaryPerm)
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		_ = os.Remove(old)
+
+		err = os.Rename(exe, old)
+		if err != nil {
+			return err
+		}
+
+		err = os.Rename(tmp.Name(), exe)
+		if err != nil {
+			_ = os.Rename(old, exe)
+
+			return err
+		}
+
+		_ = os.Remove(old) // Fails while the old binary is still running
+
+		return nil
+	}
+
+	return os.Rename(tmp.Name(), exe)
+}
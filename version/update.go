@@ -0,0 +1,155 @@
+package version
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// releaseURL is the GitHub API endpoint for the latest wc3ts release.
+const releaseURL = "https://api.github.com/repos/kradalby/wc3ts/releases/latest"
+
+// Permissions for the cached release file.
+const (
+	cacheDirPerm  = 0o755
+	cacheFilePerm = 0o644
+)
+
+// ErrReleaseCheck is returned when the latest release cannot be determined.
+var ErrReleaseCheck = errors.New("release check failed")
+
+// Release describes a published wc3ts release.
+type Release struct {
+	Version string  `json:"tag_name"` //nolint:tagliatelle // GitHub API field
+	URL     string  `json:"html_url"` //nolint:tagliatelle // GitHub API field
+	Assets  []Asset `json:"assets"`
+}
+
+// Asset is a file attached to a release.
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"` //nolint:tagliatelle // GitHub API field
+}
+
+// LatestRelease fetches the latest published release from GitHub.
+func LatestRelease(ctx context.Context) (Release, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, releaseURL, nil)
+	if err != nil {
+		return Release{}, err
+	}
+
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Release{}, err
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return Release{}, fmt.Errorf("%w: %s", ErrReleaseCheck, resp.Status)
+	}
+
+	var release Release
+
+	err = json.NewDecoder(resp.Body).Decode(&release)
+	if err != nil {
+		return Release{}, fmt.Errorf("%w: %w", ErrReleaseCheck, err)
+	}
+
+	if release.Version == "" {
+		return Release{}, fmt.Errorf("%w: release has no tag", ErrReleaseCheck)
+	}
+
+	return release, nil
+}
+
+// SaveRelease caches release at path for later runs.
+func SaveRelease(path string, release Release) error {
+	data, err := json.Marshal(release)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), cacheDirPerm)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, cacheFilePerm)
+}
+
+// LoadRelease reads a release cached by SaveRelease.
+func LoadRelease(path string) (Release, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Release{}, err
+	}
+
+	var release Release
+
+	err = json.Unmarshal(data, &release)
+
+	return release, err
+}
+
+// UpdateAvailable reports whether latest is newer than this build.
+// Development builds never report an update.
+func (i Info) UpdateAvailable(latest Release) bool {
+	return i.IsRelease() && Compare(latest.Version, i.Version) > 0
+}
+
+// Compare compares two versions such as "v0.3.1" and "0.4.0" numerically,
+// returning -1, 0 or +1. A pre-release sorts before its release.
+func Compare(a, b string) int {
+	aCore, aPre, _ := strings.Cut(strings.TrimPrefix(a, "v"), "-")
+	bCore, bPre, _ := strings.Cut(strings.TrimPrefix(b, "v"), "-")
+
+	aParts := strings.Split(aCore, ".")
+	bParts := strings.Split(bCore, ".")
+
+	for i := range max(len(aParts), len(bParts)) {
+		an, bn := versionPart(aParts, i), versionPart(bParts, i)
+		if an != bn {
+			if an < bn {
+				return -1
+			}
+
+			return 1
+		}
+	}
+
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	case aPre < bPre:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// versionPart returns the numeric value of parts[i], or 0 if absent or invalid.
+func versionPart(parts []string, i int) int {
+	if i >= len(parts) {
+		return 0
+	}
+
+	n, err := strconv.Atoi(parts[i])
+	if err != nil {
+		return 0
+	}
+
+	return n
+}
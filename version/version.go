@@ -3,23 +3,44 @@ package version
 
 import (
 	"runtime/debug"
+	"slices"
 )
 
 // shortCommitLen is the length of the abbreviated commit hash.
 const shortCommitLen = 7
 
+// devVersion is reported by builds without release version information.
+const devVersion = "dev"
+
+// version is the release version, set at build time via
+// -ldflags "-X github.com/kradalby/wc3ts/version.version=v1.2.3".
+var version string
+
+// reportedDeps are the dependency modules whose versions Info reports.
+var reportedDeps = []string{
+	"github.com/nielsAD/gowarcraft3",
+	"tailscale.com",
+}
+
 // Info holds version information.
 type Info struct {
 	Version  string
 	Commit   string
 	Modified bool
 	GoVer    string
+
+	// Deps maps the module path of key dependencies to their version.
+	Deps map[string]string
 }
 
 // Get returns the build version information.
 func Get() Info {
 	info := Info{
-		Version: "dev",
+		Version: devVersion,
+	}
+
+	if version != "" {
+		info.Version = version
 	}
 
 	bi, ok := debug.ReadBuildInfo()
@@ -28,6 +49,18 @@ func Get() Info {
 	}
 
 	info.GoVer = bi.GoVersion
+	info.Deps = make(map[string]string, len(reportedDeps))
+
+	for _, dep := range bi.Deps {
+		if slices.Contains(reportedDeps, dep.Path) {
+			info.Deps[dep.Path] = dep.Version
+		}
+	}
+
+	// go install module@version records the module version
+	if version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
 
 	for _, setting := range bi.Settings {
 		switch setting.Key {
@@ -44,9 +77,14 @@ func Get() Info {
 	return info
 }
 
+// IsRelease reports whether this is a release build with a known version.
+func (i Info) IsRelease() bool {
+	return i.Version != devVersion
+}
+
 // String returns a formatted version string.
 func (i Info) String() string {
-	if i.Commit == "" {
+	if i.Commit == "" || i.IsRelease() {
 		return i.Version
 	}
 
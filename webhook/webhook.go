@@ -0,0 +1,108 @@
+// Package webhook posts game lifecycle events to an HTTP endpoint, e.g. to
+// notify a chat channel when a lobby opens.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/kradalby/wc3ts/game"
+)
+
+const (
+	// postTimeout bounds a single delivery.
+	postTimeout = 10 * time.Second
+
+	// queueSize is how many events may wait for delivery. Events arriving
+	// while it is full are dropped rather than stall the registry.
+	queueSize = 64
+)
+
+// ErrDelivery is returned when the endpoint does not accept an event.
+var ErrDelivery = errors.New("webhook delivery failed")
+
+// ErrInvalidURL is returned for webhook URLs that are not http or https.
+var ErrInvalidURL = errors.New("invalid webhook URL")
+
+// Notifier posts every lifecycle event as JSON to a URL.
+type Notifier struct {
+	url   string
+	queue chan game.Event
+}
+
+// New creates a notifier posting to rawURL.
+func New(rawURL string) (*Notifier, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidURL, rawURL)
+	}
+
+	return &Notifier{
+		url:   rawURL,
+		queue: make(chan game.Event, queueSize),
+	}, nil
+}
+
+// Notify queues ev for delivery. It never blocks, so it can be passed to
+// game.Registry.Subscribe.
+func (n *Notifier) Notify(ev game.Event) {
+	select {
+	case n.queue <- ev:
+	default:
+		slog.Warn("webhook queue full, dropping game event", "key", ev.Key, "state", ev.To)
+	}
+}
+
+// Run delivers queued events one at a time, in order, until the context
+// is cancelled. Failed deliveries are logged and not retried.
+func (n *Notifier) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev := <-n.queue:
+			err := n.post(ctx, ev)
+			if err != nil && ctx.Err() == nil {
+				slog.Warn("failed to post game event", "key", ev.Key, "error", err)
+			}
+		}
+	}
+}
+
+// post sends ev to the endpoint.
+func (n *Notifier) post(ctx context.Context, ev game.Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, postTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%w: %s", ErrDelivery, resp.Status)
+	}
+
+	return nil
+}